@@ -36,7 +36,7 @@ export class DashboardComponent implements OnInit {
         if (resp.status === 200) {
           const body = JSON.parse(resp.body);
           body.forEach(item => {
-            const newEntry = new PasswordEntry(item.id, item.username, item.password, item.url, '');
+            const newEntry = new PasswordEntry(item.id, item.username, item.password, item.url, '', item.revision || 0);
             this.dataSource.push(newEntry);
           });
         } else {
@@ -73,7 +73,7 @@ export class DashboardComponent implements OnInit {
       bool => {
         if (bool) {
           const index = this.dataSource.indexOf(item);
-          const body = new PasswordEntry('', item.username, '', item.url, '');
+          const body = new PasswordEntry('', item.username, '', item.url, '', item.revision);
           this.crudService.deletePassword(body).subscribe(
             resp => {
               this.dataSource.splice(index, 1);
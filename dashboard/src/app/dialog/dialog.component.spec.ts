@@ -2,6 +2,9 @@ import { async, ComponentFixture, TestBed } from '@angular/core/testing';
 
 import { DialogComponent } from './dialog.component';
 import {BrowserAnimationsModule} from '@angular/platform-browser/animations';
+import {HttpClientTestingModule} from '@angular/common/http/testing';
+import {MatSnackBar} from '@angular/material/snack-bar';
+import {Overlay} from '@angular/cdk/overlay';
 
 describe('DialogComponent', () => {
   let component: DialogComponent;
@@ -11,6 +14,11 @@ describe('DialogComponent', () => {
     TestBed.configureTestingModule({
       imports: [
         BrowserAnimationsModule,
+        HttpClientTestingModule,
+      ],
+      providers: [
+        MatSnackBar,
+        Overlay,
       ],
       declarations: [ DialogComponent ]
     })
@@ -1,6 +1,8 @@
 import {Component, OnInit} from '@angular/core';
 import {MatDialogRef} from '@angular/material/dialog';
+import {MatSnackBar} from '@angular/material/snack-bar';
 import * as passwordGenerator from '../util/pwgen';
+import {CrudService} from '../services/crud.service';
 
 @Component({
   selector: 'app-dialog',
@@ -15,6 +17,8 @@ export class DialogComponent implements OnInit {
 
   constructor(
     private dialogRef: MatDialogRef<DialogComponent>,
+    private crudService: CrudService,
+    private popOver: MatSnackBar,
   ) {
   }
 
@@ -26,6 +30,24 @@ export class DialogComponent implements OnInit {
   }
 
   generatePassword() {
-    this.password = passwordGenerator.genPW();
+    if (!this.url) {
+      this.setGeneratedPassword(passwordGenerator.genPW());
+      return;
+    }
+    // sites without rules answer 404, the default characters are used for them
+    this.crudService.getPasswordRules(this.url).subscribe(
+      rules => this.setGeneratedPassword(passwordGenerator.genPW(rules)),
+      () => this.setGeneratedPassword(passwordGenerator.genPW())
+    );
+  }
+
+  // the password is used even if the breach check was skipped, the user is warned about it
+  private setGeneratedPassword(generated: Promise<any>) {
+    generated.then(result => {
+      this.password = result.password;
+      if (!result.checked) {
+        this.popOver.open('The password could not be checked against known breaches.', '', {duration: 5000});
+      }
+    });
   }
 }
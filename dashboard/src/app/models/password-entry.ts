@@ -5,6 +5,7 @@ export class PasswordEntry {
     public password,
     public url,
     public del,
+    public revision = 0,
   ) {
   }
 }
@@ -28,7 +28,14 @@ export class CrudService {
     return this.httpClient.post<PasswordEntry>(`/password`, JSON.stringify(body), this.httpOptions);
   }
 
+  getPasswordRules(url: string): Observable<any> {
+    return this.httpClient.get(`/password-rules/lookup`, {params: {url}, withCredentials: true});
+  }
+
   deletePassword(body: PasswordEntry): Observable<any> {
-    return this.httpClient.request<PasswordEntry>('delete', `/password`, {body: JSON.stringify(body)});
+    return this.httpClient.request<PasswordEntry>('delete', `/password`, {
+      body: JSON.stringify(body),
+      headers: new HttpHeaders({'If-Match': `"${body.revision}"`})
+    });
   }
 }
@@ -1,16 +1,15 @@
 import jsSHA from "jssha";
-export function genPW() {
-  let key;
-  let isValid = false;
-  while (!isValid) {
-    key = keyGen();
-    const firstFive = key["hashKey"].slice(0, 5);
-    const getUrl = "https://api.pwnedpasswords.com/range/" + firstFive;
-    const data = getData(getUrl);
-    const jsonData = handleData(data);
-    isValid = checkForMatch(jsonData, key);
-  }
-  return key["clearKey"];
+// rules are the password rules of the site from /password-rules/lookup, without them the default characters are used.
+// The promise resolves with the password and whether it was checked against the breached passwords, the check is
+// skipped if the breach range cannot be loaded, e.g. while the server answers 503 without a dataset.
+export function genPW(rules) {
+  const key = keyGen(rules);
+  const firstFive = key["hashKey"].slice(0, 5);
+  const getUrl = "/breach/range/" + firstFive;
+  return getData(getUrl).then(
+    data => checkForMatch(handleData(data), key) ? {password: key["clearKey"], checked: true} : genPW(rules),
+    () => ({password: key["clearKey"], checked: false})
+  );
 }
 
 function shuffle(array) {
@@ -25,7 +24,7 @@ function shuffle(array) {
   return array;
 }
 
-function keyGen() {
+function keyGen(rules) {
   let chars = [];
   let i;
   for (i = 48; i < 58; i++) {chars.push(String.fromCharCode(i));}
@@ -33,29 +32,56 @@ function keyGen() {
   for (i = 97; i < 122; i++) {chars.push(String.fromCharCode(i));}
   chars.push(String.fromCharCode(33));
   chars.push(String.fromCharCode(95));
+  let length = 24;
+  let required = [];
+  let maxConsecutive = 0;
+  if (rules) {
+    chars = rules.allowed.split("");
+    required = rules.required;
+    maxConsecutive = rules.maxconsecutive;
+    length = Math.max(length, rules.minlength);
+    if (rules.maxlength > 0) {length = Math.min(length, rules.maxlength);}
+  }
   chars = shuffle(chars);
   let key = "";
-  for (i = 0; i < 24; i++) {
-    var rannumber = Math.floor(Math.random() * chars.length);
-    key += chars[rannumber]
-  }
+  do {
+    let keyChars = [];
+    for (i = 0; i < length; i++) {
+      var rannumber = Math.floor(Math.random() * chars.length);
+      keyChars.push(chars[rannumber]);
+    }
+    // every required class gets a position of its own
+    const positions = shuffle(keyChars.map((c, index) => index));
+    for (i = 0; i < required.length; i++) {
+      keyChars[positions[i]] = required[i][Math.floor(Math.random() * required[i].length)];
+    }
+    key = keyChars.join("");
+  } while (maxConsecutive > 0 && hasConsecutive(key, maxConsecutive));
   const shaObj = new jsSHA("SHA-1", "TEXT");
   shaObj.update(key);
   let hashKey = shaObj.getHash("HEX");
   return {"clearKey":key,"hashKey":hashKey};
 }
 
+function hasConsecutive(key, maxConsecutive) {
+  let run = 1;
+  let i;
+  for (i = 1; i < key.length; i++) {
+    run = key[i] === key[i - 1] ? run + 1 : 1;
+    if (run > maxConsecutive) {return true;}
+  }
+  return false;
+}
+
 function getData(getUrl) {
-  let jsonData = null;
-  $.ajax({
-    type: "GET",
-    async: false,
-    url: getUrl,
-    success: function (data) {
-      jsonData = data;
-    }
+  return new Promise((resolve, reject) => {
+    $.ajax({
+      type: "GET",
+      url: getUrl,
+      success: resolve,
+      error: reject
+    });
   });
-  return jsonData;
 }
 
 function handleData(data) {
@@ -72,16 +98,13 @@ function handleData(data) {
 
 function checkForMatch(jsonData, key) {
   let checkKey = key["hashKey"].slice(5);
-  let retValue = false;
   let i;
   for (i = 0; i < jsonData.length; i++) {
     let obj = jsonData[i];
     if (obj.hash === checkKey.toUpperCase()) {
-      retValue = false;
       console.log("MATCH FOUND AT " + i + "\n occurred " + obj.count + " times");
-    } else {
-      retValue = true;
+      return false;
     }
   }
-  return retValue;
+  return true;
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var errAttachmentQuota = errors.New("attachment quota exceeded")
+
+func scanAttachments(rows *sql.Rows) (attachments []*Attachment, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		attachment := &Attachment{}
+		err = rows.Scan(&attachment.Id, &attachment.Entry, &attachment.FileName, &attachment.Size, &attachment.Created)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
+
+func QueryAttachments(db *sql.DB, user *User, entry string) (attachments []*Attachment, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT attachmentid, entryid, filename, size, createdate FROM attachments " +
+		"WHERE uuid = $1 AND entryid = $2 ORDER BY createdate")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, entry)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	attachments, err = scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func QueryAttachment(db *sql.DB, user *User, id string) (attachment *Attachment, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT attachmentid, entryid, filename, size, createdate FROM attachments " +
+		"WHERE uuid = $1 AND attachmentid = $2")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	row := stmt.QueryRow(user.Uuid, id)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	attachment = &Attachment{}
+	err = row.Scan(&attachment.Id, &attachment.Entry, &attachment.FileName, &attachment.Size, &attachment.Created)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// CreateAttachment reserves the size of the attachment against the quota of all attachments of the user and
+// reduces it to the remaining quota, UpdateAttachmentSize replaces the reservation with the size of the content
+func CreateAttachment(db *sql.DB, user *User, attachment *Attachment, quota int64) (err error) {
+	// the row lock on the user serializes the reservations of concurrent uploads
+	return createAttachment(db, user, attachment, quota, "SELECT (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uuid = $1) "+
+		"FROM users WHERE uuid = $1 FOR UPDATE", "INSERT INTO attachments (attachmentid, entryid, uuid, filename, size, createdate) "+
+		"SELECT $1, entryid, uuid, $2, $3, CURRENT_TIMESTAMP FROM passwds WHERE entryid = $4 AND uuid = $5 AND deletedate IS NULL "+
+		"RETURNING createdate")
+}
+
+// createAttachment reads the usage of the user $1 with the query usage and reserves the attachment with the query insert
+func createAttachment(db *sql.DB, user *User, attachment *Attachment, quota int64, usage string, insert string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var used int64
+	err = tx.QueryRow(usage, user.Uuid).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if remaining := quota - used; remaining < attachment.Size {
+		attachment.Size = remaining
+	}
+	if attachment.Size <= 0 {
+		return errAttachmentQuota
+	}
+	// prepare statement, the entry has to belong to the user
+	stmt, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	attachment.Id = newUUID()
+	err = stmt.QueryRow(attachment.Id, attachment.FileName, attachment.Size, attachment.Entry, user.Uuid).Scan(&attachment.Created)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdateAttachmentSize(db *sql.DB, user *User, id string, size int64) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE attachments SET size = $1 WHERE attachmentid = $2 AND uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, size, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteAttachment(db *sql.DB, user *User, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM attachments WHERE attachmentid = $1 AND uuid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryAttachmentUsage(db *sql.DB, user *User) (usage int64, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uuid = $1")
+	if err != nil {
+		return 0, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid).Scan(&usage)
+	return
+}
+
+// QueryAttachmentEntry returns the entry of an attachment of any user, the Policy decides who may access it
+func QueryAttachmentEntry(db *sql.DB, id string) (entry string, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT entryid FROM attachments WHERE attachmentid = $1")
+	if err != nil {
+		return "", err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(id).Scan(&entry)
+	return
+}
+
+func QueryAttachmentIds(db *sql.DB) (ids []string, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT attachmentid FROM attachments")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+type AttachmentHandler struct {
+	storage StorageInterface
+	policy  Policy
+	blobs   BlobStore
+	// maxFileSize limits a single attachment, maxUserSize all attachments of a user, both in bytes
+	maxFileSize int64
+	maxUserSize int64
+}
+
+type AttachmentRequest struct {
+	Id string `json:"id"`
+}
+
+func (handler AttachmentHandler) GetAttachments(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry := request.URL.Query().Get("entry")
+	owner, err := handler.policy.AuthorizeEntry(user, entry, ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	attachments, err := handler.storage.GetAttachments(owner, entry)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendListing(writer, request, attachments)
+}
+
+// UploadAttachment accepts either a multipart form with the fields entry and file
+// or the raw encrypted content as body together with the entry and name as query parameters
+func (handler AttachmentHandler) UploadAttachment(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	if request.ContentLength > handler.maxFileSize {
+		http.Error(writer, errBlobTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	entry := request.URL.Query().Get("entry")
+	name := request.URL.Query().Get("name")
+	var content io.Reader = request.Body
+	multipartReader, err := request.MultipartReader()
+	if err == nil {
+		// stream the file part instead of buffering the whole form
+		content = nil
+		for content == nil {
+			part, err := multipartReader.NextPart()
+			if err != nil {
+				http.Error(writer, "file is missing", http.StatusBadRequest)
+				return
+			}
+			switch part.FormName() {
+			case "entry":
+				value, _ := ioutil.ReadAll(io.LimitReader(part, 64))
+				entry = string(value)
+			case "file":
+				name = part.FileName()
+				content = part
+			}
+		}
+	}
+	if entry == "" || name == "" {
+		http.Error(writer, "entry and name are required", http.StatusBadRequest)
+		return
+	}
+	// the attachments of an entry belong to its owner and count against the quota of the owner
+	owner, err := handler.policy.AuthorizeEntry(user, entry, ActionWrite)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	// the attachment is created first, so blobs without attachment are always leftovers
+	attachment := &Attachment{
+		Entry:    entry,
+		FileName: name,
+		Size:     handler.maxFileSize,
+	}
+	err = handler.storage.CreateAttachment(owner, attachment, handler.maxUserSize)
+	if err == errAttachmentQuota {
+		http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the content may use the reserved size
+	limit := attachment.Size
+	attachment.Size, err = handler.blobs.Put(attachment.Id, &sizeLimitReader{reader: content, remaining: limit})
+	if err == nil {
+		err = handler.storage.UpdateAttachmentSize(owner, attachment.Id, attachment.Size)
+	}
+	if err != nil {
+		_ = handler.blobs.Delete(attachment.Id)
+		_ = handler.storage.DeleteAttachment(owner, attachment.Id)
+		if errors.Is(err, errBlobTooLarge) {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	attachmentJson, err := json.Marshal(attachment)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprint(writer, string(attachmentJson))
+}
+
+func (handler AttachmentHandler) DownloadAttachment(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.authorizeAttachment(user, request.URL.Query().Get("id"), ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	attachment, err := handler.storage.GetAttachment(owner, request.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(writer, "404 - Attachment not found - ", http.StatusNotFound)
+		return
+	}
+	content, err := handler.blobs.Get(attachment.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	writer.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(attachment.FileName))
+	_, _ = io.Copy(writer, content)
+}
+
+func (handler AttachmentHandler) RemoveAttachment(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var attachmentMsg AttachmentRequest
+	err = json.Unmarshal(b, &attachmentMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.authorizeAttachment(user, attachmentMsg.Id, ActionWrite)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeleteAttachment(owner, attachmentMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = handler.blobs.Delete(attachmentMsg.Id)
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// authorizeAttachment returns the owner of the entry of the attachment if the user may execute the action on it,
+// unknown attachments are forbidden like unknown entries
+func (handler AttachmentHandler) authorizeAttachment(user *User, id string, action Action) (*User, error) {
+	entry, err := handler.storage.GetAttachmentEntry(id)
+	if err == sql.ErrNoRows {
+		return nil, errForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+	return handler.policy.AuthorizeEntry(user, entry, action)
+}
+
+// removeOrphanedBlobs deletes the blobs whose attachment was removed together with its entry or user
+func removeOrphanedBlobs(storage StorageInterface, blobs BlobStore) error {
+	// list the blobs first, blobs uploaded afterwards always have their attachment already
+	keys, err := blobs.Keys()
+	if err != nil {
+		return err
+	}
+	ids, err := storage.GetAttachmentIds()
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		existing[id] = true
+	}
+	for _, key := range keys {
+		if existing[key] {
+			continue
+		}
+		err = blobs.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func prepareDBForAttachmentUpload(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\(SELECT COALESCE\\(SUM\\(size\\), 0\\) FROM attachments (.+) FOR UPDATE").
+		WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0))
+	mock.ExpectPrepare("INSERT INTO attachments").
+		ExpectQuery().WithArgs(sqlmock.AnyArg(), "license.key", sqlmock.AnyArg(), "3", []byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"createdate"}).AddRow(time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)))
+	mock.ExpectCommit()
+}
+
+func TestAttachmentHandler_UploadAttachment(t *testing.T) {
+	req, err := http.NewRequest("POST", "/attachment?entry=3&name=license.key", bytes.NewBufferString("encrypted"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	prepareDBForAttachmentUpload(mock)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE attachments SET size").
+		ExpectExec().WithArgs(9, sqlmock.AnyArg(), []byte("USERID")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+	handler := AttachmentHandler{
+		storage:     storage,
+		policy:      Policy{storage: storage},
+		blobs:       NewFileBlobStore(dir),
+		maxFileSize: 1 << 20,
+		maxUserSize: 1 << 20,
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handler.UploadAttachment).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	// the content has to be stored under the id of the attachment
+	keys, err := handler.blobs.Keys()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected exactly one blob, got %v (%v)", keys, err)
+	}
+	if !strings.Contains(rr.Body.String(), `"id":"`+keys[0]+`"`) ||
+		!strings.Contains(rr.Body.String(), `"size":9`) {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, keys[0]))
+	if err != nil || string(content) != "encrypted" {
+		t.Errorf("unexpected blob content: got %q (%v)", content, err)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAttachmentHandler_UploadAttachmentTooLarge(t *testing.T) {
+	// without a content length the limit is only noticed while streaming
+	req, err := http.NewRequest("POST", "/attachment?entry=3&name=license.key",
+		ioutil.NopCloser(strings.NewReader("too much content")))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	prepareDBForAttachmentUpload(mock)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("DELETE FROM attachments").
+		ExpectExec().WithArgs(sqlmock.AnyArg(), []byte("USERID")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+	handler := AttachmentHandler{
+		storage:     storage,
+		policy:      Policy{storage: storage},
+		blobs:       NewFileBlobStore(dir),
+		maxFileSize: 8,
+		maxUserSize: 1 << 20,
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handler.UploadAttachment).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+
+	// neither the partial blob nor its temporary file may remain
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expected no stored files, got %d", len(files))
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAttachmentHandler_UploadAttachmentReadShare(t *testing.T) {
+	req, err := http.NewRequest("POST", "/attachment?entry=3&name=license.key", bytes.NewBufferString("encrypted"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	// the entry is shared with the user for reading only
+	expectEntryAccess(mock, "3", "JANEID", "", "", "read", "")
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+	handler := AttachmentHandler{
+		storage:     storage,
+		policy:      Policy{storage: storage},
+		blobs:       NewFileBlobStore(dir),
+		maxFileSize: 1 << 20,
+		maxUserSize: 1 << 20,
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handler.UploadAttachment).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Errorf("expected no stored files, got %d", len(files))
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRemoveOrphanedBlobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	blobs := NewFileBlobStore(dir)
+	for _, key := range []string{"kept", "orphaned"} {
+		if _, err := blobs.Put(key, strings.NewReader(key)); err != nil {
+			t.Fatalf("an error '%s' was not expected when storing a blob", err)
+		}
+	}
+
+	mock.ExpectPrepare("SELECT attachmentid FROM attachments").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"attachmentid"}).AddRow("kept"))
+
+	err = removeOrphanedBlobs(&Storage{database: db}, blobs)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when removing orphaned blobs", err)
+	}
+
+	keys, _ := blobs.Keys()
+	sort.Strings(keys)
+	if len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("unexpected remaining blobs: got %v want [kept]", keys)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// ApplyPasswordBatch applies the operations in one transaction. If one fails, it is reported as FAILED,
+// all others as ROLLEDBACK and the error of the failed operation is returned. The passwords are sealed with the cipher.
+func ApplyPasswordBatch(db *sql.DB, user *User, operations []*BatchOperation, cipher *DataCipher) (results []*BatchResult, err error) {
+	return applyPasswordBatch(db, operations, func(tx *sql.Tx, operation *BatchOperation) (*BatchResult, error) {
+		return applyBatchOperation(tx, user, operation, cipher, postgresBatchQueries)
+	})
+}
+
+// batchQueries hold the statements of the operations which differ between the databases
+type batchQueries struct {
+	// create inserts an entry like createPassword
+	create func(tx *sql.Tx, user *User, p *Password, cipher *DataCipher) error
+	// lock selects the revision, the password and whether the entry $1 of the user $2 is shared and locks it until the batch is committed
+	lock string
+	// update changes the fields of the entry, see updatePasswordChanges
+	update string
+	// trash moves the entry $1 of the user $2 into the trash
+	trash string
+}
+
+var postgresBatchQueries = batchQueries{
+	create: createPassword,
+	lock:   "SELECT revision, passwd, sharedpasswd IS NOT NULL FROM passwds WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL FOR UPDATE",
+	update: "UPDATE passwds SET passwd = COALESCE($1::text, passwd), url = COALESCE($2::text, url), " +
+		"username = COALESCE($3::text, username), name = COALESCE($4::text, name), favorite = COALESCE($5::boolean, favorite), " +
+		"folderid = CASE WHEN $6::text IS NULL THEN folderid ELSE NULLIF($6::text, '')::integer END, " +
+		"passwordchanged = CASE WHEN $9 THEN CURRENT_TIMESTAMP ELSE passwordchanged END " +
+		"WHERE entryid = $7 AND uuid = $8 AND deletedate IS NULL " +
+		"AND (NULLIF($6::text, '') IS NULL OR EXISTS (SELECT 1 FROM folders WHERE folderid = NULLIF($6::text, '')::integer AND uuid = $8))",
+	trash: "UPDATE passwds SET deletedate = CURRENT_TIMESTAMP WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL",
+}
+
+// applyPasswordBatch runs apply for each operation in one transaction and reports the results like ApplyPasswordBatch
+func applyPasswordBatch(db *sql.DB, operations []*BatchOperation,
+	apply func(tx *sql.Tx, operation *BatchOperation) (*BatchResult, error)) (results []*BatchResult, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	results = make([]*BatchResult, len(operations))
+	for i, operation := range operations {
+		results[i], err = apply(tx, operation)
+		if err != nil {
+			return rolledBackBatch(operations, i, err), err
+		}
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return rolledBackBatch(operations, -1, err), err
+	}
+	return results, nil
+}
+
+// rolledBackBatch reports every operation as rolled back except the failed one, -1 if the commit failed
+func rolledBackBatch(operations []*BatchOperation, failed int, err error) []*BatchResult {
+	results := make([]*BatchResult, len(operations))
+	for i, operation := range operations {
+		results[i] = &BatchResult{Id: operation.Id, Status: "ROLLEDBACK"}
+	}
+	if failed >= 0 {
+		message := err.Error()
+		if err == sql.ErrNoRows {
+			message = "entry not found"
+		}
+		results[failed] = &BatchResult{Id: operations[failed].Id, Status: "FAILED", Error: message}
+		if stale, ok := err.(*staleVersionError); ok {
+			results[failed].Version = entryETag(stale.current)
+		}
+	}
+	return results
+}
+
+func applyBatchOperation(tx *sql.Tx, user *User, operation *BatchOperation, cipher *DataCipher,
+	queries batchQueries) (*BatchResult, error) {
+	switch operation.Op {
+	case BatchCreate:
+		err := queries.create(tx, user, operation.Entry, cipher)
+		if err != nil {
+			return nil, err
+		}
+		return &BatchResult{Id: operation.Entry.Id, Status: "CREATED"}, nil
+	case BatchUpdate:
+		previous, shared, err := lockEntryVersion(tx, queries.lock, operation.Owner, operation.Id, operation.Version)
+		if err != nil {
+			return nil, err
+		}
+		if shared && operation.Changes.Password != nil {
+			return nil, errSharedPassword
+		}
+		err = updatePasswordChanges(tx, queries.update, operation.Owner, operation.Id, operation.Changes, previous, cipher)
+		if err != nil {
+			return nil, err
+		}
+		return &BatchResult{Id: operation.Id, Status: "UPDATED"}, nil
+	default:
+		_, _, err := lockEntryVersion(tx, queries.lock, operation.Owner, operation.Id, operation.Version)
+		if err != nil {
+			return nil, err
+		}
+		err = trashPassword(tx, queries.trash, operation.Owner, operation.Id)
+		if err != nil {
+			return nil, err
+		}
+		return &BatchResult{Id: operation.Id, Status: "REMOVED"}, nil
+	}
+}
+
+// lockEntryVersion locks the entry until the batch is committed and checks that it is still at the version
+// the operation is based on, as it may have been changed since the Policy authorized the batch.
+// It returns the stored password of the entry and whether it is shared.
+func lockEntryVersion(tx *sql.Tx, query string, user *User, id string, version string) (string, bool, error) {
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return "", false, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	var revision int64
+	var password string
+	var shared bool
+	err = stmt.QueryRow(id, user.Uuid).Scan(&revision, &password, &shared)
+	if err != nil {
+		return "", false, err
+	}
+	return password, shared, checkEntryVersion(version, revision)
+}
+
+// updatePasswordChanges changes the given fields of the entry, a folder of another user is rejected.
+// A new password is sealed for the entry, the date of the change is kept if it equals the previous one.
+func updatePasswordChanges(tx *sql.Tx, query string, user *User, id string, changes *PasswordChanges, previous string,
+	cipher *DataCipher) error {
+	var password *string
+	changed := false
+	if changes.Password != nil {
+		opened, err := cipher.Open(columnPassword, id, string(user.Uuid), previous)
+		if err != nil {
+			return err
+		}
+		sealed, err := cipher.Seal(columnPassword, id, string(user.Uuid), *changes.Password)
+		if err != nil {
+			return err
+		}
+		password, changed = &sealed, opened != *changes.Password
+	}
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, password, changes.Url, changes.Username, changes.Name, changes.Favorite,
+		changes.Folder, id, user.Uuid, changed)
+	if err != nil {
+		return err
+	}
+	if changes.Tags == nil {
+		return nil
+	}
+	return replacePasswordTags(tx, user, id, *changes.Tags)
+}
+
+// trashPassword moves the entry into the trash like DeletePassword
+func trashPassword(tx *sql.Tx, query string, user *User, id string) error {
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, id, user.Uuid)
+}
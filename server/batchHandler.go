@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// ApplyPasswordBatch applies a list of create, update and delete operations all-or-nothing.
+// The results are listed in the order of the operations, a failed batch is answered with 409,
+// or 412 and 428 if the version of an update or delete is stale or missing.
+func (handler CRUDHandler) ApplyPasswordBatch(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var operations []*BatchOperation
+	err = json.Unmarshal(b, &operations)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(operations) == 0 {
+		http.Error(writer, "operations are required", http.StatusBadRequest)
+		return
+	}
+	if len(operations) > handler.maxBatchSize {
+		http.Error(writer, "a batch has at most "+strconv.Itoa(handler.maxBatchSize)+" operations", http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i, operation := range operations {
+		err = handler.prepareBatchOperation(user, operation)
+		if err != nil {
+			// nothing has been applied yet, the remaining operations are reported as rolled back
+			results := rolledBackBatch(operations, i, err)
+			if err == errForbidden {
+				results[i].Error = "forbidden"
+			}
+			sendBatchResults(writer, batchFailureStatus(err), results)
+			return
+		}
+	}
+	results, err := handler.storage.ApplyPasswordBatch(user, operations)
+	if err != nil && results == nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		sendBatchResults(writer, batchFailureStatus(err), results)
+		return
+	}
+	sendBatchResults(writer, http.StatusOK, results)
+}
+
+// batchFailureStatus is 412 and 428 if an operation was based on a stale version or had none, 409 otherwise
+func batchFailureStatus(err error) int {
+	if err == errPreconditionRequired {
+		return http.StatusPreconditionRequired
+	}
+	if _, ok := err.(*staleVersionError); ok {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
+
+// prepareBatchOperation validates the operation and authorizes it with the Policy
+func (handler CRUDHandler) prepareBatchOperation(user *User, operation *BatchOperation) error {
+	switch operation.Op {
+	case BatchCreate:
+		if operation.Entry == nil {
+			return fmt.Errorf("entry is required")
+		}
+		entry := operation.Entry
+		if entry.Type == "" {
+			entry.Type = TypeLogin
+		}
+		if !passwordTypes[entry.Type] {
+			return fmt.Errorf("unknown type %s", entry.Type)
+		}
+		var err error
+		entry.Match, err = checkPasswordUris(entry.Match, entry.Uris)
+		if err != nil {
+			return err
+		}
+		err = handler.checkEntryEnvelopes(entry)
+		if err != nil {
+			return err
+		}
+		return handler.policy.AuthorizeFiling(user, entry)
+	case BatchUpdate:
+		if operation.Changes == nil {
+			return fmt.Errorf("changes are required")
+		}
+		if operation.Changes.Password != nil {
+			err := handler.checkEnvelope("password", *operation.Changes.Password)
+			if err != nil {
+				return err
+			}
+		}
+		if operation.Changes.Username != nil {
+			err := handler.checkEnvelope("username", *operation.Changes.Username)
+			if err != nil {
+				return err
+			}
+		}
+	case BatchDelete:
+	default:
+		return fmt.Errorf("unknown operation %s", operation.Op)
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, operation.Id, ActionWrite, operation.Version)
+	if err != nil {
+		return err
+	}
+	operation.Owner = owner
+	return nil
+}
+
+func sendBatchResults(writer http.ResponseWriter, status int, results []*BatchResult) {
+	resultsJson, err := json.Marshal(results)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = fmt.Fprint(writer, string(resultsJson))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const batchBody = `[{"op": "create", "entry": {"url": "john.doe", "username": "` + testUsernameEnvelope + `", "password": "` + testEnvelope + `"}},
+	{"op": "update", "id": "3", "version": "\"7\"", "changes": {"folder": "2"}},
+	{"op": "delete", "id": "4", "version": "\"7\""}]`
+
+// expectEntryLock mocks the lock of an entry of the batch at its current revision
+func expectEntryLock(mock sqlmock.Sqlmock, id string, revision int64) {
+	expectSharedEntryLock(mock, id, revision, false)
+}
+
+// expectSharedEntryLock mocks the lock of an entry of the batch which may be shared
+func expectSharedEntryLock(mock sqlmock.Sqlmock, id string, revision int64, shared bool) {
+	mock.ExpectPrepare("SELECT revision, passwd, sharedpasswd IS NOT NULL FROM passwds (.+) FOR UPDATE").
+		ExpectQuery().WithArgs(id, []byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "passwd", "shared"}).AddRow(revision, testEnvelope, shared))
+}
+
+func TestCRUDHandler_ApplyPasswordBatch(t *testing.T) {
+	req, err := http.NewRequest("POST", "/passwords/batch", bytes.NewBuffer([]byte(batchBody)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	expectEntryAccess(mock, "4", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO passwds").
+		ExpectQuery().WithArgs([]byte("USERID"), "john.doe", testEnvelope, testUsernameEnvelope, "", "", "login", "domain", false).
+		WillReturnRows(sqlmock.NewRows([]string{"entryid"}).AddRow("7"))
+	expectEntryLock(mock, "3", testEntryRevision)
+	mock.ExpectPrepare("UPDATE passwds SET passwd = COALESCE").
+		ExpectExec().WithArgs(nil, nil, nil, nil, nil, "2", "3", []byte("USERID"), false).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectEntryLock(mock, "4", testEntryRevision)
+	mock.ExpectPrepare("UPDATE passwds SET deletedate = CURRENT_TIMESTAMP").
+		ExpectExec().WithArgs("4", []byte("USERID")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ApplyPasswordBatch)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"id":"7","status":"CREATED"},{"id":"3","status":"UPDATED"},{"id":"4","status":"REMOVED"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_ApplyPasswordBatchRollback(t *testing.T) {
+	req, err := http.NewRequest("POST", "/passwords/batch", bytes.NewBuffer([]byte(batchBody)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	expectEntryAccess(mock, "4", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO passwds").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"entryid"}).AddRow("7"))
+	expectEntryLock(mock, "3", testEntryRevision)
+	// the folder belongs to another user
+	mock.ExpectPrepare("UPDATE passwds SET passwd = COALESCE").
+		ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ApplyPasswordBatch)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"status":"ROLLEDBACK"},{"id":"3","status":"FAILED","error":"entry not found"},{"id":"4","status":"ROLLEDBACK"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_ApplyPasswordBatchStaleVersion(t *testing.T) {
+	req, err := http.NewRequest("POST", "/passwords/batch", bytes.NewBuffer([]byte(batchBody)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	expectEntryAccess(mock, "4", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO passwds").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"entryid"}).AddRow("7"))
+	// the entry has been changed by another device after the batch was authorized
+	expectEntryLock(mock, "3", 9)
+	mock.ExpectRollback()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ApplyPasswordBatch)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusPreconditionFailed)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"status":"ROLLEDBACK"},{"id":"3","status":"FAILED",` +
+		`"error":"the entry has been changed, its current version is \"9\"","version":"\"9\""},{"id":"4","status":"ROLLEDBACK"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_ApplyPasswordBatchSharedPassword(t *testing.T) {
+	req, err := http.NewRequest("POST", "/passwords/batch", bytes.NewBuffer([]byte(
+		`[{"op": "update", "id": "3", "version": "\"7\"", "changes": {"password": "`+testEnvelope+`"}}]`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	// the recipients read the shared password, the password of the owner must not diverge from it
+	expectSharedEntryLock(mock, "3", testEntryRevision, true)
+	mock.ExpectRollback()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ApplyPasswordBatch)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"id":"3","status":"FAILED","error":"the password of a shared entry is changed with PUT /shared-password"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStore keeps the client encrypted content of attachments, the server never sees their plain content
+type BlobStore interface {
+	Put(key string, reader io.Reader) (int64, error)
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	// Keys lists all stored blobs, it is used to clean up blobs whose attachment is gone
+	Keys() ([]string, error)
+}
+
+var errInvalidBlobKey = errors.New("invalid blob key")
+
+func checkBlobKey(key string) error {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return errInvalidBlobKey
+	}
+	return nil
+}
+
+// FileBlobStore keeps every blob in its own file below dir
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) *FileBlobStore {
+	return &FileBlobStore{dir: dir}
+}
+
+func (store *FileBlobStore) Put(key string, reader io.Reader) (int64, error) {
+	if err := checkBlobKey(key); err != nil {
+		return 0, err
+	}
+	// the directory is created with the first blob
+	err := os.MkdirAll(store.dir, 0700)
+	if err != nil {
+		return 0, err
+	}
+	// write into a hidden temporary file first, so that no partial blob is ever visible under its key
+	file, err := ioutil.TempFile(store.dir, ".upload-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		_ = file.Close()
+		return written, err
+	}
+	err = file.Close()
+	if err != nil {
+		return written, err
+	}
+	return written, os.Rename(file.Name(), filepath.Join(store.dir, key))
+}
+
+func (store *FileBlobStore) Get(key string) (io.ReadCloser, error) {
+	if err := checkBlobKey(key); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(store.dir, key))
+}
+
+func (store *FileBlobStore) Delete(key string) error {
+	if err := checkBlobKey(key); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(store.dir, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *FileBlobStore) Keys() ([]string, error) {
+	files, err := ioutil.ReadDir(store.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		keys = append(keys, file.Name())
+	}
+	return keys, nil
+}
+
+// PostgresBlobStore keeps the blobs in the attachment_blobs table, they are removed together with their attachment
+type PostgresBlobStore struct {
+	database *sql.DB
+}
+
+func (store *PostgresBlobStore) Put(key string, reader io.Reader) (int64, error) {
+	// bytea values can not be streamed, the size limit of the reader keeps the buffer bounded
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	_, err = store.database.Exec("INSERT INTO attachment_blobs (blobkey, data) VALUES ($1, $2) "+
+		"ON CONFLICT (blobkey) DO UPDATE SET data = $2", key, data)
+	return int64(len(data)), err
+}
+
+func (store *PostgresBlobStore) Get(key string) (io.ReadCloser, error) {
+	var data []byte
+	err := store.database.QueryRow("SELECT data FROM attachment_blobs WHERE blobkey = $1", key).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (store *PostgresBlobStore) Delete(key string) error {
+	_, err := store.database.Exec("DELETE FROM attachment_blobs WHERE blobkey = $1", key)
+	return err
+}
+
+func (store *PostgresBlobStore) Keys() ([]string, error) {
+	rows, err := store.database.Query("SELECT blobkey FROM attachment_blobs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]string, 0)
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// errBlobTooLarge is returned by sizeLimitReader once more than the allowed bytes were read
+var errBlobTooLarge = errors.New("attachment is too large")
+
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, errBlobTooLarge
+	}
+	// read one byte more than allowed to notice oversized uploads
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errBlobTooLarge
+	}
+	return n, err
+}
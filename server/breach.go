@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The compacted dataset starts with breachMagic, followed by the index of the first record of every prefix
+// and the total number of records as uint32, and the records sorted by hash.
+// The first 20 bits of a hash are its prefix, a record keeps the remaining bytes and the count.
+const (
+	breachMagic       = "KCPWNED1"
+	breachPrefixes    = 1 << 20
+	breachIndexOffset = int64(len(breachMagic))
+	breachDataOffset  = breachIndexOffset + (breachPrefixes+1)*4
+	breachSuffixSize  = 18
+	breachRecordSize  = breachSuffixSize + 4
+)
+
+var errBreachDataset = errors.New("malformed breach dataset")
+
+// BreachEntry is a breached hash of the range of a prefix, Suffix has the 35 remaining hex digits of the SHA-1
+type BreachEntry struct {
+	Suffix string
+	Count  uint32
+}
+
+// BreachDataset serves the ranges of the compacted Pwned Passwords dataset, it is reopened once the file is replaced
+type BreachDataset struct {
+	path    string
+	mutex   sync.RWMutex
+	file    *os.File
+	modTime time.Time
+}
+
+func NewBreachDataset(path string) *BreachDataset {
+	return &BreachDataset{path: path}
+}
+
+// Reload opens the dataset if the file has been created or replaced since it was opened
+func (dataset *BreachDataset) Reload() error {
+	if dataset == nil || dataset.path == "" {
+		return nil
+	}
+	info, err := os.Stat(dataset.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dataset.mutex.RLock()
+	current := dataset.file != nil && info.ModTime().Equal(dataset.modTime)
+	dataset.mutex.RUnlock()
+	if current {
+		return nil
+	}
+	file, err := os.Open(dataset.path)
+	if err != nil {
+		return err
+	}
+	magic := make([]byte, len(breachMagic))
+	_, err = file.ReadAt(magic, 0)
+	if err != nil || string(magic) != breachMagic {
+		file.Close()
+		return fmt.Errorf("%s: %v", dataset.path, errBreachDataset)
+	}
+	dataset.mutex.Lock()
+	previous := dataset.file
+	dataset.file, dataset.modTime = file, info.ModTime()
+	dataset.mutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Loaded tells whether the ranges can be served
+func (dataset *BreachDataset) Loaded() bool {
+	dataset.mutex.RLock()
+	defer dataset.mutex.RUnlock()
+	return dataset.file != nil
+}
+
+// Range lists the breached hashes starting with the prefix of 5 hex digits in the order of their suffix
+func (dataset *BreachDataset) Range(prefix string) ([]*BreachEntry, error) {
+	index, err := strconv.ParseUint(prefix, 16, 32)
+	if err != nil || len(prefix) != 5 {
+		return nil, fmt.Errorf("invalid prefix %s", prefix)
+	}
+	dataset.mutex.RLock()
+	defer dataset.mutex.RUnlock()
+	if dataset.file == nil {
+		return nil, errors.New("the breach dataset is not loaded")
+	}
+	bounds := make([]byte, 8)
+	_, err = dataset.file.ReadAt(bounds, breachIndexOffset+int64(index)*4)
+	if err != nil {
+		return nil, err
+	}
+	first, last := binary.BigEndian.Uint32(bounds), binary.BigEndian.Uint32(bounds[4:])
+	if last < first {
+		return nil, errBreachDataset
+	}
+	records := make([]byte, int(last-first)*breachRecordSize)
+	_, err = dataset.file.ReadAt(records, breachDataOffset+int64(first)*breachRecordSize)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*BreachEntry, 0, last-first)
+	for offset := 0; offset < len(records); offset += breachRecordSize {
+		entries = append(entries, &BreachEntry{
+			// the first digit of the stored bytes still belongs to the prefix
+			Suffix: strings.ToUpper(hex.EncodeToString(records[offset : offset+breachSuffixSize])[1:]),
+			Count:  binary.BigEndian.Uint32(records[offset+breachSuffixSize:]),
+		})
+	}
+	return entries, nil
+}
+
+// ImportBreachDataset compacts the Pwned Passwords SHA-1 dataset ordered by hash, one HASH:COUNT per line,
+// into the file at path. The file is replaced once the import is complete.
+func ImportBreachDataset(source io.Reader, path string) (records uint32, err error) {
+	temporary, err := os.Create(path + ".import")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		temporary.Close()
+		if err != nil {
+			os.Remove(temporary.Name())
+		}
+	}()
+	_, err = temporary.Seek(breachDataOffset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriterSize(temporary, 1<<20)
+	index := make([]uint32, breachPrefixes+1)
+	scanner := bufio.NewScanner(source)
+	previous := make([]byte, 20)
+	hash := make([]byte, 20)
+	record := make([]byte, breachRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		separator := strings.IndexByte(text, ':')
+		if separator != 40 {
+			return 0, fmt.Errorf("line %d: expected <sha1>:<count>", line)
+		}
+		_, err = hex.Decode(hash, []byte(text[:separator]))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if records > 0 && bytes.Compare(previous, hash) >= 0 {
+			return 0, fmt.Errorf("line %d: the dataset has to be ordered by hash", line)
+		}
+		count, err := strconv.ParseUint(text[separator+1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if count > math.MaxUint32 {
+			count = math.MaxUint32
+		}
+		if records == math.MaxUint32 {
+			return 0, errors.New("the dataset has too many hashes")
+		}
+		prefix := uint32(hash[0])<<12 | uint32(hash[1])<<4 | uint32(hash[2])>>4
+		// every prefix up to this one starts at this record or later
+		index[prefix+1] = records + 1
+		copy(record, hash[20-breachSuffixSize:])
+		binary.BigEndian.PutUint32(record[breachSuffixSize:], uint32(count))
+		_, err = writer.Write(record)
+		if err != nil {
+			return 0, err
+		}
+		copy(previous, hash)
+		records++
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	if err = writer.Flush(); err != nil {
+		return 0, err
+	}
+	// prefixes without hashes end where the previous prefix ends
+	for i := 1; i <= breachPrefixes; i++ {
+		if index[i] < index[i-1] {
+			index[i] = index[i-1]
+		}
+	}
+	header := make([]byte, breachDataOffset)
+	copy(header, breachMagic)
+	for i, first := range index {
+		binary.BigEndian.PutUint32(header[breachIndexOffset+int64(i)*4:], first)
+	}
+	_, err = temporary.WriteAt(header, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err = temporary.Close(); err != nil {
+		return 0, err
+	}
+	return records, os.Rename(temporary.Name(), path)
+}
+
+// runBreachCommand implements ./server breach import <file>, the running servers pick up the new dataset within an hour
+func runBreachCommand(args []string) error {
+	path := os.Getenv("BREACH_DATASET")
+	if path == "" {
+		return errors.New("BREACH_DATASET is not set")
+	}
+	if len(args) != 2 || args[0] != "import" {
+		return errors.New("use breach import <pwned-passwords-sha1-ordered-by-hash.txt[.gz]>")
+	}
+	source, err := openImportFile(args[1])
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	start := time.Now()
+	records, err := ImportBreachDataset(source, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d hashes into %s in %s\n", records, path, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// gzipFile closes the file along with the reader of its contents
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (gzipped gzipFile) Close() error {
+	gzipped.Reader.Close()
+	return gzipped.file.Close()
+}
+
+// openImportFile opens a dataset or corpus to import, files ending in .gz are decompressed
+func openImportFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return gzipFile{Reader: reader, file: file}, nil
+}
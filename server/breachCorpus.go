@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// CorpusBreach is a line of the breach corpus, Identifiers are the SHA-256 of the lowercased, trimmed
+// mail addresses and usernames of the breach in hex
+type CorpusBreach struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Domain      string   `json:"domain"`
+	Date        string   `json:"date"`
+	DataClasses []string `json:"dataclasses"`
+	Identifiers []string `json:"identifiers"`
+}
+
+// parseCorpusBreach checks a line of the corpus and returns the breach with its normalized identifiers
+func parseCorpusBreach(line []byte) (*Breach, []string, error) {
+	var corpus CorpusBreach
+	err := json.Unmarshal(line, &corpus)
+	if err != nil {
+		return nil, nil, err
+	}
+	if corpus.Name == "" {
+		return nil, nil, errors.New("the name of the breach is required")
+	}
+	breach := &Breach{
+		Name:        corpus.Name,
+		Title:       corpus.Title,
+		Domain:      corpus.Domain,
+		DataClasses: corpus.DataClasses,
+	}
+	if breach.Title == "" {
+		breach.Title = breach.Name
+	}
+	if breach.DataClasses == nil {
+		breach.DataClasses = make([]string, 0)
+	}
+	if corpus.Date != "" {
+		breach.Date, err = time.Parse("2006-01-02", corpus.Date)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid date of %s: %v", corpus.Name, err)
+		}
+	}
+	identifiers := make([]string, 0, len(corpus.Identifiers))
+	for _, identifier := range corpus.Identifiers {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+		if !identifierPattern.MatchString(identifier) {
+			return nil, nil, fmt.Errorf("invalid identifier %s of %s, expected a SHA-256 in hex", identifier, corpus.Name)
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	return breach, identifiers, nil
+}
+
+// ImportBreachCorpus imports the breaches of the corpus, one JSON object per line, and returns their number
+func ImportBreachCorpus(db *sql.DB, source io.Reader) (breaches int, identifiers int, err error) {
+	importBreach := ImportBreach
+	if isSQLite(db) {
+		importBreach = ImportSQLiteBreach
+	}
+	reader := bufio.NewReader(source)
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return breaches, identifiers, err
+		}
+		if len(strings.TrimSpace(string(text))) > 0 {
+			breach, hashes, parseErr := parseCorpusBreach(text)
+			if parseErr != nil {
+				return breaches, identifiers, fmt.Errorf("line %d: %v", line, parseErr)
+			}
+			importErr := importBreach(db, breach, hashes)
+			if importErr != nil {
+				return breaches, identifiers, fmt.Errorf("line %d: %v", line, importErr)
+			}
+			breaches++
+			identifiers += len(hashes)
+		}
+		if err == io.EOF {
+			return breaches, identifiers, nil
+		}
+	}
+}
+
+// runCorpusCommand implements ./server corpus import <file>, the accounts are flagged by the next run of the monitoring
+func runCorpusCommand(db *sql.DB, args []string) error {
+	if len(args) != 2 || args[0] != "import" {
+		return errors.New("use corpus import <breaches.jsonl[.gz]>")
+	}
+	source, err := openImportFile(args[1])
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	breaches, identifiers, err := ImportBreachCorpus(db, source)
+	fmt.Printf("imported %d breaches with %d identifiers\n", breaches, identifiers)
+	return err
+}
+
+// monitorBreaches flags the accounts of the users which appear in the imported corpus
+func monitorBreaches(storage StorageInterface) error {
+	flagged, err := storage.FlagBreachedAccounts()
+	if err != nil {
+		return err
+	}
+	if flagged > 0 {
+		fmt.Printf("Found %d accounts in breaches\n", flagged)
+	}
+	return nil
+}
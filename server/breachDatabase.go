@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+)
+
+// breachIdentifierChunk is the number of identifiers inserted by one statement of the import
+const breachIdentifierChunk = 1000
+
+// identifierHash is the SQL hash of an identifier column, it has to match the hashes of the corpus
+func identifierHash(column string) string {
+	return "encode(sha256(convert_to(lower(btrim(" + column + ")), 'UTF8')), 'hex')"
+}
+
+// ImportBreach adds the breach of the corpus or replaces its metadata and identifiers, the findings of earlier imports stay
+func ImportBreach(db *sql.DB, breach *Breach, identifiers []string) (err error) {
+	return importBreach(db, breach, identifiers, "INSERT INTO breaches (name, title, domain, breachdate, dataclasses) "+
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (name) DO UPDATE SET title = $2, domain = $3, breachdate = $4, dataclasses = $5, "+
+		"importdate = CURRENT_TIMESTAMP", "INSERT INTO breach_identifiers (hash, breach) SELECT DISTINCT unnest($1::text[]), $2 "+
+		"ON CONFLICT DO NOTHING")
+}
+
+// importBreach saves the breach with the query upsert and inserts the identifiers $1 of the breach $2 with the query insert
+func importBreach(db *sql.DB, breach *Breach, identifiers []string, upsert string, insert string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// a breach without a date is listed by the date of its import
+	date := sql.NullTime{Time: breach.Date, Valid: !breach.Date.IsZero()}
+	// execute statement
+	_, err = stmt.Exec(breach.Name, breach.Title, breach.Domain, date, pq.Array(breach.DataClasses))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM breach_identifiers WHERE breach = $1", breach.Name)
+	if err != nil {
+		return err
+	}
+	insertStmt, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+	for start := 0; start < len(identifiers); start += breachIdentifierChunk {
+		end := start + breachIdentifierChunk
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		_, err = insertStmt.Exec(pq.Array(identifiers[start:end]), breach.Name)
+		if err != nil {
+			return err
+		}
+	}
+	// end query
+	return tx.Commit()
+}
+
+// FlagBreachedAccounts records the mails of the users and the usernames of the entries found in the corpus.
+// Usernames which are not envelopes are hashed by the database, encrypted ones need the identifier reported by the client.
+func FlagBreachedAccounts(db *sql.DB) (flagged int64, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// execute statement
+	result, err := tx.Exec("INSERT INTO breach_findings (uuid, entryid, breach) " +
+		"SELECT u.uuid, NULL, i.breach FROM users u JOIN breach_identifiers i ON i.hash = " + identifierHash("u.mail") + " " +
+		"WHERE u.mail <> '' ON CONFLICT (uuid, (COALESCE(entryid, 0)), breach) DO NOTHING")
+	if err != nil {
+		return 0, err
+	}
+	mails, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	result, err = tx.Exec("INSERT INTO breach_findings (uuid, entryid, breach) " +
+		"SELECT p.uuid, p.entryid, i.breach FROM passwds p JOIN breach_identifiers i ON i.hash = COALESCE(p.identifier, " +
+		"CASE WHEN p.username <> '' AND p.username NOT LIKE '" + envelopeVersion + ".%' THEN " + identifierHash("p.username") + " END) " +
+		"WHERE p.deletedate IS NULL ON CONFLICT (uuid, (COALESCE(entryid, 0)), breach) DO NOTHING")
+	if err != nil {
+		return 0, err
+	}
+	entries, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// end query
+	return mails + entries, tx.Commit()
+}
+
+// QueryBreaches lists the breaches the accounts of the user were found in, the latest breach first
+func QueryBreaches(db *sql.DB, user *User) (breaches []*Breach, err error) {
+	return queryBreaches(db, user, "SELECT b.name, b.title, b.domain, COALESCE(b.breachdate, b.importdate), b.dataclasses, "+
+		"COALESCE(f.entryid::text, ''), f.founddate FROM breach_findings f JOIN breaches b ON b.name = f.breach "+
+		"LEFT JOIN passwds p ON p.entryid = f.entryid WHERE f.uuid = $1 AND (f.entryid IS NULL OR p.deletedate IS NULL) "+
+		"ORDER BY COALESCE(b.breachdate, b.importdate) DESC, b.name, f.entryid NULLS FIRST")
+}
+
+// queryBreaches groups the findings of the user $1 selected by the query by their breach
+func queryBreaches(db *sql.DB, user *User, query string) (breaches []*Breach, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var breach *Breach
+	for rows.Next() {
+		current := &Breach{}
+		account := &BreachedAccount{Account: BreachAccountEntry}
+		err = rows.Scan(&current.Name, &current.Title, &current.Domain, &current.Date, pq.Array(&current.DataClasses),
+			&account.Id, &account.Found)
+		if err != nil {
+			return nil, err
+		}
+		if account.Id == "" {
+			account.Account = BreachAccountMail
+		}
+		if breach == nil || breach.Name != current.Name {
+			breach = current
+			breaches = append(breaches, breach)
+		}
+		breach.Accounts = append(breach.Accounts, account)
+	}
+	return breaches, rows.Err()
+}
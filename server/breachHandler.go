@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+type BreachHandler struct {
+	dataset *BreachDataset
+}
+
+// minPaddedRange and maxPaddedRange bound the number of lines of a padded range like the Pwned Passwords API does
+const (
+	minPaddedRange = 800
+	maxPaddedRange = 1000
+)
+
+// GetBreachRange answers like the range API of Pwned Passwords with one SUFFIX:COUNT line per breached hash,
+// clients sending Add-Padding: true get fake hashes with a count of 0 so the size of the answer hides the prefix
+func (handler BreachHandler) GetBreachRange(writer http.ResponseWriter, request *http.Request) {
+	prefix := mux.Vars(request)["prefix"]
+	if len(prefix) != 5 || strings.Trim(prefix, "0123456789abcdefABCDEF") != "" {
+		http.Error(writer, "the prefix has to be 5 hex digits", http.StatusBadRequest)
+		return
+	}
+	if !handler.dataset.Loaded() {
+		http.Error(writer, "the breach dataset has not been imported", http.StatusServiceUnavailable)
+		return
+	}
+	entries, err := handler.dataset.Range(prefix)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var body bytes.Buffer
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(&body, "%s:%d\r\n", entry.Suffix, entry.Count)
+	}
+	if strings.EqualFold(request.Header.Get("Add-Padding"), "true") {
+		err = padBreachRange(&body, len(entries))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Header().Set("Cache-Control", "private, max-age=3600")
+	_, _ = writer.Write(bytes.TrimSuffix(body.Bytes(), []byte("\r\n")))
+}
+
+// padBreachRange appends random suffixes with a count of 0 until the range has between minPaddedRange
+// and maxPaddedRange lines
+func padBreachRange(body *bytes.Buffer, lines int) error {
+	random := make([]byte, 2)
+	_, err := rand.Read(random)
+	if err != nil {
+		return err
+	}
+	target := minPaddedRange + int(binary.BigEndian.Uint16(random))%(maxPaddedRange-minPaddedRange+1)
+	suffix := make([]byte, 18)
+	for ; lines < target; lines++ {
+		_, err = rand.Read(suffix)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(body, "%s:0\r\n", strings.ToUpper(hex.EncodeToString(suffix)[1:]))
+	}
+	return nil
+}
+
+// GetBreachReport lists the breaches of the corpus the mail of the user or the usernames of the entries were found in
+func (handler CRUDHandler) GetBreachReport(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	breaches, err := handler.storage.GetBreaches(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	breachesJson, err := json.Marshal(breaches)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_, _ = fmt.Fprint(writer, string(breachesJson))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the hashes of password and abc in the order of the dataset
+const breachSample = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n" +
+	"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD9:2\r\n" +
+	"A9993E364706816ABA3E25717850C26C9CD0D89D:94\r\n"
+
+// importBreachSample compacts breachSample into a temporary directory which is removed by the returned function
+func importBreachSample(t *testing.T) (*BreachDataset, func()) {
+	dir, err := ioutil.TempDir("", "breach")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	path := filepath.Join(dir, "pwned-passwords.bin")
+	records, err := ImportBreachDataset(strings.NewReader(breachSample), path)
+	if err != nil || records != 3 {
+		t.Fatalf("unexpected import of %d hashes: %v", records, err)
+	}
+	dataset := NewBreachDataset(path)
+	if err := dataset.Reload(); err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the dataset", err)
+	}
+	return dataset, func() {
+		dataset.file.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBreachDataset(t *testing.T) {
+	dataset, cleanup := importBreachSample(t)
+	defer cleanup()
+
+	for prefix, expected := range map[string][]*BreachEntry{
+		"5baa6": {
+			{Suffix: "1E4C9B93F3F0682250B6CF8331B7EE68FD8", Count: 3861493},
+			{Suffix: "1E4C9B93F3F0682250B6CF8331B7EE68FD9", Count: 2},
+		},
+		"A9993": {{Suffix: "E364706816ABA3E25717850C26C9CD0D89D", Count: 94}},
+		"00000": {},
+		"FFFFF": {},
+	} {
+		entries, err := dataset.Range(prefix)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when reading the range %s", err, prefix)
+		}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf("unexpected range %s: got %v want %v", prefix, entries, expected)
+		}
+	}
+
+	_, err := ImportBreachDataset(strings.NewReader("A9993E364706816ABA3E25717850C26C9CD0D89D:94\n"+
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n"), dataset.path+".unsorted")
+	if err == nil || !strings.Contains(err.Error(), "ordered by hash") {
+		t.Errorf("an unsorted dataset was imported: %v", err)
+	}
+}
+
+func TestBreachHandler_GetBreachRange(t *testing.T) {
+	dataset, cleanup := importBreachSample(t)
+	defer cleanup()
+	router := mux.NewRouter()
+	router.HandleFunc("/breach/range/{prefix}", BreachHandler{dataset: dataset}.GetBreachRange)
+
+	tests := []struct {
+		name     string
+		prefix   string
+		status   int
+		expected string
+	}{
+		{"range", "5BAA6", http.StatusOK,
+			"1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD9:2"},
+		{"empty", "00000", http.StatusOK, ""},
+		{"invalid", "5BAAG", http.StatusBadRequest, "the prefix has to be 5 hex digits\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/breach/range/"+test.prefix, nil))
+			if status := rr.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, test.status)
+			}
+			if rr.Body.String() != test.expected {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), test.expected)
+			}
+		})
+	}
+
+	// a padded range hides the number of breached hashes
+	req := httptest.NewRequest("GET", "/breach/range/A9993", nil)
+	req.Header.Set("Add-Padding", "true")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	lines := strings.Split(rr.Body.String(), "\r\n")
+	if len(lines) < minPaddedRange || len(lines) > maxPaddedRange {
+		t.Errorf("handler returned %d lines", len(lines))
+	}
+	if lines[0] != "E364706816ABA3E25717850C26C9CD0D89D:94" || !strings.HasSuffix(lines[len(lines)-1], ":0") {
+		t.Errorf("handler returned unexpected padding: %v, %v", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestParseCorpusBreach(t *testing.T) {
+	// the identifier is the SHA-256 of john@doe.com, uppercase and padded
+	breach, identifiers, err := parseCorpusBreach([]byte(`{"name": "Doe", "domain": "doe.example", "date": "2019-01-16", ` +
+		`"dataclasses": ["Email addresses"], "identifiers": [" D709F370E52B57B4EB75F04E2B3422C4D41A05148CAD8F81776D94A048FB70AF "]}`))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the breach", err)
+	}
+	expected := &Breach{Name: "Doe", Title: "Doe", Domain: "doe.example", Date: time.Date(2019, 1, 16, 0, 0, 0, 0, time.UTC),
+		DataClasses: []string{"Email addresses"}}
+	if !reflect.DeepEqual(breach, expected) {
+		t.Errorf("unexpected breach: got %+v want %+v", breach, expected)
+	}
+	if !reflect.DeepEqual(identifiers, []string{"d709f370e52b57b4eb75f04e2b3422c4d41a05148cad8f81776d94a048fb70af"}) {
+		t.Errorf("unexpected identifiers %v", identifiers)
+	}
+
+	// plaintext identifiers are refused so the corpus never holds mail addresses
+	_, _, err = parseCorpusBreach([]byte(`{"name": "Doe", "identifiers": ["john@doe.com"]}`))
+	if err == nil {
+		t.Errorf("a plaintext identifier was accepted")
+	}
+}
+
+func TestCRUDHandler_GetBreachReport(t *testing.T) {
+	req, err := http.NewRequest("GET", "/reports/breaches", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	breachDate := time.Date(2019, 1, 16, 0, 0, 0, 0, time.UTC)
+	found := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM breach_findings f JOIN breaches b").
+		ExpectQuery().WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "title", "domain", "date", "dataclasses", "entryid", "founddate"}).
+			AddRow("Doe", "Doe", "doe.example", breachDate, "{\"Email addresses\",Passwords}", "", found).
+			AddRow("Doe", "Doe", "doe.example", breachDate, "{\"Email addresses\",Passwords}", "3", found))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetBreachReport)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"name":"Doe","title":"Doe","domain":"doe.example","date":"2019-01-16T00:00:00Z",` +
+		`"dataclasses":["Email addresses","Passwords"],"accounts":[{"account":"mail","found":"2021-05-01T12:00:00Z"},` +
+		`{"account":"entry","id":"3","found":"2021-05-01T12:00:00Z"}]}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
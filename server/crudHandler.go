@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/sessions"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 )
 
@@ -17,6 +20,17 @@ type GetPasswordRequest struct {
 type CRUDHandler struct {
 	cookieStore *sessions.CookieStore
 	storage     StorageInterface
+	matcher     *UrlMatcher
+	// passwordRules are the bundled rules of the domains for the generator
+	passwordRules *PasswordRulesRegistry
+	policy        Policy
+	notifier      Notifier
+	// maxBatchSize is the maximum number of operations of a batch
+	maxBatchSize int
+	// maxImportSize is the maximum size of an imported export in bytes
+	maxImportSize int64
+	// requireEnvelopes rejects passwords and usernames which are not ciphertext envelopes
+	requireEnvelopes bool
 }
 
 type UserRequest struct {
@@ -24,9 +38,16 @@ type UserRequest struct {
 }
 
 type PasswordRequest struct {
-	Password string `json:"password"`
-	Url      string `json:"url"`
-	Username string `json:"username"`
+	Password string        `json:"password"`
+	Url      string        `json:"url"`
+	Username string        `json:"username"`
+	Folder   string        `json:"folder"`
+	Tags     []string      `json:"tags"`
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	Match    string        `json:"match"`
+	Uris     []PasswordUri `json:"uris"`
+	Favorite bool          `json:"favorite"`
 }
 
 func (handler CRUDHandler) GetPassword(writer http.ResponseWriter, request *http.Request) {
@@ -42,6 +63,14 @@ func (handler CRUDHandler) GetPassword(writer http.ResponseWriter, request *http
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	_, err = handler.policy.AuthorizeEntry(user, password.Id, ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	if checkNotModified(writer, request, password.Revision) {
+		return
+	}
 	/*
 		Send the password "plain" as received from the database, Encryption and Decryption in frontend
 	*/
@@ -59,12 +88,32 @@ func (handler CRUDHandler) GetPasswordByUrl(writer http.ResponseWriter, request
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
-	var urls = request.URL.Query()["url"]
-	passwords, err := handler.storage.GetPasswordByUrl(user, urls[0])
+	target := request.URL.Query().Get("url")
+	if target == "" {
+		http.Error(writer, "url is missing", http.StatusBadRequest)
+		return
+	}
+	equivalents, err := handler.storage.GetEquivalentDomains(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains := handler.matcher.Domains(target, equivalents)
+	candidates, err := handler.storage.GetPasswordCandidates(user, domains)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	passwords := handler.matcher.Rank(target, candidates, domains)
+	overrides, err := handler.storage.GetPasswordRules(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the generator applies the rules of the site when new credentials are created for it
+	if rules := handler.passwordRules.Resolve(handler.matcher, target, overrides); rules != nil {
+		writer.Header().Set(passwordRulesHeader, rules.Rules)
+	}
 	/*
 		Send the password "plain" as received from the database, Encryption and Decryption in frontend
 	*/
@@ -76,14 +125,105 @@ func (handler CRUDHandler) GetPasswordByUrl(writer http.ResponseWriter, request
 	_, _ = fmt.Fprint(writer, string(passwordJson))
 }
 
+const maxPageSize = 500
+
+var passwordTypes = map[string]bool{TypeLogin: true, TypeNote: true, TypeCard: true, TypeIdentity: true}
+
+func encodeCursor(cursor *PasswordCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(raw string) (*PasswordCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	cursor := &PasswordCursor{}
+	err = json.Unmarshal(b, cursor)
+	if err != nil || cursor.Id == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return cursor, nil
+}
+
+// passwordFilterFromQuery reads the search, filter, sort and paging parameters of the passwords listing
+func passwordFilterFromQuery(query url.Values) (filter PasswordFilter, limit int, err error) {
+	filter = PasswordFilter{
+		Folder: query.Get("folder"),
+		Tag:    query.Get("tag"),
+		Type:   query.Get("type"),
+		Query:  query.Get("q"),
+		Sort:   query.Get("sort"),
+	}
+	if query.Get("favorite") != "" {
+		filter.Favorite, err = strconv.ParseBool(query.Get("favorite"))
+		if err != nil {
+			return filter, 0, errors.New("favorite has to be true or false")
+		}
+	}
+	if filter.Sort == "" {
+		filter.Sort = "name"
+	}
+	if _, ok := passwordSortKeys[filter.Sort]; !ok {
+		return filter, 0, errors.New("unknown sort " + filter.Sort)
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, 0, errors.New("order has to be asc or desc")
+	}
+	if filter.Type != "" && !passwordTypes[filter.Type] {
+		return filter, 0, errors.New("unknown type " + filter.Type)
+	}
+	if query.Get("limit") != "" {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit <= 0 {
+			return filter, 0, errors.New("limit has to be a positive number")
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		// fetch one more entry to know whether another page follows
+		filter.Limit = limit + 1
+	}
+	if query.Get("cursor") != "" {
+		filter.After, err = decodeCursor(query.Get("cursor"))
+		if err != nil {
+			return filter, 0, err
+		}
+		if filter.After.Sort != filter.Sort || filter.After.Descending != filter.Descending {
+			return filter, 0, errors.New("cursor does not match the requested order")
+		}
+	}
+	return filter, limit, nil
+}
+
 func (handler CRUDHandler) GetPasswords(writer http.ResponseWriter, request *http.Request) {
 	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
-	passwords, err := handler.storage.GetPasswords(user)
-	responseMessagJSON, err := json.Marshal(passwords)
+	filter, limit, err := passwordFilterFromQuery(request.URL.Query())
 	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filed := &Password{Folder: filter.Folder}
+	if filter.Tag != "" {
+		filed.Tags = []string{filter.Tag}
+	}
+	err = handler.policy.AuthorizeFiling(user, filed)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	passwords, err := handler.storage.GetPasswords(user, filter)
+	if limit > 0 && len(passwords) > limit {
+		passwords = passwords[:limit]
+		// the cursor of the next page is handed out as header to keep the body a plain list
+		writer.Header().Set("X-Next-Cursor", encodeCursor(passwords[limit-1].Cursor))
 	}
-	_, _ = fmt.Fprint(writer, string(responseMessagJSON))
+	sendListing(writer, request, passwords)
 }
 
 func (handler CRUDHandler) CreatePassword(writer http.ResponseWriter, request *http.Request) {
@@ -96,10 +236,42 @@ func (handler CRUDHandler) CreatePassword(writer http.ResponseWriter, request *h
 	defer request.Body.Close()
 	var password PasswordRequest
 	err = json.Unmarshal(b, &password)
+	if password.Type == "" {
+		password.Type = TypeLogin
+	}
+	if !passwordTypes[password.Type] {
+		http.Error(writer, "unknown type "+password.Type, http.StatusBadRequest)
+		return
+	}
+	password.Match, err = checkPasswordUris(password.Match, password.Uris)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.checkEnvelope("password", password.Password)
+	if err == nil {
+		err = handler.checkEnvelope("username", password.Username)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.policy.AuthorizeFiling(user, &Password{Folder: password.Folder, Tags: password.Tags})
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
 	err = handler.storage.CreatePassword(user, password.Url, &Password{
 		Password: password.Password,
 		Url:      password.Url,
 		Username: password.Username,
+		Folder:   password.Folder,
+		Tags:     password.Tags,
+		Name:     password.Name,
+		Type:     password.Type,
+		Match:    password.Match,
+		Uris:     password.Uris,
+		Favorite: password.Favorite,
 	})
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
@@ -118,7 +290,17 @@ func (handler CRUDHandler) RemovePassword(writer http.ResponseWriter, request *h
 	defer request.Body.Close()
 	var passwordId GetPasswordRequest
 	err = json.Unmarshal(b, &passwordId)
-	err = handler.storage.DeletePassword(user, passwordId.Url, passwordId.Username)
+	password, err := handler.storage.GetPassword(user, passwordId.Url, passwordId.Username)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, password.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeletePassword(owner, passwordId.Url, passwordId.Username)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
@@ -128,6 +310,20 @@ func (handler CRUDHandler) RemovePassword(writer http.ResponseWriter, request *h
 
 func (handler CRUDHandler) RemoveUser(writer http.ResponseWriter, request *http.Request) {
 	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the entries of collections belong to their organization and must not be deleted with the account
+	entries, err := handler.storage.CountCollectionEntries(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries > 0 {
+		http.Error(writer, "409 - Entries of collections have to be moved out or deleted first - ", http.StatusConflict)
+		return
+	}
 	err = handler.storage.RemoveUser(user)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestCRUDHandler_GetPassword(t *testing.T) {
@@ -25,7 +26,7 @@ func TestCRUDHandler_GetPassword(t *testing.T) {
 
 	defer db.Close()
 
-	prepareDBForPasswordRequest(mock)
+	prepareDBForPasswordRequest(mock, false)
 
 	// Set global values to mocked one
 	initFromDatabaseAndRouter(db)
@@ -38,7 +39,7 @@ func TestCRUDHandler_GetPassword(t *testing.T) {
 	}
 
 	// Check the response body is what we expect.
-	expected := `{"password":"password","id":"1","url":"john.doe","username":"johndoe"}`
+	expected := `{"password":"password","id":"1","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain"}`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
@@ -66,7 +67,7 @@ func TestCRUDHandler_GetPasswords(t *testing.T) {
 
 	defer db.Close()
 
-	prepareDBForPasswordRequest(mock)
+	prepareDBForPasswordRequest(mock, true)
 
 	// Set global values to mocked one
 	initFromDatabaseAndRouter(db)
@@ -79,7 +80,7 @@ func TestCRUDHandler_GetPasswords(t *testing.T) {
 	}
 
 	// Check the response body is what we expect.
-	expected := `[{"password":"password","id":"1","url":"john.doe","username":"johndoe"}]`
+	expected := `[{"password":"password","id":"1","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain"}]`
 	if rr.Body.String() != expected {
 		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
@@ -90,7 +91,16 @@ func TestCRUDHandler_GetPasswords(t *testing.T) {
 	}
 }
 
-func prepareDBForPasswordRequest(mock sqlmock.Sqlmock) {
+var passwordColumnNames = []string{"entryid", "url", "passwd", "username", "folderid", "tags", "name", "type", "createdate", "lastused", "match", "uris", "favorite", "usecount", "deletedate", "expires", "rotationdays", "passwordchanged", "collectionid", "revision", "uuid"}
+
+// listingColumnNames are selected by listings which include the entries shared with the user
+var listingColumnNames = append(passwordColumnNames[:len(passwordColumnNames):len(passwordColumnNames)],
+	"permission", "owner", "wrappedkey", "sharedpasswd", "sortkey")
+
+var passwordCreated = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// prepareDBForPasswordRequest mocks the lookup of a single password, listings additionally select the sort key
+func prepareDBForPasswordRequest(mock sqlmock.Sqlmock, listing bool) {
 	mock.ExpectBegin()
 	mock.ExpectPrepare("SELECT (.+) FROM users").
 		ExpectQuery().WithArgs("USERID").
@@ -98,14 +108,24 @@ func prepareDBForPasswordRequest(mock sqlmock.Sqlmock) {
 			AddRow("USERID", "john", "@", "password"))
 	mock.ExpectCommit()
 	mock.ExpectBegin()
-	mock.ExpectPrepare("SELECT (.+) FROM passwds").
-		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"entryid", "url", "passwd", "username"}).
-		AddRow(1, "john.doe", "password", "johndoe"))
+	if listing {
+		mock.ExpectPrepare("SELECT (.+) FROM passwds").
+			ExpectQuery().WillReturnRows(sqlmock.NewRows(listingColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID", nil, "john", nil, nil, "john.doe"))
+	} else {
+		mock.ExpectPrepare("SELECT (.+) FROM passwds").
+			ExpectQuery().WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID"))
+	}
 	mock.ExpectCommit()
+	if !listing {
+		expectEntryAccess(mock, "1", "USERID", "", "", "", "")
+	}
 }
 
 func TestCRUDHandler_CreatePassword(t *testing.T) {
-	req, err := http.NewRequest("POST", "/password", bytes.NewBuffer([]byte(`{"username": "johndoe", "password": "doejohn", "url": "john.doe"}`)))
+	req, err := http.NewRequest("POST", "/password", bytes.NewBuffer([]byte(
+		`{"username": "`+testUsernameEnvelope+`", "password": "`+testEnvelope+`", "url": "john.doe"}`)))
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when creating a request", err)
 	}
@@ -129,7 +149,8 @@ func TestCRUDHandler_CreatePassword(t *testing.T) {
 	mock.ExpectCommit()
 	mock.ExpectBegin()
 	mock.ExpectPrepare("INSERT INTO passwds").
-		ExpectExec().WithArgs(sqlmock.AnyArg(), "john.doe", "doejohn", "johndoe").WillReturnResult(sqlmock.NewResult(1, 1))
+		ExpectQuery().WithArgs(sqlmock.AnyArg(), "john.doe", testEnvelope, testUsernameEnvelope, "", "", "login", "domain", false).
+		WillReturnRows(sqlmock.NewRows([]string{"entryid"}).AddRow(1))
 	mock.ExpectCommit()
 
 	// Set global values to mocked one
@@ -159,6 +180,7 @@ func TestCRUDHandler_RemovePassword(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when creating a request", err)
 	}
+	req.Header.Set("If-Match", `"7"`)
 	if req.Form == nil {
 		req.Form = make(map[string][]string)
 	}
@@ -178,7 +200,13 @@ func TestCRUDHandler_RemovePassword(t *testing.T) {
 			AddRow("USERID", "john", "@", "password"))
 	mock.ExpectCommit()
 	mock.ExpectBegin()
-	mock.ExpectPrepare("DELETE FROM passwds").
+	mock.ExpectPrepare("SELECT (.+) FROM passwds").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+		AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "1", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE passwds SET deletedate = CURRENT_TIMESTAMP").
 		ExpectExec().WithArgs(sqlmock.AnyArg(), "john.doe", "johndoe").WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
@@ -227,6 +255,9 @@ func TestCRUDHandler_RemoveUser(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
 			AddRow("USERID", "john", "@", "password"))
 	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT COUNT\\(\\*\\) FROM passwds").
+		ExpectQuery().WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
 	mock.ExpectBegin()
 	mock.ExpectPrepare("DELETE FROM users").
 		ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
@@ -254,6 +285,50 @@ func TestCRUDHandler_RemoveUser(t *testing.T) {
 	}
 }
 
+func TestCRUDHandler_RemoveUserWithCollectionEntries(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/user", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	// the account is kept, its entries of collections belong to the organization
+	mock.ExpectPrepare("SELECT COUNT\\(\\*\\) FROM passwds").
+		ExpectQuery().WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.RemoveUser)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestCRUDHandler_UpdateUser(t *testing.T) {
 	req, err := http.NewRequest("PUT", "/user", bytes.NewBuffer([]byte(`{"name": "johndoe"}`)))
 	if err != nil {
@@ -349,3 +424,102 @@ func TestCRUDHandler_GetUser(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestCRUDHandler_GetPasswordsPaginated(t *testing.T) {
+	req, err := http.NewRequest("GET", "/passwords?q=john&type=login&sort=created&order=desc&limit=1", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`SELECT (.+) FROM passwds (.+) ILIKE (.+) ORDER BY \(p.createdate\) DESC, p.entryid DESC LIMIT 2`).
+		ExpectQuery().WithArgs(sqlmock.AnyArg(), "login", "%john%").
+		WillReturnRows(sqlmock.NewRows(listingColumnNames).
+			AddRow(2, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID", nil, "john", nil, nil, "2020-05-01 12:00:00").
+			AddRow(1, "doe.john", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID", nil, "john", nil, nil, "2020-05-01 12:00:00"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetPasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"password":"password","id":"2","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// The cursor has to point behind the last returned entry
+	cursor, err := decodeCursor(rr.Header().Get("X-Next-Cursor"))
+	if err != nil || cursor.Id != "2" || cursor.Key != "2020-05-01 12:00:00" || cursor.Sort != "created" || !cursor.Descending {
+		t.Errorf("handler returned unexpected cursor: got %v", cursor)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_GetPasswordsInvalidSort(t *testing.T) {
+	req, err := http.NewRequest("GET", "/passwords?sort=passwd", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetPasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
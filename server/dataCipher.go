@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealedPrefix marks a column value encrypted at rest: enc1:<key id>:<wrapped data key>:<nonce and ciphertext>.
+// The column, the key of the row and its owner are authenticated with the value, so it cannot be moved to another
+// column, row or user. Values without a prefix were written before the encryption was enabled and are read as they are.
+const sealedPrefix = "enc1:"
+
+// Columns encrypted at rest
+const (
+	columnPassword       = "passwds.passwd"
+	columnSharedPassword = "passwds.sharedpasswd"
+	columnMasterPassword = "users.masterpasswd"
+)
+
+var errSealedValue = errors.New("malformed encrypted column value")
+
+// DataCipher encrypts every value with its own data key, which is wrapped by the KeyWrapper.
+// A nil DataCipher leaves the values unencrypted.
+type DataCipher struct {
+	wrapper KeyWrapper
+}
+
+// newDataCipher loads the keyfile of DATA_KEYFILE, which is created with a first key if it does not exist yet.
+// Without DATA_KEYFILE the columns are not encrypted.
+func newDataCipher() (*DataCipher, error) {
+	path := os.Getenv("DATA_KEYFILE")
+	if path == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err = AddKeyfileKey(path, "initial")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("created the keyfile %s, keep a backup of it apart from the database backups\n", path)
+	}
+	wrapper, err := LoadKeyfile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DataCipher{wrapper: wrapper}, nil
+}
+
+// isSealed tells whether the value is encrypted at rest
+func isSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// sealedData is the additional data authenticated with the value of the column in the row of the owner
+func sealedData(column string, key string, owner string) []byte {
+	return []byte(column + "\x00" + key + "\x00" + owner)
+}
+
+// Seal encrypts the value of the column in the row with the key of the owner with a new data key, empty values are kept
+func (c *DataCipher) Seal(column string, key string, owner string, value string) (string, error) {
+	if c == nil || value == "" {
+		return value, nil
+	}
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := dataKeyCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	keyId, wrapped, err := c.wrapper.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + keyId + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), sealedData(column, key, owner))), nil
+}
+
+// Open decrypts a sealed value of the column in the row with the key of the owner, other values are returned as they are
+func (c *DataCipher) Open(column string, key string, owner string, value string) (string, error) {
+	if !isSealed(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", errors.New("the value is encrypted but DATA_KEYFILE is not set")
+	}
+	keyId, wrapped, sealed, err := splitSealed(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := c.wrapper.UnwrapKey(keyId, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("data key of %s: %v", keyId, err)
+	}
+	aead, err := dataKeyCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errSealedValue
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], sealedData(column, key, owner))
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", column, err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap wraps the data key of a sealed value with the current key encryption key, unencrypted values are sealed.
+// The ciphertext is kept, changed is false if the value is already up to date.
+func (c *DataCipher) Rewrap(column string, key string, owner string, value string) (rewrapped string, changed bool, err error) {
+	if value == "" {
+		return value, false, nil
+	}
+	if !isSealed(value) {
+		rewrapped, err = c.Seal(column, key, owner, value)
+		return rewrapped, err == nil, err
+	}
+	keyId, wrapped, sealed, err := splitSealed(value)
+	if err != nil {
+		return "", false, err
+	}
+	if keyId == c.wrapper.CurrentKeyId() {
+		return value, false, nil
+	}
+	dataKey, err := c.wrapper.UnwrapKey(keyId, wrapped)
+	if err != nil {
+		return "", false, fmt.Errorf("data key of %s: %v", keyId, err)
+	}
+	keyId, wrapped, err = c.wrapper.WrapKey(dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	return sealedPrefix + keyId + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealed), true, nil
+}
+
+// splitSealed returns the key id, the wrapped data key and the nonce with the ciphertext of a sealed value
+func splitSealed(value string) (keyId string, wrapped []byte, sealed []byte, err error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return "", nil, nil, errSealedValue
+	}
+	wrapped, err = base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, errSealedValue
+	}
+	sealed, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, errSealedValue
+	}
+	return parts[1], wrapped, sealed, nil
+}
+
+func dataKeyCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openPasswords decrypts the passwords of the entries in place, shared entries carry the password of their shares
+func (c *DataCipher) openPasswords(passwords []*Password) error {
+	for _, password := range passwords {
+		column := columnPassword
+		if password.Shared != nil {
+			column = columnSharedPassword
+		}
+		var err error
+		password.Password, err = c.Open(column, password.Id, password.Owner, password.Password)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openUser decrypts the master password of the user in place
+func (c *DataCipher) openUser(user *User) error {
+	if !isSealed(string(user.MasterPassword)) {
+		return nil
+	}
+	masterPassword, err := c.Open(columnMasterPassword, string(user.Uuid), string(user.Uuid), string(user.MasterPassword))
+	if err != nil {
+		return err
+	}
+	user.MasterPassword = []byte(masterPassword)
+	return nil
+}
+
+// sealMasterPassword replaces the master password of the user with its sealed value and returns the plaintext to restore it
+func (c *DataCipher) sealMasterPassword(user *User) ([]byte, error) {
+	plaintext := user.MasterPassword
+	if len(plaintext) == 0 {
+		return plaintext, nil
+	}
+	sealed, err := c.Seal(columnMasterPassword, string(user.Uuid), string(user.Uuid), string(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	user.MasterPassword = []byte(sealed)
+	return plaintext, nil
+}
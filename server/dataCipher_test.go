@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestDataCipher creates a keyfile with the given keys, the last one is the current key
+func newTestDataCipher(t *testing.T, dir string, ids ...string) *DataCipher {
+	path := filepath.Join(dir, "keycloud.keys")
+	for _, id := range ids {
+		err := AddKeyfileKey(path, id)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when adding a key", err)
+		}
+	}
+	wrapper, err := LoadKeyfile(path)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when loading the keyfile", err)
+	}
+	return &DataCipher{wrapper: wrapper}
+}
+
+func TestDataCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cipher := newTestDataCipher(t, dir, "first")
+	sealed, err := cipher.Seal(columnPassword, "1", "USERID", "doejohn")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sealing", err)
+	}
+	if !strings.HasPrefix(sealed, "enc1:first:") || strings.Contains(sealed, "doejohn") {
+		t.Errorf("unexpected sealed value %s", sealed)
+	}
+	if again, _ := cipher.Seal(columnPassword, "1", "USERID", "doejohn"); again == sealed {
+		t.Errorf("the same value was sealed with the same data key")
+	}
+	if opened, err := cipher.Open(columnPassword, "1", "USERID", sealed); err != nil || opened != "doejohn" {
+		t.Errorf("unexpected value: got %s, %v want doejohn", opened, err)
+	}
+	// a value cannot be moved to another column, row or user
+	if _, err := cipher.Open(columnMasterPassword, "1", "USERID", sealed); err == nil {
+		t.Errorf("value of another column was opened")
+	}
+	if _, err := cipher.Open(columnPassword, "2", "USERID", sealed); err == nil {
+		t.Errorf("value of another row was opened")
+	}
+	if _, err := cipher.Open(columnPassword, "1", "OTHERID", sealed); err == nil {
+		t.Errorf("value of another user was opened")
+	}
+	// values written before the encryption are read as they are
+	if opened, err := cipher.Open(columnPassword, "1", "USERID", "legacy"); err != nil || opened != "legacy" {
+		t.Errorf("unexpected value: got %s, %v want legacy", opened, err)
+	}
+
+	// after the rotation the data key is wrapped again, the ciphertext is kept
+	rotated := newTestDataCipher(t, dir, "second")
+	rewrapped, changed, err := rotated.Rewrap(columnPassword, "1", "USERID", sealed)
+	if err != nil || !changed || !strings.HasPrefix(rewrapped, "enc1:second:") {
+		t.Fatalf("unexpected re-wrap: got %s, %v, %v", rewrapped, changed, err)
+	}
+	if rewrapped[strings.LastIndex(rewrapped, ":"):] != sealed[strings.LastIndex(sealed, ":"):] {
+		t.Errorf("the ciphertext was changed by the re-wrap")
+	}
+	if opened, err := rotated.Open(columnPassword, "1", "USERID", rewrapped); err != nil || opened != "doejohn" {
+		t.Errorf("unexpected value: got %s, %v want doejohn", opened, err)
+	}
+	if _, changed, _ := rotated.Rewrap(columnPassword, "1", "USERID", rewrapped); changed {
+		t.Errorf("value of the current key was re-wrapped")
+	}
+	// the former key is still known to the rotated keyfile
+	if opened, err := rotated.Open(columnPassword, "1", "USERID", sealed); err != nil || opened != "doejohn" {
+		t.Errorf("unexpected value: got %s, %v want doejohn", opened, err)
+	}
+}
+
+func TestStorageSealsPasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	cipher := newTestDataCipher(t, dir, "first")
+	sealed, _ := cipher.Seal(columnMasterPassword, "USERID", "USERID", "password")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", sealed))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO passwds").
+		ExpectQuery().WithArgs([]byte("USERID"), "john.doe", "", "johndoe", "", "", "login", "domain", false).
+		WillReturnRows(sqlmock.NewRows([]string{"entryid"}).AddRow("7"))
+	// the password is sealed once the key of the entry is known
+	mock.ExpectPrepare("UPDATE passwds SET passwd").
+		ExpectExec().WithArgs(sealedArgument{cipher, "7", "USERID"}, "7").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	storage := &Storage{database: db, cipher: cipher}
+	user, err := storage.GetUser("USERID")
+	if err != nil || string(user.MasterPassword) != "password" {
+		t.Fatalf("unexpected master password: got %s, %v want password", user.MasterPassword, err)
+	}
+	password := &Password{Url: "john.doe", Username: "johndoe", Password: "doejohn", Type: TypeLogin, Match: MatchDomain}
+	err = storage.CreatePassword(user, "", password)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the password", err)
+	}
+	// the caller keeps the plaintext
+	if password.Password != "doejohn" || password.Id != "7" {
+		t.Errorf("unexpected entry: %+v", password)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// sealedArgument matches a password sealed with the cipher for the entry of the owner
+type sealedArgument struct {
+	cipher *DataCipher
+	id     string
+	owner  string
+}
+
+func (argument sealedArgument) Match(value driver.Value) bool {
+	sealed, ok := value.(string)
+	if !ok || !isSealed(sealed) {
+		return false
+	}
+	opened, err := argument.cipher.Open(columnPassword, argument.id, argument.owner, sealed)
+	return err == nil && opened == "doejohn"
+}
+
+// expectRewrap expects the update of a password column of an entry by the re-wrap, which keeps the revision
+func expectRewrap(mock sqlmock.Sqlmock, column string, id string, previous string, affected int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('keycloud.rewrap', 'on', true\\)").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("UPDATE passwds SET "+column+" ").
+		ExpectExec().WithArgs(sqlmock.AnyArg(), id, previous).WillReturnResult(sqlmock.NewResult(0, affected))
+	if affected > 0 {
+		mock.ExpectCommit()
+	} else {
+		mock.ExpectRollback()
+	}
+}
+
+func TestRewrapColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	cipher := newTestDataCipher(t, dir, "first")
+	old, _ := cipher.Seal(columnPassword, "1", "USERID", "doejohn")
+	cipher = newTestDataCipher(t, dir, "second")
+	current, _ := cipher.Seal(columnPassword, "2", "USERID", "janedoe")
+	mock.ExpectPrepare("SELECT entryid::text, uuid, passwd FROM passwds").
+		ExpectQuery().WithArgs("0", rewrapBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"entryid", "uuid", "passwd"}).
+			AddRow("1", "USERID", old).AddRow("2", "USERID", current).AddRow("3", "USERID", "legacy"))
+	expectRewrap(mock, "passwd", "1", old, 1)
+	// the third entry has been changed in the meantime
+	expectRewrap(mock, "passwd", "3", "legacy", 0)
+	shared, _ := cipher.Seal(columnSharedPassword, "2", "USERID", "shared")
+	mock.ExpectPrepare("SELECT entryid::text, uuid, sharedpasswd FROM passwds (.+) sharedpasswd IS NOT NULL").
+		ExpectQuery().WithArgs("0", rewrapBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"entryid", "uuid", "sharedpasswd"}).
+			AddRow("1", "USERID", "shared").AddRow("2", "USERID", shared))
+	expectRewrap(mock, "sharedpasswd", "1", "shared", 1)
+	mock.ExpectPrepare("SELECT uuid::text, uuid, masterpasswd FROM users").
+		ExpectQuery().WithArgs("", rewrapBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "uuid", "masterpasswd"}))
+
+	results, err := RewrapColumns(db, cipher)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when re-wrapping", err)
+	}
+	if len(results) != 3 || results[0].Rewrapped != 1 || results[0].Skipped != 1 || results[1].Rewrapped != 1 ||
+		results[2].Rewrapped != 0 {
+		t.Errorf("unexpected results: %+v %+v %+v", results[0], results[1], results[2])
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
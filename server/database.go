@@ -4,10 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/keycloud/webauthn/webauthn"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func connectDatabase() (*sql.DB, error) {
@@ -16,17 +17,16 @@ func connectDatabase() (*sql.DB, error) {
 		os.Exit(1)
 	}
 
-	// due to integer parsing
-	p, _ := strconv.Atoi(os.Getenv("POSTGRES_PORT"))
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable",
-		os.Getenv("POSTGRES_HOST"),
-		p,
-		os.Getenv("POSTGRES_USER"),
-		os.Getenv("POSTGRES_PASSWORD"),
-		os.Getenv("POSTGRES_DB"),
-	)
+	// STORAGE_BACKEND=sqlite keeps the data in the file SQLITE_PATH instead of Postgres
+	if os.Getenv("STORAGE_BACKEND") == "sqlite" {
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "keycloud.db"
+		}
+		return openSQLite(path)
+	}
 
-	db, err := sql.Open("postgres", psqlInfo)
+	db, err := sql.Open("postgres", databaseConnInfo())
 	if err != nil {
 		return nil, err
 	}
@@ -38,6 +38,19 @@ func connectDatabase() (*sql.DB, error) {
 	return db, nil
 }
 
+// databaseConnInfo is the connection string of the database, which the listener of the vault events uses as well
+func databaseConnInfo() string {
+	// due to integer parsing
+	p, _ := strconv.Atoi(os.Getenv("POSTGRES_PORT"))
+	return fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable",
+		os.Getenv("POSTGRES_HOST"),
+		p,
+		os.Getenv("POSTGRES_USER"),
+		os.Getenv("POSTGRES_PASSWORD"),
+		os.Getenv("POSTGRES_DB"),
+	)
+}
+
 func CreateUser(db *sql.DB, user *User) (err error) {
 	// begin new statement
 	tx, err := db.Begin()
@@ -50,7 +63,9 @@ func CreateUser(db *sql.DB, user *User) (err error) {
 		return err
 	}
 	// execute statement
-	user.Uuid = []byte(newUUID())
+	if len(user.Uuid) == 0 {
+		user.Uuid = []byte(newUUID())
+	}
 	_, err = stmt.Exec(user.Uuid, user.Name, user.Mail, user.MasterPassword)
 	if err != nil {
 		return err
@@ -117,40 +132,115 @@ func UpdateUser(db *sql.DB, user *User) (err error) {
 	return err
 }
 
-func CreatePassword(db *sql.DB, user *User, p *Password) (err error) {
+// passwordLastUsed and passwordUseCount select the usage of the entry by the user $1
+const passwordLastUsed = "(SELECT pu.lastused FROM passwd_usage pu WHERE pu.entryid = p.entryid AND pu.uuid = $1)"
+const passwordUseCount = "COALESCE((SELECT pu.usecount FROM passwd_usage pu WHERE pu.entryid = p.entryid AND pu.uuid = $1), 0)"
+
+// passwordColumns selects an entry together with its folder and tag ids, its revision and its owner from passwordTables,
+// queries using them have to group by p.entryid and pass the reading user as $1
+const passwordColumns = "p.entryid, p.url, p.passwd, p.username, COALESCE(p.folderid::text, ''), " +
+	"array_remove(array_agg(pt.tagid::text), NULL), p.name, p.type, p.createdate, " + passwordLastUsed + ", p.match, " +
+	"COALESCE((SELECT json_agg(json_build_object('uri', u.uri, 'match', u.match) ORDER BY u.uriid) " +
+	"FROM passwd_uris u WHERE u.entryid = p.entryid), '[]'), p.favorite, " + passwordUseCount + ", p.deletedate, " +
+	"p.expires, p.rotationdays, p.passwordchanged, COALESCE(p.collectionid::text, ''), p.revision, p.uuid"
+
+const passwordTables = "passwds p LEFT JOIN passwd_tags pt ON pt.entryid = p.entryid"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPassword(row rowScanner, extra ...interface{}) (*Password, error) {
+	psw := &Password{}
+	dest := append([]interface{}{&psw.Id, &psw.Url, &psw.Password, &psw.Username, &psw.Folder, pq.Array(&psw.Tags),
+		&psw.Name, &psw.Type, &psw.Created, &psw.LastUsed, &psw.Match, &psw.Uris,
+		&psw.Favorite, &psw.UseCount, &psw.Deleted, &psw.Expires, &psw.RotationDays, &psw.Changed,
+		&psw.Collection, &psw.Revision, &psw.Owner}, extra...)
+	err := row.Scan(dest...)
+	psw.Due = psw.dueDate()
+	return psw, err
+}
+
+func scanPasswords(rows *sql.Rows) (passwords []*Password, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		psw, err := scanPassword(rows)
+		if err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, psw)
+	}
+	return passwords, rows.Err()
+}
+
+func CreatePassword(db *sql.DB, user *User, p *Password, cipher *DataCipher) (err error) {
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	err = createPassword(tx, user, p, cipher)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// createPassword inserts the entry with its tags and uris within the transaction.
+// A sealed password is bound to the key of the entry, it is stored once the entry has been inserted.
+func createPassword(tx *sql.Tx, user *User, p *Password, cipher *DataCipher) (err error) {
+	return insertPassword(tx, user, p, cipher, "INSERT INTO passwds (uuid, url, passwd, username, folderid, name, type, match, favorite) "+
+		"VALUES ($1, $2, $3, $4, (SELECT folderid FROM folders WHERE folderid = NULLIF($5, '')::integer AND uuid = $1), $6, $7, $8, $9) "+
+		"RETURNING entryid", "UPDATE passwds SET passwd = $1 WHERE entryid = $2")
+}
+
+// insertPassword creates the entry with the query insert and stores its sealed password with the query store
+func insertPassword(tx *sql.Tx, user *User, p *Password, cipher *DataCipher, insert string, store string) (err error) {
+	password := p.Password
+	if cipher != nil {
+		password = ""
+	}
 	// prepare statement
-	stmt, err := db.Prepare("INSERT INTO passwds (uuid, url, passwd, username) VALUES ($1, $2, $3, $4)")
+	stmt, err := tx.Prepare(insert)
 	if err != nil {
 		return err
 	}
-	// execute statement
-	_, err = stmt.Exec(user.Uuid, p.Url, p.Password, p.Username)
 	// close connection and connection once query is executed
 	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid, p.Url, password, p.Username, p.Folder, p.Name, p.Type, p.Match, p.Favorite).Scan(&p.Id)
 	if err != nil {
 		return err
 	}
-	// end query
-	err = tx.Commit()
+	if cipher != nil && p.Password != "" {
+		err = storeSealedPassword(tx, store, user, p.Id, p.Password, cipher)
+		if err != nil {
+			return err
+		}
+	}
+	err = insertPasswordTags(tx, user, p.Id, p.Tags)
 	if err != nil {
 		return err
 	}
-	return err
+	return insertPasswordUris(tx, p.Id, p.Uris)
 }
 
 func QueryPassword(db *sql.DB, user *User, url string, username string) (password *Password, err error) {
+	return queryPassword(db, user, url, username, "SELECT "+passwordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.url = $2 AND p.username = $3 AND p.deletedate IS NULL GROUP BY p.entryid")
+}
+
+// queryPassword reads the entry of the user $1 with the url $2 and the username $3 with the query
+func queryPassword(db *sql.DB, user *User, url string, username string, query string) (password *Password, err error) {
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	// prepare statement
-	stmt, err := db.Prepare("SELECT entryid, url, passwd, username FROM passwds WHERE uuid = $1 AND url = $2 AND username = $3")
+	stmt, err := db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
@@ -163,42 +253,41 @@ func QueryPassword(db *sql.DB, user *User, url string, username string) (passwor
 	}
 	// close connection and connection once query is executed
 	defer stmt.Close()
-	password = &Password{}
-	err = row.Scan(&password.Id, &password.Url, &password.Password, &password.Username)
-	if err != nil {
-		return nil, err
-	}
-	return
+	return scanPassword(row)
+}
+
+// QueryPasswordCandidates preselects the entries which might match one of the registrable domains,
+// the exact matching is done by the UrlMatcher
+func QueryPasswordCandidates(db *sql.DB, user *User, domains []string) (passwords []*Password, err error) {
+	return queryPasswordCandidates(db, user, domains, "SELECT "+passwordColumns+" FROM "+passwordTables+" WHERE "+policyReadablePasswords+" "+
+		"AND p.deletedate IS NULL AND (p.match = 'regex' OR p.url ILIKE ANY($2) OR EXISTS (SELECT 1 FROM passwd_uris u "+
+		"WHERE u.entryid = p.entryid AND (u.match = 'regex' OR u.uri ILIKE ANY($2)))) GROUP BY p.entryid")
 }
 
-func QueryPasswordByUrl(db *sql.DB, user *User, url string) (passwords []*Password, err error) {
+// queryPasswordCandidates selects the entries of the user $1 matching one of the patterns $2 with the query
+func queryPasswordCandidates(db *sql.DB, user *User, domains []string, query string) (passwords []*Password, err error) {
+	patterns := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		patterns = append(patterns, likePattern(domain))
+	}
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	// prepare statement
-	stmt, err := db.Prepare("SELECT entryid, url, passwd, username FROM passwds WHERE uuid = $1 AND url = $2")
+	// prepare statement, regular expressions can not be preselected
+	stmt, err := db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	// execute statement
-	rows, err := stmt.Query(user.Uuid, url)
+	rows, err := stmt.Query(user.Uuid, pq.Array(patterns))
 	// close connection and connection once query is executed
 	defer stmt.Close()
 	if err != nil {
 		return nil, err
 	}
-	for rows.Next() {
-		psw := Password{}
-		err = rows.Scan(&psw.Id, &psw.Url, &psw.Password, &psw.Username)
-		passwords = append(passwords, &psw)
-		if err != nil {
-			return nil, err
-		}
-	}
-	defer rows.Close()
-	err = rows.Err()
+	passwords, err = scanPasswords(rows)
 	if err != nil {
 		return nil, err
 	}
@@ -237,40 +326,114 @@ func QuerySessionForUser(db *sql.DB, user *User) (token []byte, err error) {
 	return
 }
 
-func UpdatePassword(db *sql.DB, user *User, p *Password) (err error) {
+// storeSealedPassword replaces the password of the entry $2 with the one sealed for its key $1 within the transaction
+func storeSealedPassword(tx *sql.Tx, query string, user *User, id string, password string, cipher *DataCipher) error {
+	sealed, err := cipher.Seal(columnPassword, id, string(user.Uuid), password)
+	if err != nil {
+		return err
+	}
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, sealed, id)
+}
+
+// UpdatePassword changes the passwords of the entries of the user with the url, each is sealed for its own key.
+// The sealed values differ on every write, the date of the change is kept if the plaintext stays the same.
+// Shared entries are rejected with errSharedPassword, their password is changed with UpdateSharedPassword.
+func UpdatePassword(db *sql.DB, user *User, p *Password, cipher *DataCipher) (err error) {
+	return updatePassword(db, user, p, cipher, "SELECT entryid, passwd, sharedpasswd IS NOT NULL FROM passwds WHERE uuid = $1 AND url = $2 FOR UPDATE",
+		"UPDATE passwds SET passwd = $1, "+
+			"passwordchanged = CASE WHEN $3 THEN CURRENT_TIMESTAMP ELSE passwordchanged END WHERE entryid = $2")
+}
+
+// updatePassword locks the entries of the user $1 with the url $2 with the query lock, which tells whether
+// an entry is shared, and sets the password $1
+// of each entry $2 with the query update, $3 tells whether the password has been changed
+func updatePassword(db *sql.DB, user *User, p *Password, cipher *DataCipher, lock string, update string) (err error) {
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 	// prepare statement
-	stmt, err := db.Prepare("UPDATE passwds SET url = $1, passwd = $2 WHERE uuid = $3 AND url = $4")
+	stmt, err := tx.Prepare(lock)
 	if err != nil {
 		return err
 	}
-	// execute statement
-	_, err = stmt.Exec(p.Url, p.Password, user.Uuid, p.Url)
 	// close connection and connection once query is executed
 	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, p.Url)
 	if err != nil {
 		return err
 	}
-	// end query
-	err = tx.Commit()
+	var ids, passwords []string
+	for rows.Next() {
+		var id, password string
+		var shared bool
+		err = rows.Scan(&id, &password, &shared)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if shared {
+			rows.Close()
+			return errSharedPassword
+		}
+		ids = append(ids, id)
+		passwords = append(passwords, password)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	// prepare statement
+	updateStmt, err := tx.Prepare(update)
 	if err != nil {
 		return err
 	}
-	return err
+	// close connection and connection once query is executed
+	defer updateStmt.Close()
+	for i, id := range ids {
+		previous, err := cipher.Open(columnPassword, id, string(user.Uuid), passwords[i])
+		if err != nil {
+			return err
+		}
+		password, err := cipher.Seal(columnPassword, id, string(user.Uuid), p.Password)
+		if err != nil {
+			return err
+		}
+		// execute statement
+		_, err = updateStmt.Exec(password, id, previous != p.Password)
+		if err != nil {
+			return err
+		}
+	}
+	// end query
+	return tx.Commit()
 }
 
+// DeletePassword moves the password into the trash, it is purged by PurgeTrash
 func DeletePassword(db *sql.DB, url string, username string, uuid string) (err error) {
+	return deletePassword(db, url, username, uuid, "UPDATE passwds SET deletedate = CURRENT_TIMESTAMP WHERE uuid = $1 AND url = $2 AND username = $3 AND deletedate IS NULL")
+}
+
+// deletePassword trashes the entry of the user $1 with the url $2 and the username $3 with the query
+func deletePassword(db *sql.DB, url string, username string, uuid string, query string) (err error) {
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	// prepare statement
-	stmt, err := db.Prepare("DELETE FROM passwds WHERE uuid = $1 AND url = $2 AND username = $3")
+	stmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
@@ -323,13 +486,18 @@ func queryUser(db *sql.DB, identifier string, query string) (user *User, err err
 }
 
 func UpdateOrCreateSessionKeyForUser(db *sql.DB, u *User, token []byte) (err error) {
+	return updateOrCreateSessionKeyForUser(db, u, token, "INSERT INTO sessions VALUES ($1, $2) ON CONFLICT ON CONSTRAINT sessions_pk DO UPDATE SET session_token = $3 WHERE sessions.uuid = $4")
+}
+
+// updateOrCreateSessionKeyForUser stores the token of the user with the query
+func updateOrCreateSessionKeyForUser(db *sql.DB, u *User, token []byte, query string) (err error) {
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	// prepare statement
-	stmt, err := db.Prepare("INSERT INTO sessions VALUES ($1, $2) ON CONFLICT ON CONSTRAINT sessions_pk DO UPDATE SET session_token = $3 WHERE sessions.uuid = $4")
+	stmt, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
@@ -400,33 +568,126 @@ func ClearAllSessionKeys(db *sql.DB) (err error) {
 	return err
 }
 
-func QueryAllPasswords(db *sql.DB, u *User) (passwords []*Password, err error) {
+type passwordSortKey struct {
+	expression string
+	cast       string
+}
+
+// passwordSortKeys maps the sort options of the passwords listing onto the ordered expression,
+// the entry id is always used as tie breaker to keep the order stable for the cursor
+var passwordSortKeys = map[string]passwordSortKey{
+	"name":     {"lower(COALESCE(NULLIF(p.name, ''), p.url, ''))", "text"},
+	"url":      {"lower(COALESCE(p.url, ''))", "text"},
+	"created":  {"p.createdate", "timestamp"},
+	"lastused": {"COALESCE(" + passwordLastUsed + ", 'epoch'::timestamp)", "timestamp"},
+	"usecount": {passwordUseCount, "integer"},
+	"favorite": {"p.favorite", "boolean"},
+}
+
+// passwordListing holds the parts of the passwords listing which differ between the databases
+type passwordListing struct {
+	// columns select the entry and its share
+	columns  string
+	sortKeys map[string]passwordSortKey
+	// keyText converts the sort key %s into the text of the cursor
+	keyText string
+	// search matches the url, the username or the name with the pattern $%[1]d
+	search string
+	// cursor converts the parameter $n into the type of the sort key
+	cursor func(n int, cast string) string
+}
+
+var postgresPasswordListing = passwordListing{
+	columns:  passwordColumns + ", " + sharedPasswordColumns,
+	sortKeys: passwordSortKeys,
+	keyText:  "(%s)::text",
+	search:   "(p.url ILIKE $%[1]d OR p.username ILIKE $%[1]d OR p.name ILIKE $%[1]d)",
+	cursor: func(n int, cast string) string {
+		return fmt.Sprintf("$%d::%s", n, cast)
+	},
+}
+
+// likePattern escapes the wildcards of the search text for the usage in an ILIKE expression
+func likePattern(text string) string {
+	text = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+	return "%" + text + "%"
+}
+
+func QueryAllPasswords(db *sql.DB, u *User, filter PasswordFilter) (passwords []*Password, err error) {
+	return queryAllPasswords(db, u, filter, postgresPasswordListing)
+}
+
+func queryAllPasswords(db *sql.DB, u *User, filter PasswordFilter, listing passwordListing) (passwords []*Password, err error) {
+	sortKey, ok := listing.sortKeys[filter.Sort]
+	if !ok {
+		sortKey = listing.sortKeys["name"]
+	}
 	// begin new statement
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
+	// entries shared with the user and of the collections the user can access are listed together with the own ones
+	query := "SELECT " + listing.columns + ", " + fmt.Sprintf(listing.keyText, sortKey.expression) + " FROM " +
+		passwordTables + sharedPasswordTables + " WHERE (" + policyReadablePasswords + " OR (p.collectionid IS NULL AND s.recipient IS NOT NULL)) " +
+		"AND p.deletedate IS NULL"
+	args := []interface{}{u.Uuid}
+	if filter.Folder == RootFolder {
+		query += " AND p.folderid IS NULL"
+	} else if filter.Folder != "" {
+		args = append(args, filter.Folder)
+		query += fmt.Sprintf(" AND p.folderid = $%d", len(args))
+	}
+	if filter.Tag != "" {
+		args = append(args, filter.Tag)
+		query += fmt.Sprintf(" AND EXISTS (SELECT 1 FROM passwd_tags f WHERE f.entryid = p.entryid AND f.tagid = $%d)", len(args))
+	}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		query += fmt.Sprintf(" AND p.type = $%d", len(args))
+	}
+	if filter.Favorite {
+		query += " AND p.favorite"
+	}
+	if filter.Query != "" {
+		args = append(args, likePattern(filter.Query))
+		query += " AND " + fmt.Sprintf(listing.search, len(args))
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.After != nil {
+		args = append(args, filter.After.Key, filter.After.Id)
+		query += fmt.Sprintf(" AND ((%s), p.entryid) %s (%s, %s)", sortKey.expression, comparison,
+			listing.cursor(len(args)-1, sortKey.cast), listing.cursor(len(args), "integer"))
+	}
+	query += fmt.Sprintf(" GROUP BY p.entryid, s.shareid, o.uuid ORDER BY (%s) %s, p.entryid %s", sortKey.expression, direction, direction)
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
 	// prepare statement
-	stmt, err := db.Prepare("SELECT entryid, url, passwd, username FROM passwds WHERE uuid = $1")
+	stmt, err := db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	// execute statement
-	rows, err := stmt.Query(u.Uuid)
+	rows, err := stmt.Query(args...)
 	// close connection and connection once query is executed
 	defer stmt.Close()
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
-		psw := Password{}
-		err = rows.Scan(&psw.Id, &psw.Url, &psw.Password, &psw.Username)
-		passwords = append(passwords, &psw)
+		var key string
+		psw, err := scanSharedPassword(rows, &key)
 		if err != nil {
 			return nil, err
 		}
+		psw.Cursor = &PasswordCursor{Sort: filter.Sort, Descending: filter.Descending, Key: key, Id: psw.Id}
+		passwords = append(passwords, psw)
 	}
-	defer rows.Close()
 	err = rows.Err()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// emergencyContactColumns selects a contact from emergency_contacts e with the names of grantor g and grantee r
+const emergencyContactColumns = "e.contactid, g.name, r.name, e.access, e.waitdays, e.status, e.requestdate, " +
+	"e.requestdate + e.waitdays * interval '1 day'"
+
+// emergencyWrappedKey only reveals the wrapped vault key to the grantee $1 once access has been granted
+const emergencyWrappedKey = "CASE WHEN e.grantee = $1 AND e.status = 'granted' THEN e.wrappedkey ELSE '' END"
+
+const emergencyContactTables = "emergency_contacts e JOIN users g ON g.uuid = e.grantor JOIN users r ON r.uuid = e.grantee"
+
+func scanEmergencyContact(row rowScanner, extra ...interface{}) (*EmergencyContact, error) {
+	contact := &EmergencyContact{}
+	dest := append([]interface{}{&contact.Id, &contact.Grantor, &contact.Grantee, &contact.Access, &contact.WaitDays,
+		&contact.Status, &contact.Requested, &contact.GrantsAt}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// QueryEmergencyContacts returns the contacts named by the user and those naming the user as contact
+func QueryEmergencyContacts(db *sql.DB, user *User) (contacts []*EmergencyContact, err error) {
+	return queryEmergencyContacts(db, user, "SELECT "+emergencyContactColumns+", "+emergencyWrappedKey+" FROM "+
+		emergencyContactTables+" WHERE e.grantor = $1 OR e.grantee = $1 ORDER BY e.contactid")
+}
+
+// queryEmergencyContacts reads the contacts of the user $1 with the query
+func queryEmergencyContacts(db *sql.DB, user *User, query string) (contacts []*EmergencyContact, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var wrappedKey string
+		contact, err := scanEmergencyContact(rows, &wrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		contact.WrappedKey = wrappedKey
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
+
+func QueryEmergencyContact(db *sql.DB, user *User, id string) (contact *EmergencyContact, err error) {
+	return queryEmergencyContact(db, user, id, "SELECT "+emergencyContactColumns+", "+emergencyWrappedKey+" FROM "+
+		emergencyContactTables+" WHERE e.contactid::text = $2 AND (e.grantor = $1 OR e.grantee = $1)")
+}
+
+// queryEmergencyContact reads the contact $2 of the user $1 with the query
+func queryEmergencyContact(db *sql.DB, user *User, id string, query string) (contact *EmergencyContact, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	var wrappedKey string
+	contact, err = scanEmergencyContact(stmt.QueryRow(user.Uuid, id), &wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	contact.WrappedKey = wrappedKey
+	return contact, nil
+}
+
+// CreateEmergencyContact names the user contact.Grantee as emergency contact of the user
+func CreateEmergencyContact(db *sql.DB, user *User, contact *EmergencyContact) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("INSERT INTO emergency_contacts (grantor, grantee, access, waitdays, wrappedkey) " +
+		"SELECT $1, uuid, $2, $3, $4 FROM users WHERE name = $5 RETURNING contactid")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid, contact.Access, contact.WaitDays, contact.WrappedKey, contact.Grantee).Scan(&contact.Id)
+	if err != nil {
+		return err
+	}
+	contact.Grantor, contact.Status = user.Name, EmergencyInvited
+	return nil
+}
+
+// UpdateEmergencyContact changes access and waiting period of a contact named by the user, an empty key keeps the existing one
+func UpdateEmergencyContact(db *sql.DB, user *User, contact *EmergencyContact) (err error) {
+	return updateEmergencyContact(db, user, contact, "UPDATE emergency_contacts SET access = $1, waitdays = $2, "+
+		"wrappedkey = COALESCE(NULLIF($3, ''), wrappedkey) WHERE contactid::text = $4 AND grantor = $5")
+}
+
+// updateEmergencyContact changes the contact with the query
+func updateEmergencyContact(db *sql.DB, user *User, contact *EmergencyContact, query string) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, contact.Access, contact.WaitDays, contact.WrappedKey, contact.Id, user.Uuid)
+}
+
+func DeleteEmergencyContact(db *sql.DB, user *User, id string) (err error) {
+	return deleteEmergencyContact(db, user, id, "DELETE FROM emergency_contacts WHERE contactid::text = $1 AND (grantor = $2 OR grantee = $2)")
+}
+
+// deleteEmergencyContact deletes the contact $1 of the user $2 with the query
+func deleteEmergencyContact(db *sql.DB, user *User, id string, query string) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, id, user.Uuid)
+}
+
+// updateEmergencyStatus applies a change of state, set is the assignment and condition the expected state of the contact
+func updateEmergencyStatus(db *sql.DB, set string, condition string, args ...interface{}) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("UPDATE emergency_contacts SET " + set + " WHERE contactid::text = $1 AND " + condition)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, args...)
+}
+
+func AcceptEmergencyContact(db *sql.DB, user *User, id string) (err error) {
+	return updateEmergencyStatus(db, "status = 'accepted'", "grantee = $2 AND status = 'invited'", id, user.Uuid)
+}
+
+// RequestEmergencyAccess starts the waiting period, without waiting period access is granted at once
+func RequestEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateEmergencyStatus(db, "status = CASE WHEN waitdays = 0 THEN 'granted' ELSE 'requested' END, "+
+		"requestdate = CURRENT_TIMESTAMP", "grantee = $2 AND status = 'accepted'", id, user.Uuid)
+}
+
+func ApproveEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateEmergencyStatus(db, "status = 'granted'", "grantor = $2 AND status = 'requested'", id, user.Uuid)
+}
+
+// RejectEmergencyAccess rejects a pending request or ends granted access
+func RejectEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateEmergencyStatus(db, "status = 'accepted', requestdate = NULL",
+		"grantor = $2 AND status IN ('requested', 'granted')", id, user.Uuid)
+}
+
+// GrantDueEmergencyAccess grants the requests whose waiting period is over and returns them
+func GrantDueEmergencyAccess(db *sql.DB) (contacts []*EmergencyContact, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("WITH granted AS (UPDATE emergency_contacts SET status = 'granted' WHERE status = 'requested' " +
+		"AND requestdate + waitdays * interval '1 day' <= CURRENT_TIMESTAMP RETURNING *) " +
+		"SELECT " + emergencyContactColumns + " FROM granted e JOIN users g ON g.uuid = e.grantor JOIN users r ON r.uuid = e.grantee")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		contact, err := scanEmergencyContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// QueryEmergencyPasswords returns the personal entries of the grantor of a contact which granted access to the user
+func QueryEmergencyPasswords(db *sql.DB, user *User, id string) (passwords []*Password, err error) {
+	return queryEmergencyPasswords(db, user, id, "SELECT "+passwordColumns+" FROM "+passwordTables+
+		" JOIN emergency_contacts e ON e.grantor = p.uuid WHERE e.grantee = $1 AND e.contactid::text = $2 "+
+		"AND e.status = 'granted' AND p.collectionid IS NULL AND p.deletedate IS NULL GROUP BY p.entryid ORDER BY p.entryid")
+}
+
+// queryEmergencyPasswords reads the entries the contact $2 grants the user $1 with the query
+func queryEmergencyPasswords(db *sql.DB, user *User, id string, query string) (passwords []*Password, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, id)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	passwords, err = scanPasswords(rows)
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type EmergencyContactRequest struct {
+	Id         string `json:"id"`
+	Username   string `json:"username"`
+	Access     string `json:"access"`
+	WaitDays   int    `json:"waitdays"`
+	WrappedKey string `json:"wrappedkey"`
+}
+
+// defaultEmergencyWaitDays is the waiting period of contacts created without one
+const defaultEmergencyWaitDays = 7
+
+func (handler CRUDHandler) GetEmergencyContacts(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contacts, err := handler.storage.GetEmergencyContacts(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, contacts)
+}
+
+func (handler CRUDHandler) CreateEmergencyContact(writer http.ResponseWriter, request *http.Request) {
+	handler.saveEmergencyContact(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateEmergencyContact(writer http.ResponseWriter, request *http.Request) {
+	handler.saveEmergencyContact(writer, request, false)
+}
+
+func (handler CRUDHandler) saveEmergencyContact(writer http.ResponseWriter, request *http.Request, create bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	contactMsg := EmergencyContactRequest{Access: EmergencyView, WaitDays: defaultEmergencyWaitDays}
+	err = json.Unmarshal(b, &contactMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if contactMsg.Access != EmergencyView && contactMsg.Access != EmergencyTakeover {
+		http.Error(writer, "unknown access "+contactMsg.Access, http.StatusBadRequest)
+		return
+	}
+	if contactMsg.WaitDays < 0 {
+		http.Error(writer, "waitdays must not be negative", http.StatusBadRequest)
+		return
+	}
+	if create && (contactMsg.Username == "" || contactMsg.WrappedKey == "") {
+		http.Error(writer, "username and wrappedkey are required", http.StatusBadRequest)
+		return
+	}
+	contact := &EmergencyContact{
+		Id:         contactMsg.Id,
+		Grantee:    contactMsg.Username,
+		Access:     contactMsg.Access,
+		WaitDays:   contactMsg.WaitDays,
+		WrappedKey: contactMsg.WrappedKey,
+	}
+	if create {
+		err = handler.storage.CreateEmergencyContact(user, contact)
+	} else {
+		err = handler.storage.UpdateEmergencyContact(user, contact)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(writer, "404 - Not Found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+// RemoveEmergencyContact deletes a contact, grantor and grantee may both end it
+func (handler CRUDHandler) RemoveEmergencyContact(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var contactMsg EmergencyContactRequest
+	err = json.Unmarshal(b, &contactMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeleteEmergencyContact(user, contactMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// AcceptEmergencyContact is called by the grantee to agree to be a contact
+func (handler CRUDHandler) AcceptEmergencyContact(writer http.ResponseWriter, request *http.Request) {
+	handler.changeEmergencyStatus(writer, request, handler.storage.AcceptEmergencyContact)
+}
+
+// RequestEmergencyAccess is called by the grantee and starts the waiting period
+func (handler CRUDHandler) RequestEmergencyAccess(writer http.ResponseWriter, request *http.Request) {
+	handler.changeEmergencyStatus(writer, request, handler.storage.RequestEmergencyAccess)
+}
+
+// ApproveEmergencyAccess is called by the grantor to grant a request before the waiting period is over
+func (handler CRUDHandler) ApproveEmergencyAccess(writer http.ResponseWriter, request *http.Request) {
+	handler.changeEmergencyStatus(writer, request, handler.storage.ApproveEmergencyAccess)
+}
+
+// RejectEmergencyAccess is called by the grantor to reject a request or to end granted access
+func (handler CRUDHandler) RejectEmergencyAccess(writer http.ResponseWriter, request *http.Request) {
+	handler.changeEmergencyStatus(writer, request, handler.storage.RejectEmergencyAccess)
+}
+
+// changeEmergencyStatus applies the change to the contact and notifies the other side about its new state
+func (handler CRUDHandler) changeEmergencyStatus(writer http.ResponseWriter, request *http.Request, change func(*User, string) error) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var contactMsg EmergencyContactRequest
+	err = json.Unmarshal(b, &contactMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = change(user, contactMsg.Id)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "409 - Emergency contact not found or in another state - ", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contact, err := handler.storage.GetEmergencyContact(user, contactMsg.Id)
+	if err == nil {
+		err = notifyEmergency(handler.storage, handler.notifier, contact, user)
+	}
+	if err != nil {
+		// the change is done, a failed notification must not revert it
+		fmt.Println("Unable to notify about emergency contact", contactMsg.Id, err)
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// GetEmergencyPasswords lists the personal entries of the grantor to a grantee with granted access
+func (handler CRUDHandler) GetEmergencyPasswords(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := request.URL.Query().Get("id")
+	contact, err := handler.storage.GetEmergencyContact(user, id)
+	if err == nil && (contact.Grantee != user.Name || contact.Status != EmergencyGranted) {
+		err = errForbidden
+	}
+	if err == sql.ErrNoRows {
+		err = errForbidden
+	}
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	passwords, err := handler.storage.GetEmergencyPasswords(user, id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, passwords)
+}
+
+// notifyEmergency tells the users of the contact except the acting one about its current state,
+// a nil actor notifies grantor and grantee
+func notifyEmergency(storage StorageInterface, notifier Notifier, contact *EmergencyContact, actor *User) error {
+	event := contact.Status
+	switch {
+	case contact.Status == EmergencyAccepted && actor != nil && actor.Name == contact.Grantor:
+		event = "rejected"
+	case contact.Status == EmergencyGranted && actor != nil && actor.Name == contact.Grantor:
+		event = "approved"
+	}
+	for _, name := range []string{contact.Grantor, contact.Grantee} {
+		if actor != nil && actor.Name == name {
+			continue
+		}
+		user, err := storage.GetUserByName(name)
+		if err != nil {
+			return err
+		}
+		err = notifier.NotifyEmergency(user, &EmergencyNotice{
+			User:     user.Name,
+			Mail:     user.Mail,
+			Created:  time.Now(),
+			Event:    event,
+			Grantor:  contact.Grantor,
+			Grantee:  contact.Grantee,
+			GrantsAt: contact.GrantsAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grantEmergencyAccess grants the requests whose waiting period is over and notifies grantor and grantee
+func grantEmergencyAccess(storage StorageInterface, notifier Notifier) error {
+	contacts, err := storage.GrantDueEmergencyAccess()
+	if err != nil {
+		return err
+	}
+	// access is granted anyway, a failed notification must not keep the others from being notified
+	var failed error
+	for _, contact := range contacts {
+		err = notifyEmergency(storage, notifier, contact, nil)
+		if err != nil {
+			failed = errors.New("notifying about emergency contact " + contact.Id + ": " + err.Error())
+		}
+	}
+	return failed
+}
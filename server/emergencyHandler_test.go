@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var emergencyContactColumnNames = []string{"contactid", "grantor", "grantee", "access", "waitdays", "status", "requestdate", "grantsat"}
+
+func TestCRUDHandler_GetEmergencyPasswordsWhileRequested(t *testing.T) {
+	req, err := http.NewRequest("GET", "/emergency-access/passwords?id=5", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM emergency_contacts e").
+		ExpectQuery().WithArgs([]byte("USERID"), "5").
+		WillReturnRows(sqlmock.NewRows(append(emergencyContactColumnNames, "wrappedkey")).
+			AddRow("5", "jane", "john", "view", 7, "requested", passwordCreated, passwordCreated.AddDate(0, 0, 7), ""))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetEmergencyPasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGrantEmergencyAccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "emergency")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("WITH granted AS \\(UPDATE emergency_contacts SET status = 'granted'").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows(emergencyContactColumnNames).
+			AddRow("5", "jane", "john", "takeover", 7, "granted", passwordCreated, passwordCreated.AddDate(0, 0, 7)))
+	mock.ExpectCommit()
+	for _, name := range []string{"jane", "john"} {
+		mock.ExpectBegin()
+		mock.ExpectPrepare("SELECT (.+) FROM users WHERE name").
+			ExpectQuery().WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+				AddRow(strings.ToUpper(name)+"ID", name, name+"@doe.com", "password"))
+		mock.ExpectCommit()
+	}
+
+	path := filepath.Join(dir, "notices.log")
+	err = grantEmergencyAccess(&Storage{database: db}, NewFileNotifier(path))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when granting emergency access", err)
+	}
+
+	// grantor and grantee are both notified
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the notices", err)
+	}
+	notices := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(notices) != 2 || !strings.Contains(notices[0], `"mail":"jane@doe.com"`) ||
+		!strings.Contains(notices[1], `"event":"granted","grantor":"jane","grantee":"john"`) {
+		t.Errorf("unexpected notices: %s", content)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
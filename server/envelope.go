@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// envelopeVersion is the current version of the envelope format
+const envelopeVersion = "v1"
+
+// envelopeFormat describes how the parts of an envelope are joined, binary parts are base64url encoded without padding
+const envelopeFormat = "v1.<algorithm>.<keyid>.<nonce>.<ciphertext>.<mac>"
+
+// Envelope is a value encrypted by the client. The server never decrypts it, it only checks that it is well-formed.
+type Envelope struct {
+	Algorithm  *EnvelopeAlgorithm
+	KeyId      string
+	Nonce      []byte
+	Ciphertext []byte
+	Mac        []byte
+}
+
+// Status of an algorithm: clients encrypt with the preferred one and re-encrypt deprecated values over time
+const (
+	AlgorithmPreferred  = "preferred"
+	AlgorithmSupported  = "supported"
+	AlgorithmDeprecated = "deprecated"
+)
+
+// EnvelopeAlgorithm lists the sizes of the parts of an envelope, BlockSize is set for block cipher modes with padding
+type EnvelopeAlgorithm struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+	NonceSize   int    `json:"noncesize"`
+	MacSize     int    `json:"macsize"`
+	BlockSize   int    `json:"blocksize,omitempty"`
+	Status      string `json:"status"`
+}
+
+var envelopeAlgorithms = []*EnvelopeAlgorithm{
+	{Id: "A256GCM", Description: "AES-256-GCM", NonceSize: 12, MacSize: 16, Status: AlgorithmPreferred},
+	{Id: "XC20P", Description: "XChaCha20-Poly1305", NonceSize: 24, MacSize: 16, Status: AlgorithmSupported},
+	{Id: "A256CBC-HS256", Description: "AES-256-CBC with HMAC-SHA256 over key id, nonce and ciphertext", NonceSize: 16,
+		MacSize: 32, BlockSize: 16, Status: AlgorithmDeprecated},
+}
+
+// envelopeFields are the fields of an entry which have to be envelopes. Notes are the text of note entries,
+// they are kept and checked in the password.
+var envelopeFields = []string{"password", "username", "notes"}
+
+var envelopeKeyId = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errNotEnvelope = errors.New("expected " + envelopeFormat)
+
+// ParseEnvelope splits the value into the parts of an envelope and checks them against the algorithm
+func ParseEnvelope(value string) (*Envelope, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 6 {
+		return nil, errNotEnvelope
+	}
+	if parts[0] != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %s", parts[0])
+	}
+	envelope := &Envelope{KeyId: parts[2]}
+	for _, algorithm := range envelopeAlgorithms {
+		if algorithm.Id == parts[1] {
+			envelope.Algorithm = algorithm
+		}
+	}
+	if envelope.Algorithm == nil {
+		return nil, fmt.Errorf("unsupported algorithm %s", parts[1])
+	}
+	if !envelopeKeyId.MatchString(envelope.KeyId) {
+		return nil, errors.New("invalid key id")
+	}
+	var err error
+	envelope.Nonce, err = base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || len(envelope.Nonce) != envelope.Algorithm.NonceSize {
+		return nil, fmt.Errorf("%s needs a nonce of %d bytes", envelope.Algorithm.Id, envelope.Algorithm.NonceSize)
+	}
+	envelope.Ciphertext, err = base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, errors.New("invalid ciphertext")
+	}
+	if size := envelope.Algorithm.BlockSize; size > 0 && (len(envelope.Ciphertext) == 0 || len(envelope.Ciphertext)%size != 0) {
+		return nil, fmt.Errorf("%s needs a ciphertext of whole %d byte blocks", envelope.Algorithm.Id, size)
+	}
+	envelope.Mac, err = base64.RawURLEncoding.DecodeString(parts[5])
+	if err != nil || len(envelope.Mac) != envelope.Algorithm.MacSize {
+		return nil, fmt.Errorf("%s needs a mac of %d bytes", envelope.Algorithm.Id, envelope.Algorithm.MacSize)
+	}
+	return envelope, nil
+}
+
+// isEnvelope reports whether the value is a well-formed envelope
+func isEnvelope(value string) bool {
+	_, err := ParseEnvelope(value)
+	return err == nil
+}
+
+// checkEnvelope rejects a value of the field which is not an envelope, empty values and disabled enforcement pass
+func (handler CRUDHandler) checkEnvelope(field string, value string) error {
+	if !handler.requireEnvelopes || value == "" {
+		return nil
+	}
+	_, err := ParseEnvelope(value)
+	if err != nil {
+		return fmt.Errorf("%s is not a ciphertext envelope: %v", field, err)
+	}
+	return nil
+}
+
+// checkEntryEnvelopes checks the encrypted fields of an entry
+func (handler CRUDHandler) checkEntryEnvelopes(entry *Password) error {
+	err := handler.checkEnvelope("password", entry.Password)
+	if err != nil {
+		return err
+	}
+	return handler.checkEnvelope("username", entry.Username)
+}
+
+// GetEnvelopeAlgorithms describes the envelope format and the algorithms the server accepts
+func (handler CRUDHandler) GetEnvelopeAlgorithms(writer http.ResponseWriter, request *http.Request) {
+	algorithmsJson, err := json.Marshal(struct {
+		Format     string               `json:"format"`
+		Fields     []string             `json:"fields"`
+		Enforced   bool                 `json:"enforced"`
+		Algorithms []*EnvelopeAlgorithm `json:"algorithms"`
+	}{envelopeFormat, envelopeFields, handler.requireEnvelopes, envelopeAlgorithms})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprint(writer, string(algorithmsJson))
+}
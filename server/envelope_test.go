@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// testEnvelope and testUsernameEnvelope are well-formed A256GCM envelopes
+const testEnvelope = "v1.A256GCM.k1.AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw"
+const testUsernameEnvelope = "v1.A256GCM.k1.AQIDBAUGBwgJCgsM.am9obmRvZQ.ICEiIyQlJicoKSorLC0uLw"
+
+func TestParseEnvelope(t *testing.T) {
+	envelope, err := ParseEnvelope(testEnvelope)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the envelope", err)
+	}
+	if envelope.Algorithm.Id != "A256GCM" || envelope.KeyId != "k1" || string(envelope.Ciphertext) != "doejohn" {
+		t.Errorf("unexpected envelope: %+v", envelope)
+	}
+	valid := []string{
+		"v1.XC20P.k1.AAECAwQFBgcICQoLDA0ODxAREhMUFRYX.eA.AAAAAAAAAAAAAAAAAAAAAA",
+		"v1.A256CBC-HS256.key_2.AAAAAAAAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+	}
+	for _, value := range valid {
+		if _, err := ParseEnvelope(value); err != nil {
+			t.Errorf("envelope %s was rejected: %v", value, err)
+		}
+	}
+	malformed := []string{
+		"password",
+		"v2.A256GCM.k1.AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw",
+		"v1.A128GCM.k1.AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw",
+		"v1.A256GCM.k.1.AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw",
+		"v1.A256GCM..AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw",
+		"v1.A256GCM.k1.AAECAwQFBgcICQ.ZG9lam9obg.EBESExQVFhcYGRobHB0eHw",
+		"v1.A256GCM.k1.AAECAwQFBgcICQoL.ZG9lam9obg==.EBESExQVFhcYGRobHB0eHw",
+		"v1.A256GCM.k1.AAECAwQFBgcICQoL.ZG9lam9obg.EBESExQVFhcYGRobHB0e",
+		// a block cipher needs whole blocks
+		"v1.A256CBC-HS256.k1.AAAAAAAAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+	}
+	for _, value := range malformed {
+		if _, err := ParseEnvelope(value); err == nil {
+			t.Errorf("malformed envelope %s was accepted", value)
+		}
+	}
+}
+
+func TestFilterImportEnvelopes(t *testing.T) {
+	handler := CRUDHandler{requireEnvelopes: true}
+	plain := &ImportedEntry{Record: 2, Name: "Mail", Entry: &Password{Username: testUsernameEnvelope, Password: "secret"}}
+	encrypted := &ImportedEntry{Record: 3, Entry: &Password{Username: testUsernameEnvelope, Password: testEnvelope}}
+	entries, skipped := handler.filterImportEnvelopes([]*ImportedEntry{plain, encrypted}, nil)
+	if !reflect.DeepEqual(entries, []*ImportedEntry{encrypted}) {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if len(skipped) != 1 || skipped[0].Record != 2 || skipped[0].Name != "Mail" {
+		t.Errorf("unexpected skipped records: %+v", skipped)
+	}
+}
+
+func TestCRUDHandler_CreatePasswordWithoutEnvelope(t *testing.T) {
+	req, err := http.NewRequest("POST", "/password", bytes.NewBuffer([]byte(
+		`{"username": "`+testUsernameEnvelope+`", "password": "doejohn", "url": "john.doe"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+	crudHandler.requireEnvelopes = true
+	defer func() { crudHandler.requireEnvelopes = false }()
+
+	// the plaintext password is not written
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.CreatePassword)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFilterImportDuplicatesEnvelopes(t *testing.T) {
+	existing := []*Password{{Url: "john.doe", Username: testUsernameEnvelope, Type: TypeLogin}}
+	login := &ImportedEntry{Record: 2, Entry: &Password{Url: "john.doe", Username: "johndoe", Type: TypeLogin}}
+	encrypted := &ImportedEntry{Record: 3, Entry: &Password{Url: "john.doe", Username: testUsernameEnvelope, Type: TypeLogin}}
+	again := &ImportedEntry{Record: 4, Entry: &Password{Url: "john.doe", Username: testUsernameEnvelope, Type: TypeLogin}}
+	// the encrypted usernames neither match the plaintext nor each other
+	entries, duplicates := filterImportDuplicates([]*ImportedEntry{login, encrypted, again}, existing)
+	if !reflect.DeepEqual(entries, []*ImportedEntry{login, encrypted, again}) {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("unexpected duplicates: %+v", duplicates)
+	}
+}
+
+func TestCRUDHandler_ExportVaultCsvWithEnvelopes(t *testing.T) {
+	req, err := http.NewRequest("GET", "/export?format=csv&confirm=plaintext", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set(masterPasswordHeader, "password")
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+	crudHandler.requireEnvelopes = true
+	defer func() { crudHandler.requireEnvelopes = false }()
+
+	// the csv would only contain the ciphertext, nothing is read
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ExportVault)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
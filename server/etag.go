@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errPreconditionRequired rejects changes of an entry without an If-Match header
+var errPreconditionRequired = errors.New("the If-Match header with the ETag of the entry is required")
+
+// staleVersionError rejects changes based on another version than the current one of the entry
+type staleVersionError struct {
+	current int64
+}
+
+func (err *staleVersionError) Error() string {
+	return "the entry has been changed, its current version is " + entryETag(err.current)
+}
+
+// entryETag is the strong ETag of the revision of an entry
+func entryETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// matchETag tells whether the list of ETags of an If-Match or If-None-Match header contains the ETag.
+// The strong comparison of If-Match never matches weak ETags, * matches every version.
+func matchETag(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntryVersion checks the If-Match header of a change against the current revision of the entry
+func checkEntryVersion(ifMatch string, revision int64) error {
+	if strings.TrimSpace(ifMatch) == "" {
+		return errPreconditionRequired
+	}
+	if !matchETag(ifMatch, entryETag(revision), false) {
+		return &staleVersionError{current: revision}
+	}
+	return nil
+}
+
+// listingETag is the strong ETag of a listing. Listings combine the entries of several owners with the usage of the
+// reading user, which does not advance the revisions, so the ETag is the hash of the answer.
+func listingETag(listingJson []byte) string {
+	sum := sha256.Sum256(listingJson)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// sendListing answers with the listing as json and its ETag, or with 304 if it matches the If-None-Match header
+func sendListing(writer http.ResponseWriter, request *http.Request, listing interface{}) {
+	listingJson, err := json.Marshal(listing)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if checkETagNotModified(writer, request, listingETag(listingJson)) {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = writer.Write(listingJson)
+}
+
+// checkNotModified sets the ETag of the entry and answers with 304 if it matches the If-None-Match header
+func checkNotModified(writer http.ResponseWriter, request *http.Request, revision int64) bool {
+	return checkETagNotModified(writer, request, entryETag(revision))
+}
+
+// checkETagNotModified sets the ETag and answers with 304 if it matches the If-None-Match header
+func checkETagNotModified(writer http.ResponseWriter, request *http.Request, etag string) bool {
+	writer.Header().Set("ETag", etag)
+	ifNoneMatch := request.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !matchETag(ifNoneMatch, etag, true) {
+		return false
+	}
+	writer.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// sendStaleVersion answers with 412 and the current version of the entry, which the client merges its change with
+func sendStaleVersion(writer http.ResponseWriter, err *staleVersionError) {
+	responseJson, jsonErr := json.Marshal(struct {
+		Status  string
+		Error   string
+		Version string
+	}{
+		Status:  "PRECONDITION FAILED",
+		Error:   err.Error(),
+		Version: entryETag(err.current),
+	})
+	if jsonErr != nil {
+		http.Error(writer, jsonErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("ETag", entryETag(err.current))
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusPreconditionFailed)
+	_, _ = fmt.Fprint(writer, string(responseJson))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckEntryVersion(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		err     error
+	}{
+		{`"7"`, nil},
+		{`"3", "7"`, nil},
+		{`*`, nil},
+		{``, errPreconditionRequired},
+		{`"3"`, &staleVersionError{current: 7}},
+		// If-Match uses the strong comparison
+		{`W/"7"`, &staleVersionError{current: 7}},
+	}
+	for _, test := range tests {
+		err := checkEntryVersion(test.ifMatch, 7)
+		if err != test.err && (err == nil || test.err == nil || err.Error() != test.err.Error()) {
+			t.Errorf("unexpected result of %s: got %v want %v", test.ifMatch, err, test.err)
+		}
+	}
+}
+
+func TestCRUDHandler_GetPasswordNotModified(t *testing.T) {
+	req, err := http.NewRequest("GET", "/password?url=john.doe&username=johndoe", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set("If-None-Match", `W/"0"`)
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	prepareDBForPasswordRequest(mock, false)
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetPassword)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotModified)
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"0"` {
+		t.Errorf("handler returned unexpected ETag: got %v want %v", etag, `"0"`)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_GetPasswordsNotModified(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	prepareDBForPasswordRequest(mock, true)
+	prepareDBForPasswordRequest(mock, true)
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	// the second request sends the ETag of the first listing, which has not changed since
+	var etag string
+	for _, status := range []int{http.StatusOK, http.StatusNotModified} {
+		req, err := http.NewRequest("GET", "/passwords", nil)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when creating a request", err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if req.Form == nil {
+			req.Form = make(map[string][]string)
+		}
+		req.Form.Add("UserId", string("USERID"))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(crudHandler.GetPasswords)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, status)
+		}
+		if rr.Header().Get("ETag") == "" || (etag != "" && rr.Header().Get("ETag") != etag) {
+			t.Errorf("handler returned unexpected ETag: got %v want %v", rr.Header().Get("ETag"), etag)
+		}
+		if status == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+		}
+		etag = rr.Header().Get("ETag")
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestListingETag(t *testing.T) {
+	first := listingETag([]byte(`[{"id":"1","revision":41,"lastused":"2020-05-01T12:00:00Z"}]`))
+	// the usage of the reader changes the listing without advancing the revision
+	used := listingETag([]byte(`[{"id":"1","revision":41,"lastused":"2020-05-02T12:00:00Z"}]`))
+	if first == used || first != listingETag([]byte(`[{"id":"1","revision":41,"lastused":"2020-05-01T12:00:00Z"}]`)) {
+		t.Errorf("unexpected ETags %s and %s", first, used)
+	}
+}
+
+func TestCRUDHandler_MovePasswordStaleVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifMatch  string
+		status   int
+		expected string
+	}{
+		{"stale", `"5"`, http.StatusPreconditionFailed,
+			`{"Status":"PRECONDITION FAILED","Error":"the entry has been changed, its current version is \"7\"","Version":"\"7\""}`},
+		{"missing", "", http.StatusPreconditionRequired, errPreconditionRequired.Error() + "\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "/password/folder", bytes.NewBuffer([]byte(`{"id": "3", "folder": "2"}`)))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when creating a request", err)
+			}
+			if test.ifMatch != "" {
+				req.Header.Set("If-Match", test.ifMatch)
+			}
+			if req.Form == nil {
+				req.Form = make(map[string][]string)
+			}
+			req.Form.Add("UserId", string("USERID"))
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectPrepare("SELECT (.+) FROM users").
+				ExpectQuery().WithArgs("USERID").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+					AddRow("USERID", "john", "@", "password"))
+			mock.ExpectCommit()
+			expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+
+			// Set global values to mocked one
+			initFromDatabaseAndRouter(db)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(crudHandler.MovePassword)
+			handler.ServeHTTP(rr, req)
+			if status := rr.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, test.status)
+			}
+
+			// Check the response body is what we expect.
+			if rr.Body.String() != test.expected {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), test.expected)
+			}
+
+			// we make sure that all expectations were met
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
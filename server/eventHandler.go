@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type EventHandler struct {
+	hub *EventHub
+	// heartbeat is the interval of the keep-alive messages, proxies close streams which stay idle for too long
+	heartbeat time.Duration
+	// allowedOrigins may open WebSockets besides the own host, e.g. chrome-extension://<id> of the plugin
+	allowedOrigins []string
+}
+
+// webSocketWriteTimeout limits writing a frame to a WebSocket client which stopped reading
+const webSocketWriteTimeout = 10 * time.Second
+
+// GetEvents streams the changes of the vault of the user as server-sent events,
+// or as JSON text messages if the client upgrades the connection to a WebSocket.
+// Events are only pushed while the client is connected, it syncs after connecting to catch up.
+func (handler *EventHandler) GetEvents(writer http.ResponseWriter, request *http.Request) {
+	user := request.Form.Get("UserId")
+	if isWebSocketUpgrade(request) {
+		handler.streamWebSocket(writer, request, user)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events := handler.hub.Subscribe(user)
+	defer handler.hub.Unsubscribe(user, events)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-store")
+	// keeps reverse proxies from buffering the stream
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(writer, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(handler.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				// the client fell behind, it reconnects and syncs
+				return
+			}
+			eventJson, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", event.name(), eventJson)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			_, err := fmt.Fprint(writer, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// streamWebSocket pushes the events as text messages, the heartbeat is sent as ping
+func (handler *EventHandler) streamWebSocket(writer http.ResponseWriter, request *http.Request, user string) {
+	conn, readWriter, err := upgradeWebSocket(writer, request, handler.allowedOrigins)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	events := handler.hub.Subscribe(user)
+	defer handler.hub.Unsubscribe(user, events)
+
+	// the frames of the client are read apart, all frames are written by this loop
+	pings := make(chan []byte, 1)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			opcode, payload, err := readWebSocketFrame(readWriter.Reader)
+			if err != nil || opcode == opClose {
+				return
+			}
+			if opcode == opPing {
+				select {
+				case pings <- payload:
+				default:
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(handler.heartbeat)
+	defer ticker.Stop()
+	for {
+		var opcode byte
+		var payload []byte
+		select {
+		case <-closed:
+			_ = conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			_ = writeWebSocketFrame(readWriter.Writer, opClose, nil)
+			return
+		case event, ok := <-events:
+			if !ok {
+				// the client fell behind, it reconnects and syncs
+				opcode = opClose
+				break
+			}
+			eventJson, err := json.Marshal(struct {
+				Event string `json:"event"`
+				*VaultEvent
+			}{event.name(), event})
+			if err != nil {
+				return
+			}
+			opcode, payload = opText, eventJson
+		case payload = <-pings:
+			opcode = opPong
+		case <-ticker.C:
+			opcode = opPing
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+		err = writeWebSocketFrame(readWriter.Writer, opcode, payload)
+		if err != nil || opcode == opClose {
+			return
+		}
+	}
+}
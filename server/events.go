@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"sync"
+	"time"
+)
+
+// vaultEventsChannel is notified by the triggers of the schema for every change which advances the revision of a vault
+const vaultEventsChannel = "vault_events"
+
+// eventBufferSize is the number of events a subscriber may fall behind before it is dropped
+const eventBufferSize = 64
+
+// Types of the VaultEvents
+const (
+	EventEntry  = "entry"
+	EventFolder = "folder"
+	EventTag    = "tag"
+	// EventVault asks the clients to sync, as events may have been lost
+	EventVault = "vault"
+)
+
+// VaultEvent notifies the clients of a user of a change of their vault.
+// Entries are created, updated, trashed, restored and deleted, folders and tags created, updated and deleted.
+type VaultEvent struct {
+	Type     string `json:"type"`
+	Action   string `json:"action"`
+	Id       string `json:"id,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+// name is the name of the event in the event stream, e.g. entry.updated
+func (event *VaultEvent) name() string {
+	return event.Type + "." + event.Action
+}
+
+// vaultNotification is the payload of a notification on vaultEventsChannel
+type vaultNotification struct {
+	User string `json:"user"`
+	VaultEvent
+}
+
+// EventHub passes the events of the vaults to the streams of their users connected to this server
+type EventHub struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan *VaultEvent]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[string]map[chan *VaultEvent]bool)}
+}
+
+// Subscribe returns a channel receiving the events of the user, it is closed if the subscriber falls behind
+func (hub *EventHub) Subscribe(user string) chan *VaultEvent {
+	events := make(chan *VaultEvent, eventBufferSize)
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.subscribers[user] == nil {
+		hub.subscribers[user] = make(map[chan *VaultEvent]bool)
+	}
+	hub.subscribers[user][events] = true
+	return events
+}
+
+// Unsubscribe removes and closes the channel unless it has already been dropped
+func (hub *EventHub) Unsubscribe(user string, events chan *VaultEvent) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.remove(user, events)
+}
+
+func (hub *EventHub) remove(user string, events chan *VaultEvent) {
+	if !hub.subscribers[user][events] {
+		return
+	}
+	delete(hub.subscribers[user], events)
+	if len(hub.subscribers[user]) == 0 {
+		delete(hub.subscribers, user)
+	}
+	close(events)
+}
+
+// Publish passes the event to the subscribers of the user without blocking,
+// a subscriber which cannot take it is dropped and syncs once it has reconnected
+func (hub *EventHub) Publish(user string, event *VaultEvent) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for events := range hub.subscribers[user] {
+		select {
+		case events <- event:
+		default:
+			hub.remove(user, events)
+		}
+	}
+}
+
+// Broadcast publishes the event to the subscribers of all users
+func (hub *EventHub) Broadcast(event *VaultEvent) {
+	hub.mutex.Lock()
+	users := make([]string, 0, len(hub.subscribers))
+	for user := range hub.subscribers {
+		users = append(users, user)
+	}
+	hub.mutex.Unlock()
+	for _, user := range users {
+		hub.Publish(user, event)
+	}
+}
+
+// dispatch publishes the payload of a notification on vaultEventsChannel
+func (hub *EventHub) dispatch(payload string) error {
+	var notification vaultNotification
+	err := json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		return err
+	}
+	if notification.User == "" || notification.Type == "" || notification.Action == "" {
+		return errors.New("incomplete vault event " + payload)
+	}
+	event := notification.VaultEvent
+	hub.Publish(notification.User, &event)
+	return nil
+}
+
+// listenVaultEvents publishes the changes committed through any server to the subscribers connected to this one
+func listenVaultEvents(connInfo string, hub *EventHub) error {
+	listener := pq.NewListener(connInfo, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("Vault events listener:", err)
+		}
+	})
+	err := listener.Listen(vaultEventsChannel)
+	if err != nil {
+		_ = listener.Close()
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case notification := <-listener.Notify:
+				if notification == nil {
+					// the connection has been re-established, notifications may have been lost meanwhile
+					hub.Broadcast(&VaultEvent{Type: EventVault, Action: "resync"})
+					continue
+				}
+				err := hub.dispatch(notification.Extra)
+				if err != nil {
+					fmt.Println("Vault events listener:", err)
+				}
+			case <-time.After(90 * time.Second):
+				// detects a broken connection while no changes are made
+				go func() {
+					_ = listener.Ping()
+				}()
+			}
+		}
+	}()
+	return nil
+}
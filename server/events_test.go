@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+	john := hub.Subscribe("john")
+	jane := hub.Subscribe("jane")
+
+	err := hub.dispatch(`{"user": "john", "type": "entry", "action": "updated", "id": "3", "revision": 42}`)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when dispatching", err)
+	}
+	select {
+	case event := <-john:
+		if *event != (VaultEvent{Type: EventEntry, Action: "updated", Id: "3", Revision: 42}) {
+			t.Errorf("unexpected event %+v", event)
+		}
+	default:
+		t.Errorf("the event of john was not published")
+	}
+	if len(jane) != 0 {
+		t.Errorf("the event of john was published to jane")
+	}
+	if err := hub.dispatch(`{"type": "entry"}`); err == nil {
+		t.Errorf("an incomplete event was dispatched")
+	}
+
+	// a subscriber which falls behind is dropped
+	for i := 0; i <= eventBufferSize; i++ {
+		hub.Publish("jane", &VaultEvent{Type: EventTag, Action: "created"})
+	}
+	for range jane {
+	}
+	hub.Unsubscribe("jane", jane)
+	hub.Unsubscribe("john", john)
+	if len(hub.subscribers) != 0 {
+		t.Errorf("unexpected subscribers %v", hub.subscribers)
+	}
+}
+
+// newEventServer serves the events of USERID like the cookie middleware
+func newEventServer(handler *EventHandler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		request.Form = map[string][]string{"UserId": {"USERID"}}
+		handler.GetEvents(writer, request)
+	}))
+}
+
+// waitForSubscriber waits until the stream of the request has subscribed to the hub
+func waitForSubscriber(t *testing.T, hub *EventHub) {
+	for i := 0; i < 100; i++ {
+		hub.mutex.Lock()
+		subscribed := len(hub.subscribers["USERID"]) > 0
+		hub.mutex.Unlock()
+		if subscribed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the stream did not subscribe")
+}
+
+func TestEventHandler_GetEvents(t *testing.T) {
+	handler := &EventHandler{hub: NewEventHub(), heartbeat: time.Hour}
+	server := newEventServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the stream", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("handler returned unexpected content type: got %v want text/event-stream", contentType)
+	}
+	waitForSubscriber(t, handler.hub)
+	handler.hub.Publish("USERID", &VaultEvent{Type: EventEntry, Action: "created", Id: "7", Revision: 43})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 5 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when reading the stream", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	expected := "retry: 5000||event: entry.created|data: {\"type\":\"entry\",\"action\":\"created\",\"id\":\"7\",\"revision\":43}|"
+	if strings.Join(lines, "|") != expected {
+		t.Errorf("handler returned unexpected stream: got %v want %v", strings.Join(lines, "|"), expected)
+	}
+}
+
+func TestEventHandler_WebSocket(t *testing.T) {
+	handler := &EventHandler{hub: NewEventHub(), heartbeat: time.Hour}
+	server := newEventServer(handler)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when connecting", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /events HTTP/1.1\r\nHost: " + strings.TrimPrefix(server.URL, "http://") + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nOrigin: " + server.URL + "\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sending the handshake", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the handshake", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// the example of RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("handler returned unexpected accept key: got %v", accept)
+	}
+
+	waitForSubscriber(t, handler.hub)
+	handler.hub.Publish("USERID", &VaultEvent{Type: EventFolder, Action: "deleted", Id: "2", Revision: 44})
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != 0x80|opText {
+		t.Fatalf("unexpected frame header %x, %v", header, err)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the frame", err)
+	}
+	expected := `{"event":"folder.deleted","type":"folder","action":"deleted","id":"2","revision":44}`
+	if string(payload) != expected {
+		t.Errorf("handler returned unexpected message: got %v want %v", string(payload), expected)
+	}
+
+	// a masked close frame of the client is answered with a close frame
+	_, err = conn.Write([]byte{0x80 | opClose, 0x80, 1, 2, 3, 4})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when closing", err)
+	}
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != 0x80|opClose {
+		t.Errorf("unexpected frame header %x, %v", header, err)
+	}
+}
+
+func TestCheckWebSocketOrigin(t *testing.T) {
+	request := httptest.NewRequest("GET", "http://keycloud.example/events", nil)
+	allowed := []string{"chrome-extension://plugin"}
+	for origin, expected := range map[string]bool{
+		"":                          true,
+		"https://keycloud.example":  true,
+		"chrome-extension://plugin": true,
+		"https://evil.example":      false,
+	} {
+		request.Header.Set("Origin", origin)
+		if checkWebSocketOrigin(request, allowed) != expected {
+			t.Errorf("unexpected result for the origin %s: want %v", origin, expected)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// passwordDueDate is the earlier of the expiry date and the date the next rotation is due, see Password.dueDate
+const passwordDueDate = "LEAST(p.expires, CASE WHEN p.rotationdays > 0 " +
+	"THEN COALESCE(p.passwordchanged, p.createdate) + p.rotationdays * interval '1 day' END)"
+
+func UpdatePasswordExpiry(db *sql.DB, user *User, id string, expires *time.Time, rotationDays int) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE passwds SET expires = $1, rotationdays = $2 WHERE entryid = $3 AND uuid = $4")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, expires, rotationDays, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// RecordPasswordRotation restarts the rotation interval of the entry
+func RecordPasswordRotation(db *sql.DB, user *User, id string) (err error) {
+	return recordPasswordRotation(db, user, id, "UPDATE passwds SET passwordchanged = CURRENT_TIMESTAMP WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL")
+}
+
+// recordPasswordRotation restarts the rotation of the entry $1 of the user $2 with the query
+func recordPasswordRotation(db *sql.DB, user *User, id string, query string) (err error) {
+	// prepare statement, a single update does not need an explicit transaction
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, id, user.Uuid)
+}
+
+// QueryDuePasswords returns the entries which expire or have to be rotated before the given time, most urgent first
+func QueryDuePasswords(db *sql.DB, user *User, before time.Time) (passwords []*Password, err error) {
+	return queryDuePasswords(db, user, before, "SELECT "+passwordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.deletedate IS NULL AND "+passwordDueDate+" <= $2 "+
+		"GROUP BY p.entryid ORDER BY "+passwordDueDate+", p.entryid")
+}
+
+// queryDuePasswords reads the entries of the user $1 due before $2 with the query
+func queryDuePasswords(db *sql.DB, user *User, before time.Time, query string) (passwords []*Password, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, before)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	passwords, err = scanPasswords(rows)
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// QueryUsersToRemind returns the users with entries due before the given time who have not been reminded today
+func QueryUsersToRemind(db *sql.DB, before time.Time) (users []*User, err error) {
+	return queryUsersToRemind(db, before, "SELECT u.uuid, u.name, u.mail, u.masterpasswd FROM users u "+
+		"WHERE (u.reminded IS NULL OR u.reminded < CURRENT_DATE) AND EXISTS (SELECT 1 FROM passwds p "+
+		"WHERE p.uuid = u.uuid AND p.deletedate IS NULL AND "+passwordDueDate+" <= $1)")
+}
+
+// queryUsersToRemind reads the users with entries due before $1 with the query
+func queryUsersToRemind(db *sql.DB, before time.Time, query string) (users []*User, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.Uuid, &user.Name, &user.Mail, &user.MasterPassword)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func UpdateUserReminded(db *sql.DB, user *User) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("UPDATE users SET reminded = CURRENT_DATE WHERE uuid = $1")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(user.Uuid)
+	return err
+}
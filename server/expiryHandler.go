@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PasswordExpiryRequest struct {
+	Id           string     `json:"id"`
+	Expires      *time.Time `json:"expires"`
+	RotationDays int        `json:"rotationdays"`
+}
+
+type PasswordRotationRequest struct {
+	Id string `json:"id"`
+}
+
+// defaultDueDays is the number of days in which an entry counts as due soon
+const defaultDueDays = 14
+
+func (handler CRUDHandler) SetPasswordExpiry(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var expiryMsg PasswordExpiryRequest
+	err = json.Unmarshal(b, &expiryMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if expiryMsg.RotationDays < 0 {
+		http.Error(writer, "rotationdays must not be negative", http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, expiryMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordExpiry(owner, expiryMsg.Id, expiryMsg.Expires, expiryMsg.RotationDays)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// RecordPasswordRotation is called once the password of an entry has been changed at its service
+func (handler CRUDHandler) RecordPasswordRotation(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var rotationMsg PasswordRotationRequest
+	err = json.Unmarshal(b, &rotationMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntry(user, rotationMsg.Id, ActionWrite)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.RecordPasswordRotation(owner, rotationMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// GetDuePasswords lists the expired entries and those due within the next days, most urgent first
+func (handler CRUDHandler) GetDuePasswords(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days := defaultDueDays
+	if value := request.URL.Query().Get("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			http.Error(writer, "invalid days "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	passwords, err := handler.storage.GetDuePasswords(user, time.Now().AddDate(0, 0, days))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, passwords)
+}
+
+// sendReminders notifies every user with entries due within the window, each user at most once a day
+func sendReminders(storage StorageInterface, notifier Notifier, window time.Duration) error {
+	now := time.Now()
+	users, err := storage.GetUsersToRemind(now.Add(window))
+	if err != nil {
+		return err
+	}
+	// a failing user must not keep the others from being reminded
+	var failed error
+	for _, user := range users {
+		passwords, err := storage.GetDuePasswords(user, now.Add(window))
+		if err == nil {
+			err = notifier.Notify(user, newReminderDigest(user, passwords, now))
+		}
+		if err == nil {
+			err = storage.SetUserReminded(user)
+		}
+		if err != nil {
+			failed = errors.New("reminding " + user.Name + ": " + err.Error())
+		}
+	}
+	return failed
+}
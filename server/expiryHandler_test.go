@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassword_DueDate(t *testing.T) {
+	changed := passwordCreated.AddDate(0, 1, 0)
+	expires := passwordCreated.AddDate(0, 2, 0)
+	tests := []struct {
+		name     string
+		password Password
+		expected *time.Time
+	}{
+		{"nothing", Password{Created: &passwordCreated}, nil},
+		{"expiry", Password{Created: &passwordCreated, Expires: &expires}, &expires},
+		{"rotation since creation", Password{Created: &passwordCreated, RotationDays: 90}, timePointer(passwordCreated.AddDate(0, 0, 90))},
+		{"rotation since change", Password{Created: &passwordCreated, Changed: &changed, RotationDays: 10}, timePointer(changed.AddDate(0, 0, 10))},
+		{"earlier expiry", Password{Created: &passwordCreated, Expires: &expires, RotationDays: 90}, &expires},
+	}
+	for _, test := range tests {
+		due := test.password.dueDate()
+		if (due == nil) != (test.expected == nil) || (due != nil && !due.Equal(*test.expected)) {
+			t.Errorf("%s: got due date %v want %v", test.name, due, test.expected)
+		}
+	}
+}
+
+func timePointer(t time.Time) *time.Time {
+	return &t
+}
+
+func TestCRUDHandler_GetDuePasswords(t *testing.T) {
+	req, err := http.NewRequest("GET", "/passwords/due?days=7", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM passwds (.+) LEAST\\(p.expires").
+		ExpectQuery().WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 90, nil, "", 0, "USERID"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetDuePasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"password":"password","id":"1","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain","rotationdays":90,"due":"2020-07-30T12:00:00Z"}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "reminders")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock.ExpectPrepare("SELECT (.+) FROM users u WHERE \\(u.reminded IS NULL").
+		ExpectQuery().
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "john@doe.com", "password"))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM passwds").
+		ExpectQuery().WithArgs([]byte("USERID"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 90, nil, "", 0, "USERID"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("UPDATE users SET reminded").
+		ExpectExec().WithArgs([]byte("USERID")).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	path := filepath.Join(dir, "reminders.log")
+	err = sendReminders(&Storage{database: db}, NewFileNotifier(path), 14*24*time.Hour)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sending reminders", err)
+	}
+
+	// the digest must not contain the password itself
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the digests", err)
+	}
+	digest := string(content)
+	if !strings.Contains(digest, `"expired":[{"id":"1","url":"john.doe","username":"johndoe","due":"2020-07-30T12:00:00Z"}]`) ||
+		strings.Contains(digest, `"password"`) {
+		t.Errorf("unexpected digest: %s", digest)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
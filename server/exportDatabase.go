@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+)
+
+func QueryExports(db *sql.DB, user *User) (exports []*ExportRecord, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT exportid, format, entries, address, useragent, createdate FROM exports " +
+		"WHERE uuid = $1 ORDER BY createdate DESC, exportid DESC")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record := &ExportRecord{}
+		err = rows.Scan(&record.Id, &record.Format, &record.Entries, &record.Address, &record.UserAgent, &record.Created)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, record)
+	}
+	return exports, rows.Err()
+}
+
+func RecordExport(db *sql.DB, user *User, record *ExportRecord) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("INSERT INTO exports (uuid, format, entries, address, useragent) VALUES ($1, $2, $3, $4, $5) " +
+		"RETURNING exportid, createdate")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return stmt.QueryRow(user.Uuid, record.Format, record.Entries, record.Address, record.UserAgent).Scan(&record.Id, &record.Created)
+}
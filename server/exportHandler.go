@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// masterPasswordHeader re-authenticates the user for an export
+const masterPasswordHeader = "X-Master-Password"
+
+// exportPasswordHeader carries the password the json and KDBX exports are encrypted with
+const exportPasswordHeader = "X-Export-Password"
+
+const minExportPasswordLength = 8
+
+var exportContentTypes = map[string]string{
+	ExportJson: "application/json",
+	ExportCsv:  "text/csv; charset=utf-8",
+	ExportKdbx: "application/octet-stream",
+}
+
+// ExportVault hands out the entries owned by the user as encrypted json, KDBX 4 or, once confirmed, as plain csv.
+// The master password has to be given again and every export is recorded before it is handed out.
+func (handler CRUDHandler) ExportVault(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	masterPassword := []byte(request.Header.Get(masterPasswordHeader))
+	if len(user.MasterPassword) == 0 || subtle.ConstantTimeCompare(masterPassword, user.MasterPassword) != 1 {
+		http.Error(writer, "re-authentication with the master password is required", http.StatusUnauthorized)
+		return
+	}
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = ExportJson
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(writer, "unknown export format "+format, http.StatusBadRequest)
+		return
+	}
+	// the server can not decrypt the envelopes, other applications could not read them from csv and KDBX files
+	if handler.requireEnvelopes && format != ExportJson {
+		http.Error(writer, "while envelopes are enforced the clients create csv and kdbx exports from the json export", http.StatusBadRequest)
+		return
+	}
+	password := request.Header.Get(exportPasswordHeader)
+	if format == ExportCsv && request.URL.Query().Get("confirm") != "plaintext" {
+		http.Error(writer, "plain csv exports have to be confirmed with confirm=plaintext", http.StatusBadRequest)
+		return
+	}
+	if format != ExportCsv && len(password) < minExportPasswordLength {
+		http.Error(writer, fmt.Sprintf("the export password needs at least %d characters", minExportPasswordLength), http.StatusBadRequest)
+		return
+	}
+	vault, err := handler.loadExportVault(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var export []byte
+	switch format {
+	case ExportJson:
+		export, err = encryptExport(vault, password)
+	case ExportCsv:
+		export, err = exportCsv(vault)
+	default:
+		export, err = exportKdbx(vault, password)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	address := request.Header.Get("X-Real-IP")
+	if address == "" {
+		address = request.RemoteAddr
+	}
+	err = handler.storage.RecordExport(user, &ExportRecord{
+		Format:    format,
+		Entries:   len(vault.Passwords),
+		Address:   address,
+		UserAgent: request.UserAgent(),
+	})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"keycloud-%s.%s\"",
+		vault.Exported.Format("20060102"), format))
+	writer.Header().Set("Cache-Control", "no-store")
+	_, _ = writer.Write(export)
+}
+
+// loadExportVault collects the entries owned by the user, see ownVaultEntry
+func (handler CRUDHandler) loadExportVault(user *User) (*ExportVault, error) {
+	passwords, err := handler.storage.GetPasswords(user, PasswordFilter{})
+	if err != nil {
+		return nil, err
+	}
+	vault := &ExportVault{Exported: time.Now().UTC(), User: user.Name, Passwords: make([]*Password, 0)}
+	for _, password := range passwords {
+		if ownVaultEntry(password) {
+			vault.Passwords = append(vault.Passwords, password)
+		}
+	}
+	vault.Folders, err = handler.storage.GetFolders(user)
+	if err != nil {
+		return nil, err
+	}
+	vault.Tags, err = handler.storage.GetTags(user)
+	if err != nil {
+		return nil, err
+	}
+	return vault, nil
+}
+
+// ownVaultEntry reports whether the entry is in the personal vault of the user, the entries the user shares with others
+// are own entries as well, entries shared with the user or kept in the collections of an organization are not
+func ownVaultEntry(password *Password) bool {
+	return (password.Shared == nil || password.Shared.Permission == PermissionOwner) && password.Collection == ""
+}
+
+// GetExports lists the audit trail of the exports of the user, the latest first
+func (handler CRUDHandler) GetExports(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	exports, err := handler.storage.GetExports(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, exports)
+}
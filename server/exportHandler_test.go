@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var exportVault = &ExportVault{
+	User:    "john",
+	Folders: []*Folder{{Id: "1", Name: "work"}, {Id: "2", Name: "servers", Parent: "1"}},
+	Tags:    []*Tag{{Id: "3", Name: "mail"}},
+	Passwords: []*Password{
+		{Id: "4", Name: "Mail", Url: "https://mail.john.doe", Username: "johndoe", Password: "s<cr>et", Folder: "2", Tags: []string{"3"}},
+		{Id: "5", Name: "Recovery codes", Type: TypeNote, Password: "1234 5678"},
+	},
+}
+
+func TestEncryptExport(t *testing.T) {
+	data, err := encryptExport(exportVault, "correct horse")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when encrypting the export", err)
+	}
+	var export EncryptedExport
+	err = json.Unmarshal(data, &export)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the export", err)
+	}
+	if export.Format != "keycloud-export" || export.Kdf.Algorithm != "pbkdf2-sha256" || export.Cipher.Algorithm != "aes-256-gcm" {
+		t.Errorf("unexpected envelope: %+v", export)
+	}
+	block, _ := aes.NewCipher(pbkdf2Key([]byte("correct horse"), export.Kdf.Salt, export.Kdf.Iterations, 32))
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, export.Cipher.Nonce, export.Data, nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when decrypting the export", err)
+	}
+	var vault ExportVault
+	_ = json.Unmarshal(plaintext, &vault)
+	if !reflect.DeepEqual(vault.Passwords, exportVault.Passwords) {
+		t.Errorf("unexpected passwords: got %+v want %+v", vault.Passwords, exportVault.Passwords)
+	}
+}
+
+func TestPbkdf2Key(t *testing.T) {
+	// test vector of RFC 7914
+	expected := []byte{0x55, 0xac, 0x04, 0x6e, 0x56, 0xe3, 0x08, 0x9f, 0xec, 0x16, 0x91, 0xc2, 0x25, 0x44, 0xb6, 0x05,
+		0xf9, 0x41, 0x85, 0x21, 0x6d, 0xde, 0x04, 0x65, 0xe6, 0x8b, 0x9d, 0x57, 0xc2, 0x0d, 0xac, 0xbc,
+		0x49, 0xca, 0x9c, 0xcc, 0xf1, 0x79, 0xb6, 0x45, 0x99, 0x16, 0x64, 0xb3, 0x9d, 0x77, 0xef, 0x31,
+		0x7c, 0x71, 0xb8, 0x45, 0xb1, 0xe3, 0x0b, 0xd5, 0x09, 0x11, 0x20, 0x41, 0xd3, 0xa1, 0x97, 0x83}
+	if key := pbkdf2Key([]byte("passwd"), []byte("salt"), 1, 64); !bytes.Equal(key, expected) {
+		t.Errorf("unexpected key: got %x want %x", key, expected)
+	}
+}
+
+// readKdbx checks the header and the blocks of a KDBX 4 file and returns the decrypted xml
+func readKdbx(t *testing.T, data []byte, password string) []byte {
+	if !bytes.HasPrefix(data, kdbxSignature) {
+		t.Fatalf("unexpected signature %x", data[:12])
+	}
+	fields := make(map[byte][]byte)
+	position := len(kdbxSignature)
+	for {
+		id, size := data[position], int(binary.LittleEndian.Uint32(data[position+1:]))
+		fields[id] = data[position+5 : position+5+size]
+		position += 5 + size
+		if id == kdbxEndOfHeader {
+			break
+		}
+	}
+	header := data[:position]
+	if hash := sha256.Sum256(header); !bytes.Equal(hash[:], data[position:position+32]) {
+		t.Fatalf("header hash does not match")
+	}
+	if !bytes.Equal(fields[kdbxKdfParameters], kdbxKdfDictionary(fields[kdbxKdfParameters][60:92])) {
+		t.Fatalf("unexpected kdf parameters %x", fields[kdbxKdfParameters])
+	}
+	transformedKey, _ := kdbxTransformKey(password, fields[kdbxKdfParameters][60:92], kdbxRounds)
+	seed := fields[kdbxMasterSeed]
+	encryptionKey := sha256.Sum256(append(append([]byte{}, seed...), transformedKey...))
+	hmacKey := sha512.Sum512(append(append(append([]byte{}, seed...), transformedKey...), 0x01))
+	mac := hmac.New(sha256.New, kdbxBlockKey(hmacKey[:], ^uint64(0)))
+	mac.Write(header)
+	if !hmac.Equal(mac.Sum(nil), data[position+32:position+64]) {
+		t.Fatalf("header hmac does not match")
+	}
+	position += 64
+	var ciphertext []byte
+	for index := uint64(0); ; index++ {
+		size := int(binary.LittleEndian.Uint32(data[position+32:]))
+		indexBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(indexBytes, index)
+		mac = hmac.New(sha256.New, kdbxBlockKey(hmacKey[:], index))
+		mac.Write(indexBytes)
+		mac.Write(data[position+32 : position+36+size])
+		if !hmac.Equal(mac.Sum(nil), data[position:position+32]) {
+			t.Fatalf("hmac of block %d does not match", index)
+		}
+		ciphertext = append(ciphertext, data[position+36:position+36+size]...)
+		position += 36 + size
+		if size == 0 {
+			break
+		}
+	}
+	block, _ := aes.NewCipher(encryptionKey[:])
+	cipher.NewCBCDecrypter(block, fields[kdbxEncryptionIv]).CryptBlocks(ciphertext, ciphertext)
+	payload := ciphertext[:len(ciphertext)-int(ciphertext[len(ciphertext)-1])]
+	// skip the inner header
+	for {
+		id, size := payload[0], int(binary.LittleEndian.Uint32(payload[1:]))
+		payload = payload[5+size:]
+		if id == kdbxEndOfHeader {
+			return payload
+		}
+	}
+}
+
+func TestExportKdbx(t *testing.T) {
+	data, err := exportKdbx(exportVault, "correct horse")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when writing the database", err)
+	}
+	// the import reads the database back
+	entries, skipped, err := parseKeePassXml(readKdbx(t, data, "correct horse"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the database", err)
+	}
+	if len(entries) != 2 || len(skipped) != 0 {
+		t.Fatalf("unexpected number of entries and skipped records: got %v and %v want 2 and 0", len(entries), len(skipped))
+	}
+	if note := entries[0]; note.Type != TypeNote || note.Entry.Password != "1234 5678" || note.Folder != "" {
+		t.Errorf("unexpected note: %+v in folder %s", note.Entry, note.Folder)
+	}
+	if login := entries[1]; login.Entry.Password != "s<cr>et" || login.Folder != "work/servers" || !reflect.DeepEqual(login.Tags, []string{"mail"}) {
+		t.Errorf("unexpected login: %+v in folder %s with tags %v", login.Entry, login.Folder, login.Tags)
+	}
+}
+
+func TestCRUDHandler_ExportVaultWithoutMasterPassword(t *testing.T) {
+	req, err := http.NewRequest("GET", "/export?format=csv&confirm=plaintext", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set(masterPasswordHeader, "wrong")
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	// neither the passwords are read nor an export is recorded
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ExportVault)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_ExportVaultCsv(t *testing.T) {
+	req, err := http.NewRequest("GET", "/export?format=csv&confirm=plaintext", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set(masterPasswordHeader, "password")
+	req.Header.Set("X-Real-IP", "203.0.113.7")
+	req.Header.Set("User-Agent", "KeyCloud")
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	prepareDBForPasswordRequest(mock, true)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM folders").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"folderid", "name", "parentid"}))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM tags").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"tagid", "name"}))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("INSERT INTO exports").
+		ExpectQuery().WithArgs([]byte("USERID"), "csv", 1, "203.0.113.7", "KeyCloud").
+		WillReturnRows(sqlmock.NewRows([]string{"exportid", "createdate"}).AddRow("1", passwordCreated))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ExportVault)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := "name,url,username,password,type,folder,tags,favorite\n,john.doe,johndoe,password,login,,,false\n"
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("handler allowed the export to be cached")
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestOwnVaultEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		password *Password
+		own      bool
+	}{
+		{"personal", &Password{}, true},
+		{"shared by the user", &Password{Shared: &PasswordShare{Permission: PermissionOwner}}, true},
+		{"shared with the user", &Password{Shared: &PasswordShare{Owner: "jane", Permission: PermissionEdit}}, false},
+		{"collection", &Password{Collection: "2"}, false},
+	}
+	for _, test := range tests {
+		if own := ownVaultEntry(test.password); own != test.own {
+			t.Errorf("%s: got %v want %v", test.name, own, test.own)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportVault is the content of an export: the entries owned by the user with their folders and tags
+type ExportVault struct {
+	Exported  time.Time   `json:"exported"`
+	User      string      `json:"user"`
+	Folders   []*Folder   `json:"folders"`
+	Tags      []*Tag      `json:"tags"`
+	Passwords []*Password `json:"passwords"`
+}
+
+// Formats of an export
+const (
+	ExportJson = "json"
+	ExportCsv  = "csv"
+	ExportKdbx = "kdbx"
+)
+
+// exportIterations is the PBKDF2 work factor of the encrypted json export
+const exportIterations = 600000
+
+// EncryptedExport is the documented envelope of the encrypted json export.
+// The data is the json of the ExportVault sealed with AES-256-GCM, the key is derived from the export password.
+type EncryptedExport struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Kdf     struct {
+		Algorithm  string `json:"algorithm"`
+		Iterations int    `json:"iterations"`
+		Salt       []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Algorithm string `json:"algorithm"`
+		Nonce     []byte `json:"nonce"`
+	} `json:"cipher"`
+	Data []byte `json:"data"`
+}
+
+// encryptExport seals the vault with a key derived from the password
+func encryptExport(vault *ExportVault, password string) ([]byte, error) {
+	plaintext, err := json.Marshal(vault)
+	if err != nil {
+		return nil, err
+	}
+	export := &EncryptedExport{Format: "keycloud-export", Version: 1}
+	export.Kdf.Algorithm = "pbkdf2-sha256"
+	export.Kdf.Iterations = exportIterations
+	export.Kdf.Salt = make([]byte, 32)
+	_, err = rand.Read(export.Kdf.Salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2Key([]byte(password), export.Kdf.Salt, exportIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	export.Cipher.Algorithm = "aes-256-gcm"
+	export.Cipher.Nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(export.Cipher.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	export.Data = gcm.Seal(nil, export.Cipher.Nonce, plaintext, nil)
+	return json.Marshal(export)
+}
+
+// pbkdf2Key derives a key with PBKDF2-HMAC-SHA256 as specified in RFC 8018
+func pbkdf2Key(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	size := prf.Size()
+	key := make([]byte, 0, (keyLength+size-1)/size*size)
+	counter := make([]byte, 4)
+	u := make([]byte, size)
+	for block := uint32(1); len(key) < keyLength; block++ {
+		binary.BigEndian.PutUint32(counter, block)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter)
+		key = prf.Sum(key)
+		t := key[len(key)-size:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return key[:keyLength]
+}
+
+// exportCsv writes the vault unencrypted, the columns start like the csv export of Chrome
+func exportCsv(vault *ExportVault) ([]byte, error) {
+	paths := folderPaths(vault.Folders)
+	names := make(map[string]string)
+	for _, tag := range vault.Tags {
+		names[tag.Id] = tag.Name
+	}
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	_ = writer.Write([]string{"name", "url", "username", "password", "type", "folder", "tags", "favorite"})
+	for _, password := range vault.Passwords {
+		tags := make([]string, len(password.Tags))
+		for i, id := range password.Tags {
+			tags[i] = names[id]
+		}
+		_ = writer.Write([]string{password.Name, password.Url, password.Username, password.Password, password.Type,
+			paths[password.Folder], strings.Join(tags, ";"), strconv.FormatBool(password.Favorite)})
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+// folderPaths maps the folder ids to their slash separated paths
+func folderPaths(folders []*Folder) map[string]string {
+	byId := make(map[string]*Folder)
+	for _, folder := range folders {
+		byId[folder.Id] = folder
+	}
+	paths := make(map[string]string)
+	for _, folder := range folders {
+		path := folder.Name
+		// the depth is bounded in case the parents form a cycle
+		for parent, depth := byId[folder.Parent], 0; parent != nil && depth < len(folders); parent, depth = byId[parent.Parent], depth+1 {
+			path = parent.Name + "/" + path
+		}
+		paths[folder.Id] = path
+	}
+	return paths
+}
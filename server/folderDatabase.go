@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// execAffectingRows executes the statement and reports sql.ErrNoRows if no row of the user was touched
+func execAffectingRows(stmt *sql.Stmt, args ...interface{}) error {
+	res, err := stmt.Exec(args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func insertPasswordTags(tx *sql.Tx, user *User, id string, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	// prepare statement, both the entry and the tag have to belong to the user
+	stmt, err := tx.Prepare("INSERT INTO passwd_tags (entryid, tagid) SELECT p.entryid, t.tagid FROM passwds p, tags t " +
+		"WHERE p.entryid = $1 AND t.tagid = $2 AND p.uuid = $3 AND t.uuid = $3")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		err = execAffectingRows(stmt, id, tag, user.Uuid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdatePasswordFolder(db *sql.DB, user *User, id string, folder string) (err error) {
+	return updatePasswordFolder(db, user, id, folder, "UPDATE passwds SET folderid = NULLIF($1, '')::integer WHERE entryid = $2 AND uuid = $3 "+
+		"AND (NULLIF($1, '') IS NULL OR EXISTS (SELECT 1 FROM folders WHERE folderid = NULLIF($1, '')::integer AND uuid = $3))")
+}
+
+// updatePasswordFolder moves the entry $2 of the user $3 into the folder $1 with the query
+func updatePasswordFolder(db *sql.DB, user *User, id string, folder string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement, an empty folder moves the entry back to the root
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, folder, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdatePasswordTags(db *sql.DB, user *User, id string, tags []string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = replacePasswordTags(tx, user, id, tags)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func replacePasswordTags(tx *sql.Tx, user *User, id string, tags []string) error {
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM passwd_tags WHERE entryid = (SELECT entryid FROM passwds WHERE entryid = $1 AND uuid = $2)")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	return insertPasswordTags(tx, user, id, tags)
+}
+
+func QueryFolders(db *sql.DB, user *User) (folders []*Folder, err error) {
+	return queryFolders(db, user, "SELECT folderid, name, COALESCE(parentid::text, '') FROM folders WHERE uuid = $1 ORDER BY name")
+}
+
+// queryFolders reads the folders of the user $1 with the query
+func queryFolders(db *sql.DB, user *User, query string) (folders []*Folder, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		folder := &Folder{}
+		err = rows.Scan(&folder.Id, &folder.Name, &folder.Parent)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func CreateFolder(db *sql.DB, user *User, folder *Folder) (err error) {
+	return createFolder(db, user, folder, "INSERT INTO folders (uuid, name, parentid) VALUES ($1, $2, NULLIF($3, '')::integer) RETURNING folderid")
+}
+
+// createFolder inserts the folder with the query, which returns its id
+func createFolder(db *sql.DB, user *User, folder *Folder, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid, folder.Name, folder.Parent).Scan(&folder.Id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdateFolder(db *sql.DB, user *User, folder *Folder) (err error) {
+	return updateFolder(db, user, folder, "UPDATE folders SET name = $1, parentid = NULLIF($2, '')::integer WHERE folderid = $3 AND uuid = $4")
+}
+
+// updateFolder renames and moves the folder with the query
+func updateFolder(db *sql.DB, user *User, folder *Folder, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, folder.Name, folder.Parent, folder.Id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteFolder(db *sql.DB, user *User, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement, entries and sub folders are moved to the root by the foreign keys
+	stmt, err := tx.Prepare("DELETE FROM folders WHERE folderid = $1 AND uuid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryTags(db *sql.DB, user *User) (tags []*Tag, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT tagid, name FROM tags WHERE uuid = $1 ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tag := &Tag{}
+		err = rows.Scan(&tag.Id, &tag.Name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func CreateTag(db *sql.DB, user *User, tag *Tag) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO tags (uuid, name) VALUES ($1, $2) RETURNING tagid")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid, tag.Name).Scan(&tag.Id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdateTag(db *sql.DB, user *User, tag *Tag) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE tags SET name = $1 WHERE tagid = $2 AND uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, tag.Name, tag.Id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteTag(db *sql.DB, user *User, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement, assignments to entries are removed by the foreign key
+	stmt, err := tx.Prepare("DELETE FROM tags WHERE tagid = $1 AND uuid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+type FolderRequest struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Parent string `json:"parent"`
+}
+
+type TagRequest struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasswordFolderRequest struct {
+	Id     string `json:"id"`
+	Folder string `json:"folder"`
+}
+
+type PasswordTagsRequest struct {
+	Id   string   `json:"id"`
+	Tags []string `json:"tags"`
+}
+
+// checkFolderParent makes sure the parent belongs to the user and that moving the folder below it creates no cycle
+func checkFolderParent(folders []*Folder, id string, parent string) error {
+	parents := make(map[string]string)
+	for _, folder := range folders {
+		parents[folder.Id] = folder.Parent
+	}
+	for current := parent; current != ""; current = parents[current] {
+		if _, ok := parents[current]; !ok {
+			return errors.New("parent folder does not exist")
+		}
+		if current == id {
+			return errors.New("folder can not be moved into itself")
+		}
+	}
+	return nil
+}
+
+func (handler CRUDHandler) GetFolders(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	folders, err := handler.storage.GetFolders(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, folders)
+}
+
+func (handler CRUDHandler) CreateFolder(writer http.ResponseWriter, request *http.Request) {
+	handler.saveFolder(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateFolder(writer http.ResponseWriter, request *http.Request) {
+	handler.saveFolder(writer, request, false)
+}
+
+func (handler CRUDHandler) saveFolder(writer http.ResponseWriter, request *http.Request, create bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var folderMsg FolderRequest
+	err = json.Unmarshal(b, &folderMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if folderMsg.Name == "" {
+		http.Error(writer, "folder name must not be empty", http.StatusBadRequest)
+		return
+	}
+	folders, err := handler.storage.GetFolders(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = checkFolderParent(folders, folderMsg.Id, folderMsg.Parent)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	folder := &Folder{
+		Id:     folderMsg.Id,
+		Name:   folderMsg.Name,
+		Parent: folderMsg.Parent,
+	}
+	if create {
+		err = handler.storage.CreateFolder(user, folder)
+	} else {
+		err = handler.storage.UpdateFolder(user, folder)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+func (handler CRUDHandler) RemoveFolder(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var folderMsg FolderRequest
+	err = json.Unmarshal(b, &folderMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeleteFolder(user, folderMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+func (handler CRUDHandler) GetTags(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags, err := handler.storage.GetTags(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, tags)
+}
+
+func (handler CRUDHandler) CreateTag(writer http.ResponseWriter, request *http.Request) {
+	handler.saveTag(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateTag(writer http.ResponseWriter, request *http.Request) {
+	handler.saveTag(writer, request, false)
+}
+
+func (handler CRUDHandler) saveTag(writer http.ResponseWriter, request *http.Request, create bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var tagMsg TagRequest
+	err = json.Unmarshal(b, &tagMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tagMsg.Name == "" {
+		http.Error(writer, "tag name must not be empty", http.StatusBadRequest)
+		return
+	}
+	tag := &Tag{
+		Id:   tagMsg.Id,
+		Name: tagMsg.Name,
+	}
+	if create {
+		err = handler.storage.CreateTag(user, tag)
+	} else {
+		err = handler.storage.UpdateTag(user, tag)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+func (handler CRUDHandler) RemoveTag(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var tagMsg TagRequest
+	err = json.Unmarshal(b, &tagMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeleteTag(user, tagMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+func (handler CRUDHandler) MovePassword(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var moveMsg PasswordFolderRequest
+	err = json.Unmarshal(b, &moveMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, moveMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordFolder(owner, moveMsg.Id, moveMsg.Folder)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+func (handler CRUDHandler) TagPassword(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var tagsMsg PasswordTagsRequest
+	err = json.Unmarshal(b, &tagsMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, tagsMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordTags(owner, tagsMsg.Id, tagsMsg.Tags)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
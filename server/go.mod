@@ -8,6 +8,8 @@ require (
 	github.com/gorilla/sessions v1.2.0
 	github.com/keycloud/webauthn v1.2.0
 	github.com/lib/pq v1.5.2
+	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
+	golang.org/x/crypto v0.0.0-20200221231518-2aa609cf4a9d
 	gopkg.in/ini.v1 v1.55.0
 )
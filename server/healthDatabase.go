@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// UpdatePasswordHealth records the fingerprints, strength scores and identifiers of the entries of the user, all or none
+func UpdatePasswordHealth(db *sql.DB, user *User, health []*EntryHealth) (err error) {
+	return updatePasswordHealth(db, user, health, "UPDATE passwds SET fingerprint = $1, strength = $2, identifier = COALESCE(NULLIF($3, ''), identifier), "+
+		"healthdate = CURRENT_TIMESTAMP WHERE entryid = $4 AND uuid = $5 AND deletedate IS NULL")
+}
+
+// updatePasswordHealth records the health of each entry with the query
+func updatePasswordHealth(db *sql.DB, user *User, health []*EntryHealth, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	for _, entry := range health {
+		err = execAffectingRows(stmt, entry.Fingerprint, entry.Strength, entry.Identifier, entry.Id, user.Uuid)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("entry %s not found", entry.Id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// end query
+	return tx.Commit()
+}
+
+// QueryHealthRecords reads the health of the logins of the user, reports made before the last change
+// of the password are outdated and left out
+func QueryHealthRecords(db *sql.DB, user *User) (records []*HealthRecord, err error) {
+	return queryHealthRecords(db, user, "SELECT p.entryid::text, "+
+		"CASE WHEN p.healthdate >= COALESCE(p.passwordchanged, p.createdate) THEN COALESCE(p.fingerprint, '') ELSE '' END, "+
+		"CASE WHEN p.healthdate >= COALESCE(p.passwordchanged, p.createdate) THEN COALESCE(p.strength, -1) ELSE -1 END, "+
+		"COALESCE(p.passwordchanged, p.createdate), p.url ILIKE 'http://%' OR EXISTS (SELECT 1 FROM passwd_uris u "+
+		"WHERE u.entryid = p.entryid AND u.uri ILIKE 'http://%') FROM passwds p "+
+		"WHERE p.uuid = $1 AND p.deletedate IS NULL AND p.type = $2 ORDER BY p.entryid")
+}
+
+// queryHealthRecords reads the health of the logins of the user $1 with the query
+func queryHealthRecords(db *sql.DB, user *User, query string) (records []*HealthRecord, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, TypeLogin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record := &HealthRecord{}
+		err = rows.Scan(&record.Id, &record.Fingerprint, &record.Strength, &record.Changed, &record.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
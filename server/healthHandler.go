@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// defaultHealthDays is the number of days after which a password which has not been changed counts as old
+const defaultHealthDays = 365
+
+// weakStrength is the highest strength score which counts as weak
+const weakStrength = 2
+
+// fingerprintPattern accepts the hex or base64 encoding of a keyed hash, e.g. an HMAC-SHA256
+var fingerprintPattern = regexp.MustCompile(`^[A-Za-z0-9+/_=-]{16,128}$`)
+
+// identifierPattern accepts the lowercase hex encoding of a SHA-256
+var identifierPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// SetPasswordHealth records the fingerprints, strength scores and identifiers the client computed for the entries
+func (handler CRUDHandler) SetPasswordHealth(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var health []*EntryHealth
+	err = json.Unmarshal(b, &health)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(health) == 0 {
+		http.Error(writer, "entries are required", http.StatusBadRequest)
+		return
+	}
+	if len(health) > handler.maxBatchSize {
+		http.Error(writer, "a report has at most "+strconv.Itoa(handler.maxBatchSize)+" entries", http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, entry := range health {
+		if !validEntryId(entry.Id) {
+			http.Error(writer, "invalid id of entry "+entry.Id, http.StatusBadRequest)
+			return
+		}
+		if !fingerprintPattern.MatchString(entry.Fingerprint) {
+			http.Error(writer, "invalid fingerprint of entry "+entry.Id, http.StatusBadRequest)
+			return
+		}
+		if entry.Strength < 0 || entry.Strength > 4 {
+			http.Error(writer, "strength of entry "+entry.Id+" must be between 0 and 4", http.StatusBadRequest)
+			return
+		}
+		if entry.Identifier != "" && !identifierPattern.MatchString(entry.Identifier) {
+			http.Error(writer, "invalid identifier of entry "+entry.Id, http.StatusBadRequest)
+			return
+		}
+	}
+	err = handler.storage.SetPasswordHealth(user, health)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// GetHealthReport reports the reused, weak, old and insecure passwords of the logins of the user
+func (handler CRUDHandler) GetHealthReport(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	days := defaultHealthDays
+	if value := request.URL.Query().Get("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 {
+			http.Error(writer, "invalid days "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	records, err := handler.storage.GetHealthRecords(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reportJson, err := json.Marshal(buildHealthReport(records, days, time.Now()))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_, _ = fmt.Fprint(writer, string(reportJson))
+}
+
+// buildHealthReport groups the logins by their issues, passwords changed before now minus days are old
+func buildHealthReport(records []*HealthRecord, days int, now time.Time) *HealthReport {
+	report := &HealthReport{
+		Entries:    len(records),
+		Days:       days,
+		Reused:     make([][]string, 0),
+		Weak:       make([]string, 0),
+		Old:        make([]string, 0),
+		Insecure:   make([]string, 0),
+		Unreported: make([]string, 0),
+	}
+	// the groups are listed in the order of their first entry
+	groups := make(map[string]int)
+	var reused [][]string
+	for _, record := range records {
+		if record.Fingerprint == "" {
+			continue
+		}
+		if group, ok := groups[record.Fingerprint]; ok {
+			reused[group] = append(reused[group], record.Id)
+			continue
+		}
+		groups[record.Fingerprint] = len(reused)
+		reused = append(reused, []string{record.Id})
+	}
+	issues := make(map[string]bool)
+	for _, group := range reused {
+		if len(group) < 2 {
+			continue
+		}
+		report.Reused = append(report.Reused, group)
+		for _, id := range group {
+			issues[id] = true
+		}
+	}
+	oldBefore := now.AddDate(0, 0, -days)
+	for _, record := range records {
+		if record.Fingerprint == "" || record.Strength < 0 {
+			report.Unreported = append(report.Unreported, record.Id)
+		} else if record.Strength <= weakStrength {
+			report.Weak = append(report.Weak, record.Id)
+			issues[record.Id] = true
+		}
+		if record.Changed.Before(oldBefore) {
+			report.Old = append(report.Old, record.Id)
+			issues[record.Id] = true
+		}
+		if record.Insecure {
+			report.Insecure = append(report.Insecure, record.Id)
+			issues[record.Id] = true
+		}
+	}
+	report.Score = 100
+	if len(records) > 0 {
+		report.Score = 100 * (len(records) - len(issues)) / len(records)
+	}
+	return report
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildHealthReport(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []*HealthRecord{
+		{Id: "1", Fingerprint: "aaaaaaaaaaaaaaaa", Strength: 4, Changed: now},
+		{Id: "2", Fingerprint: "bbbbbbbbbbbbbbbb", Strength: 1, Changed: now},
+		{Id: "3", Fingerprint: "aaaaaaaaaaaaaaaa", Strength: 4, Changed: now.AddDate(-2, 0, 0)},
+		{Id: "4", Fingerprint: "", Strength: -1, Changed: now, Insecure: true},
+		{Id: "5", Fingerprint: "cccccccccccccccc", Strength: 3, Changed: now},
+	}
+	report := buildHealthReport(records, 365, now)
+	expected := &HealthReport{
+		Score:      20,
+		Entries:    5,
+		Days:       365,
+		Reused:     [][]string{{"1", "3"}},
+		Weak:       []string{"2"},
+		Old:        []string{"3"},
+		Insecure:   []string{"4"},
+		Unreported: []string{"4"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("unexpected report: got %+v want %+v", report, expected)
+	}
+
+	if report := buildHealthReport(nil, 365, now); report.Score != 100 || report.Reused == nil {
+		t.Errorf("unexpected report of an empty vault: %+v", report)
+	}
+}
+
+func TestCRUDHandler_GetHealthReport(t *testing.T) {
+	req, err := http.NewRequest("GET", "/reports/health?days=30", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM passwds p WHERE p.uuid = (.+) AND p.type").
+		ExpectQuery().WithArgs([]byte("USERID"), "login").
+		WillReturnRows(sqlmock.NewRows([]string{"entryid", "fingerprint", "strength", "changed", "insecure"}).
+			AddRow("1", "aaaaaaaaaaaaaaaa", 4, time.Now(), false).
+			AddRow("2", "aaaaaaaaaaaaaaaa", 2, time.Now(), true))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetHealthReport)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"score":0,"entries":2,"days":30,"reused":[["1","2"]],"weak":["2"],"old":[],"insecure":["2"],"unreported":[]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_SetPasswordHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		expected string
+	}{
+		{"valid", `[{"id": "3", "fingerprint": "9f86d081884c7d659a2feaa0c55ad015", "strength": 3}]`,
+			http.StatusOK, `{"Status":"UPDATED","Error":""}`},
+		{"plaintext", `[{"id": "3", "fingerprint": "doe john", "strength": 3}]`,
+			http.StatusBadRequest, "invalid fingerprint of entry 3\n"},
+		{"strength", `[{"id": "3", "fingerprint": "9f86d081884c7d659a2feaa0c55ad015", "strength": 5}]`,
+			http.StatusBadRequest, "strength of entry 3 must be between 0 and 4\n"},
+		{"identifier", `[{"id": "3", "fingerprint": "9f86d081884c7d659a2feaa0c55ad015", "strength": 3, "identifier": "john@doe.com"}]`,
+			http.StatusBadRequest, "invalid identifier of entry 3\n"},
+		{"id", `[{"id": "3x", "fingerprint": "9f86d081884c7d659a2feaa0c55ad015", "strength": 3}]`,
+			http.StatusBadRequest, "invalid id of entry 3x\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "/passwords/health", bytes.NewBuffer([]byte(test.body)))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when creating a request", err)
+			}
+			if req.Form == nil {
+				req.Form = make(map[string][]string)
+			}
+			req.Form.Add("UserId", string("USERID"))
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectPrepare("SELECT (.+) FROM users").
+				ExpectQuery().WithArgs("USERID").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+					AddRow("USERID", "john", "@", "password"))
+			mock.ExpectCommit()
+			if test.status == http.StatusOK {
+				mock.ExpectBegin()
+				mock.ExpectPrepare("UPDATE passwds SET fingerprint").
+					ExpectExec().WithArgs("9f86d081884c7d659a2feaa0c55ad015", 3, "", "3", []byte("USERID")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			// Set global values to mocked one
+			initFromDatabaseAndRouter(db)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(crudHandler.SetPasswordHealth)
+			handler.ServeHTTP(rr, req)
+			if status := rr.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, test.status)
+			}
+
+			// Check the response body is what we expect.
+			if rr.Body.String() != test.expected {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), test.expected)
+			}
+
+			// we make sure that all expectations were met
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
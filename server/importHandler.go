@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// ImportReport lists what an import has written or, in a dry run, would write
+type ImportReport struct {
+	Format     string           `json:"format"`
+	DryRun     bool             `json:"dryrun"`
+	Entries    []*ImportedEntry `json:"entries"`
+	Folders    []string         `json:"folders"`
+	Tags       []string         `json:"tags"`
+	Duplicates []*ImportIssue   `json:"duplicates"`
+	Skipped    []*ImportIssue   `json:"skipped"`
+}
+
+// ImportPasswords reads the export of another password manager from the body, the format is given as parameter.
+// Missing folders and tags are created by name, the entries are written all-or-nothing like a batch.
+func (handler CRUDHandler) ImportPasswords(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := request.URL.Query().Get("format")
+	parse, ok := importParsers[format]
+	if !ok {
+		http.Error(writer, "unknown import format "+format, http.StatusBadRequest)
+		return
+	}
+	dryRun := request.URL.Query().Get("dryrun") == "true"
+	reveal := dryRun && request.URL.Query().Get("reveal") == "true"
+	b, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, handler.maxImportSize))
+	if err != nil {
+		http.Error(writer, "export is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	defer request.Body.Close()
+	entries, skipped, err := parse(b)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	existing, err := handler.storage.GetPasswords(user, PasswordFilter{})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries, duplicates := filterImportDuplicates(entries, existing)
+	if reveal {
+		// the client encrypts the revealed entries and writes them with a batch
+		for _, entry := range entries {
+			entry.Password = entry.Entry.Password
+		}
+	} else {
+		entries, skipped = handler.filterImportEnvelopes(entries, skipped)
+	}
+	report := &ImportReport{
+		Format:     format,
+		DryRun:     dryRun,
+		Entries:    entries,
+		Duplicates: duplicates,
+		Skipped:    skipped,
+	}
+	report.Folders, err = handler.importFolders(user, entries, dryRun)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report.Tags, err = handler.importTags(user, entries, dryRun)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !dryRun && len(entries) > 0 {
+		operations := make([]*BatchOperation, len(entries))
+		filed := make([]*Password, len(entries))
+		for i, entry := range entries {
+			operations[i] = &BatchOperation{Op: BatchCreate, Entry: entry.Entry}
+			filed[i] = entry.Entry
+		}
+		err = handler.policy.AuthorizeFiling(user, filed...)
+		if err != nil {
+			sendPolicyError(writer, err)
+			return
+		}
+		results, err := handler.storage.ApplyPasswordBatch(user, operations)
+		if err != nil {
+			// the folders and tags created so far are kept, they are found by name on the next attempt
+			for i, result := range results {
+				if result.Status == "FAILED" {
+					err = fmt.Errorf("record %d: %s", entries[i].Record, result.Error)
+				}
+			}
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	sendImportReport(writer, report)
+}
+
+// filterImportEnvelopes skips the entries whose password or username is not a ciphertext envelope
+func (handler CRUDHandler) filterImportEnvelopes(entries []*ImportedEntry, skipped []*ImportIssue) ([]*ImportedEntry, []*ImportIssue) {
+	accepted := make([]*ImportedEntry, 0, len(entries))
+	for _, entry := range entries {
+		err := handler.checkEntryEnvelopes(entry.Entry)
+		if err != nil {
+			skipped = append(skipped, &ImportIssue{Record: entry.Record, Name: entry.Name, Reason: err.Error()})
+			continue
+		}
+		accepted = append(accepted, entry)
+	}
+	return accepted, skipped
+}
+
+// importFolders finds or creates the folders of the entries by their path and returns the paths of the new ones.
+// A dry run does not create any folder.
+func (handler CRUDHandler) importFolders(user *User, entries []*ImportedEntry, dryRun bool) ([]string, error) {
+	folders, err := handler.storage.GetFolders(user)
+	if err != nil {
+		return nil, err
+	}
+	// folder ids by the id of their parent and their name
+	children := make(map[string]string)
+	for _, folder := range folders {
+		children[folder.Parent+"\x00"+folder.Name] = folder.Id
+	}
+	paths := make(map[string]string)
+	created := make([]string, 0)
+	for _, entry := range entries {
+		parent, path := "", ""
+		for _, name := range strings.Split(entry.Folder, "/") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			path += "/" + name
+			id, ok := paths[path]
+			if !ok {
+				id, ok = children[parent+"\x00"+name]
+			}
+			if !ok {
+				created = append(created, path[1:])
+				// a dry run continues with the path in place of the id
+				id = path
+				if !dryRun {
+					folder := &Folder{Name: name, Parent: parent}
+					err = handler.storage.CreateFolder(user, folder)
+					if err != nil {
+						return nil, err
+					}
+					id = folder.Id
+				}
+			}
+			paths[path] = id
+			parent = id
+		}
+		if !dryRun {
+			entry.Entry.Folder = parent
+		}
+	}
+	return created, nil
+}
+
+// importTags finds or creates the tags of the entries by their name and returns the names of the new ones.
+// A dry run does not create any tag.
+func (handler CRUDHandler) importTags(user *User, entries []*ImportedEntry, dryRun bool) ([]string, error) {
+	tags, err := handler.storage.GetTags(user)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string)
+	for _, tag := range tags {
+		ids[tag.Name] = tag.Id
+	}
+	created := make([]string, 0)
+	for _, entry := range entries {
+		entry.Entry.Tags = nil
+		for _, name := range entry.Tags {
+			id, ok := ids[name]
+			if !ok {
+				created = append(created, name)
+				id = name
+				if !dryRun {
+					tag := &Tag{Name: name}
+					err = handler.storage.CreateTag(user, tag)
+					if err != nil {
+						return nil, err
+					}
+					id = tag.Id
+				}
+				ids[name] = id
+			}
+			if !dryRun {
+				entry.Entry.Tags = append(entry.Entry.Tags, id)
+			}
+		}
+	}
+	return created, nil
+}
+
+func sendImportReport(writer http.ResponseWriter, report *ImportReport) {
+	if report.Entries == nil {
+		report.Entries = make([]*ImportedEntry, 0)
+	}
+	if report.Duplicates == nil {
+		report.Duplicates = make([]*ImportIssue, 0)
+	}
+	if report.Skipped == nil {
+		report.Skipped = make([]*ImportIssue, 0)
+	}
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprint(writer, string(reportJson))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+const bitwardenExportJson = `{"encrypted": false, "folders": [{"id": "f1", "name": "work"}], "items": [
+	{"type": 1, "name": "Mail", "folderId": "f1", "favorite": true, "login": {"username": "johndoe", "password": "secret",
+		"uris": [{"uri": "https://mail.john.doe", "match": 1}, {"uri": "https://webmail.john.doe", "match": null}]}},
+	{"type": 2, "name": "Recovery codes", "notes": "1234 5678"},
+	{"type": 5, "name": "Key"},
+	{"type": 1, "name": "Empty", "login": {}}]}`
+
+func TestParseBitwardenJson(t *testing.T) {
+	entries, skipped, err := parseBitwardenJson([]byte(bitwardenExportJson))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the export", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected number of entries: got %v want %v", len(entries), 2)
+	}
+	login := entries[0].Entry
+	if login.Url != "https://mail.john.doe" || login.Match != MatchHost || login.Username != "johndoe" ||
+		login.Password != "secret" || !login.Favorite || entries[0].Folder != "work" {
+		t.Errorf("unexpected login: %+v in folder %s", login, entries[0].Folder)
+	}
+	if len(login.Uris) != 1 || login.Uris[0].Match != MatchDomain {
+		t.Errorf("unexpected uris: %+v", login.Uris)
+	}
+	if note := entries[1].Entry; note.Type != TypeNote || note.Password != "1234 5678" {
+		t.Errorf("unexpected note: %+v", note)
+	}
+	expected := []*ImportIssue{
+		{Record: 3, Name: "Key", Reason: "unsupported item type 5"},
+		{Record: 4, Name: "Empty", Reason: "record is empty"},
+	}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("unexpected skipped records: got %+v want %+v", skipped, expected)
+	}
+	_, _, err = parseBitwardenJson([]byte(`{"encrypted": true, "items": []}`))
+	if err == nil {
+		t.Errorf("encrypted export was not rejected")
+	}
+}
+
+const keePassExportXml = `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta><RecycleBinUUID>BIN</RecycleBinUUID></Meta>
+	<Root>
+		<Group>
+			<UUID>ROOT</UUID>
+			<Name>Database</Name>
+			<Entry>
+				<Tags>mail;private</Tags>
+				<String><Key>Title</Key><Value>Mail</Value></String>
+				<String><Key>UserName</Key><Value>johndoe</Value></String>
+				<String><Key>Password</Key><Value ProtectInMemory="True">secret</Value></String>
+				<String><Key>URL</Key><Value>https://mail.john.doe</Value></String>
+				<History>
+					<Entry><String><Key>Password</Key><Value>old</Value></String></Entry>
+				</History>
+			</Entry>
+			<Group>
+				<UUID>WORK</UUID>
+				<Name>work</Name>
+				<Group>
+					<UUID>SERVERS</UUID>
+					<Name>servers</Name>
+					<Entry>
+						<String><Key>Title</Key><Value>Backup</Value></String>
+						<String><Key>Notes</Key><Value>rotate monthly</Value></String>
+					</Entry>
+				</Group>
+			</Group>
+			<Group>
+				<UUID>BIN</UUID>
+				<Name>Recycle Bin</Name>
+				<Entry><String><Key>Password</Key><Value>deleted</Value></String></Entry>
+			</Group>
+		</Group>
+	</Root>
+</KeePassFile>`
+
+func TestParseKeePassXml(t *testing.T) {
+	entries, skipped, err := parseKeePassXml([]byte(keePassExportXml))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the export", err)
+	}
+	if len(entries) != 2 || len(skipped) != 0 {
+		t.Fatalf("unexpected number of entries and skipped records: got %v and %v want 2 and 0", len(entries), len(skipped))
+	}
+	if login := entries[0]; login.Entry.Password != "secret" || login.Folder != "" || !reflect.DeepEqual(login.Tags, []string{"mail", "private"}) {
+		t.Errorf("unexpected login: %+v in folder %s with tags %v", login.Entry, login.Folder, login.Tags)
+	}
+	if note := entries[1]; note.Type != TypeNote || note.Entry.Password != "rotate monthly" || note.Folder != "work/servers" {
+		t.Errorf("unexpected note: %+v in folder %s", note.Entry, note.Folder)
+	}
+}
+
+func TestParse1Pux(t *testing.T) {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	file, err := writer.Create("export.data")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the archive", err)
+	}
+	_, _ = file.Write([]byte(`{"accounts": [{"vaults": [{"attrs": {"name": "Private"}, "items": [
+		{"favIndex": 1, "state": "active", "categoryUuid": "001",
+			"overview": {"title": "Mail", "url": "https://mail.john.doe", "tags": ["mail"]},
+			"details": {"loginFields": [{"designation": "username", "value": "johndoe"}, {"designation": "password", "value": "secret"}]}},
+		{"state": "archived", "categoryUuid": "001", "overview": {"title": "Old"}},
+		{"state": "active", "categoryUuid": "002", "overview": {"title": "Visa"}}]}]}]}`))
+	_ = writer.Close()
+
+	entries, skipped, err := parse1Pux(archive.Bytes())
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the export", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("unexpected number of entries: got %v want %v", len(entries), 1)
+	}
+	if login := entries[0]; login.Entry.Username != "johndoe" || login.Entry.Password != "secret" || !login.Entry.Favorite || login.Folder != "Private" {
+		t.Errorf("unexpected login: %+v in folder %s", login.Entry, login.Folder)
+	}
+	expected := []*ImportIssue{
+		{Record: 2, Name: "Old", Reason: "item is archived"},
+		{Record: 3, Name: "Visa", Reason: "unsupported category 002"},
+	}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("unexpected skipped records: got %+v want %+v", skipped, expected)
+	}
+}
+
+func TestParseLastPassCsv(t *testing.T) {
+	export := "url,username,password,totp,extra,name,grouping,fav\n" +
+		"https://mail.john.doe,johndoe,secret,,,Mail,Private\\Mail,1\n" +
+		"http://sn,,,,\"1234\n5678\",Recovery codes,,0\n" +
+		",,,,,Nothing,,0\n"
+	entries, skipped, err := parseLastPassCsv([]byte(export))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the export", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected number of entries: got %v want %v", len(entries), 2)
+	}
+	if login := entries[0]; !login.Entry.Favorite || login.Folder != "Private/Mail" {
+		t.Errorf("unexpected login: %+v in folder %s", login.Entry, login.Folder)
+	}
+	if note := entries[1].Entry; note.Type != TypeNote || note.Url != "" || note.Password != "1234\n5678" {
+		t.Errorf("unexpected note: %+v", note)
+	}
+	expected := []*ImportIssue{{Record: 4, Name: "Nothing", Reason: "record is empty"}}
+	if !reflect.DeepEqual(skipped, expected) {
+		t.Errorf("unexpected skipped records: got %+v want %+v", skipped, expected)
+	}
+	_, _, err = parseLastPassCsv([]byte("name,url,username,password\n"))
+	if err == nil {
+		t.Errorf("export of another format was not rejected")
+	}
+}
+
+func TestCRUDHandler_ImportPasswordsDryRun(t *testing.T) {
+	export := "url,username,password,httpRealm\n" +
+		"john.doe,johndoe,secret,\n" +
+		"https://mail.john.doe,johndoe,secret,\n" +
+		"https://mail.john.doe,johndoe,other,\n"
+	req, err := http.NewRequest("POST", "/passwords/import?format=firefox&dryrun=true&reveal=true", bytes.NewBuffer([]byte(export)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	prepareDBForPasswordRequest(mock, true)
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM folders").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"folderid", "name", "parentid"}))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM tags").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"tagid", "name"}))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	// nothing is written in a dry run, the revealed passwords are encrypted by the client
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.ImportPasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"format":"firefox","dryrun":true,` +
+		`"entries":[{"record":3,"url":"https://mail.john.doe","username":"johndoe","password":"secret","type":"login"}],"folders":[],"tags":[],` +
+		`"duplicates":[{"record":2,"reason":"duplicate of an existing entry"},{"record":4,"reason":"duplicate of record 3"}],"skipped":[]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFilterImportDuplicatesSharedEntries(t *testing.T) {
+	existing := []*Password{
+		{Url: "https://mail.john.doe", Username: "johndoe", Type: TypeLogin, Shared: &PasswordShare{Permission: PermissionOwner}},
+		{Url: "https://shop.jane.doe", Username: "johndoe", Type: TypeLogin, Shared: &PasswordShare{Owner: "jane", Permission: PermissionRead}},
+	}
+	entries := []*ImportedEntry{
+		{Record: 2, Entry: &Password{Url: "https://mail.john.doe", Username: "johndoe", Type: TypeLogin}},
+		{Record: 3, Entry: &Password{Url: "https://shop.jane.doe", Username: "johndoe", Type: TypeLogin}},
+	}
+	// the entry the user shares is an own entry, the entry shared with the user is not
+	accepted, duplicates := filterImportDuplicates(entries, existing)
+	if len(accepted) != 1 || accepted[0].Record != 3 {
+		t.Errorf("unexpected accepted entries %+v", accepted)
+	}
+	if len(duplicates) != 1 || duplicates[0].Record != 2 {
+		t.Errorf("unexpected duplicates %+v", duplicates)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ImportedEntry is an entry read from an export, Folder is the slash separated path of its folder.
+// The other exported fields preview the entry without its password.
+type ImportedEntry struct {
+	Entry    *Password `json:"-"`
+	Record   int       `json:"record"`
+	Name     string    `json:"name,omitempty"`
+	Url      string    `json:"url,omitempty"`
+	Username string    `json:"username,omitempty"`
+	// Password is only revealed in a dry run, for clients which encrypt the entries themselves
+	Password string   `json:"password,omitempty"`
+	Type     string   `json:"type"`
+	Folder   string   `json:"folder,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// ImportIssue explains why a record of an export is not imported
+type ImportIssue struct {
+	Record int    `json:"record"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// importParser reads an export into entries and the records it skipped, records are counted from 1
+type importParser func(data []byte) ([]*ImportedEntry, []*ImportIssue, error)
+
+var importParsers = map[string]importParser{
+	"bitwarden":      parseBitwardenJson,
+	"keepass":        parseKeePassXml,
+	"1password":      parse1PasswordCsv,
+	"1password-1pux": parse1Pux,
+	"lastpass":       parseLastPassCsv,
+	"chrome":         parseChromeCsv,
+	"firefox":        parseFirefoxCsv,
+}
+
+// maxImportDataSize limits the uncompressed size of the data read from a 1PUX archive
+const maxImportDataSize = 256 << 20
+
+// newImportedEntry checks that the record holds anything worth importing and fills the preview fields
+func newImportedEntry(record int, entry *Password, folder string, tags []string) (*ImportedEntry, *ImportIssue) {
+	if entry.Type == "" {
+		entry.Type = TypeLogin
+	}
+	if entry.Url == "" && entry.Username == "" && entry.Password == "" {
+		return nil, &ImportIssue{Record: record, Name: entry.Name, Reason: "record is empty"}
+	}
+	var err error
+	entry.Match, err = checkPasswordUris(entry.Match, entry.Uris)
+	if err != nil {
+		return nil, &ImportIssue{Record: record, Name: entry.Name, Reason: err.Error()}
+	}
+	return &ImportedEntry{
+		Entry:    entry,
+		Record:   record,
+		Name:     entry.Name,
+		Url:      entry.Url,
+		Username: entry.Username,
+		Type:     entry.Type,
+		Folder:   strings.Trim(folder, "/"),
+		Tags:     tags,
+	}, nil
+}
+
+// collectImportedEntry appends the entry or the reason why it is skipped
+func collectImportedEntry(entries []*ImportedEntry, skipped []*ImportIssue, entry *ImportedEntry, issue *ImportIssue) ([]*ImportedEntry, []*ImportIssue) {
+	if issue != nil {
+		return entries, append(skipped, issue)
+	}
+	return append(entries, entry), skipped
+}
+
+// importKey identifies duplicates: logins by url and username, all other types by their name.
+// Usernames which are envelopes can not be compared with the plaintext of an export, their logins have no key.
+func importKey(entry *Password) (string, bool) {
+	if entry.Type == TypeLogin || entry.Type == "" {
+		if isEnvelope(entry.Username) {
+			return "", false
+		}
+		return TypeLogin + "\x00" + strings.ToLower(strings.TrimSpace(entry.Url)) + "\x00" + entry.Username, true
+	}
+	return entry.Type + "\x00" + strings.ToLower(strings.TrimSpace(entry.Name)), true
+}
+
+// filterImportDuplicates drops the entries the user already owns or that occur earlier in the export.
+// Only the entries of the personal vault are considered, see ownVaultEntry. Logins with encrypted usernames
+// are not considered either, the clients find those duplicates in the dry run.
+func filterImportDuplicates(entries []*ImportedEntry, existing []*Password) ([]*ImportedEntry, []*ImportIssue) {
+	// the record of the first occurrence, zero for the existing entries
+	known := make(map[string]int)
+	for _, password := range existing {
+		if key, ok := importKey(password); ok && ownVaultEntry(password) {
+			known[key] = 0
+		}
+	}
+	var accepted []*ImportedEntry
+	var duplicates []*ImportIssue
+	for _, entry := range entries {
+		key, comparable := importKey(entry.Entry)
+		if !comparable {
+			accepted = append(accepted, entry)
+			continue
+		}
+		if record, ok := known[key]; ok {
+			reason := "duplicate of an existing entry"
+			if record > 0 {
+				reason = fmt.Sprintf("duplicate of record %d", record)
+			}
+			duplicates = append(duplicates, &ImportIssue{Record: entry.Record, Name: entry.Name, Reason: reason})
+			continue
+		}
+		known[key] = entry.Record
+		accepted = append(accepted, entry)
+	}
+	return accepted, duplicates
+}
+
+func splitImportTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == ';' }) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+/* Bitwarden */
+
+type bitwardenExport struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	Type     int             `json:"type"`
+	Name     string          `json:"name"`
+	Notes    string          `json:"notes"`
+	Favorite bool            `json:"favorite"`
+	FolderId string          `json:"folderId"`
+	Login    *bitwardenLogin `json:"login"`
+	Card     json.RawMessage `json:"card"`
+	Identity json.RawMessage `json:"identity"`
+}
+
+type bitwardenLogin struct {
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	Uris     []bitwardenUri `json:"uris"`
+}
+
+type bitwardenUri struct {
+	Uri   string `json:"uri"`
+	Match *int   `json:"match"`
+}
+
+// bitwardenMatches maps the uri match detection of Bitwarden, exact matches are widened to startswith
+var bitwardenMatches = map[int]string{0: MatchDomain, 1: MatchHost, 2: MatchStartsWith, 3: MatchStartsWith, 4: MatchRegex, 5: MatchNever}
+
+// parseBitwardenJson reads an unencrypted json export. Cards and identities keep their details as json in the password.
+func parseBitwardenJson(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	var export bitwardenExport
+	err := json.Unmarshal(data, &export)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a Bitwarden json export: %v", err)
+	}
+	if export.Encrypted {
+		return nil, nil, fmt.Errorf("encrypted Bitwarden exports are not supported")
+	}
+	folders := make(map[string]string)
+	for _, folder := range export.Folders {
+		folders[folder.Id] = folder.Name
+	}
+	var entries []*ImportedEntry
+	var skipped []*ImportIssue
+	for i, item := range export.Items {
+		entry := &Password{Name: item.Name, Favorite: item.Favorite}
+		switch item.Type {
+		case 1:
+			entry.Type = TypeLogin
+			if item.Login != nil {
+				entry.Username = item.Login.Username
+				entry.Password = item.Login.Password
+				for j, uri := range item.Login.Uris {
+					match := ""
+					if uri.Match != nil {
+						match = bitwardenMatches[*uri.Match]
+					}
+					if j == 0 {
+						entry.Url, entry.Match = uri.Uri, match
+					} else {
+						entry.Uris = append(entry.Uris, PasswordUri{Uri: uri.Uri, Match: match})
+					}
+				}
+			}
+		case 2:
+			entry.Type, entry.Password = TypeNote, item.Notes
+		case 3:
+			entry.Type, entry.Password = TypeCard, bitwardenDetails(item.Card)
+		case 4:
+			entry.Type, entry.Password = TypeIdentity, bitwardenDetails(item.Identity)
+		default:
+			skipped = append(skipped, &ImportIssue{Record: i + 1, Name: item.Name, Reason: fmt.Sprintf("unsupported item type %d", item.Type)})
+			continue
+		}
+		imported, issue := newImportedEntry(i+1, entry, folders[item.FolderId], nil)
+		entries, skipped = collectImportedEntry(entries, skipped, imported, issue)
+	}
+	return entries, skipped, nil
+}
+
+// bitwardenDetails is the json of a card or an identity, empty if the item has none
+func bitwardenDetails(details json.RawMessage) string {
+	if len(details) == 0 || string(details) == "null" {
+		return ""
+	}
+	return string(details)
+}
+
+/* KeePass */
+
+// keePassFile is read by the import and written by the KDBX export
+type keePassFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Meta    struct {
+		Generator      string `xml:"Generator,omitempty"`
+		DatabaseName   string `xml:"DatabaseName,omitempty"`
+		RecycleBinUUID string `xml:"RecycleBinUUID,omitempty"`
+	} `xml:"Meta"`
+	Root struct {
+		Groups []keePassGroup `xml:"Group"`
+	} `xml:"Root"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+// keePassEntry is a current entry, the History of an entry is not read
+type keePassEntry struct {
+	UUID    string          `xml:"UUID"`
+	Tags    string          `xml:"Tags,omitempty"`
+	Strings []keePassString `xml:"String"`
+}
+
+type keePassString struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// parseKeePassXml reads a KeePass 2 xml export. The groups below the root group become folders,
+// the recycle bin is skipped and an entry with nothing but notes becomes a note.
+func parseKeePassXml(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	var file keePassFile
+	err := xml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a KeePass xml export: %v", err)
+	}
+	if len(file.Root.Groups) == 0 {
+		return nil, nil, fmt.Errorf("not a KeePass xml export: root group is missing")
+	}
+	var entries []*ImportedEntry
+	var skipped []*ImportIssue
+	record := 0
+	var walk func(group keePassGroup, path string)
+	walk = func(group keePassGroup, path string) {
+		if file.Meta.RecycleBinUUID != "" && group.UUID == file.Meta.RecycleBinUUID {
+			return
+		}
+		for _, keePassEntry := range group.Entries {
+			record++
+			values := make(map[string]string)
+			for _, value := range keePassEntry.Strings {
+				values[value.Key] = value.Value
+			}
+			entry := &Password{Name: values["Title"], Url: values["URL"], Username: values["UserName"], Password: values["Password"]}
+			if entry.Url == "" && entry.Username == "" && entry.Password == "" && values["Notes"] != "" {
+				entry.Type, entry.Password = TypeNote, values["Notes"]
+			}
+			imported, issue := newImportedEntry(record, entry, path, splitImportTags(keePassEntry.Tags))
+			entries, skipped = collectImportedEntry(entries, skipped, imported, issue)
+		}
+		for _, child := range group.Groups {
+			walk(child, path+"/"+child.Name)
+		}
+	}
+	for _, root := range file.Root.Groups {
+		walk(root, "")
+	}
+	return entries, skipped, nil
+}
+
+/* 1Password */
+
+type onePuxExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Attrs struct {
+				Name string `json:"name"`
+			} `json:"attrs"`
+			Items []onePuxItem `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+type onePuxItem struct {
+	FavIndex     int    `json:"favIndex"`
+	State        string `json:"state"`
+	CategoryUuid string `json:"categoryUuid"`
+	Overview     struct {
+		Title string `json:"title"`
+		Url   string `json:"url"`
+		Urls  []struct {
+			Url string `json:"url"`
+		} `json:"urls"`
+		Tags []string `json:"tags"`
+	} `json:"overview"`
+	Details struct {
+		LoginFields []struct {
+			Designation string `json:"designation"`
+			Value       string `json:"value"`
+		} `json:"loginFields"`
+		NotesPlain string `json:"notesPlain"`
+		Password   string `json:"password"`
+	} `json:"details"`
+}
+
+// parse1Pux reads the export.data of a 1PUX archive, vaults become folders.
+// Logins, passwords and secure notes are imported, archived items are skipped.
+func parse1Pux(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a 1PUX export: %v", err)
+	}
+	var exportData []byte
+	for _, file := range archive.File {
+		if file.Name != "export.data" {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		exportData, err = ioutil.ReadAll(io.LimitReader(reader, maxImportDataSize+1))
+		reader.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(exportData) > maxImportDataSize {
+			return nil, nil, fmt.Errorf("export.data is too large")
+		}
+	}
+	if exportData == nil {
+		return nil, nil, fmt.Errorf("not a 1PUX export: export.data is missing")
+	}
+	var export onePuxExport
+	err = json.Unmarshal(exportData, &export)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a 1PUX export: %v", err)
+	}
+	var entries []*ImportedEntry
+	var skipped []*ImportIssue
+	record := 0
+	for _, account := range export.Accounts {
+		for _, vault := range account.Vaults {
+			for _, item := range vault.Items {
+				record++
+				entry := &Password{Name: item.Overview.Title, Url: item.Overview.Url, Favorite: item.FavIndex > 0}
+				if item.State == "archived" {
+					skipped = append(skipped, &ImportIssue{Record: record, Name: entry.Name, Reason: "item is archived"})
+					continue
+				}
+				switch item.CategoryUuid {
+				case "001":
+					for _, field := range item.Details.LoginFields {
+						switch field.Designation {
+						case "username":
+							entry.Username = field.Value
+						case "password":
+							entry.Password = field.Value
+						}
+					}
+				case "005":
+					entry.Password = item.Details.Password
+				case "003":
+					entry.Type, entry.Password = TypeNote, item.Details.NotesPlain
+				default:
+					skipped = append(skipped, &ImportIssue{Record: record, Name: entry.Name, Reason: "unsupported category " + item.CategoryUuid})
+					continue
+				}
+				for _, url := range item.Overview.Urls {
+					if url.Url != entry.Url {
+						entry.Uris = append(entry.Uris, PasswordUri{Uri: url.Url})
+					}
+				}
+				imported, issue := newImportedEntry(record, entry, vault.Attrs.Name, item.Overview.Tags)
+				entries, skipped = collectImportedEntry(entries, skipped, imported, issue)
+			}
+		}
+	}
+	return entries, skipped, nil
+}
+
+// parse1PasswordCsv reads the csv export of 1Password 8, archived items are skipped
+func parse1PasswordCsv(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	return parseCsvExport(data, "1Password", []string{"title", "url", "username", "password"},
+		func(record int, get func(string) string) (*ImportedEntry, *ImportIssue) {
+			entry := &Password{Name: get("title"), Url: get("url"), Username: get("username"), Password: get("password"),
+				Favorite: get("favorite") == "true"}
+			if get("archived") == "true" {
+				return nil, &ImportIssue{Record: record, Name: entry.Name, Reason: "item is archived"}
+			}
+			return newImportedEntry(record, entry, "", splitImportTags(get("tags")))
+		})
+}
+
+/* CSV exports */
+
+// parseCsvExport reads a csv file with a header naming the required columns, case is ignored.
+// The header is record 1, row is called for each following record with a getter of its columns.
+func parseCsvExport(data []byte, product string, required []string,
+	row func(record int, get func(string) string) (*ImportedEntry, *ImportIssue)) ([]*ImportedEntry, []*ImportIssue, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a %s csv export: %v", product, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("not a %s csv export: column %s is missing", product, name)
+		}
+	}
+	var entries []*ImportedEntry
+	var skipped []*ImportIssue
+	for record := 2; ; record++ {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			skipped = append(skipped, &ImportIssue{Record: record, Reason: err.Error()})
+			continue
+		}
+		get := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(values) {
+				return ""
+			}
+			return values[i]
+		}
+		imported, issue := row(record, get)
+		entries, skipped = collectImportedEntry(entries, skipped, imported, issue)
+	}
+	return entries, skipped, nil
+}
+
+// lastPassNoteUrl marks the secure notes of a LastPass export
+const lastPassNoteUrl = "http://sn"
+
+// parseLastPassCsv reads a LastPass export, groupings become folders and secure notes keep their text as password
+func parseLastPassCsv(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	return parseCsvExport(data, "LastPass", []string{"url", "username", "password", "extra", "name", "grouping"},
+		func(record int, get func(string) string) (*ImportedEntry, *ImportIssue) {
+			entry := &Password{Name: get("name"), Url: get("url"), Username: get("username"), Password: get("password"),
+				Favorite: get("fav") == "1"}
+			if entry.Url == lastPassNoteUrl {
+				entry.Type, entry.Url, entry.Password = TypeNote, "", get("extra")
+			}
+			return newImportedEntry(record, entry, strings.Replace(get("grouping"), "\\", "/", -1), nil)
+		})
+}
+
+// parseChromeCsv reads the passwords exported by Chrome and other Chromium based browsers
+func parseChromeCsv(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	return parseCsvExport(data, "Chrome", []string{"name", "url", "username", "password"},
+		func(record int, get func(string) string) (*ImportedEntry, *ImportIssue) {
+			entry := &Password{Name: get("name"), Url: get("url"), Username: get("username"), Password: get("password")}
+			return newImportedEntry(record, entry, "", nil)
+		})
+}
+
+// parseFirefoxCsv reads the logins exported by Firefox, which have no name
+func parseFirefoxCsv(data []byte) ([]*ImportedEntry, []*ImportIssue, error) {
+	return parseCsvExport(data, "Firefox", []string{"url", "username", "password"},
+		func(record int, get func(string) string) (*ImportedEntry, *ImportIssue) {
+			entry := &Password{Url: get("url"), Username: get("username"), Password: get("password")}
+			return newImportedEntry(record, entry, "", nil)
+		})
+}
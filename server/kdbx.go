@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// KDBX 4 identifiers, the file is encrypted with AES-256-CBC and its key derived with AES-KDF
+var (
+	kdbxSignature = []byte{0x03, 0xd9, 0xa2, 0x9a, 0x67, 0xfb, 0x4b, 0xb5, 0x00, 0x00, 0x04, 0x00}
+	kdbxAesCipher = []byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	kdbxAesKdf    = []byte{0x7c, 0x02, 0xbb, 0x82, 0x79, 0xa7, 0x4a, 0xc0, 0x92, 0x7d, 0x11, 0x4a, 0x00, 0x64, 0x82, 0x38}
+)
+
+// Fields of the outer and the inner header
+const (
+	kdbxEndOfHeader    = 0
+	kdbxCipherId       = 2
+	kdbxCompression    = 3
+	kdbxMasterSeed     = 4
+	kdbxEncryptionIv   = 7
+	kdbxKdfParameters  = 11
+	kdbxInnerStreamId  = 1
+	kdbxInnerStreamKey = 2
+	// kdbxChaCha20 protects values in memory, no value is marked as protected so the stream is never used
+	kdbxChaCha20 = 3
+)
+
+// kdbxRounds is the work factor of AES-KDF
+const kdbxRounds = 2000000
+
+// kdbxBlockSize is the size of the HMAC protected blocks the payload is split into
+const kdbxBlockSize = 1 << 20
+
+// exportKdbx writes the vault as KDBX 4 database protected by the password, folders become groups
+func exportKdbx(vault *ExportVault, password string) ([]byte, error) {
+	random := make([]byte, 32+16+32+64)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+	masterSeed, iv, kdfSeed, streamKey := random[:32], random[32:48], random[48:80], random[80:]
+
+	var header bytes.Buffer
+	header.Write(kdbxSignature)
+	writeKdbxField(&header, kdbxCipherId, kdbxAesCipher)
+	writeKdbxField(&header, kdbxCompression, kdbxUint32(0))
+	writeKdbxField(&header, kdbxMasterSeed, masterSeed)
+	writeKdbxField(&header, kdbxEncryptionIv, iv)
+	writeKdbxField(&header, kdbxKdfParameters, kdbxKdfDictionary(kdfSeed))
+	writeKdbxField(&header, kdbxEndOfHeader, []byte("\r\n\r\n"))
+
+	transformedKey, err := kdbxTransformKey(password, kdfSeed, kdbxRounds)
+	if err != nil {
+		return nil, err
+	}
+	encryptionKey := sha256.Sum256(append(append([]byte{}, masterSeed...), transformedKey...))
+	hmacKey := sha512.Sum512(append(append(append([]byte{}, masterSeed...), transformedKey...), 0x01))
+
+	var payload bytes.Buffer
+	writeKdbxField(&payload, kdbxInnerStreamId, kdbxUint32(kdbxChaCha20))
+	writeKdbxField(&payload, kdbxInnerStreamKey, streamKey)
+	writeKdbxField(&payload, kdbxEndOfHeader, nil)
+	payload.WriteString(xml.Header)
+	err = xml.NewEncoder(&payload).Encode(kdbxDocument(vault))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(encryptionKey[:])
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - payload.Len()%aes.BlockSize
+	payload.Write(bytes.Repeat([]byte{byte(padding)}, padding))
+	ciphertext := payload.Bytes()
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	var file bytes.Buffer
+	file.Write(header.Bytes())
+	headerHash := sha256.Sum256(header.Bytes())
+	file.Write(headerHash[:])
+	headerHmac := hmac.New(sha256.New, kdbxBlockKey(hmacKey[:], ^uint64(0)))
+	headerHmac.Write(header.Bytes())
+	file.Write(headerHmac.Sum(nil))
+	for index := uint64(0); ; index++ {
+		size := len(ciphertext)
+		if size > kdbxBlockSize {
+			size = kdbxBlockSize
+		}
+		data := append(kdbxUint32(uint32(size)), ciphertext[:size]...)
+		indexBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(indexBytes, index)
+		blockHmac := hmac.New(sha256.New, kdbxBlockKey(hmacKey[:], index))
+		blockHmac.Write(indexBytes)
+		blockHmac.Write(data)
+		file.Write(blockHmac.Sum(nil))
+		file.Write(data)
+		ciphertext = ciphertext[size:]
+		// the payload ends with an empty block
+		if size == 0 {
+			return file.Bytes(), nil
+		}
+	}
+}
+
+func writeKdbxField(buffer *bytes.Buffer, id byte, data []byte) {
+	buffer.WriteByte(id)
+	buffer.Write(kdbxUint32(uint32(len(data))))
+	buffer.Write(data)
+}
+
+func kdbxUint32(value uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	return b
+}
+
+// kdbxKdfDictionary is the variant dictionary with the parameters of AES-KDF
+func kdbxKdfDictionary(seed []byte) []byte {
+	var dictionary bytes.Buffer
+	dictionary.Write([]byte{0x00, 0x01})
+	item := func(kind byte, key string, value []byte) {
+		dictionary.WriteByte(kind)
+		dictionary.Write(kdbxUint32(uint32(len(key))))
+		dictionary.WriteString(key)
+		dictionary.Write(kdbxUint32(uint32(len(value))))
+		dictionary.Write(value)
+	}
+	rounds := make([]byte, 8)
+	binary.LittleEndian.PutUint64(rounds, kdbxRounds)
+	item(0x42, "$UUID", kdbxAesKdf)
+	item(0x05, "R", rounds)
+	item(0x42, "S", seed)
+	dictionary.WriteByte(0x00)
+	return dictionary.Bytes()
+}
+
+// kdbxTransformKey derives the key from the composite key of the password with AES-KDF
+func kdbxTransformKey(password string, seed []byte, rounds uint64) ([]byte, error) {
+	passwordHash := sha256.Sum256([]byte(password))
+	compositeKey := sha256.Sum256(passwordHash[:])
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, err
+	}
+	key := compositeKey[:]
+	for i := uint64(0); i < rounds; i++ {
+		block.Encrypt(key[:16], key[:16])
+		block.Encrypt(key[16:], key[16:])
+	}
+	transformed := sha256.Sum256(key)
+	return transformed[:], nil
+}
+
+// kdbxBlockKey is the HMAC key of the block with the index, the header uses the maximum index
+func kdbxBlockKey(hmacKey []byte, index uint64) []byte {
+	indexBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(indexBytes, index)
+	blockKey := sha512.Sum512(append(indexBytes, hmacKey...))
+	return blockKey[:]
+}
+
+// kdbxDocument builds the xml database, entries of unknown folders are kept in the root group
+func kdbxDocument(vault *ExportVault) *keePassFile {
+	document := &keePassFile{}
+	document.Meta.Generator = "KeyCloud"
+	document.Meta.DatabaseName = "KeyCloud"
+	names := make(map[string]string)
+	for _, tag := range vault.Tags {
+		names[tag.Id] = tag.Name
+	}
+	entries := make(map[string][]keePassEntry)
+	known := make(map[string]bool)
+	for _, folder := range vault.Folders {
+		known[folder.Id] = true
+	}
+	for _, password := range vault.Passwords {
+		folder := password.Folder
+		if !known[folder] {
+			folder = ""
+		}
+		entries[folder] = append(entries[folder], kdbxEntry(password, names))
+	}
+	children := make(map[string][]*Folder)
+	for _, folder := range vault.Folders {
+		parent := folder.Parent
+		if !known[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], folder)
+	}
+	// visited guards against parents forming a cycle
+	visited := make(map[string]bool)
+	var group func(id, name string) keePassGroup
+	group = func(id, name string) keePassGroup {
+		visited[id] = true
+		result := keePassGroup{UUID: kdbxUuid(), Name: name, Entries: entries[id]}
+		for _, child := range children[id] {
+			if !visited[child.Id] {
+				result.Groups = append(result.Groups, group(child.Id, child.Name))
+			}
+		}
+		return result
+	}
+	document.Root.Groups = []keePassGroup{group("", "KeyCloud")}
+	return document
+}
+
+// kdbxEntry maps an entry like the import reads it back, notes keep their text in the Notes field
+func kdbxEntry(password *Password, tagNames map[string]string) keePassEntry {
+	tags := make([]string, len(password.Tags))
+	for i, id := range password.Tags {
+		tags[i] = tagNames[id]
+	}
+	entry := keePassEntry{UUID: kdbxUuid(), Tags: strings.Join(tags, ";")}
+	add := func(key, value string) {
+		entry.Strings = append(entry.Strings, keePassString{Key: key, Value: value})
+	}
+	add("Title", password.Name)
+	add("UserName", password.Username)
+	add("URL", password.Url)
+	if password.Type == TypeNote {
+		add("Password", "")
+		add("Notes", password.Password)
+	} else {
+		add("Password", password.Password)
+	}
+	// additional uris are stored like KeePass2Android and KeePassXC expect them
+	for i, uri := range password.Uris {
+		add("KP2A_URL_"+strconv.Itoa(i+1), uri.Uri)
+	}
+	return entry
+}
+
+func kdbxUuid() string {
+	uuid := make([]byte, 16)
+	_, _ = rand.Read(uuid)
+	return base64.StdEncoding.EncodeToString(uuid)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// KeyWrapper encrypts the data keys of the rows with a key encryption key.
+// The keyfile implements it, a KMS can take its place without touching the stored rows.
+type KeyWrapper interface {
+	// CurrentKeyId names the key encryption key new data keys are wrapped with
+	CurrentKeyId() string
+	// WrapKey encrypts the data key with the current key encryption key
+	WrapKey(dataKey []byte) (keyId string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key which was wrapped with the key encryption key keyId
+	UnwrapKey(keyId string, wrapped []byte) ([]byte, error)
+}
+
+var keyIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+var errUnknownKey = errors.New("unknown key encryption key")
+
+// KeyfileWrapper keeps the key encryption keys in a local file with one "<id> <base64 key>" per line.
+// The last key is the current one, the ones before it are kept to unwrap the rows until they are re-wrapped.
+type KeyfileWrapper struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// LoadKeyfile reads the key encryption keys of the keyfile, empty lines and lines starting with # are ignored
+func LoadKeyfile(path string) (*KeyfileWrapper, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wrapper := &KeyfileWrapper{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 || !keyIdPattern.MatchString(fields[0]) {
+			return nil, fmt.Errorf("%s line %d: expected <id> <base64 key>", path, line)
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s line %d: the key has to be 32 bytes in base64", path, line)
+		}
+		if _, ok := wrapper.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("%s line %d: duplicate key id %s", path, line, fields[0])
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		wrapper.keys[fields[0]], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		wrapper.current = fields[0]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if wrapper.current == "" {
+		return nil, fmt.Errorf("%s has no key", path)
+	}
+	return wrapper, nil
+}
+
+// AddKeyfileKey appends a new random key to the keyfile, which becomes the current key, the file is created if needed
+func AddKeyfileKey(path string, id string) error {
+	if !keyIdPattern.MatchString(id) {
+		return errors.New("the key id has 1 to 64 letters, digits, _ or -")
+	}
+	if _, err := os.Stat(path); err == nil {
+		wrapper, err := LoadKeyfile(path)
+		if err != nil {
+			return err
+		}
+		if _, ok := wrapper.keys[id]; ok {
+			return fmt.Errorf("the key %s already exists", id)
+		}
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(file, "%s %s\n", id, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (wrapper *KeyfileWrapper) CurrentKeyId() string {
+	return wrapper.current
+}
+
+// WrapKey encrypts the data key with AES-256-GCM, the key id is authenticated with it
+func (wrapper *KeyfileWrapper) WrapKey(dataKey []byte) (string, []byte, error) {
+	aead := wrapper.keys[wrapper.current]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+	return wrapper.current, aead.Seal(nonce, nonce, dataKey, []byte(wrapper.current)), nil
+}
+
+func (wrapper *KeyfileWrapper) UnwrapKey(keyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := wrapper.keys[keyId]
+	if !ok {
+		return nil, errUnknownKey
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(keyId))
+}
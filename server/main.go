@@ -10,6 +10,8 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -21,14 +23,18 @@ const (
 )
 
 var (
-	authn           *webauthn.WebAuthn
-	err             error
-	store           *sessions.CookieStore
-	fileServer      *FileServer
-	webauthnHandler *AuthnHandler
-	crudHandler     *CRUDHandler
-	database        *sql.DB
-	storage         StorageInterface
+	authn             *webauthn.WebAuthn
+	err               error
+	store             *sessions.CookieStore
+	fileServer        *FileServer
+	webauthnHandler   *AuthnHandler
+	crudHandler       *CRUDHandler
+	attachmentHandler *AttachmentHandler
+	sendHandler       *SendHandler
+	eventHandler      *EventHandler
+	breachHandler     *BreachHandler
+	database          *sql.DB
+	storage           StorageInterface
 )
 
 func initFromDatabaseAndRouter(db *sql.DB) {
@@ -39,8 +45,20 @@ func initFromDatabaseAndRouter(db *sql.DB) {
 	// TODO: change for productive server again
 	store = sessions.NewCookieStore([]byte("aaaaaaaaaaaaaaaa"), []byte("aaaaaaaaaaaaaaaa"))
 
-	storage = &Storage{
-		database: db,
+	dataCipher, err := newDataCipher()
+	if err != nil {
+		panic(err)
+	}
+	if isSQLite(db) {
+		storage = &SQLiteStorage{Storage{
+			database: db,
+			cipher:   dataCipher,
+		}}
+	} else {
+		storage = &Storage{
+			database: db,
+			cipher:   dataCipher,
+		}
 	}
 
 	authn, err = webauthn.New(&webauthn.Config{
@@ -70,14 +88,100 @@ func initFromDatabaseAndRouter(db *sql.DB) {
 		cookieSessionName: sessionName,
 	}
 
+	suffixes, err := LoadPublicSuffixList("public_suffix_list.dat")
+	if err != nil {
+		fmt.Println("Unable to load public suffix list, falling back to the last label as suffix:", err)
+	}
+
+	passwordRules, err := LoadPasswordRules("password_rules.json")
+	if err != nil {
+		fmt.Println("Unable to load the bundled password rules, only the rules of the users apply:", err)
+		passwordRules = NewPasswordRulesRegistry(nil)
+	}
+
 	crudHandler = &CRUDHandler{
-		cookieStore: store,
+		cookieStore:   store,
+		storage:       storage,
+		matcher:       NewUrlMatcher(suffixes),
+		passwordRules: passwordRules,
+		policy:        Policy{storage: storage},
+		notifier:      newNotifier(),
+		maxBatchSize:  getEnvInt("BATCH_MAX_OPERATIONS", 500),
+		maxImportSize: int64(getEnvInt("IMPORT_MAX_MB", 20)) << 20,
+		// the enforcement is turned on once all clients encrypt, the dashboard and the plugins still send plaintext
+		requireEnvelopes: os.Getenv("ENVELOPE_ENFORCEMENT") == "true",
+	}
+
+	attachmentHandler = &AttachmentHandler{
 		storage:     storage,
+		policy:      Policy{storage: storage},
+		blobs:       newBlobStore(db),
+		maxFileSize: int64(getEnvInt("ATTACHMENT_MAX_FILE_MB", 20)) << 20,
+		maxUserSize: int64(getEnvInt("ATTACHMENT_MAX_USER_MB", 500)) << 20,
+	}
+
+	sendHandler = &SendHandler{
+		storage: storage,
+		maxSize: int64(getEnvInt("SEND_MAX_MB", 10)) << 20,
+	}
+
+	eventHandler = &EventHandler{
+		hub:            NewEventHub(),
+		heartbeat:      time.Duration(getEnvInt("EVENTS_HEARTBEAT_SECONDS", 25)) * time.Second,
+		allowedOrigins: strings.Fields(strings.Replace(os.Getenv("EVENTS_ALLOWED_ORIGINS"), ",", " ", -1)),
+	}
+
+	breachHandler = &BreachHandler{
+		dataset: NewBreachDataset(os.Getenv("BREACH_DATASET")),
 	}
 }
 
+// newNotifier creates the notifier for reminders and emergency access configured by REMINDER_NOTIFIER, the log is used by default
+func newNotifier() Notifier {
+	if os.Getenv("REMINDER_NOTIFIER") == "file" {
+		path := os.Getenv("REMINDER_FILE")
+		if path == "" {
+			path = "reminders.log"
+		}
+		return NewFileNotifier(path)
+	}
+	return LogNotifier{}
+}
+
+// newBlobStore creates the store for attachments configured by ATTACHMENT_STORE, the filesystem is used by default
+func newBlobStore(db *sql.DB) BlobStore {
+	if os.Getenv("ATTACHMENT_STORE") == "postgres" {
+		return &PostgresBlobStore{database: db}
+	}
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		dir = "attachments"
+	}
+	return NewFileBlobStore(dir)
+}
+
 func main() {
 
+	// ./server keys adds key encryption keys to the keyfile without a database
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		err = runKeysCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// ./server breach import compacts the Pwned Passwords dataset without a database
+	if len(os.Args) > 1 && os.Args[1] == "breach" {
+		err = runBreachCommand(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Connect to database
 	database, err = connectDatabase()
 	defer database.Close()
@@ -85,9 +189,41 @@ func main() {
 		panic(err)
 	}
 
-	// Create Tables if they do not exist yet
-	data, _ := ioutil.ReadFile("init.sql")
-	_, err = database.Exec(string(data))
+	// ./server migrate manages the schema without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrateCommand(database, os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// ./server rewrap wraps the data keys with the current key encryption key while the servers keep running
+	if len(os.Args) > 1 && os.Args[1] == "rewrap" {
+		err = runRewrapCommand(database)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Bring the schema up to date, a schema newer than this server is refused
+	err = prepareSchema(database)
+	if err != nil {
+		panic(err)
+	}
+
+	// ./server corpus import loads a breach corpus into the database, the monitoring matches it on its next run
+	if len(os.Args) > 1 && os.Args[1] == "corpus" {
+		err = runCorpusCommand(database, os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Delete all previous stored Sessions
 	err = ClearAllSessionKeys(database)
@@ -96,6 +232,61 @@ func main() {
 	}
 
 	initFromDatabaseAndRouter(database)
+	if !crudHandler.requireEnvelopes {
+		fmt.Println("Envelope enforcement is off, plaintext passwords and usernames are stored until ENVELOPE_ENFORCEMENT=true")
+	}
+
+	// Push the changes committed through any server to the event streams connected to this one
+	if isSQLite(database) {
+		listenSQLiteVaultEvents(eventHandler.hub)
+	} else {
+		err = listenVaultEvents(databaseConnInfo(), eventHandler.hub)
+		if err != nil {
+			fmt.Println("Unable to listen for vault events, the event streams stay idle:", err)
+		}
+	}
+
+	// Purge passwords which have been in the trash for longer than the retention
+	trashRetention := time.Duration(getEnvInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	runPeriodically("trash purge", time.Hour, func() error {
+		return purgeTrash(storage, trashRetention)
+	})
+
+	// Prune the deletion markers of the sync, clients which synced before them get a full sync
+	syncRetention := time.Duration(getEnvInt("SYNC_RETENTION_DAYS", 90)) * 24 * time.Hour
+	runPeriodically("sync pruning", time.Hour, func() error {
+		return pruneSyncDeletions(storage, syncRetention)
+	})
+
+	// Remind users of expired passwords and passwords which have to be rotated soon
+	reminderWindow := time.Duration(getEnvInt("REMINDER_DAYS", defaultDueDays)) * 24 * time.Hour
+	runPeriodically("rotation reminders", time.Hour, func() error {
+		return sendReminders(storage, crudHandler.notifier, reminderWindow)
+	})
+
+	// Grant emergency access once the waiting period of a request is over
+	runPeriodically("emergency access", time.Hour, func() error {
+		return grantEmergencyAccess(storage, crudHandler.notifier)
+	})
+
+	// Delete sends which have expired or whose views are used up
+	runPeriodically("send purge", time.Hour, func() error {
+		return purgeSends(storage)
+	})
+
+	// Open the breach dataset and pick it up again once it has been imported anew
+	runPeriodically("breach dataset", time.Hour, breachHandler.dataset.Reload)
+
+	// Flag the mails and usernames found in the imported breach corpus
+	breachInterval := time.Duration(getEnvInt("BREACH_MONITOR_HOURS", 24)) * time.Hour
+	runPeriodically("breach monitoring", breachInterval, func() error {
+		return monitorBreaches(storage)
+	})
+
+	// Remove attachment contents whose entry or user has been deleted
+	runPeriodically("attachment cleanup", time.Hour, func() error {
+		return removeOrphanedBlobs(storage, attachmentHandler.blobs)
+	})
 
 	webauthnRouter := mux.NewRouter()
 
@@ -130,6 +321,149 @@ func main() {
 	webauthnRouter.Handle("/password", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreatePassword))).Methods(http.MethodPost)
 	webauthnRouter.Handle("/password", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemovePassword))).Methods(http.MethodDelete)
 	webauthnRouter.Handle("/password-by-url", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetPasswordByUrl))).Methods(http.MethodGet, http.MethodPost)
+	webauthnRouter.Handle("/password/folder", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.MovePassword))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password/tags", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.TagPassword))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password/uris", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetPasswordUris))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password/favorite", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetPasswordFavorite))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password/used", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RecordPasswordUsage))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/password/expiry", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetPasswordExpiry))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password/rotated", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RecordPasswordRotation))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/passwords/due", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetDuePasswords))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/passwords/batch", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.ApplyPasswordBatch))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/passwords/import", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.ImportPasswords))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/password/usage", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.DeletePasswordUsage))).Methods(http.MethodDelete)
+
+	/*
+		Health of the user's vault from the fingerprints and strength scores reported by the client
+	*/
+	webauthnRouter.Handle("/passwords/health", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetPasswordHealth))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/reports/health", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetHealthReport))).Methods(http.MethodGet)
+
+	/*
+		Breaches of the local corpus the user's mail or usernames were found in
+	*/
+	webauthnRouter.Handle("/reports/breaches", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetBreachReport))).Methods(http.MethodGet)
+
+	/*
+		End-to-end encrypted sharing of passwords between users
+	*/
+	webauthnRouter.Handle("/user/keys", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetUserKeys))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/user/keys", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetUserKeys))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/user/publickey", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetPublicKey))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/shares", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetShares))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/share", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SharePassword))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/share", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RevokeShare))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/shared-password", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetSharedPassword))).Methods(http.MethodPut)
+
+	/*
+		Organizations with members, roles and shared collections of passwords
+	*/
+	webauthnRouter.Handle("/organizations", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetOrganizations))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/organization", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateOrganization))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/organization", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveOrganization))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/organization/members", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetMembers))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/organization/member", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.InviteMember))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/organization/member", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateMember))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/organization/member", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveMember))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/organization/accept", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.AcceptInvitation))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/collections", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetCollections))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/collection", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateCollection))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/collection", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateCollection))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/collection", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveCollection))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/collection/grants", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetCollectionGrants))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/collection/grant", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GrantCollection))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/collection/grant", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RevokeCollection))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/password/collection", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.MovePasswordToCollection))).Methods(http.MethodPut)
+
+	/*
+		Emergency access of trusted contacts after a waiting period
+	*/
+	webauthnRouter.Handle("/emergency-contacts", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetEmergencyContacts))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/emergency-contact", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateEmergencyContact))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/emergency-contact", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateEmergencyContact))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/emergency-contact", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveEmergencyContact))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/emergency-access/accept", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.AcceptEmergencyContact))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/emergency-access/request", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RequestEmergencyAccess))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/emergency-access/approve", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.ApproveEmergencyAccess))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/emergency-access/reject", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RejectEmergencyAccess))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/emergency-access/passwords", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetEmergencyPasswords))).Methods(http.MethodGet)
+
+	/*
+		Delta sync of the user's vault by revision
+	*/
+	webauthnRouter.Handle("/sync", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetSync))).Methods(http.MethodGet)
+
+	/*
+		Change notifications of the user's vault as server-sent events or over a WebSocket
+	*/
+	webauthnRouter.Handle("/events", checkCookiePermissionsMiddleware(http.HandlerFunc(eventHandler.GetEvents))).Methods(http.MethodGet)
+
+	/*
+		Breached password hashes of the local Pwned Passwords dataset by the first 5 hex digits of their SHA-1
+	*/
+	webauthnRouter.Handle("/breach/range/{prefix}", checkCookiePermissionsMiddleware(http.HandlerFunc(breachHandler.GetBreachRange))).Methods(http.MethodGet)
+
+	/*
+		Ciphertext envelope format of the encrypted fields, public so clients can check it before logging in
+	*/
+	webauthnRouter.HandleFunc("/envelope", crudHandler.GetEnvelopeAlgorithms).Methods(http.MethodGet)
+
+	/*
+		Export of the user's passwords, recorded in an audit trail
+	*/
+	webauthnRouter.Handle("/export", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.ExportVault))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/exports", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetExports))).Methods(http.MethodGet)
+
+	/*
+		Trash of deleted passwords
+	*/
+	webauthnRouter.Handle("/trash", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetTrash))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/trash", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.EmptyTrash))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/trash/restore", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RestorePassword))).Methods(http.MethodPost)
+
+	/*
+		Encrypted file attachments of passwords
+	*/
+	webauthnRouter.Handle("/attachments", checkCookiePermissionsMiddleware(http.HandlerFunc(attachmentHandler.GetAttachments))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/attachment", checkCookiePermissionsMiddleware(http.HandlerFunc(attachmentHandler.DownloadAttachment))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/attachment", checkCookiePermissionsMiddleware(http.HandlerFunc(attachmentHandler.UploadAttachment))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/attachment", checkCookiePermissionsMiddleware(http.HandlerFunc(attachmentHandler.RemoveAttachment))).Methods(http.MethodDelete)
+
+	/*
+		One-time secret links, sends are read without an account
+	*/
+	webauthnRouter.Handle("/sends", checkCookiePermissionsMiddleware(http.HandlerFunc(sendHandler.GetSends))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/sends", checkCookiePermissionsMiddleware(http.HandlerFunc(sendHandler.CreateSend))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/sends/{id}", checkCookiePermissionsMiddleware(http.HandlerFunc(sendHandler.RemoveSend))).Methods(http.MethodDelete)
+	webauthnRouter.HandleFunc("/sends/{id}", sendHandler.ReadSend).Methods(http.MethodGet)
+
+	/*
+		Folders and tags to organize the user's passwords
+	*/
+	webauthnRouter.Handle("/folders", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetFolders))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/folder", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateFolder))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/folder", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateFolder))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/folder", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveFolder))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/tags", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetTags))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/tag", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateTag))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/tag", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateTag))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/tag", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveTag))).Methods(http.MethodDelete)
+
+	/*
+		Domains which share their credentials when matching urls
+	*/
+	webauthnRouter.Handle("/equivalent-domains", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetEquivalentDomains))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/equivalent-domains", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.CreateEquivalentDomains))).Methods(http.MethodPost)
+	webauthnRouter.Handle("/equivalent-domains", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.UpdateEquivalentDomains))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/equivalent-domains", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemoveEquivalentDomains))).Methods(http.MethodDelete)
+
+	/*
+		Password rules of sites for the generator, bundled and set by the user
+	*/
+	webauthnRouter.Handle("/password-rules", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.GetPasswordRules))).Methods(http.MethodGet)
+	webauthnRouter.Handle("/password-rules", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.SetPasswordRule))).Methods(http.MethodPut)
+	webauthnRouter.Handle("/password-rules", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.RemovePasswordRule))).Methods(http.MethodDelete)
+	webauthnRouter.Handle("/password-rules/lookup", checkCookiePermissionsMiddleware(http.HandlerFunc(crudHandler.LookupPasswordRules))).Methods(http.MethodGet)
 
 	panic(http.ListenAndServe(":8080", webauthnRouter))
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Migration is a versioned step of the database schema, Down reverts Up
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationLockKey identifies the advisory lock which serializes the migrations of concurrent instances
+const migrationLockKey = 7041770
+
+var errSchemaTooNew = errors.New("the database schema is newer than this server, upgrade the server or run migrate down with the newer one")
+
+// SchemaStatus compares the versions applied to the database with the migrations of the binary
+type SchemaStatus struct {
+	Current int
+	Latest  int
+	Pending []Migration
+	Unknown []int
+}
+
+// withMigrationLock runs fn on a single connection holding the advisory lock, other instances wait for it
+func withMigrationLock(db *sql.DB, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// a SQLite file is used by a single server, the transactions of the migrations lock it anyway
+	if !isSQLite(db) {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	}
+	_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations "+
+		"(version integer not null constraint schema_migrations_pk primary key, name text not null, "+
+		"applied timestamp not null default CURRENT_TIMESTAMP)")
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// querySchemaStatus reads the applied versions, the caller has to hold the migration lock
+func querySchemaStatus(conn *sql.Conn, migrations []Migration) (*SchemaStatus, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	status := &SchemaStatus{}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = true
+		status.Current = version
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	known := make(map[int]bool)
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if !applied[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+		status.Latest = migration.Version
+	}
+	for version := range applied {
+		if !known[version] {
+			status.Unknown = append(status.Unknown, version)
+		}
+	}
+	return status, nil
+}
+
+// MigrateDatabase applies the pending migrations up to the target version, each in its own transaction.
+// A database with versions this binary does not know is refused.
+func MigrateDatabase(db *sql.DB, migrations []Migration, target int) (applied []Migration, err error) {
+	err = withMigrationLock(db, func(conn *sql.Conn) error {
+		status, err := querySchemaStatus(conn, migrations)
+		if err != nil {
+			return err
+		}
+		if len(status.Unknown) > 0 {
+			return errSchemaTooNew
+		}
+		for _, migration := range status.Pending {
+			if migration.Version > target {
+				break
+			}
+			err = runMigration(conn, migration.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+				migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// RollbackDatabase reverts the applied migrations above the target version, the latest first
+func RollbackDatabase(db *sql.DB, migrations []Migration, target int) (reverted []Migration, err error) {
+	err = withMigrationLock(db, func(conn *sql.Conn) error {
+		status, err := querySchemaStatus(conn, migrations)
+		if err != nil {
+			return err
+		}
+		if len(status.Unknown) > 0 {
+			return errSchemaTooNew
+		}
+		pending := make(map[int]bool)
+		for _, migration := range status.Pending {
+			pending[migration.Version] = true
+		}
+		for i := len(migrations) - 1; i >= 0 && migrations[i].Version > target; i-- {
+			migration := migrations[i]
+			if pending[migration.Version] {
+				continue
+			}
+			err = runMigration(conn, migration.Down, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+			if err != nil {
+				return fmt.Errorf("rollback of migration %d %s: %v", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// runMigration executes the statements of a migration and records it in one transaction
+func runMigration(conn *sql.Conn, statements string, record string, args ...interface{}) error {
+	ctx := context.Background()
+	// begin new statement
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// execute statement
+	_, err = tx.ExecContext(ctx, statements)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// QuerySchemaStatus compares the database with the migrations of the binary
+func QuerySchemaStatus(db *sql.DB, migrations []Migration) (status *SchemaStatus, err error) {
+	err = withMigrationLock(db, func(conn *sql.Conn) error {
+		status, err = querySchemaStatus(conn, migrations)
+		return err
+	})
+	return status, err
+}
+
+// databaseMigrations are the migrations of the backend of the database
+func databaseMigrations(db *sql.DB) []Migration {
+	if isSQLite(db) {
+		return sqliteMigrations
+	}
+	return schemaMigrations
+}
+
+// prepareSchema runs at startup: pending migrations are applied unless MIGRATE_ON_START is false,
+// in which case the server refuses to start until they are applied with the migrate command
+func prepareSchema(db *sql.DB) error {
+	migrations := databaseMigrations(db)
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		_, err := MigrateDatabase(db, migrations, migrations[len(migrations)-1].Version)
+		return err
+	}
+	status, err := QuerySchemaStatus(db, migrations)
+	if err != nil {
+		return err
+	}
+	if len(status.Unknown) > 0 {
+		return errSchemaTooNew
+	}
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("%d migrations are pending, run ./server migrate up", len(status.Pending))
+	}
+	return nil
+}
+
+// runMigrateCommand implements ./server migrate [status | up [version] | down version]
+func runMigrateCommand(db *sql.DB, args []string) error {
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	migrations := databaseMigrations(db)
+	target := migrations[len(migrations)-1].Version
+	if len(args) > 1 {
+		var err error
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %s", args[1])
+		}
+	}
+	switch command {
+	case "status":
+		status, err := QuerySchemaStatus(db, migrations)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest version %d\n", status.Current, status.Latest)
+		for _, migration := range status.Pending {
+			fmt.Printf("pending %d %s\n", migration.Version, migration.Name)
+		}
+		for _, version := range status.Unknown {
+			fmt.Printf("unknown %d, the schema is newer than this server\n", version)
+		}
+		return nil
+	case "up":
+		applied, err := MigrateDatabase(db, migrations, target)
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "down":
+		if len(args) < 2 {
+			return errors.New("migrate down requires the version to roll back to")
+		}
+		reverted, err := RollbackDatabase(db, migrations, target)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown migrate command %s, use status, up [version] or down version", command)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+)
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "one", Up: "CREATE TABLE one", Down: "DROP TABLE one"},
+	{Version: 2, Name: "two", Up: "CREATE TABLE two", Down: "DROP TABLE two"},
+	{Version: 3, Name: "three", Up: "CREATE TABLE three", Down: "DROP TABLE three"},
+}
+
+// expectMigrationLock mocks taking the lock and reading the applied versions
+func expectMigrationLock(mock sqlmock.Sqlmock, versions ...int) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, version := range versions {
+		rows.AddRow(version)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(rows)
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	expectMigrationLock(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "two").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// migration 3 is beyond the target
+	applied, err := MigrateDatabase(db, testMigrations, 2)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Errorf("unexpected migrations applied: %+v", applied)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMigrateDatabaseFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	expectMigrationLock(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "two").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE three").WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// the failed migration is rolled back, the ones before it stay applied
+	applied, err := MigrateDatabase(db, testMigrations, 3)
+	if err == nil {
+		t.Errorf("failed migration was not reported")
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Errorf("unexpected migrations applied: %+v", applied)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMigrateDatabaseNewerSchema(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	expectMigrationLock(mock, 1, 2, 3, 4)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err = MigrateDatabase(db, testMigrations, 3)
+	if err != errSchemaTooNew {
+		t.Errorf("unexpected error: got %v want %v", err, errSchemaTooNew)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRollbackDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	expectMigrationLock(mock, 1, 2, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE three").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("DROP TABLE two").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	reverted, err := RollbackDatabase(db, testMigrations, 1)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when rolling back", err)
+	}
+	if len(reverted) != 2 || reverted[0].Version != 3 || reverted[1].Version != 2 {
+		t.Errorf("unexpected migrations reverted: %+v", reverted)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSchemaMigrationsOrdered(t *testing.T) {
+	for i, migration := range schemaMigrations {
+		if migration.Version != i+1 || migration.Name == "" || migration.Up == "" || migration.Down == "" {
+			t.Errorf("migration %d is not numbered consecutively or incomplete: %d %s", i, migration.Version, migration.Name)
+		}
+	}
+}
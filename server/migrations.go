@@ -0,0 +1,727 @@
+package main
+
+// schemaMigrations are applied in the order of their versions, a migration is never changed once it is released.
+// The baseline creates the schema of the former init.sql and adopts databases which were created by it.
+var schemaMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: `
+create table if not exists users
+(
+    uuid varchar(36) not null
+        constraint users_pk
+            primary key,
+    name text not null
+        constraint users_name_check
+            check (name <> ''::text),
+    mail text not null,
+    masterpasswd varchar(32) not null,
+    createdate timestamp
+);
+
+create table if not exists passwds
+(
+    entryid serial not null
+        constraint passwds_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint passwds_users_uuid_fk
+            references users on delete cascade,
+    url text,
+    passwd varchar(32) not null,
+    username varchar(36)
+);
+
+create table if not exists sessions
+(
+    uuid varchar(36) not null,
+    session_token varchar(32) not null,
+    constraint sessions_pk
+        primary key (uuid)
+);
+
+create table if not exists authenticators
+(
+    id bytea not null,
+    credentialid bytea not null,
+    publickey bytea,
+    aaguid bytea not null,
+    signcount integer not null,
+    userid varchar(36)
+);
+
+create table if not exists folders
+(
+    folderid serial not null
+        constraint folders_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint folders_users_uuid_fk
+            references users on delete cascade,
+    name text not null
+        constraint folders_name_check
+            check (name <> ''::text),
+    parentid integer
+        constraint folders_folders_folderid_fk
+            references folders on delete set null
+);
+
+alter table passwds add column if not exists folderid integer
+    constraint passwds_folders_folderid_fk
+        references folders on delete set null;
+
+create table if not exists tags
+(
+    tagid serial not null
+        constraint tags_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint tags_users_uuid_fk
+            references users on delete cascade,
+    name text not null
+        constraint tags_name_check
+            check (name <> ''::text),
+    constraint tags_uuid_name_key
+        unique (uuid, name)
+);
+
+create table if not exists passwd_tags
+(
+    entryid integer not null
+        constraint passwd_tags_passwds_entryid_fk
+            references passwds on delete cascade,
+    tagid integer not null
+        constraint passwd_tags_tags_tagid_fk
+            references tags on delete cascade,
+    constraint passwd_tags_pk
+        primary key (entryid, tagid)
+);
+
+alter table passwds add column if not exists name text not null default '';
+alter table passwds add column if not exists type text not null default 'login'
+    constraint passwds_type_check
+        check (type in ('login', 'note', 'card', 'identity'));
+alter table passwds add column if not exists createdate timestamp not null default CURRENT_TIMESTAMP;
+
+create extension if not exists pg_trgm;
+
+create index if not exists passwds_uuid_url_idx on passwds (uuid, url);
+create index if not exists passwds_uuid_folderid_idx on passwds (uuid, folderid);
+create index if not exists passwds_uuid_name_idx on passwds (uuid, lower(COALESCE(NULLIF(name, ''), url, '')), entryid);
+create index if not exists passwds_uuid_lowerurl_idx on passwds (uuid, lower(COALESCE(url, '')), entryid);
+create index if not exists passwds_uuid_createdate_idx on passwds (uuid, createdate, entryid);
+create index if not exists passwds_url_trgm_idx on passwds using gin (url gin_trgm_ops);
+create index if not exists passwds_username_trgm_idx on passwds using gin (username gin_trgm_ops);
+create index if not exists passwd_tags_tagid_idx on passwd_tags (tagid);
+create index if not exists folders_uuid_idx on folders (uuid);
+
+alter table passwds add column if not exists match text not null default 'domain'
+    constraint passwds_match_check
+        check (match in ('domain', 'host', 'hostport', 'startswith', 'regex', 'never'));
+
+create table if not exists passwd_uris
+(
+    uriid serial not null
+        constraint passwd_uris_pk
+            primary key,
+    entryid integer not null
+        constraint passwd_uris_passwds_entryid_fk
+            references passwds on delete cascade,
+    uri text not null,
+    match text not null default 'domain'
+        constraint passwd_uris_match_check
+            check (match in ('domain', 'host', 'hostport', 'startswith', 'regex', 'never'))
+);
+
+create index if not exists passwd_uris_entryid_idx on passwd_uris (entryid);
+create index if not exists passwd_uris_uri_trgm_idx on passwd_uris using gin (uri gin_trgm_ops);
+
+create table if not exists equivalent_domains
+(
+    groupid serial not null
+        constraint equivalent_domains_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint equivalent_domains_users_uuid_fk
+            references users on delete cascade,
+    domains text[] not null
+);
+
+alter table passwds add column if not exists favorite boolean not null default false;
+
+-- shared entries are used by every user who can read them, each has an own usage
+create table if not exists passwd_usage
+(
+    entryid integer not null
+        constraint passwd_usage_passwds_entryid_fk
+            references passwds on delete cascade,
+    uuid varchar(36) not null
+        constraint passwd_usage_users_uuid_fk
+            references users on delete cascade,
+    lastused timestamp not null,
+    usecount integer not null default 0,
+    constraint passwd_usage_pk
+        primary key (entryid, uuid)
+);
+
+create index if not exists passwd_usage_uuid_idx on passwd_usage (uuid);
+
+alter table passwds add column if not exists deletedate timestamp;
+
+create index if not exists passwds_deletedate_idx on passwds (deletedate) where deletedate is not null;
+
+create table if not exists attachments
+(
+    attachmentid varchar(36) not null
+        constraint attachments_pk
+            primary key,
+    entryid integer not null
+        constraint attachments_passwds_entryid_fk
+            references passwds on delete cascade,
+    uuid varchar(36) not null
+        constraint attachments_users_uuid_fk
+            references users on delete cascade,
+    filename text not null,
+    size bigint not null,
+    createdate timestamp not null
+);
+
+create index if not exists attachments_entryid_idx on attachments (entryid);
+create index if not exists attachments_uuid_idx on attachments (uuid);
+
+create table if not exists attachment_blobs
+(
+    blobkey varchar(36) not null
+        constraint attachment_blobs_pk
+            primary key
+        constraint attachment_blobs_attachments_attachmentid_fk
+            references attachments on delete cascade,
+    data bytea not null
+);
+
+alter table passwds add column if not exists expires timestamp;
+alter table passwds add column if not exists rotationdays integer not null default 0;
+alter table passwds add column if not exists passwordchanged timestamp;
+alter table users add column if not exists reminded date;
+
+alter table users add column if not exists publickey text;
+alter table users add column if not exists privatekey text;
+alter table passwds add column if not exists sharedpasswd text;
+alter table passwds add column if not exists sharekey text;
+
+create table if not exists shares
+(
+    shareid serial not null
+        constraint shares_pk
+            primary key,
+    entryid integer not null
+        constraint shares_passwds_entryid_fk
+            references passwds on delete cascade,
+    recipient varchar(36) not null
+        constraint shares_users_uuid_fk
+            references users on delete cascade,
+    wrappedkey text not null,
+    permission varchar(8) not null
+        constraint shares_permission_check
+            check (permission in ('read', 'edit')),
+    constraint shares_entryid_recipient_key
+        unique (entryid, recipient)
+);
+
+create index if not exists shares_recipient_idx on shares (recipient);
+
+alter table passwds alter column passwd type text;
+
+create table if not exists organizations
+(
+    orgid serial not null
+        constraint organizations_pk
+            primary key,
+    name text not null
+        constraint organizations_name_check
+            check (name <> ''::text),
+    createdate timestamp not null default CURRENT_TIMESTAMP
+);
+
+create table if not exists memberships
+(
+    orgid integer not null
+        constraint memberships_organizations_orgid_fk
+            references organizations on delete cascade,
+    uuid varchar(36) not null
+        constraint memberships_users_uuid_fk
+            references users on delete cascade,
+    role varchar(8) not null
+        constraint memberships_role_check
+            check (role in ('owner', 'admin', 'manager', 'member')),
+    status varchar(8) not null
+        constraint memberships_status_check
+            check (status in ('invited', 'accepted')),
+    orgkey text not null,
+    constraint memberships_pk
+        primary key (orgid, uuid)
+);
+
+create index if not exists memberships_uuid_idx on memberships (uuid);
+
+create table if not exists collections
+(
+    collectionid serial not null
+        constraint collections_pk
+            primary key,
+    orgid integer not null
+        constraint collections_organizations_orgid_fk
+            references organizations on delete cascade,
+    name text not null
+        constraint collections_name_check
+            check (name <> ''::text)
+);
+
+create table if not exists collection_grants
+(
+    collectionid integer not null
+        constraint collection_grants_collections_collectionid_fk
+            references collections on delete cascade,
+    uuid varchar(36) not null
+        constraint collection_grants_users_uuid_fk
+            references users on delete cascade,
+    permission varchar(8) not null
+        constraint collection_grants_permission_check
+            check (permission in ('read', 'edit')),
+    constraint collection_grants_pk
+        primary key (collectionid, uuid)
+);
+
+alter table passwds add column if not exists collectionid integer
+    constraint passwds_collections_collectionid_fk
+        references collections on delete restrict;
+
+create index if not exists passwds_collectionid_idx on passwds (collectionid) where collectionid is not null;
+
+create table if not exists emergency_contacts
+(
+    contactid serial not null
+        constraint emergency_contacts_pk
+            primary key,
+    grantor varchar(36) not null
+        constraint emergency_contacts_grantor_fk
+            references users on delete cascade,
+    grantee varchar(36) not null
+        constraint emergency_contacts_grantee_fk
+            references users on delete cascade,
+    access varchar(8) not null
+        constraint emergency_contacts_access_check
+            check (access in ('view', 'takeover')),
+    waitdays integer not null
+        constraint emergency_contacts_waitdays_check
+            check (waitdays >= 0),
+    status varchar(9) not null default 'invited'
+        constraint emergency_contacts_status_check
+            check (status in ('invited', 'accepted', 'requested', 'granted')),
+    wrappedkey text not null,
+    requestdate timestamp,
+    constraint emergency_contacts_grantor_grantee_key
+        unique (grantor, grantee),
+    constraint emergency_contacts_self_check
+        check (grantor <> grantee)
+);
+
+create index if not exists emergency_contacts_grantee_idx on emergency_contacts (grantee);
+
+create table if not exists sends
+(
+    sendid varchar(32) not null
+        constraint sends_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint sends_users_uuid_fk
+            references users on delete cascade,
+    type varchar(8) not null
+        constraint sends_type_check
+            check (type in ('text', 'file')),
+    name text not null default '',
+    content text not null,
+    expires timestamp not null,
+    maxviews integer not null
+        constraint sends_maxviews_check
+            check (maxviews > 0),
+    views integer not null default 0,
+    passwordhash bytea,
+    failedattempts integer not null default 0,
+    createdate timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists sends_uuid_idx on sends (uuid);
+
+create table if not exists exports
+(
+    exportid serial not null
+        constraint exports_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint exports_users_uuid_fk
+            references users on delete cascade,
+    format varchar(4) not null
+        constraint exports_format_check
+            check (format in ('json', 'csv', 'kdbx')),
+    entries integer not null,
+    address text not null default '',
+    useragent text not null default '',
+    createdate timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists exports_uuid_idx on exports (uuid, createdate);
+`,
+		Down: `
+drop table if exists exports cascade;
+drop table if exists sends cascade;
+drop table if exists emergency_contacts cascade;
+drop table if exists collection_grants cascade;
+drop table if exists collections cascade;
+drop table if exists memberships cascade;
+drop table if exists organizations cascade;
+drop table if exists shares cascade;
+drop table if exists attachment_blobs cascade;
+drop table if exists attachments cascade;
+drop table if exists equivalent_domains cascade;
+drop table if exists passwd_usage cascade;
+drop table if exists passwd_uris cascade;
+drop table if exists passwd_tags cascade;
+drop table if exists tags cascade;
+drop table if exists folders cascade;
+drop table if exists authenticators cascade;
+drop table if exists sessions cascade;
+drop table if exists passwds cascade;
+drop table if exists users cascade;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "widen_entry_columns",
+		Up: `
+alter table passwds alter column passwd type text;
+alter table passwds alter column username type text;
+`,
+		Down: `
+alter table passwds alter column username type varchar(36) using left(username, 36);
+`,
+	},
+	{
+		Version: 3,
+		Name:    "widen_master_password",
+		Up: `
+alter table users alter column masterpasswd type text;
+`,
+		// fails while master passwords are encrypted at rest, they do not fit the former column
+		Down: `
+alter table users alter column masterpasswd type varchar(32);
+`,
+	},
+	{
+		Version: 4,
+		Name:    "sync_revisions",
+		Up: `
+alter table users add column if not exists revision bigint not null default 0;
+alter table users add column if not exists syncpruned bigint not null default 0;
+alter table passwds add column if not exists revision bigint not null default 0;
+alter table folders add column if not exists revision bigint not null default 0;
+alter table tags add column if not exists revision bigint not null default 0;
+
+create index if not exists passwds_uuid_revision_idx on passwds (uuid, revision);
+create index if not exists folders_uuid_revision_idx on folders (uuid, revision);
+create index if not exists tags_uuid_revision_idx on tags (uuid, revision);
+
+create table if not exists sync_deletions
+(
+    deletionid serial not null
+        constraint sync_deletions_pk
+            primary key,
+    uuid varchar(36) not null,
+    kind text not null
+        constraint sync_deletions_kind_check
+            check (kind in ('entry', 'folder', 'tag')),
+    itemid integer not null,
+    revision bigint not null,
+    createdate timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists sync_deletions_uuid_revision_idx on sync_deletions (uuid, revision);
+create index if not exists sync_deletions_createdate_idx on sync_deletions (createdate);
+
+-- the row lock on the user orders the revisions of concurrent transactions by their commit
+create or replace function next_revision(owner varchar) returns bigint as $$
+    update users set revision = revision + 1 where uuid = owner returning revision;
+$$ language sql;
+
+create or replace function sync_revision() returns trigger as $$
+begin
+    NEW.revision := COALESCE(next_revision(NEW.uuid), 0);
+    return NEW;
+end;
+$$ language plpgsql;
+
+-- TG_ARGV: the kind of the deletion marker and the id column, rows deleted together with their user leave no marker
+create or replace function sync_deletion() returns trigger as $$
+declare
+    deleted bigint;
+begin
+    deleted := next_revision(OLD.uuid);
+    if deleted is not null then
+        insert into sync_deletions (uuid, kind, itemid, revision)
+        values (OLD.uuid, TG_ARGV[0], (to_jsonb(OLD) ->> TG_ARGV[1])::integer, deleted);
+    end if;
+    return OLD;
+end;
+$$ language plpgsql;
+
+-- changed tags and uris change the revision of their entry
+create or replace function sync_entry_revision() returns trigger as $$
+declare
+    entry integer;
+begin
+    if TG_OP = 'DELETE' then
+        entry := OLD.entryid;
+    else
+        entry := NEW.entryid;
+    end if;
+    update passwds set revision = next_revision(uuid) where entryid = entry;
+    return null;
+end;
+$$ language plpgsql;
+
+-- ./server rewrap changes the ciphertext of the passwords but not the passwords, it sets keycloud.rewrap
+create trigger passwds_sync_revision
+    before insert or update of url, passwd, username, folderid, name, type, match, favorite, deletedate,
+        expires, rotationdays, sharedpasswd, sharekey, collectionid
+    on passwds for each row when (current_setting('keycloud.rewrap', true) is distinct from 'on')
+    execute procedure sync_revision();
+create trigger passwds_sync_deletion
+    after delete on passwds for each row execute procedure sync_deletion('entry', 'entryid');
+create trigger folders_sync_revision
+    before insert or update of name, parentid on folders for each row execute procedure sync_revision();
+create trigger folders_sync_deletion
+    after delete on folders for each row execute procedure sync_deletion('folder', 'folderid');
+create trigger tags_sync_revision
+    before insert or update of name on tags for each row execute procedure sync_revision();
+create trigger tags_sync_deletion
+    after delete on tags for each row execute procedure sync_deletion('tag', 'tagid');
+create trigger passwd_tags_sync_revision
+    after insert or delete on passwd_tags for each row execute procedure sync_entry_revision();
+create trigger passwd_uris_sync_revision
+    after insert or update or delete on passwd_uris for each row execute procedure sync_entry_revision();
+`,
+		Down: `
+drop trigger if exists passwd_uris_sync_revision on passwd_uris;
+drop trigger if exists passwd_tags_sync_revision on passwd_tags;
+drop trigger if exists tags_sync_deletion on tags;
+drop trigger if exists tags_sync_revision on tags;
+drop trigger if exists folders_sync_deletion on folders;
+drop trigger if exists folders_sync_revision on folders;
+drop trigger if exists passwds_sync_deletion on passwds;
+drop trigger if exists passwds_sync_revision on passwds;
+drop function if exists sync_entry_revision();
+drop function if exists sync_deletion();
+drop function if exists sync_revision();
+drop function if exists next_revision(varchar);
+drop table if exists sync_deletions cascade;
+alter table tags drop column if exists revision;
+alter table folders drop column if exists revision;
+alter table passwds drop column if exists revision;
+alter table users drop column if exists syncpruned;
+alter table users drop column if exists revision;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "vault_events",
+		Up: `
+-- the users who may read an entry besides its owner: the recipients of its shares and the members of its collection
+-- with the same rules as the policy of the server
+create or replace function entry_readers(entry integer, collection integer) returns setof varchar as $$
+    select recipient from shares where entryid = entry
+    union
+    select m.uuid from collections c
+    join memberships m on m.orgid = c.orgid and m.status = 'accepted'
+    where c.collectionid = collection and (m.role in ('owner', 'admin')
+        or exists (select 1 from collection_grants g where g.collectionid = c.collectionid and g.uuid = m.uuid));
+$$ language sql stable;
+
+-- the servers listen on vault_events and push the notifications to the clients of the user, they are sent on commit.
+-- The events of an entry are published to its owner and the users who may read it.
+create or replace function vault_event() returns trigger as $$
+declare
+    action text;
+    reader varchar;
+begin
+    if TG_OP = 'INSERT' then
+        action := 'created';
+    elsif (to_jsonb(OLD) ->> 'deletedate') is null and (to_jsonb(NEW) ->> 'deletedate') is not null then
+        action := 'trashed';
+    elsif (to_jsonb(OLD) ->> 'deletedate') is not null and (to_jsonb(NEW) ->> 'deletedate') is null then
+        action := 'restored';
+    else
+        action := 'updated';
+    end if;
+    perform pg_notify('vault_events', json_build_object('user', NEW.uuid, 'type', TG_ARGV[0], 'action', action,
+        'id', to_jsonb(NEW) ->> TG_ARGV[1], 'revision', NEW.revision)::text);
+    if TG_ARGV[0] = 'entry' then
+        for reader in select r from entry_readers((to_jsonb(NEW) ->> TG_ARGV[1])::integer,
+            (to_jsonb(NEW) ->> 'collectionid')::integer) r where r <> NEW.uuid loop
+            perform pg_notify('vault_events', json_build_object('user', reader, 'type', TG_ARGV[0], 'action', action,
+                'id', to_jsonb(NEW) ->> TG_ARGV[1], 'revision', NEW.revision)::text);
+        end loop;
+    end if;
+    return null;
+end;
+$$ language plpgsql;
+
+create or replace function vault_deletion_event() returns trigger as $$
+begin
+    perform pg_notify('vault_events', json_build_object('user', NEW.uuid, 'type', NEW.kind, 'action', 'deleted',
+        'id', NEW.itemid::text, 'revision', NEW.revision)::text);
+    return null;
+end;
+$$ language plpgsql;
+
+-- the owner learns about the deletion from its marker, the readers before the shares are deleted along with the entry
+create or replace function vault_readers_deletion_event() returns trigger as $$
+declare
+    reader varchar;
+begin
+    for reader in select r from entry_readers(OLD.entryid, OLD.collectionid) r where r <> OLD.uuid loop
+        perform pg_notify('vault_events', json_build_object('user', reader, 'type', 'entry', 'action', 'deleted',
+            'id', OLD.entryid::text, 'revision', OLD.revision)::text);
+    end loop;
+    return OLD;
+end;
+$$ language plpgsql;
+
+-- only changes which advance the revision are published, the usage statistics are not
+create trigger passwds_vault_created
+    after insert on passwds for each row execute procedure vault_event('entry', 'entryid');
+create trigger passwds_vault_updated
+    after update on passwds for each row when (OLD.revision is distinct from NEW.revision)
+    execute procedure vault_event('entry', 'entryid');
+create trigger folders_vault_created
+    after insert on folders for each row execute procedure vault_event('folder', 'folderid');
+create trigger folders_vault_updated
+    after update on folders for each row when (OLD.revision is distinct from NEW.revision)
+    execute procedure vault_event('folder', 'folderid');
+create trigger tags_vault_created
+    after insert on tags for each row execute procedure vault_event('tag', 'tagid');
+create trigger tags_vault_updated
+    after update on tags for each row when (OLD.revision is distinct from NEW.revision)
+    execute procedure vault_event('tag', 'tagid');
+create trigger sync_deletions_vault_deleted
+    after insert on sync_deletions for each row execute procedure vault_deletion_event();
+create trigger passwds_vault_deleted
+    before delete on passwds for each row execute procedure vault_readers_deletion_event();
+`,
+		Down: `
+drop trigger if exists passwds_vault_deleted on passwds;
+drop trigger if exists sync_deletions_vault_deleted on sync_deletions;
+drop trigger if exists tags_vault_updated on tags;
+drop trigger if exists tags_vault_created on tags;
+drop trigger if exists folders_vault_updated on folders;
+drop trigger if exists folders_vault_created on folders;
+drop trigger if exists passwds_vault_updated on passwds;
+drop trigger if exists passwds_vault_created on passwds;
+drop function if exists vault_readers_deletion_event();
+drop function if exists vault_deletion_event();
+drop function if exists vault_event();
+drop function if exists entry_readers(integer, integer);
+`,
+	},
+	{
+		Version: 6,
+		Name:    "entry_health",
+		Up: `
+alter table passwds add column if not exists fingerprint text;
+alter table passwds add column if not exists strength smallint;
+alter table passwds add column if not exists healthdate timestamp;
+`,
+		Down: `
+alter table passwds drop column if exists healthdate;
+alter table passwds drop column if exists strength;
+alter table passwds drop column if exists fingerprint;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "breach_monitoring",
+		Up: `
+-- the corpus lists the SHA-256 of the lowercased, trimmed mail addresses and usernames of a breach
+create table if not exists breaches
+(
+    name text not null
+        constraint breaches_pk
+            primary key,
+    title text not null,
+    domain text not null default '',
+    breachdate date,
+    dataclasses text[] not null default '{}',
+    importdate timestamp not null default current_timestamp
+);
+
+create table if not exists breach_identifiers
+(
+    hash char(64) not null,
+    breach text not null
+        constraint breach_identifiers_breaches_name_fk
+            references breaches on delete cascade,
+    constraint breach_identifiers_pk
+        primary key (hash, breach)
+);
+
+create index if not exists breach_identifiers_breach_idx on breach_identifiers (breach);
+
+-- the identifier of the username of an entry is reported by the client as the username is encrypted
+alter table passwds add column if not exists identifier char(64);
+
+-- a finding without an entry is the mail of the account
+create table if not exists breach_findings
+(
+    uuid varchar(36) not null
+        constraint breach_findings_users_uuid_fk
+            references users on delete cascade,
+    entryid integer
+        constraint breach_findings_passwds_entryid_fk
+            references passwds on delete cascade,
+    breach text not null
+        constraint breach_findings_breaches_name_fk
+            references breaches on delete cascade,
+    founddate timestamp not null default current_timestamp
+);
+
+create unique index if not exists breach_findings_account_idx on breach_findings (uuid, coalesce(entryid, 0), breach);
+`,
+		Down: `
+drop table if exists breach_findings;
+alter table passwds drop column if exists identifier;
+drop table if exists breach_identifiers;
+drop table if exists breaches;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "password_rules",
+		Up: `
+create table if not exists password_rules
+(
+    uuid varchar(36) not null
+        constraint password_rules_users_uuid_fk
+            references users on delete cascade,
+    domain text not null
+        constraint password_rules_domain_check
+            check (domain <> ''::text),
+    rules text not null,
+    constraint password_rules_pk
+        primary key (uuid, domain)
+);
+`,
+		Down: `
+drop table if exists password_rules;
+`,
+	},
+}
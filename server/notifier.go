@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReminderEntry describes a due entry without its password
+type ReminderEntry struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Url      string    `json:"url"`
+	Username string    `json:"username"`
+	Due      time.Time `json:"due"`
+}
+
+// ReminderDigest collects all entries of a user which are expired or due soon
+type ReminderDigest struct {
+	User    string           `json:"user"`
+	Mail    string           `json:"mail"`
+	Created time.Time        `json:"created"`
+	Expired []*ReminderEntry `json:"expired"`
+	DueSoon []*ReminderEntry `json:"duesoon"`
+}
+
+func newReminderDigest(user *User, passwords []*Password, now time.Time) *ReminderDigest {
+	digest := &ReminderDigest{
+		User:    user.Name,
+		Mail:    user.Mail,
+		Created: now,
+		Expired: make([]*ReminderEntry, 0),
+		DueSoon: make([]*ReminderEntry, 0),
+	}
+	for _, password := range passwords {
+		if password.Due == nil {
+			continue
+		}
+		entry := &ReminderEntry{
+			Id:       password.Id,
+			Name:     password.Name,
+			Url:      password.Url,
+			Username: password.Username,
+			Due:      *password.Due,
+		}
+		if entry.Due.After(now) {
+			digest.DueSoon = append(digest.DueSoon, entry)
+		} else {
+			digest.Expired = append(digest.Expired, entry)
+		}
+	}
+	return digest
+}
+
+// EmergencyNotice informs grantor or grantee of an emergency contact about a change of its state
+type EmergencyNotice struct {
+	User    string    `json:"user"`
+	Mail    string    `json:"mail"`
+	Created time.Time `json:"created"`
+	// Event is one of accepted, requested, approved, rejected and granted
+	Event    string     `json:"event"`
+	Grantor  string     `json:"grantor"`
+	Grantee  string     `json:"grantee"`
+	GrantsAt *time.Time `json:"grantsat,omitempty"`
+}
+
+// Notifier delivers the reminder digests and emergency access notices to the users
+type Notifier interface {
+	Notify(user *User, digest *ReminderDigest) error
+	NotifyEmergency(user *User, notice *EmergencyNotice) error
+}
+
+// LogNotifier prints the digests to the server log
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(user *User, digest *ReminderDigest) error {
+	fmt.Printf("Reminder for %s: %d expired and %d soon due passwords\n", digest.User, len(digest.Expired), len(digest.DueSoon))
+	return nil
+}
+
+func (LogNotifier) NotifyEmergency(user *User, notice *EmergencyNotice) error {
+	fmt.Printf("Emergency access of %s to the vault of %s %s, notifying %s\n", notice.Grantee, notice.Grantor, notice.Event, notice.User)
+	return nil
+}
+
+// FileNotifier appends every digest as json line to a file
+type FileNotifier struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (notifier *FileNotifier) Notify(user *User, digest *ReminderDigest) error {
+	return notifier.append(digest)
+}
+
+func (notifier *FileNotifier) NotifyEmergency(user *User, notice *EmergencyNotice) error {
+	return notifier.append(notice)
+}
+
+func (notifier *FileNotifier) append(message interface{}) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	file, err := os.OpenFile(notifier.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"database/sql"
+)
+
+func QueryOrganizations(db *sql.DB, user *User) (orgs []*Organization, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT o.orgid, o.name, m.role, m.status, m.orgkey FROM organizations o " +
+		"JOIN memberships m ON m.orgid = o.orgid WHERE m.uuid = $1 ORDER BY lower(o.name), o.orgid")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		org := &Organization{}
+		err = rows.Scan(&org.Id, &org.Name, &org.Role, &org.Status, &org.OrgKey)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// CreateOrganization creates the organization with the user as its owner
+func CreateOrganization(db *sql.DB, user *User, org *Organization) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO organizations (name) VALUES ($1) RETURNING orgid")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(org.Name).Scan(&org.Id)
+	if err != nil {
+		return err
+	}
+	org.Role, org.Status = RoleOwner, MembershipAccepted
+	_, err = tx.Exec("INSERT INTO memberships (orgid, uuid, role, status, orgkey) VALUES ($1, $2, $3, $4, $5)",
+		org.Id, user.Uuid, org.Role, org.Status, org.OrgKey)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteOrganization(db *sql.DB, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// the owner deletes the entries of the collections with the organization, a collection keeps its entries otherwise
+	_, err = tx.Exec("DELETE FROM passwds WHERE collectionid IN (SELECT collectionid FROM collections WHERE orgid = $1)", id)
+	if err != nil {
+		return err
+	}
+	// prepare statement, the collections are removed with the organization
+	stmt, err := tx.Prepare("DELETE FROM organizations WHERE orgid = $1")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryMembership(db *sql.DB, user *User, org string) (membership *Membership, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT m.orgid, u.name, m.role, m.status, m.orgkey FROM memberships m " +
+		"JOIN users u ON u.uuid = m.uuid WHERE m.orgid = $1 AND m.uuid = $2")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	membership = &Membership{}
+	err = stmt.QueryRow(org, user.Uuid).Scan(&membership.Organization, &membership.Username, &membership.Role,
+		&membership.Status, &membership.OrgKey)
+	if err != nil {
+		return nil, err
+	}
+	return membership, nil
+}
+
+func QueryMembers(db *sql.DB, org string) (members []*Membership, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement, the keys of the other members are not listed
+	stmt, err := db.Prepare("SELECT m.orgid, u.name, m.role, m.status FROM memberships m " +
+		"JOIN users u ON u.uuid = m.uuid WHERE m.orgid = $1 ORDER BY u.name")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(org)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		member := &Membership{}
+		err = rows.Scan(&member.Organization, &member.Username, &member.Role, &member.Status)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// SaveMember invites a user, an invitation of a member changes its role and an empty key keeps the existing one
+func SaveMember(db *sql.DB, member *Membership) (err error) {
+	return saveMember(db, member, "INSERT INTO memberships (orgid, uuid, role, status, orgkey) "+
+		"SELECT $1, uuid, $2, 'invited', $3 FROM users WHERE name = $4 "+
+		"ON CONFLICT ON CONSTRAINT memberships_pk DO UPDATE SET role = $2, orgkey = COALESCE(NULLIF($3, ''), memberships.orgkey)")
+}
+
+// UpdateMember changes the role of an existing member, the key of the organization is only replaced when given
+func UpdateMember(db *sql.DB, member *Membership) (err error) {
+	return saveMember(db, member, "UPDATE memberships m SET role = $2, orgkey = COALESCE(NULLIF($3, ''), m.orgkey) "+
+		"FROM users u WHERE m.orgid = $1 AND u.uuid = m.uuid AND u.name = $4")
+}
+
+// saveMember invites or changes the member with the query
+func saveMember(db *sql.DB, member *Membership, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, member.Organization, member.Role, member.OrgKey, member.Username)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func AcceptMembership(db *sql.DB, user *User, org string) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("UPDATE memberships SET status = 'accepted' WHERE orgid = $1 AND uuid = $2 AND status = 'invited'")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, org, user.Uuid)
+}
+
+// DeleteMember removes the member together with its grants on the collections of the organization
+func DeleteMember(db *sql.DB, org string, username string) (err error) {
+	return deleteMember(db, org, username, "DELETE FROM memberships m USING users u WHERE m.orgid = $1 AND u.uuid = m.uuid AND u.name = $2",
+		"DELETE FROM collection_grants g USING collections c, users u WHERE c.collectionid = g.collectionid "+
+			"AND c.orgid = $1 AND u.uuid = g.uuid AND u.name = $2")
+}
+
+// deleteMember removes the user $2 from the organization $1 with the query member and the grants with the query grants
+func deleteMember(db *sql.DB, org string, username string, member string, grants string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(member)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, org, username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(grants, org, username)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// QueryCollections returns the collections of the organization the user can access, admins and owners access all
+func QueryCollections(db *sql.DB, user *User, org string) (collections []*Collection, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT c.collectionid, c.orgid, c.name, CASE WHEN m.role IN ('owner', 'admin') THEN 'edit' " +
+		"ELSE COALESCE(g.permission, '') END FROM collections c JOIN memberships m ON m.orgid = c.orgid AND m.uuid = $1 " +
+		"LEFT JOIN collection_grants g ON g.collectionid = c.collectionid AND g.uuid = $1 WHERE c.orgid = $2 " +
+		"AND (m.role IN ('owner', 'admin', 'manager') OR g.permission IS NOT NULL) ORDER BY lower(c.name), c.collectionid")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, org)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		collection := &Collection{}
+		err = rows.Scan(&collection.Id, &collection.Organization, &collection.Name, &collection.Permission)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func QueryCollection(db *sql.DB, id string) (collection *Collection, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT collectionid, orgid, name FROM collections WHERE collectionid = $1")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	collection = &Collection{}
+	err = stmt.QueryRow(id).Scan(&collection.Id, &collection.Organization, &collection.Name)
+	if err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func CreateCollection(db *sql.DB, collection *Collection) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO collections (orgid, name) VALUES ($1, $2) RETURNING collectionid")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(collection.Organization, collection.Name).Scan(&collection.Id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdateCollection(db *sql.DB, collection *Collection) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE collections SET name = $1 WHERE collectionid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, collection.Name, collection.Id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// DeleteCollection only deletes collections without entries, the entries in the trash have to be purged first
+func DeleteCollection(db *sql.DB, id string) (err error) {
+	return deleteCollection(db, id, "DELETE FROM collections c WHERE c.collectionid = $1 "+
+		"AND NOT EXISTS (SELECT 1 FROM passwds p WHERE p.collectionid = c.collectionid)")
+}
+
+// deleteCollection deletes the empty collection $1 with the query
+func deleteCollection(db *sql.DB, id string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryCollectionGrants(db *sql.DB, collection string) (grants []*CollectionGrant, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT g.collectionid, u.name, g.permission FROM collection_grants g " +
+		"JOIN users u ON u.uuid = g.uuid WHERE g.collectionid = $1 ORDER BY u.name")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		grant := &CollectionGrant{}
+		err = rows.Scan(&grant.Collection, &grant.Username, &grant.Permission)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, rows.Err()
+}
+
+// SaveCollectionGrant grants a member of the organization of the collection access to it
+func SaveCollectionGrant(db *sql.DB, grant *CollectionGrant) (err error) {
+	return saveCollectionGrant(db, grant, "INSERT INTO collection_grants (collectionid, uuid, permission) "+
+		"SELECT c.collectionid, m.uuid, $2 FROM collections c JOIN memberships m ON m.orgid = c.orgid "+
+		"JOIN users u ON u.uuid = m.uuid WHERE c.collectionid = $1 AND u.name = $3 "+
+		"ON CONFLICT ON CONSTRAINT collection_grants_pk DO UPDATE SET permission = $2")
+}
+
+// saveCollectionGrant grants the member access to the collection with the query
+func saveCollectionGrant(db *sql.DB, grant *CollectionGrant, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, grant.Collection, grant.Permission, grant.Username)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteCollectionGrant(db *sql.DB, collection string, username string) (err error) {
+	return deleteCollectionGrant(db, collection, username, "DELETE FROM collection_grants g USING users u WHERE g.collectionid = $1 AND u.uuid = g.uuid AND u.name = $2")
+}
+
+// deleteCollectionGrant revokes the access of the user $2 to the collection $1 with the query
+func deleteCollectionGrant(db *sql.DB, collection string, username string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, collection, username)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// CountCollectionEntries counts the entries the user created in collections, they would be deleted with the account
+func CountCollectionEntries(db *sql.DB, user *User) (count int, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT COUNT(*) FROM passwds WHERE uuid = $1 AND collectionid IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// QueryEntryAccess collects everything the Policy needs to know about the relation of the user to the entry
+func QueryEntryAccess(db *sql.DB, user *User, id string) (access *EntryAccess, err error) {
+	return queryEntryAccess(db, user, id, "SELECT p.uuid, COALESCE(p.collectionid::text, ''), COALESCE(c.orgid::text, ''), "+
+		"COALESCE(m.role, ''), COALESCE(g.permission, ''), COALESCE(s.permission, ''), COALESCE(e.access, ''), p.revision FROM passwds p "+
+		"LEFT JOIN collections c ON c.collectionid = p.collectionid "+
+		"LEFT JOIN memberships m ON m.orgid = c.orgid AND m.uuid = $2 AND m.status = 'accepted' "+
+		"LEFT JOIN collection_grants g ON g.collectionid = p.collectionid AND g.uuid = $2 "+
+		"LEFT JOIN shares s ON s.entryid = p.entryid AND s.recipient = $2 "+
+		"LEFT JOIN emergency_contacts e ON e.grantor = p.uuid AND e.grantee = $2 AND e.status = 'granted' WHERE p.entryid = $1")
+}
+
+// queryEntryAccess reads the relation of the user $2 to the entry $1 with the query
+func queryEntryAccess(db *sql.DB, user *User, id string, query string) (access *EntryAccess, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	access = &EntryAccess{}
+	err = stmt.QueryRow(id, user.Uuid).Scan(&access.Owner, &access.Collection, &access.Organization,
+		&access.Role, &access.Grant, &access.Share, &access.Emergency, &access.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+// UpdatePasswordCollection moves the entry into a collection, its password has to be encrypted with the organization key.
+// An empty collection moves it back into the personal vault of the owner.
+func UpdatePasswordCollection(db *sql.DB, owner *User, id string, collection string, password string) (err error) {
+	return updatePasswordCollection(db, owner, id, collection, password, "UPDATE passwds SET collectionid = NULLIF($1, '')::integer, passwd = $2, folderid = NULL, "+
+		"sharedpasswd = NULL, sharekey = NULL WHERE entryid = $3 AND uuid = $4 AND deletedate IS NULL")
+}
+
+// updatePasswordCollection moves the entry into the collection with the query
+func updatePasswordCollection(db *sql.DB, owner *User, id string, collection string, password string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement, shares and folders are personal and do not apply to collections
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, collection, password, id, owner.Uuid)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM shares WHERE entryid = $1", id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+type OrganizationRequest struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	OrgKey string `json:"orgkey"`
+}
+
+type MemberRequest struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// OrgKey is the key of the organization wrapped for the public key of the invited user
+	OrgKey string `json:"orgkey"`
+}
+
+type CollectionRequest struct {
+	Id           string `json:"id"`
+	Organization string `json:"organization"`
+	Name         string `json:"name"`
+}
+
+type PasswordCollectionRequest struct {
+	Id         string `json:"id"`
+	Collection string `json:"collection"`
+	// Password is encrypted with the key of the organization, or the personal key when leaving the collection
+	Password string `json:"password"`
+}
+
+var errLastOwner = errors.New("409 - An organization needs an owner - ")
+
+// checkMemberChange makes sure only owners appoint or demote owners and that the last owner stays
+func checkMemberChange(members []*Membership, actor *Membership, username string, role string) error {
+	var target *Membership
+	owners := 0
+	for _, member := range members {
+		if member.Username == username {
+			target = member
+		}
+		if member.Role == RoleOwner && member.Status == MembershipAccepted {
+			owners++
+		}
+	}
+	targetIsOwner := target != nil && target.Role == RoleOwner
+	if (targetIsOwner || role == RoleOwner) && actor.Role != RoleOwner {
+		return errForbidden
+	}
+	// an invited owner does not own the organization yet, only the last accepted owner has to stay
+	if targetIsOwner && target.Status == MembershipAccepted && role != RoleOwner && owners <= 1 {
+		return errLastOwner
+	}
+	return nil
+}
+
+func (handler CRUDHandler) GetOrganizations(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	orgs, err := handler.storage.GetOrganizations(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, orgs)
+}
+
+func (handler CRUDHandler) CreateOrganization(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var orgMsg OrganizationRequest
+	err = json.Unmarshal(b, &orgMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if orgMsg.Name == "" || orgMsg.OrgKey == "" {
+		http.Error(writer, "name and orgkey are required", http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.CreateOrganization(user, &Organization{Name: orgMsg.Name, OrgKey: orgMsg.OrgKey})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("CREATED", "", writer)
+}
+
+func (handler CRUDHandler) RemoveOrganization(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var orgMsg OrganizationRequest
+	err = json.Unmarshal(b, &orgMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeOrganization(user, orgMsg.Id, ActionDelete)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeleteOrganization(orgMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+func (handler CRUDHandler) GetMembers(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	org := request.URL.Query().Get("id")
+	_, err = handler.policy.AuthorizeOrganization(user, org, ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	members, err := handler.storage.GetMembers(org)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, members)
+}
+
+func (handler CRUDHandler) InviteMember(writer http.ResponseWriter, request *http.Request) {
+	handler.saveMember(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateMember(writer http.ResponseWriter, request *http.Request) {
+	handler.saveMember(writer, request, false)
+}
+
+func (handler CRUDHandler) saveMember(writer http.ResponseWriter, request *http.Request, invite bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var memberMsg MemberRequest
+	err = json.Unmarshal(b, &memberMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if memberMsg.Role == "" {
+		memberMsg.Role = RoleMember
+	}
+	if roleRanks[memberMsg.Role] == 0 {
+		http.Error(writer, "unknown role "+memberMsg.Role, http.StatusBadRequest)
+		return
+	}
+	if invite && memberMsg.OrgKey == "" {
+		http.Error(writer, "orgkey is required", http.StatusBadRequest)
+		return
+	}
+	actor, err := handler.policy.AuthorizeOrganization(user, memberMsg.Id, ActionAdminister)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	members, err := handler.storage.GetMembers(memberMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = checkMemberChange(members, actor, memberMsg.Username, memberMsg.Role)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	member := &Membership{
+		Organization: memberMsg.Id,
+		Username:     memberMsg.Username,
+		Role:         memberMsg.Role,
+		OrgKey:       memberMsg.OrgKey,
+	}
+	if invite {
+		err = handler.storage.SaveMember(member)
+	} else {
+		// only the role of a member is changed, a new member has to be invited
+		err = handler.storage.UpdateMember(member)
+	}
+	if err == sql.ErrNoRows && !invite {
+		http.Error(writer, "404 - Member not found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if invite {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+// RemoveMember removes a member, every member may leave the organization
+func (handler CRUDHandler) RemoveMember(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var memberMsg MemberRequest
+	err = json.Unmarshal(b, &memberMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := ActionAdminister
+	if memberMsg.Username == user.Name {
+		action = ActionRead
+	}
+	actor, err := handler.policy.AuthorizeOrganization(user, memberMsg.Id, action)
+	if err == errForbidden && action == ActionRead {
+		// declining an invitation
+		actor, err = handler.storage.GetMembership(user, memberMsg.Id)
+	}
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	members, err := handler.storage.GetMembers(memberMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = checkMemberChange(members, actor, memberMsg.Username, "")
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeleteMember(memberMsg.Id, memberMsg.Username)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+func (handler CRUDHandler) AcceptInvitation(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var orgMsg OrganizationRequest
+	err = json.Unmarshal(b, &orgMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.AcceptMembership(user, orgMsg.Id)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "404 - Invitation not found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+func (handler CRUDHandler) GetCollections(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	org := request.URL.Query().Get("organization")
+	_, err = handler.policy.AuthorizeOrganization(user, org, ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	collections, err := handler.storage.GetCollections(user, org)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, collections)
+}
+
+func (handler CRUDHandler) CreateCollection(writer http.ResponseWriter, request *http.Request) {
+	handler.saveCollection(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateCollection(writer http.ResponseWriter, request *http.Request) {
+	handler.saveCollection(writer, request, false)
+}
+
+func (handler CRUDHandler) saveCollection(writer http.ResponseWriter, request *http.Request, create bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var collectionMsg CollectionRequest
+	err = json.Unmarshal(b, &collectionMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if collectionMsg.Name == "" {
+		http.Error(writer, "name must not be empty", http.StatusBadRequest)
+		return
+	}
+	collection := &Collection{
+		Id:           collectionMsg.Id,
+		Organization: collectionMsg.Organization,
+		Name:         collectionMsg.Name,
+	}
+	if create {
+		_, err = handler.policy.AuthorizeOrganization(user, collection.Organization, ActionManage)
+	} else {
+		_, err = handler.policy.AuthorizeCollection(user, collection.Id, ActionManage)
+	}
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	if create {
+		err = handler.storage.CreateCollection(collection)
+	} else {
+		err = handler.storage.UpdateCollection(collection)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+// RemoveCollection deletes an empty collection
+func (handler CRUDHandler) RemoveCollection(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var collectionMsg CollectionRequest
+	err = json.Unmarshal(b, &collectionMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeCollection(user, collectionMsg.Id, ActionManage)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeleteCollection(collectionMsg.Id)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "409 - Collection is not empty, its entries have to be deleted and purged from the trash - ", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+func (handler CRUDHandler) GetCollectionGrants(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collection := request.URL.Query().Get("id")
+	_, err = handler.policy.AuthorizeCollection(user, collection, ActionManage)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	grants, err := handler.storage.GetCollectionGrants(collection)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, grants)
+}
+
+func (handler CRUDHandler) GrantCollection(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var grantMsg CollectionGrant
+	err = json.Unmarshal(b, &grantMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if grantMsg.Permission != PermissionRead && grantMsg.Permission != PermissionEdit {
+		http.Error(writer, "unknown permission "+grantMsg.Permission, http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeCollection(user, grantMsg.Collection, ActionManage)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	// only members of the organization can be granted access
+	err = handler.storage.SaveCollectionGrant(&grantMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+func (handler CRUDHandler) RevokeCollection(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var grantMsg CollectionGrant
+	err = json.Unmarshal(b, &grantMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeCollection(user, grantMsg.Collection, ActionManage)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.DeleteCollectionGrant(grantMsg.Collection, grantMsg.Username)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// MovePasswordToCollection moves an entry into a collection or back into the personal vault of its owner
+func (handler CRUDHandler) MovePasswordToCollection(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var moveMsg PasswordCollectionRequest
+	err = json.Unmarshal(b, &moveMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if moveMsg.Password == "" {
+		http.Error(writer, "password is required", http.StatusBadRequest)
+		return
+	}
+	err = handler.checkEnvelope("password", moveMsg.Password)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, moveMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err == nil && moveMsg.Collection != "" {
+		_, err = handler.policy.AuthorizeCollection(user, moveMsg.Collection, ActionWrite)
+	} else if err == nil && string(owner.Uuid) != string(user.Uuid) {
+		// only the owner can take an entry back into the personal vault
+		err = errForbidden
+	}
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordCollection(owner, moveMsg.Id, moveMsg.Collection, moveMsg.Password)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
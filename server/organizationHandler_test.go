@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCRUDHandler_InviteMemberAsMember(t *testing.T) {
+	req, err := http.NewRequest("POST", "/organization/member", bytes.NewBuffer([]byte(
+		`{"id": "1", "username": "jane", "role": "member", "orgkey": "KEY"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM memberships m").
+		ExpectQuery().WithArgs("1", []byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"orgid", "name", "role", "status", "orgkey"}).
+			AddRow("1", "john", "member", "accepted", "KEY"))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.InviteMember)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_UpdateMemberNotFound(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/organization/member", bytes.NewBuffer([]byte(
+		`{"id": "1", "username": "jane", "role": "admin"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM memberships m").
+		ExpectQuery().WithArgs("1", []byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"orgid", "name", "role", "status", "orgkey"}).
+			AddRow("1", "john", "owner", "accepted", "KEY"))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM memberships m").
+		ExpectQuery().WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"orgid", "name", "role", "status"}).
+			AddRow("1", "john", "owner", "accepted"))
+	mock.ExpectCommit()
+	// jane is no member, the change must not invite her
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE memberships m SET role").
+		ExpectExec().WithArgs("1", "admin", "", "jane").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.UpdateMember)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_MovePasswordToCollection(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/password/collection", bytes.NewBuffer([]byte(
+		`{"id": "3", "collection": "2", "password": "`+testEnvelope+`"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set("If-Match", entryETag(testEntryRevision))
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	mock.ExpectPrepare("SELECT (.+) FROM collections").
+		ExpectQuery().WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"collectionid", "orgid", "name"}).AddRow("2", "1", "Team"))
+	mock.ExpectPrepare("SELECT (.+) FROM memberships m").
+		ExpectQuery().WithArgs("1", []byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"orgid", "name", "role", "status", "orgkey"}).
+			AddRow("1", "john", "member", "accepted", "KEY"))
+	mock.ExpectPrepare("SELECT (.+) FROM collection_grants g").
+		ExpectQuery().WithArgs("2").
+		WillReturnRows(sqlmock.NewRows([]string{"collectionid", "name", "permission"}).AddRow("2", "john", "edit"))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE passwds SET collectionid").
+		ExpectExec().WithArgs("2", testEnvelope, "3", []byte("USERID")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM shares").WithArgs("3").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.MovePasswordToCollection)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"Status":"UPDATED","Error":""}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
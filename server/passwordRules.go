@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sources of the password rules of a domain, the rules of the user override the bundled ones
+const (
+	RulesSourceUser    = "user"
+	RulesSourceBundled = "bundled"
+)
+
+// Character classes of the passwordrules syntax, unicode is generated from ascii-printable
+var passwordRuleClasses = map[string]string{
+	"upper":           "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"lower":           "abcdefghijklmnopqrstuvwxyz",
+	"digit":           "0123456789",
+	"special":         " -~!@#$%^&*_+=`|(){}[:;\"'<>,.?]",
+	"ascii-printable": asciiPrintable(),
+	"unicode":         asciiPrintable(),
+}
+
+func asciiPrintable() string {
+	var chars strings.Builder
+	for c := byte(0x20); c <= 0x7e; c++ {
+		chars.WriteByte(c)
+	}
+	return chars.String()
+}
+
+// PasswordRuleSet is a rule of the passwordrules syntax resolved to characters, a generated password
+// has MinLength to MaxLength characters of Allowed with at least one of every Required set.
+// MaxLength and MaxConsecutive are 0 if they are not limited.
+type PasswordRuleSet struct {
+	MinLength      int      `json:"minlength"`
+	MaxLength      int      `json:"maxlength"`
+	MaxConsecutive int      `json:"maxconsecutive"`
+	Required       []string `json:"required"`
+	Allowed        string   `json:"allowed"`
+}
+
+// PasswordRule is the rule of a domain in the passwordrules syntax, e.g. "minlength: 8; maxlength: 16; required: lower;"
+type PasswordRule struct {
+	Domain string `json:"domain"`
+	Rules  string `json:"rules"`
+}
+
+// ResolvedPasswordRules are the rules applying to an url together with their characters
+type ResolvedPasswordRules struct {
+	PasswordRule
+	Source string `json:"source"`
+	*PasswordRuleSet
+}
+
+// splitPasswordRules splits the rules at the semicolons which are not part of a custom character class
+func splitPasswordRules(rules string) []string {
+	var parts []string
+	start, inClass := 0, false
+	for i, c := range rules {
+		switch {
+		case c == '[' && !inClass:
+			inClass = true
+		case c == ']' && inClass && classEnds(rules[i+1:]):
+			inClass = false
+		case c == ';' && !inClass:
+			parts = append(parts, rules[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, rules[start:])
+}
+
+// classEnds tells whether a bracket followed by the rest closes a custom character class, which is the case
+// if the next class or rule follows, so brackets can be part of a class
+func classEnds(rest string) bool {
+	rest = strings.TrimLeft(rest, " ")
+	return rest == "" || rest[0] == ',' || rest[0] == ';'
+}
+
+// parseRuleClasses returns the characters of the comma separated classes, a custom class lists its characters in brackets
+func parseRuleClasses(value string) (string, error) {
+	var chars strings.Builder
+	value = strings.TrimSpace(value)
+	for value != "" {
+		if strings.HasPrefix(value, "[") {
+			end := 1
+			for end < len(value) && !(value[end] == ']' && classEnds(value[end+1:])) {
+				end++
+			}
+			if end == len(value) {
+				return "", fmt.Errorf("unterminated character class %s", value)
+			}
+			for _, c := range value[1:end] {
+				if c < 0x20 || c > 0x7e {
+					return "", fmt.Errorf("unsupported character %q", c)
+				}
+				chars.WriteRune(c)
+			}
+			value = strings.TrimSpace(value[end+1:])
+		} else {
+			end := strings.IndexByte(value, ',')
+			if end < 0 {
+				end = len(value)
+			}
+			class := strings.ToLower(strings.TrimSpace(value[:end]))
+			named, ok := passwordRuleClasses[class]
+			if !ok {
+				return "", fmt.Errorf("unknown character class %s", class)
+			}
+			chars.WriteString(named)
+			value = strings.TrimSpace(value[end:])
+		}
+		if strings.HasPrefix(value, ",") {
+			value = strings.TrimSpace(value[1:])
+		} else if value != "" {
+			return "", fmt.Errorf("expected a comma before %s", value)
+		}
+	}
+	if chars.Len() == 0 {
+		return "", fmt.Errorf("empty character class")
+	}
+	return chars.String(), nil
+}
+
+// uniqueChars returns the characters of the value sorted and without duplicates
+func uniqueChars(value string) string {
+	seen := make(map[rune]bool)
+	var chars []rune
+	for _, c := range value {
+		if !seen[c] {
+			seen[c] = true
+			chars = append(chars, c)
+		}
+	}
+	sort.Slice(chars, func(i, j int) bool { return chars[i] < chars[j] })
+	return string(chars)
+}
+
+// ParsePasswordRules resolves rules of the passwordrules syntax, the strictest of repeated limits applies
+func ParsePasswordRules(rules string) (*PasswordRuleSet, error) {
+	set := &PasswordRuleSet{Required: make([]string, 0)}
+	var allowed strings.Builder
+	for _, part := range splitPasswordRules(rules) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		separator := strings.IndexByte(part, ':')
+		if separator < 0 {
+			return nil, fmt.Errorf("expected <property>: <value> in %s", part)
+		}
+		name := strings.ToLower(strings.TrimSpace(part[:separator]))
+		value := strings.TrimSpace(part[separator+1:])
+		switch name {
+		case "required", "allowed":
+			chars, err := parseRuleClasses(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			if name == "required" {
+				set.Required = append(set.Required, uniqueChars(chars))
+			}
+			// the required characters are allowed as well
+			allowed.WriteString(chars)
+		case "minlength", "maxlength", "max-consecutive":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				return nil, fmt.Errorf("%s has to be a positive number", name)
+			}
+			switch {
+			case name == "minlength" && limit > set.MinLength:
+				set.MinLength = limit
+			case name == "maxlength" && (set.MaxLength == 0 || limit < set.MaxLength):
+				set.MaxLength = limit
+			case name == "max-consecutive" && (set.MaxConsecutive == 0 || limit < set.MaxConsecutive):
+				set.MaxConsecutive = limit
+			}
+		default:
+			return nil, fmt.Errorf("unknown property %s", name)
+		}
+	}
+	set.Allowed = uniqueChars(allowed.String())
+	if set.Allowed == "" {
+		set.Allowed = passwordRuleClasses["ascii-printable"]
+	}
+	if set.MaxLength > 0 && set.MinLength > set.MaxLength {
+		return nil, fmt.Errorf("minlength %d is larger than maxlength %d", set.MinLength, set.MaxLength)
+	}
+	if set.MaxLength > 0 && len(set.Required) > set.MaxLength {
+		return nil, fmt.Errorf("%d required classes do not fit into maxlength %d", len(set.Required), set.MaxLength)
+	}
+	return set, nil
+}
+
+// PasswordRulesRegistry knows the bundled rules of the domains, they are read from the format of Apple's
+// password-rules.json: {"example.com": {"password-rules": "minlength: 8; required: lower;"}}
+type PasswordRulesRegistry struct {
+	rules map[string]string
+}
+
+func NewPasswordRulesRegistry(rules map[string]string) *PasswordRulesRegistry {
+	if rules == nil {
+		rules = make(map[string]string)
+	}
+	return &PasswordRulesRegistry{rules: rules}
+}
+
+// LoadPasswordRules reads the bundled rules, rules which cannot be parsed are left out
+func LoadPasswordRules(path string) (*PasswordRulesRegistry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var quirks map[string]struct {
+		Rules string `json:"password-rules"`
+	}
+	err = json.NewDecoder(file).Decode(&quirks)
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]string, len(quirks))
+	for domain, quirk := range quirks {
+		if _, err := ParsePasswordRules(quirk.Rules); err != nil {
+			fmt.Printf("Skipping the password rules of %s: %v\n", domain, err)
+			continue
+		}
+		rules[strings.ToLower(domain)] = quirk.Rules
+	}
+	return NewPasswordRulesRegistry(rules), nil
+}
+
+// ruleDomains lists the host of the url and its parents down to the registrable domain, the most specific first
+func ruleDomains(matcher *UrlMatcher, target string) []string {
+	u, err := parseUri(target)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	domain := matcher.suffixes.RegistrableDomain(host)
+	domains := []string{host}
+	for host != domain && strings.Contains(host, ".") {
+		host = host[strings.IndexByte(host, '.')+1:]
+		domains = append(domains, host)
+	}
+	return domains
+}
+
+// Resolve returns the rules applying to the url or nil, the rules of the user for the host or one of its parents
+// win over the bundled ones
+func (registry *PasswordRulesRegistry) Resolve(matcher *UrlMatcher, target string, overrides []*PasswordRule) *ResolvedPasswordRules {
+	domains := ruleDomains(matcher, target)
+	for _, domain := range domains {
+		for _, override := range overrides {
+			if override.Domain != domain {
+				continue
+			}
+			// the rules of the user are checked when they are saved
+			if set, err := ParsePasswordRules(override.Rules); err == nil {
+				return &ResolvedPasswordRules{PasswordRule: *override, Source: RulesSourceUser, PasswordRuleSet: set}
+			}
+		}
+	}
+	for _, domain := range domains {
+		if rules, ok := registry.rules[domain]; ok {
+			set, _ := ParsePasswordRules(rules)
+			return &ResolvedPasswordRules{PasswordRule: PasswordRule{Domain: domain, Rules: rules}, Source: RulesSourceBundled,
+				PasswordRuleSet: set}
+		}
+	}
+	return nil
+}
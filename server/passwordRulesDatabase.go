@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// QueryPasswordRules reads the rules the user has set for domains
+func QueryPasswordRules(db *sql.DB, user *User) (rules []*PasswordRule, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT domain, rules FROM password_rules WHERE uuid = $1 ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rule := &PasswordRule{}
+		err = rows.Scan(&rule.Domain, &rule.Rules)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SavePasswordRule sets the rules of the user for the domain, replacing the previous ones
+func SavePasswordRule(db *sql.DB, user *User, rule *PasswordRule) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO password_rules (uuid, domain, rules) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (uuid, domain) DO UPDATE SET rules = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(user.Uuid, rule.Domain, rule.Rules)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// DeletePasswordRule removes the rules of the user for the domain, the bundled ones apply again
+func DeletePasswordRule(db *sql.DB, user *User, domain string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM password_rules WHERE uuid = $1 AND domain = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, user.Uuid, domain)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
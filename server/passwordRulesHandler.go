@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// passwordRulesHeader carries the rules of the site in the answer of /password-by-url, like the passwordrules attribute
+const passwordRulesHeader = "Password-Rules"
+
+// normalizeRuleDomain returns the lowercase host of the domain or url, rules are looked up by the host and its parents
+func normalizeRuleDomain(domain string) (string, error) {
+	u, err := parseUri(domain)
+	if err != nil || u.Hostname() == "" {
+		return "", errors.New("invalid domain " + domain)
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), "."), nil
+}
+
+// GetPasswordRules lists the rules the user has set for domains
+func (handler CRUDHandler) GetPasswordRules(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules, err := handler.storage.GetPasswordRules(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, rules)
+}
+
+// SetPasswordRule sets the rules of the user for a domain, they override the bundled rules of the domain
+func (handler CRUDHandler) SetPasswordRule(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var rule PasswordRule
+	err = json.Unmarshal(b, &rule)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Domain, err = normalizeRuleDomain(rule.Domain)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.Rules = strings.TrimSpace(rule.Rules)
+	if rule.Rules == "" {
+		http.Error(writer, "rules are required", http.StatusBadRequest)
+		return
+	}
+	_, err = ParsePasswordRules(rule.Rules)
+	if err != nil {
+		http.Error(writer, "invalid rules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.SavePasswordRule(user, &rule)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// RemovePasswordRule removes the rules of the user for a domain
+func (handler CRUDHandler) RemovePasswordRule(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var rule PasswordRule
+	err = json.Unmarshal(b, &rule)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	domain, err := normalizeRuleDomain(rule.Domain)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeletePasswordRule(user, domain)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// LookupPasswordRules returns the rules applying to the url with their characters for the generator
+func (handler CRUDHandler) LookupPasswordRules(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := request.URL.Query().Get("url")
+	if target == "" {
+		http.Error(writer, "url is missing", http.StatusBadRequest)
+		return
+	}
+	overrides, err := handler.storage.GetPasswordRules(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rules := handler.passwordRules.Resolve(handler.matcher, target, overrides)
+	if rules == nil {
+		http.Error(writer, "no password rules for "+target, http.StatusNotFound)
+		return
+	}
+	rulesJson, err := json.Marshal(rules)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = fmt.Fprint(writer, string(rulesJson))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParsePasswordRules(t *testing.T) {
+	set, err := ParsePasswordRules("minlength: 8; maxlength: 20; max-consecutive: 3; required: lower, upper; " +
+		"required: digit; allowed: [-@#;,]; maxlength: 16;")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when parsing the rules", err)
+	}
+	expected := &PasswordRuleSet{
+		MinLength:      8,
+		MaxLength:      16,
+		MaxConsecutive: 3,
+		Required:       []string{"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "0123456789"},
+		Allowed:        "#,-0123456789;@ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	}
+	if !reflect.DeepEqual(set, expected) {
+		t.Errorf("unexpected rules: got %+v want %+v", set, expected)
+	}
+
+	// without allowed or required characters every printable ascii character is allowed
+	if set, err := ParsePasswordRules("minlength: 12"); err != nil || len(set.Allowed) != 95 {
+		t.Errorf("unexpected rules %+v, %v", set, err)
+	}
+
+	for _, rules := range []string{
+		"required: emoji;",
+		"minlength: twelve;",
+		"minlength: 20; maxlength: 10;",
+		"allowed: [abc;",
+		"forbidden: digit;",
+	} {
+		if _, err := ParsePasswordRules(rules); err == nil {
+			t.Errorf("the rules %s were accepted", rules)
+		}
+	}
+}
+
+func TestPasswordRulesRegistry_Resolve(t *testing.T) {
+	registry := NewPasswordRulesRegistry(map[string]string{
+		"example.com":       "minlength: 8;",
+		"login.example.com": "minlength: 10;",
+	})
+	matcher := NewUrlMatcher(nil)
+	overrides := []*PasswordRule{{Domain: "example.com", Rules: "minlength: 12;"}}
+	tests := []struct {
+		target    string
+		overrides []*PasswordRule
+		domain    string
+		source    string
+	}{
+		{"https://www.example.com/signup", nil, "example.com", RulesSourceBundled},
+		{"https://login.example.com/signup", nil, "login.example.com", RulesSourceBundled},
+		{"https://login.example.com/signup", overrides, "example.com", RulesSourceUser},
+	}
+	for _, test := range tests {
+		rules := registry.Resolve(matcher, test.target, test.overrides)
+		if rules == nil || rules.Domain != test.domain || rules.Source != test.source {
+			t.Errorf("unexpected rules of %s: got %+v", test.target, rules)
+		}
+	}
+	if rules := registry.Resolve(matcher, "https://example.org", overrides); rules != nil {
+		t.Errorf("unexpected rules of an unknown domain: %+v", rules)
+	}
+}
+
+func TestCRUDHandler_LookupPasswordRules(t *testing.T) {
+	req, err := http.NewRequest("GET", "/password-rules/lookup?url=https%3A%2F%2Fwww.ebay.com%2Fsignin", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM password_rules").
+		ExpectQuery().WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"domain", "rules"}))
+
+	// Set global values to mocked one, the bundled rules are loaded from password_rules.json
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.LookupPasswordRules)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"domain":"ebay.com","rules":"minlength: 6; maxlength: 64; required: lower, upper; required: digit;",` +
+		`"source":"bundled","minlength":6,"maxlength":64,"maxconsecutive":0,` +
+		`"required":["ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz","0123456789"],` +
+		`"allowed":"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_SetPasswordRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		expected string
+	}{
+		{"valid", `{"domain": "https://Login.Example.com/signup", "rules": "maxlength: 16; required: digit;"}`,
+			http.StatusOK, `{"Status":"UPDATED","Error":""}`},
+		{"invalid", `{"domain": "example.com", "rules": "maxlength: sixteen;"}`,
+			http.StatusBadRequest, "invalid rules: maxlength has to be a positive number\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "/password-rules", bytes.NewBuffer([]byte(test.body)))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when creating a request", err)
+			}
+			if req.Form == nil {
+				req.Form = make(map[string][]string)
+			}
+			req.Form.Add("UserId", string("USERID"))
+
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectPrepare("SELECT (.+) FROM users").
+				ExpectQuery().WithArgs("USERID").
+				WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+					AddRow("USERID", "john", "@", "password"))
+			mock.ExpectCommit()
+			if test.status == http.StatusOK {
+				mock.ExpectBegin()
+				mock.ExpectPrepare("INSERT INTO password_rules").
+					ExpectExec().WithArgs([]byte("USERID"), "login.example.com", "maxlength: 16; required: digit;").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			// Set global values to mocked one
+			initFromDatabaseAndRouter(db)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(crudHandler.SetPasswordRule)
+			handler.ServeHTTP(rr, req)
+			if status := rr.Code; status != test.status {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, test.status)
+			}
+
+			// Check the response body is what we expect.
+			if rr.Body.String() != test.expected {
+				t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), test.expected)
+			}
+
+			// we make sure that all expectations were met
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
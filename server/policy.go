@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Action is an operation the Policy decides on
+type Action int
+
+const (
+	// ActionRead reads an entry, an organization or a collection
+	ActionRead Action = iota
+	// ActionWrite changes an entry or the entries of a collection
+	ActionWrite
+	// ActionManage manages the collections of an organization and their grants
+	ActionManage
+	// ActionAdminister invites and removes the members of an organization
+	ActionAdminister
+	// ActionDelete deletes an organization
+	ActionDelete
+	// ActionShare shares a personal entry with other users, entries of collections can not be shared
+	ActionShare
+)
+
+var errForbidden = errors.New("403 - Forbidden - ")
+
+var roleRanks = map[string]int{RoleMember: 1, RoleManager: 2, RoleAdmin: 3, RoleOwner: 4}
+
+// roleActions is the most privileged action every role may execute on its organization
+var roleActions = map[string]Action{
+	RoleMember:  ActionRead,
+	RoleManager: ActionManage,
+	RoleAdmin:   ActionAdminister,
+	RoleOwner:   ActionDelete,
+}
+
+// policyCollectionRoles access every collection of their organization, the other members need a grant
+var policyCollectionRoles = []string{RoleOwner, RoleAdmin}
+
+// policyReadablePasswords selects the personal and collection entries the user $1 may read with the same rules as
+// authorizeEntryAccess. The entries shared with the user are joined by the listings, which return them with their keys.
+var policyReadablePasswords = "((p.uuid = $1 AND p.collectionid IS NULL) OR EXISTS (SELECT 1 FROM collections pc " +
+	"JOIN memberships pm ON pm.orgid = pc.orgid AND pm.uuid = $1 AND pm.status = '" + MembershipAccepted + "' " +
+	"WHERE pc.collectionid = p.collectionid AND (pm.role IN ('" + strings.Join(policyCollectionRoles, "', '") + "') " +
+	"OR EXISTS (SELECT 1 FROM collection_grants pg WHERE pg.collectionid = pc.collectionid AND pg.uuid = $1))))"
+
+// Policy decides which user may execute which action on entries, organizations and collections.
+// Every handler acting on them asks it first, also for the own entries of the user since they may be in a collection.
+type Policy struct {
+	storage StorageInterface
+}
+
+// AuthorizeOrganization returns the membership of the user if the role allows the action
+func (policy Policy) AuthorizeOrganization(user *User, org string, action Action) (*Membership, error) {
+	membership, err := policy.storage.GetMembership(user, org)
+	if err == sql.ErrNoRows {
+		return nil, errForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+	if membership.Status != MembershipAccepted || roleActions[membership.Role] < action {
+		return nil, errForbidden
+	}
+	return membership, nil
+}
+
+// AuthorizeCollection returns the collection if the user may execute the action on it.
+// Admins and owners access all collections, the others need a grant to read or write its entries.
+func (policy Policy) AuthorizeCollection(user *User, id string, action Action) (*Collection, error) {
+	collection, err := policy.storage.GetCollection(id)
+	if err == sql.ErrNoRows {
+		return nil, errForbidden
+	}
+	if err != nil {
+		return nil, err
+	}
+	membership, err := policy.AuthorizeOrganization(user, collection.Organization, ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(policyCollectionRoles, membership.Role) || action == ActionManage && membership.Role == RoleManager {
+		return collection, nil
+	}
+	if action > ActionWrite {
+		return nil, errForbidden
+	}
+	grants, err := policy.storage.GetCollectionGrants(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, grant := range grants {
+		if grant.Username == user.Name && (action == ActionRead || grant.Permission == PermissionEdit) {
+			return collection, nil
+		}
+	}
+	return nil, errForbidden
+}
+
+// AuthorizeEntry returns the owner of the entry if the user may execute the action on it,
+// the storage has to be called with the owner to change the entry.
+// Personal entries are only accessible by their owner, the users they are shared with and granted emergency contacts,
+// entries of collections only by the members of the organization with access to the collection.
+func (policy Policy) AuthorizeEntry(user *User, id string, action Action) (*User, error) {
+	owner, _, err := policy.authorizeEntryAccess(user, id, action)
+	return owner, err
+}
+
+// AuthorizeEntryVersion authorizes the action like AuthorizeEntry and checks the If-Match header of the request
+// against the current version of the entry, so changes based on a stale version are rejected.
+func (policy Policy) AuthorizeEntryVersion(user *User, id string, action Action, ifMatch string) (*User, error) {
+	owner, access, err := policy.authorizeEntryAccess(user, id, action)
+	if err != nil {
+		return nil, err
+	}
+	err = checkEntryVersion(ifMatch, access.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
+
+// AuthorizeFiling checks that the folders and the tags entries are created in or a listing is filtered by belong to the user
+func (policy Policy) AuthorizeFiling(user *User, entries ...*Password) error {
+	var folders, tags []string
+	for _, entry := range entries {
+		if entry.Folder != "" && entry.Folder != RootFolder {
+			folders = append(folders, entry.Folder)
+		}
+		tags = append(tags, entry.Tags...)
+	}
+	if len(folders) > 0 {
+		own, err := policy.storage.GetFolders(user)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, len(own))
+		for i, folder := range own {
+			ids[i] = folder.Id
+		}
+		for _, folder := range folders {
+			if !containsString(ids, folder) {
+				return errForbidden
+			}
+		}
+	}
+	if len(tags) > 0 {
+		own, err := policy.storage.GetTags(user)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, len(own))
+		for i, tag := range own {
+			ids[i] = tag.Id
+		}
+		for _, tag := range tags {
+			if !containsString(ids, tag) {
+				return errForbidden
+			}
+		}
+	}
+	return nil
+}
+
+func (policy Policy) authorizeEntryAccess(user *User, id string, action Action) (*User, *EntryAccess, error) {
+	// an id which is no entry id can not be found
+	if !validEntryId(id) {
+		return nil, nil, errForbidden
+	}
+	access, err := policy.storage.GetEntryAccess(user, id)
+	if err == sql.ErrNoRows {
+		return nil, nil, errForbidden
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	owner := &User{Uuid: access.Owner}
+	if access.Collection == "" {
+		if string(access.Owner) == string(user.Uuid) {
+			return user, access, nil
+		}
+		if action == ActionShare {
+			return nil, nil, errForbidden
+		}
+		if action == ActionRead && access.Share != "" || action == ActionWrite && access.Share == PermissionEdit {
+			return owner, access, nil
+		}
+		// granted emergency contacts view the personal entries of the owner or take them over
+		if action == ActionRead && access.Emergency != "" || action == ActionWrite && access.Emergency == EmergencyTakeover {
+			return owner, access, nil
+		}
+		return nil, nil, errForbidden
+	}
+	switch {
+	case action == ActionShare:
+		return nil, nil, errForbidden
+	case containsString(policyCollectionRoles, access.Role):
+		return owner, access, nil
+	case access.Role == RoleManager && action == ActionManage:
+		return owner, access, nil
+	case access.Role != "" && action == ActionRead && access.Grant != "":
+		return owner, access, nil
+	case access.Role != "" && action == ActionWrite && access.Grant == PermissionEdit:
+		return owner, access, nil
+	}
+	return nil, nil, errForbidden
+}
+
+// sendPolicyError answers with 403 for denied actions, 428 and 412 for a missing or stale If-Match header
+func sendPolicyError(writer http.ResponseWriter, err error) {
+	if err == errForbidden {
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err == errPreconditionRequired {
+		http.Error(writer, err.Error(), http.StatusPreconditionRequired)
+		return
+	}
+	if err == errLastOwner {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+	if stale, ok := err.(*staleVersionError); ok {
+		sendStaleVersion(writer, stale)
+		return
+	}
+	http.Error(writer, err.Error(), http.StatusInternalServerError)
+}
+
+// validEntryId reports whether the id can be the id of an entry, the queries compare the ids as integers
+func validEntryId(id string) bool {
+	entry, err := strconv.ParseInt(id, 10, 32)
+	return err == nil && entry > 0
+}
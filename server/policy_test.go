@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+)
+
+// testEntryRevision is the version of the entries mocked by expectEntryAccess
+const testEntryRevision = 7
+
+// expectEntryAccess mocks the lookup of the access of the user to an entry, a role places the entry in collection 1
+func expectEntryAccess(mock sqlmock.Sqlmock, id string, owner string, role string, grant string, share string, emergency string) {
+	collection, org := "", ""
+	if role != "" {
+		collection, org = "1", "1"
+	}
+	mock.ExpectPrepare("SELECT (.+) FROM passwds p LEFT JOIN collections").
+		ExpectQuery().WithArgs(id, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "collectionid", "orgid", "role", "permission", "share", "emergency", "revision"}).
+			AddRow(owner, collection, org, role, grant, share, emergency, testEntryRevision))
+}
+
+func TestPolicy_AuthorizeEntry(t *testing.T) {
+	tests := []struct {
+		name      string
+		owner     string
+		role      string
+		grant     string
+		share     string
+		emergency string
+		action    Action
+		allowed   bool
+	}{
+		{"own entry", "USERID", "", "", "", "", ActionShare, true},
+		{"foreign entry", "JANEID", "", "", "", "", ActionRead, false},
+		{"read share", "JANEID", "", "", "read", "", ActionRead, true},
+		{"write read share", "JANEID", "", "", "read", "", ActionWrite, false},
+		{"write edit share", "JANEID", "", "", "edit", "", ActionWrite, true},
+		{"reshare", "JANEID", "", "", "edit", "", ActionShare, false},
+		{"member without grant", "JANEID", RoleMember, "", "", "", ActionRead, false},
+		{"member with read grant", "JANEID", RoleMember, "read", "", "", ActionRead, true},
+		{"write read grant", "JANEID", RoleMember, "read", "", "", ActionWrite, false},
+		{"write edit grant", "JANEID", RoleMember, "edit", "", "", ActionWrite, true},
+		{"admin", "JANEID", RoleAdmin, "", "", "", ActionWrite, true},
+		{"emergency view", "JANEID", "", "", "", EmergencyView, ActionRead, true},
+		{"write emergency view", "JANEID", "", "", "", EmergencyView, ActionWrite, false},
+		{"emergency takeover", "JANEID", "", "", "", EmergencyTakeover, ActionWrite, true},
+		{"share collection entry", "USERID", RoleOwner, "", "", "", ActionShare, false},
+	}
+	for _, test := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		expectEntryAccess(mock, "3", test.owner, test.role, test.grant, test.share, test.emergency)
+
+		policy := Policy{storage: &Storage{database: db}}
+		owner, err := policy.AuthorizeEntry(&User{Uuid: []byte("USERID"), Name: "john"}, "3", test.action)
+		if test.allowed && (err != nil || string(owner.Uuid) != test.owner) {
+			t.Errorf("%s: expected access for owner %s, got %v", test.name, test.owner, err)
+		}
+		if !test.allowed && err != errForbidden {
+			t.Errorf("%s: expected access to be forbidden, got %v", test.name, err)
+		}
+		db.Close()
+	}
+	// ids which are no entry ids are not looked up
+	policy := Policy{storage: &Storage{}}
+	for _, id := range []string{"", "0", "abc", "3 OR 1=1", "99999999999"} {
+		if _, err := policy.AuthorizeEntry(&User{Uuid: []byte("USERID"), Name: "john"}, id, ActionRead); err != errForbidden {
+			t.Errorf("expected access to %q to be forbidden, got %v", id, err)
+		}
+	}
+}
+
+func TestCheckMemberChange(t *testing.T) {
+	members := []*Membership{
+		{Username: "john", Role: RoleOwner, Status: MembershipAccepted},
+		{Username: "jane", Role: RoleAdmin, Status: MembershipAccepted},
+		{Username: "joe", Role: RoleMember, Status: MembershipInvited},
+		{Username: "jim", Role: RoleOwner, Status: MembershipInvited},
+	}
+	if err := checkMemberChange(members, members[1], "joe", RoleManager); err != nil {
+		t.Errorf("admins should change the role of members, got %v", err)
+	}
+	if err := checkMemberChange(members, members[1], "joe", RoleOwner); err != errForbidden {
+		t.Errorf("admins must not appoint owners, got %v", err)
+	}
+	if err := checkMemberChange(members, members[1], "john", ""); err != errForbidden {
+		t.Errorf("admins must not remove owners, got %v", err)
+	}
+	if err := checkMemberChange(members, members[0], "john", ""); err != errLastOwner {
+		t.Errorf("the last owner must not leave, got %v", err)
+	}
+	if err := checkMemberChange(members, members[0], "jim", ""); err != nil {
+		t.Errorf("the invitation of an owner should be withdrawn, got %v", err)
+	}
+}
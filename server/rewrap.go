@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// sealedColumn is a column encrypted at rest, its rows are walked in the order of their key starting after first.
+// The values are bound to the key and the owner of their row.
+type sealedColumn struct {
+	name    string
+	table   string
+	key     string
+	keyType string
+	owner   string
+	column  string
+	first   string
+}
+
+var sealedColumns = []sealedColumn{
+	{name: columnPassword, table: "passwds", key: "entryid", keyType: "integer", owner: "uuid", column: "passwd", first: "0"},
+	{name: columnSharedPassword, table: "passwds", key: "entryid", keyType: "integer", owner: "uuid", column: "sharedpasswd", first: "0"},
+	{name: columnMasterPassword, table: "users", key: "uuid", keyType: "text", owner: "uuid", column: "masterpasswd", first: ""},
+}
+
+// rewrapBatchSize is the number of rows read at once, each row is updated on its own so writers are not blocked
+const rewrapBatchSize = 500
+
+// RewrapResult counts the rows of a column the re-wrap has changed and the ones changed by others in the meantime
+type RewrapResult struct {
+	Column    string
+	Rewrapped int
+	Skipped   int
+}
+
+// QuerySealedValues reads the owners and the values of the column of the rows following the key after, empty columns are skipped
+func QuerySealedValues(db *sql.DB, column sealedColumn, after string, limit int) (keys []string, owners []string, values []string, err error) {
+	return querySealedValues(db, column, after, limit, fmt.Sprintf("SELECT %s::text, %s, %s FROM %s WHERE %s > $1::%s AND %s IS NOT NULL "+
+		"ORDER BY %s LIMIT $2", column.key, column.owner, column.column, column.table, column.key, column.keyType, column.column, column.key))
+}
+
+// querySealedValues reads the rows following the key $1 with the query
+func querySealedValues(db *sql.DB, column sealedColumn, after string, limit int, query string) (keys []string, owners []string, values []string, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(after, limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, owner, value string
+		err = rows.Scan(&key, &owner, &value)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		keys = append(keys, key)
+		owners = append(owners, owner)
+		values = append(values, value)
+	}
+	return keys, owners, values, rows.Err()
+}
+
+// rewrapSetting is set for the transactions of the re-wrap, the triggers keep the revision of the entries
+// since their passwords do not change
+const rewrapSetting = "keycloud.rewrap"
+
+// UpdateSealedValue replaces the value of the row unless it has been changed since it was read
+func UpdateSealedValue(db *sql.DB, column sealedColumn, key string, previous string, value string) (bool, error) {
+	return updateSealedValue(db, column, key, previous, value, fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2::%s AND %s = $3",
+		column.table, column.column, column.key, column.keyType, column.column))
+}
+
+// updateSealedValue sets the value $1 of the row $2 with the previous value $3 with the query
+func updateSealedValue(db *sql.DB, column sealedColumn, key string, previous string, value string, query string) (bool, error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("SELECT set_config('" + rewrapSetting + "', 'on', true)")
+	if err != nil {
+		return false, err
+	}
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return false, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, value, key, previous)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// end query
+	return true, tx.Commit()
+}
+
+// RewrapColumns wraps the data keys of all rows with the current key encryption key and encrypts the rows
+// written before the encryption was enabled or sealed before the rows were bound.
+// The server keeps running, a row changed meanwhile is skipped.
+func RewrapColumns(db *sql.DB, cipher *DataCipher) ([]*RewrapResult, error) {
+	querySealed, updateSealed := QuerySealedValues, UpdateSealedValue
+	if isSQLite(db) {
+		querySealed, updateSealed = QuerySQLiteSealedValues, UpdateSQLiteSealedValue
+	}
+	results := make([]*RewrapResult, 0, len(sealedColumns))
+	for _, column := range sealedColumns {
+		result := &RewrapResult{Column: column.name}
+		results = append(results, result)
+		after := column.first
+		for {
+			keys, owners, values, err := querySealed(db, column, after, rewrapBatchSize)
+			if err != nil {
+				return results, err
+			}
+			for i, key := range keys {
+				value, changed, err := cipher.Rewrap(column.name, key, owners[i], values[i])
+				if err != nil {
+					return results, fmt.Errorf("%s of %s: %v", column.name, key, err)
+				}
+				if !changed {
+					continue
+				}
+				updated, err := updateSealed(db, column, key, values[i], value)
+				if err != nil {
+					return results, err
+				}
+				if updated {
+					result.Rewrapped++
+				} else {
+					result.Skipped++
+				}
+			}
+			if len(keys) < rewrapBatchSize {
+				break
+			}
+			after = keys[len(keys)-1]
+		}
+	}
+	return results, nil
+}
+
+// runRewrapCommand implements ./server rewrap
+func runRewrapCommand(db *sql.DB) error {
+	cipher, err := newDataCipher()
+	if err != nil {
+		return err
+	}
+	if cipher == nil {
+		return errors.New("DATA_KEYFILE is not set")
+	}
+	results, err := RewrapColumns(db, cipher)
+	for _, result := range results {
+		fmt.Printf("%s: %d re-wrapped with %s, %d changed during the re-wrap\n", result.Column, result.Rewrapped,
+			cipher.wrapper.CurrentKeyId(), result.Skipped)
+	}
+	return err
+}
+
+// runKeysCommand implements ./server keys add <id>, the new key becomes the current one
+func runKeysCommand(args []string) error {
+	path := os.Getenv("DATA_KEYFILE")
+	if path == "" {
+		return errors.New("DATA_KEYFILE is not set")
+	}
+	if len(args) != 2 || args[0] != "add" {
+		return errors.New("use keys add <id>")
+	}
+	err := AddKeyfileKey(path, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added the key %s to %s, restart the servers and run ./server rewrap\n", args[1], path)
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// runPeriodically starts the job in the background, it is executed right away and then after every interval.
+// Failing runs are logged and retried with the next interval.
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	go func() {
+		for {
+			if err := job(); err != nil {
+				fmt.Printf("Job %s failed: %v\n", name, err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
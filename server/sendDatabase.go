@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// sendUnused restricts sends s to those which have neither expired nor used up their views
+const sendUnused = "s.expires > CURRENT_TIMESTAMP AND s.views < s.maxviews"
+
+// sendAvailable additionally excludes the sends whose access password was guessed wrong too often
+const sendAvailable = sendUnused + " AND s.failedattempts < 10"
+
+func QuerySends(db *sql.DB, user *User) (sends []*Send, err error) {
+	return querySends(db, user, "SELECT s.sendid, s.type, s.name, s.expires, s.maxviews, s.views, s.passwordhash IS NOT NULL, "+
+		"s.createdate FROM sends s WHERE s.uuid = $1 AND "+sendAvailable+" ORDER BY s.createdate")
+}
+
+// querySends reads the available sends of the user $1 with the query
+func querySends(db *sql.DB, user *User, query string) (sends []*Send, err error) {
+	// prepare statement, the content is only handed out to the recipients
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		send := &Send{}
+		err = rows.Scan(&send.Id, &send.Type, &send.Name, &send.Expires, &send.MaxViews, &send.Views, &send.Protected, &send.Created)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+func CreateSend(db *sql.DB, user *User, send *Send) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("INSERT INTO sends (sendid, uuid, type, name, content, expires, maxviews, passwordhash) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(send.Id, user.Uuid, send.Type, send.Name, send.Content, send.Expires, send.MaxViews,
+		send.PasswordHash)
+	return err
+}
+
+func DeleteSend(db *sql.DB, user *User, id string) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("DELETE FROM sends WHERE sendid = $1 AND uuid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, id, user.Uuid)
+}
+
+// QuerySendProtection returns the access password of an available send without using up a view
+func QuerySendProtection(db *sql.DB, id string) (send *Send, err error) {
+	return querySendProtection(db, id, "SELECT s.sendid, s.passwordhash FROM sends s WHERE s.sendid = $1 AND "+sendAvailable)
+}
+
+// querySendProtection reads the access password of the send $1 with the query
+func querySendProtection(db *sql.DB, id string, query string) (send *Send, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	send = &Send{}
+	err = stmt.QueryRow(id).Scan(&send.Id, &send.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	send.Protected = send.PasswordHash != nil
+	return send, nil
+}
+
+// CountSendAttempt counts an attempt to read a protected send before its access password is checked,
+// so parallel guesses can not exceed the limit. ConsumeSend takes the attempt back once the password was right.
+func CountSendAttempt(db *sql.DB, id string) (err error) {
+	return countSendAttempt(db, id, "UPDATE sends s SET failedattempts = s.failedattempts + 1 WHERE s.sendid = $1 "+
+		"AND s.passwordhash IS NOT NULL AND "+sendAvailable)
+}
+
+// countSendAttempt counts a wrong access password of the send $1 with the query
+func countSendAttempt(db *sql.DB, id string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// ConsumeSend uses up one view of an available send and returns it, the send is deleted after its last view.
+// The attempt counted for a protected send has already been admitted and is taken back.
+func ConsumeSend(db *sql.DB, id string) (send *Send, err error) {
+	return consumeSend(db, id, "UPDATE sends s SET views = s.views + 1, failedattempts = s.failedattempts - "+
+		"CASE WHEN s.passwordhash IS NULL THEN 0 ELSE 1 END WHERE s.sendid = $1 AND "+sendUnused+
+		" RETURNING s.sendid, s.type, s.name, s.content, s.expires, s.maxviews, s.views, s.passwordhash IS NOT NULL")
+}
+
+// consumeSend uses up the view of the send $1 with the query, which returns the send
+func consumeSend(db *sql.DB, id string, query string) (send *Send, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	send = &Send{}
+	err = stmt.QueryRow(id).Scan(&send.Id, &send.Type, &send.Name, &send.Content, &send.Expires, &send.MaxViews,
+		&send.Views, &send.Protected)
+	if err != nil {
+		return nil, err
+	}
+	if send.Views >= send.MaxViews {
+		_, err = tx.Exec("DELETE FROM sends WHERE sendid = $1", id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return send, nil
+}
+
+// PurgeSends deletes the expired sends and returns their number
+func PurgeSends(db *sql.DB) (purged int64, err error) {
+	return deleteUnavailableSends(db, "DELETE FROM sends s WHERE NOT ("+sendAvailable+")")
+}
+
+// deleteUnavailableSends deletes the sends which are no longer available with the query
+func deleteUnavailableSends(db *sql.DB, query string) (purged int64, err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	result, err := stmt.Exec()
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
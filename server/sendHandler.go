@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type SendHandler struct {
+	storage StorageInterface
+	// maxSize limits the request creating a send in bytes
+	maxSize int64
+}
+
+type SendRequest struct {
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	Content  string     `json:"content"`
+	Expires  *time.Time `json:"expires"`
+	MaxViews int        `json:"maxviews"`
+	// Password is the access password derived by the client, an empty one leaves the send unprotected
+	Password string `json:"password"`
+}
+
+// maxSendDuration is the longest time a send may be available
+const maxSendDuration = 30 * 24 * time.Hour
+
+// sendPasswordHeader carries the access password derived by the client when reading a protected send
+const sendPasswordHeader = "X-Send-Password"
+
+// newSendId returns an unguessable url safe id, it is the only secret protecting an unprotected send on the server
+func newSendId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
+
+// checkSendPassword compares the access password with the bcrypt hash of the send
+func checkSendPassword(send *Send, password string) bool {
+	return bcrypt.CompareHashAndPassword(send.PasswordHash, []byte(password)) == nil
+}
+
+func (handler SendHandler) GetSends(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sends, err := handler.storage.GetSends(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, sends)
+}
+
+// CreateSend stores a client encrypted text or file and answers with the id of the link
+func (handler SendHandler) CreateSend(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, handler.maxSize))
+	if err != nil {
+		http.Error(writer, "send is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	defer request.Body.Close()
+	sendMsg := SendRequest{MaxViews: 1}
+	err = json.Unmarshal(b, &sendMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sendMsg.Type != SendText && sendMsg.Type != SendFile {
+		http.Error(writer, "unknown type "+sendMsg.Type, http.StatusBadRequest)
+		return
+	}
+	if sendMsg.Content == "" || sendMsg.Type == SendFile && sendMsg.Name == "" {
+		http.Error(writer, "content and the name of files are required", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	if sendMsg.Expires == nil || !sendMsg.Expires.After(now) || sendMsg.Expires.After(now.Add(maxSendDuration)) {
+		http.Error(writer, "expires has to be within the next 30 days", http.StatusBadRequest)
+		return
+	}
+	if sendMsg.MaxViews < 1 {
+		http.Error(writer, "maxviews must be positive", http.StatusBadRequest)
+		return
+	}
+	send := &Send{
+		Type:      sendMsg.Type,
+		Name:      sendMsg.Name,
+		Content:   sendMsg.Content,
+		Expires:   *sendMsg.Expires,
+		MaxViews:  sendMsg.MaxViews,
+		Protected: sendMsg.Password != "",
+		Created:   &now,
+	}
+	send.Id, err = newSendId()
+	if err == nil && send.Protected {
+		send.PasswordHash, err = bcrypt.GenerateFromPassword([]byte(sendMsg.Password), bcrypt.DefaultCost)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = handler.storage.CreateSend(user, send)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the content is not echoed back
+	send.Content = ""
+	sendJson, err := json.Marshal(send)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprint(writer, string(sendJson))
+}
+
+func (handler SendHandler) RemoveSend(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeleteSend(user, mux.Vars(request)["id"])
+	if err == sql.ErrNoRows {
+		http.Error(writer, "404 - Send not found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// ReadSend hands out a send without an account, every successful read uses up a view.
+// Expired and used up sends are not distinguished from unknown ones.
+func (handler SendHandler) ReadSend(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Cache-Control", "no-store")
+	id := mux.Vars(request)["id"]
+	protection, err := handler.storage.GetSendProtection(id)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "404 - Send not found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if protection.Protected {
+		// the attempt is counted first, a send whose password was guessed wrong too often is gone
+		err = handler.storage.CountSendAttempt(id)
+		if err == sql.ErrNoRows {
+			http.Error(writer, "404 - Send not found - ", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkSendPassword(protection, request.Header.Get(sendPasswordHeader)) {
+			http.Error(writer, "401 - Password required - ", http.StatusUnauthorized)
+			return
+		}
+	}
+	send, err := handler.storage.ConsumeSend(id)
+	if err == sql.ErrNoRows {
+		// the last view was used up in the meantime
+		http.Error(writer, "404 - Send not found - ", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJson, err := json.Marshal(send)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprint(writer, string(sendJson))
+}
+
+// purgeSends deletes the sends which have expired
+func purgeSends(storage StorageInterface) error {
+	purged, err := storage.PurgeSends()
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		fmt.Printf("Purged %d expired sends\n", purged)
+	}
+	return nil
+}
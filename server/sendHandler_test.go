@@ -0,0 +1,151 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendHandler_ReadSendLastView(t *testing.T) {
+	req, err := http.NewRequest("GET", "/sends/SENDID", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": "SENDID"})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT (.+) FROM sends s WHERE s.sendid = \\$1 AND s.expires > CURRENT_TIMESTAMP AND s.views < s.maxviews").
+		ExpectQuery().WithArgs("SENDID").
+		WillReturnRows(sqlmock.NewRows([]string{"sendid", "passwordhash"}).AddRow("SENDID", nil))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE sends s SET views = s.views \\+ 1").
+		ExpectQuery().WithArgs("SENDID").
+		WillReturnRows(sqlmock.NewRows([]string{"sendid", "type", "name", "content", "expires", "maxviews", "views", "protected"}).
+			AddRow("SENDID", "text", "", "SECRET", passwordCreated, 1, 1, false))
+	mock.ExpectExec("DELETE FROM sends").WithArgs("SENDID").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sendHandler.ReadSend)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"id":"SENDID","type":"text","content":"SECRET","expires":"2020-05-01T12:00:00Z","maxviews":1,"views":1,"protected":false}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("handler allowed the send to be cached")
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSendHandler_ReadSendWrongPassword(t *testing.T) {
+	req, err := http.NewRequest("GET", "/sends/SENDID", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set(sendPasswordHeader, "wrong")
+	req = mux.SetURLVars(req, map[string]string{"id": "SENDID"})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when hashing the password", err)
+	}
+	mock.ExpectPrepare("SELECT (.+) FROM sends s").
+		ExpectQuery().WithArgs("SENDID").
+		WillReturnRows(sqlmock.NewRows([]string{"sendid", "passwordhash"}).
+			AddRow("SENDID", hash))
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE sends s SET failedattempts = s.failedattempts \\+ 1").
+		ExpectExec().WithArgs("SENDID").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	// no view is used up by a wrong password
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sendHandler.ReadSend)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSendHandler_ReadSendGuessed(t *testing.T) {
+	req, err := http.NewRequest("GET", "/sends/SENDID", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	req.Header.Set(sendPasswordHeader, "right")
+	req = mux.SetURLVars(req, map[string]string{"id": "SENDID"})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when hashing the password", err)
+	}
+	mock.ExpectPrepare("SELECT (.+) FROM sends s").
+		ExpectQuery().WithArgs("SENDID").
+		WillReturnRows(sqlmock.NewRows([]string{"sendid", "passwordhash"}).
+			AddRow("SENDID", hash))
+	// the attempts were used up by parallel guesses in the meantime
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE sends s SET failedattempts = s.failedattempts \\+ 1").
+		ExpectExec().WithArgs("SENDID").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	// even the right password does not read the send any more
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sendHandler.ReadSend)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// errSharedPassword rejects writes of the password of a shared entry, its recipients read the shared password
+var errSharedPassword = errors.New("the password of a shared entry is changed with PUT /shared-password")
+
+// sharedPasswordColumns are selected after the passwordColumns by listings which include the entries shared with the user $1
+const sharedPasswordColumns = "COALESCE(s.permission, CASE WHEN p.sharedpasswd IS NOT NULL THEN 'owner' END), " +
+	"o.name, COALESCE(s.wrappedkey, p.sharekey), p.sharedpasswd"
+
+const sharedPasswordTables = " LEFT JOIN shares s ON s.entryid = p.entryid AND s.recipient = $1 JOIN users o ON o.uuid = p.uuid"
+
+// scanSharedPassword reads a row selected with the sharedPasswordColumns
+func scanSharedPassword(row rowScanner, extra ...interface{}) (*Password, error) {
+	var permission, owner, wrappedKey, sharedPassword sql.NullString
+	psw, err := scanPassword(row, append([]interface{}{&permission, &owner, &wrappedKey, &sharedPassword}, extra...)...)
+	if err != nil || !permission.Valid {
+		return psw, err
+	}
+	// once shared, every user reads the password encrypted with the entry key
+	psw.Password = sharedPassword.String
+	psw.Shared = &PasswordShare{
+		Owner:      owner.String,
+		Permission: permission.String,
+		WrappedKey: wrappedKey.String,
+	}
+	if permission.String != PermissionOwner {
+		// folders and tags belong to the owner
+		psw.Folder = ""
+		psw.Tags = nil
+	}
+	return psw, nil
+}
+
+func UpdateUserKeys(db *sql.DB, user *User, keys *UserKeys) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("UPDATE users SET publickey = $1, privatekey = $2 WHERE uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, keys.PublicKey, keys.PrivateKey, user.Uuid)
+}
+
+func QueryUserKeys(db *sql.DB, user *User) (keys *UserKeys, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT COALESCE(publickey, ''), COALESCE(privatekey, '') FROM users WHERE uuid = $1")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	keys = &UserKeys{}
+	err = stmt.QueryRow(user.Uuid).Scan(&keys.PublicKey, &keys.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func QueryPublicKey(db *sql.DB, username string) (publicKey string, err error) {
+	// prepare statement
+	stmt, err := db.Prepare("SELECT publickey FROM users WHERE name = $1 AND publickey IS NOT NULL")
+	if err != nil {
+		return "", err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(username).Scan(&publicKey)
+	return publicKey, err
+}
+
+// CreateShare stores the password encrypted with the entry key and grants the recipient access to it
+func CreateShare(db *sql.DB, user *User, share *Share, password string, ownerKey string) (err error) {
+	return createShare(db, user, share, password, ownerKey, "INSERT INTO shares (entryid, recipient, wrappedkey, permission) "+
+		"SELECT $1, uuid, $2, $3 FROM users WHERE name = $4 AND uuid <> $5 "+
+		"ON CONFLICT ON CONSTRAINT shares_entryid_recipient_key DO UPDATE SET wrappedkey = $2, permission = $3")
+}
+
+// createShare grants the recipient access to the shared entry with the query
+func createShare(db *sql.DB, user *User, share *Share, password string, ownerKey string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement, only the owner can share an entry
+	stmt, err := tx.Prepare("UPDATE passwds SET sharedpasswd = $1, sharekey = $2 WHERE entryid = $3 AND uuid = $4 AND deletedate IS NULL")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, password, ownerKey, share.Entry, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// prepare statement, sharing again replaces the key and the permission
+	shareStmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer shareStmt.Close()
+	// execute statement
+	err = execAffectingRows(shareStmt, share.Entry, share.WrappedKey, share.Permission, share.Username, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryShares(db *sql.DB, user *User, entry string) (shares []*Share, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT s.entryid, u.name, s.permission, s.wrappedkey FROM shares s " +
+		"JOIN passwds p ON p.entryid = s.entryid JOIN users u ON u.uuid = s.recipient " +
+		"WHERE s.entryid = $1 AND p.uuid = $2 ORDER BY u.name")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(entry, user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		share := &Share{}
+		err = rows.Scan(&share.Entry, &share.Username, &share.Permission, &share.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func DeleteShare(db *sql.DB, user *User, entry string, username string) (err error) {
+	return deleteShare(db, user, entry, username, "DELETE FROM shares s USING passwds p, users u "+
+		"WHERE s.entryid = $1 AND p.entryid = s.entryid AND p.uuid = $2 AND u.uuid = s.recipient AND u.name = $3")
+}
+
+// deleteShare revokes the share of the entry $1 of the user $2 with the recipient $3 with the query
+func deleteShare(db *sql.DB, user *User, entry string, username string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, entry, user.Uuid, username)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// UpdateSharedPassword replaces the password of a shared entry, it is allowed for the owner and editing recipients
+func UpdateSharedPassword(db *sql.DB, user *User, id string, password string) (err error) {
+	return updateSharedPassword(db, user, id, password, "UPDATE passwds p SET sharedpasswd = $1, passwordchanged = CURRENT_TIMESTAMP "+
+		"WHERE p.entryid = $2 AND p.sharedpasswd IS NOT NULL AND p.deletedate IS NULL AND (p.uuid = $3 OR EXISTS "+
+		"(SELECT 1 FROM shares s WHERE s.entryid = p.entryid AND s.recipient = $3 AND s.permission = 'edit'))")
+}
+
+// updateSharedPassword sets the password $1 of the entry $2 for the user $3 with the query
+func updateSharedPassword(db *sql.DB, user *User, id string, password string, query string) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, password, id, user.Uuid)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+type SharePasswordRequest struct {
+	Id         string `json:"id"`
+	Username   string `json:"username"`
+	Permission string `json:"permission"`
+	// WrappedKey is the entry key encrypted for the recipient, OwnerKey the entry key encrypted for the owner
+	WrappedKey string `json:"wrappedkey"`
+	OwnerKey   string `json:"ownerkey"`
+	// Password is encrypted with the entry key
+	Password string `json:"password"`
+}
+
+type RevokeShareRequest struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type SharedPasswordRequest struct {
+	Id       string `json:"id"`
+	Password string `json:"password"`
+}
+
+type PublicKeyResponse struct {
+	Username  string `json:"username"`
+	PublicKey string `json:"publickey"`
+}
+
+func (handler CRUDHandler) GetUserKeys(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	keys, err := handler.storage.GetUserKeys(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keysJson, err := json.Marshal(keys)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprint(writer, string(keysJson))
+}
+
+func (handler CRUDHandler) SetUserKeys(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var keysMsg UserKeys
+	err = json.Unmarshal(b, &keysMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if keysMsg.PublicKey == "" || keysMsg.PrivateKey == "" {
+		http.Error(writer, "publickey and privatekey are required", http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.SetUserKeys(user, &keysMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// GetPublicKey returns the public key of another user, clients wrap entry keys with it before sharing
+func (handler CRUDHandler) GetPublicKey(writer http.ResponseWriter, request *http.Request) {
+	username := request.URL.Query().Get("username")
+	publicKey, err := handler.storage.GetPublicKey(username)
+	if err != nil {
+		http.Error(writer, "404 - User without public key not found - ", http.StatusNotFound)
+		return
+	}
+	keyJson, err := json.Marshal(PublicKeyResponse{Username: username, PublicKey: publicKey})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprint(writer, string(keyJson))
+}
+
+func (handler CRUDHandler) SharePassword(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var shareMsg SharePasswordRequest
+	err = json.Unmarshal(b, &shareMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if shareMsg.Permission == "" {
+		shareMsg.Permission = PermissionRead
+	}
+	if shareMsg.Permission != PermissionRead && shareMsg.Permission != PermissionEdit {
+		http.Error(writer, "unknown permission "+shareMsg.Permission, http.StatusBadRequest)
+		return
+	}
+	if shareMsg.Username == "" || shareMsg.WrappedKey == "" || shareMsg.OwnerKey == "" || shareMsg.Password == "" {
+		http.Error(writer, "username, wrappedkey, ownerkey and password are required", http.StatusBadRequest)
+		return
+	}
+	err = handler.checkEnvelope("password", shareMsg.Password)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	share := &Share{
+		Entry:      shareMsg.Id,
+		Username:   shareMsg.Username,
+		Permission: shareMsg.Permission,
+		WrappedKey: shareMsg.WrappedKey,
+	}
+	_, err = handler.policy.AuthorizeEntry(user, shareMsg.Id, ActionShare)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SharePassword(user, share, shareMsg.Password, shareMsg.OwnerKey)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("CREATED", "", writer)
+}
+
+func (handler CRUDHandler) GetShares(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeEntry(user, request.URL.Query().Get("id"), ActionShare)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	shares, err := handler.storage.GetShares(user, request.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendListing(writer, request, shares)
+}
+
+func (handler CRUDHandler) RevokeShare(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var revokeMsg RevokeShareRequest
+	err = json.Unmarshal(b, &revokeMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeEntry(user, revokeMsg.Id, ActionShare)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.RevokeShare(user, revokeMsg.Id, revokeMsg.Username)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// SetSharedPassword replaces the password of a shared entry, read-only recipients are rejected
+func (handler CRUDHandler) SetSharedPassword(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var passwordMsg SharedPasswordRequest
+	err = json.Unmarshal(b, &passwordMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if passwordMsg.Password == "" {
+		http.Error(writer, "password is required", http.StatusBadRequest)
+		return
+	}
+	err = handler.checkEnvelope("password", passwordMsg.Password)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, passwordMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetSharedPassword(user, owner, passwordMsg.Id, passwordMsg.Password)
+	if err == sql.ErrNoRows {
+		http.Error(writer, "403 - Not allowed to edit the entry - ", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCRUDHandler_GetPasswordsSharedWithMe(t *testing.T) {
+	req, err := http.NewRequest("GET", "/passwords", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM passwds (.+) LEFT JOIN shares s (.+) OR \\(p.collectionid IS NULL AND s.recipient IS NOT NULL\\)").
+		ExpectQuery().WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(listingColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID", nil, "john", nil, nil, "john.doe").
+			AddRow(2, "jane.doe", "owners-password", "janedoe", "4", "{8}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "JANEID", "read", "jane", "KEY", "SHARED", "jane.doe"))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetPasswords)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect, folders and tags of the owner are not visible
+	expected := `[{"password":"password","id":"1","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain"},` +
+		`{"password":"SHARED","id":"2","url":"jane.doe","username":"janedoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain","shared":{"owner":"jane","permission":"read","wrappedkey":"KEY"}}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_SharePassword(t *testing.T) {
+	req, err := http.NewRequest("POST", "/share", bytes.NewBuffer([]byte(
+		`{"id": "3", "username": "jane", "permission": "edit", "wrappedkey": "KEY", "ownerkey": "OWNERKEY", "password": "`+testEnvelope+`"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "USERID", "", "", "", "")
+	mock.ExpectBegin()
+	mock.ExpectPrepare("UPDATE passwds SET sharedpasswd").
+		ExpectExec().WithArgs(testEnvelope, "OWNERKEY", "3", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectPrepare("INSERT INTO shares").
+		ExpectExec().WithArgs("3", "KEY", "edit", "jane", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.SharePassword)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"Status":"CREATED","Error":""}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_SetSharedPasswordReadOnly(t *testing.T) {
+	req, err := http.NewRequest("PUT", "/shared-password", bytes.NewBuffer([]byte(`{"id": "3", "password": "`+testEnvelope+`"}`)))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	expectEntryAccess(mock, "3", "JANEID", "", "", "read", "")
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.SetSharedPassword)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sqliteDriverName is the driver of the SQLite backend
+const sqliteDriverName = "keycloud-sqlite"
+
+// sqliteTimestampFormat is the format the timestamps are stored in, the fixed precision keeps them comparable as text
+const sqliteTimestampFormat = "2006-01-02 15:04:05.000"
+
+// sqliteNow is the current time in sqliteTimestampFormat
+const sqliteNow = "(strftime('%Y-%m-%d %H:%M:%f', 'now'))"
+
+// sqliteTimestamp matches the timestamps computed by expressions, they have no declared type the driver could convert them by
+var sqliteTimestamp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(\.\d+)?$`)
+
+// sqliteArgs converts the arguments to the types the queries expect: the uuids are passed as byte slices but stored
+// as text, byte slices which are valid UTF-8 are therefore bound as text. Times are stored in UTC.
+// SQLite numbers the parameters $n in the order they appear, the arguments are bound by their name instead.
+func sqliteArgs(args []driver.NamedValue) []driver.NamedValue {
+	converted := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		if arg.Name == "" {
+			arg.Name = strconv.Itoa(arg.Ordinal)
+		}
+		switch value := arg.Value.(type) {
+		case []byte:
+			if value != nil && utf8.Valid(value) {
+				arg.Value = string(value)
+			}
+		case time.Time:
+			arg.Value = value.UTC().Format(sqliteTimestampFormat)
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+func sqliteValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return sqliteArgs(named)
+}
+
+// sqliteVaultEvents receives the vault events of the committed transactions, they are dropped while nobody listens
+var sqliteVaultEvents = make(chan string, 1024)
+
+// listenSQLiteVaultEvents publishes the changes committed through this server, which is the only one using the file
+func listenSQLiteVaultEvents(hub *EventHub) {
+	go func() {
+		for payload := range sqliteVaultEvents {
+			err := hub.dispatch(payload)
+			if err != nil {
+				fmt.Println("Vault events listener:", err)
+			}
+		}
+	}()
+}
+
+// pgArray parses an array in the text format of Postgres, which is how the arrays are stored in SQLite
+func pgArray(value interface{}) ([]string, error) {
+	var array pq.StringArray
+	err := array.Scan(value)
+	return array, err
+}
+
+// pgArrayText formats the array in the text format of Postgres
+func pgArrayText(array []string) (string, error) {
+	value, err := pq.StringArray(array).Value()
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// sqliteArrayAgg is the array_agg aggregate of Postgres, nulls are left out
+type sqliteArrayAgg struct {
+	values []string
+}
+
+func (agg *sqliteArrayAgg) Step(value interface{}) {
+	switch value := value.(type) {
+	case nil:
+	case []byte:
+		agg.values = append(agg.values, string(value))
+	default:
+		agg.values = append(agg.values, fmt.Sprint(value))
+	}
+}
+
+func (agg *sqliteArrayAgg) Done() (string, error) {
+	return pgArrayText(agg.values)
+}
+
+// sqliteCompare orders numbers by their value and everything else by its text, timestamps are stored as text
+func sqliteCompare(a interface{}, b interface{}) int {
+	x, xNumber := sqliteNumber(a)
+	y, yNumber := sqliteNumber(b)
+	if xNumber && yNumber {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func sqliteNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+func sqliteText(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	case nil:
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// registerSQLiteFunctions provides the functions of Postgres the queries use and vault_event, which collects the
+// events of the triggers until the transaction is committed
+func registerSQLiteFunctions(conn *sqlite3.SQLiteConn) error {
+	functions := map[string]interface{}{
+		"array_to_json": func(array interface{}) (interface{}, error) {
+			if array == nil {
+				return nil, nil
+			}
+			values, err := pgArray(array)
+			if err != nil {
+				return nil, err
+			}
+			if values == nil {
+				values = make([]string, 0)
+			}
+			text, err := json.Marshal(values)
+			return string(text), err
+		},
+		// unlike min the nulls are ignored
+		"least": func(values ...interface{}) interface{} {
+			var least interface{}
+			for _, value := range values {
+				if value != nil && (least == nil || sqliteCompare(value, least) < 0) {
+					least = value
+				}
+			}
+			return least
+		},
+		"btrim": func(value interface{}) interface{} {
+			text, ok := sqliteText(value)
+			if !ok {
+				return nil
+			}
+			return strings.Trim(text, " ")
+		},
+		"convert_to": func(value interface{}, encoding string) (interface{}, error) {
+			text, ok := sqliteText(value)
+			if !ok {
+				return nil, nil
+			}
+			if !strings.EqualFold(encoding, "UTF8") {
+				return nil, fmt.Errorf("unsupported encoding %s", encoding)
+			}
+			return []byte(text), nil
+		},
+		"sha256": func(value interface{}) interface{} {
+			text, ok := sqliteText(value)
+			if !ok {
+				return nil
+			}
+			sum := sha256.Sum256([]byte(text))
+			return sum[:]
+		},
+		"encode": func(value interface{}, format string) (interface{}, error) {
+			text, ok := sqliteText(value)
+			if !ok {
+				return nil, nil
+			}
+			switch format {
+			case "hex":
+				return hex.EncodeToString([]byte(text)), nil
+			case "base64":
+				return base64.StdEncoding.EncodeToString([]byte(text)), nil
+			}
+			return nil, fmt.Errorf("unsupported format %s", format)
+		},
+	}
+	for name, impl := range functions {
+		err := conn.RegisterFunc(name, impl, true)
+		if err != nil {
+			return err
+		}
+	}
+	err := conn.RegisterAggregator("array_agg", func() *sqliteArrayAgg { return &sqliteArrayAgg{} }, true)
+	if err != nil {
+		return err
+	}
+
+	// the commit hook runs right before the commit, which only fails if the file cannot be written
+	var pending []string
+	err = conn.RegisterFunc("vault_event", func(payload string) int {
+		pending = append(pending, payload)
+		return len(pending)
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	// the settings of set_config belong to the connection like the ones of a Postgres session,
+	// the local ones end with the transaction
+	settings := make(map[string]string)
+	var local []string
+	err = conn.RegisterFunc("set_config", func(name string, value string, isLocal bool) string {
+		settings[name] = value
+		if isLocal {
+			local = append(local, name)
+		}
+		return value
+	}, false)
+	if err != nil {
+		return err
+	}
+	err = conn.RegisterFunc("current_setting", func(name string, missingOk bool) (interface{}, error) {
+		value, ok := settings[name]
+		if !ok && !missingOk {
+			return nil, fmt.Errorf("unrecognized configuration parameter %s", name)
+		}
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	}, false)
+	if err != nil {
+		return err
+	}
+	endTransaction := func() {
+		for _, name := range local {
+			delete(settings, name)
+		}
+		pending, local = nil, nil
+	}
+	conn.RegisterCommitHook(func() int {
+		for _, payload := range pending {
+			select {
+			case sqliteVaultEvents <- payload:
+			default:
+			}
+		}
+		endTransaction()
+		return 0
+	})
+	conn.RegisterRollbackHook(endTransaction)
+	return nil
+}
+
+// sqliteDriver opens the connections of the SQLite backend, they convert the arguments and the computed timestamps
+type sqliteDriver struct {
+	driver *sqlite3.SQLiteDriver
+}
+
+func init() {
+	sql.Register(sqliteDriverName, &sqliteDriver{driver: &sqlite3.SQLiteDriver{ConnectHook: registerSQLiteFunctions}})
+}
+
+func (d *sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn: conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type sqliteConn struct {
+	conn *sqlite3.SQLiteConn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStmt{stmt: stmt.(*sqlite3.SQLiteStmt)}, nil
+}
+
+func (c *sqliteConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *sqliteConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+func (c *sqliteConn) Ping(ctx context.Context) error {
+	return c.conn.Ping(ctx)
+}
+
+// ExecContext runs scripts of several statements like the migrations, prepared statements only run the first one
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.ExecContext(ctx, query, sqliteArgs(args))
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.conn.QueryContext(ctx, query, sqliteArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{rows: rows.(*sqlite3.SQLiteRows)}, nil
+}
+
+type sqliteStmt struct {
+	stmt *sqlite3.SQLiteStmt
+}
+
+func (s *sqliteStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *sqliteStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *sqliteStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.stmt.ExecContext(context.Background(), sqliteValues(args))
+}
+
+func (s *sqliteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.stmt.ExecContext(ctx, sqliteArgs(args))
+}
+
+func (s *sqliteStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), sqliteValues(args))
+}
+
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.stmt.QueryContext(ctx, sqliteArgs(args))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteRows{rows: rows.(*sqlite3.SQLiteRows)}, nil
+}
+
+// sqliteRows converts the timestamps computed by expressions, the driver converts the ones of timestamp columns
+type sqliteRows struct {
+	rows *sqlite3.SQLiteRows
+}
+
+func (r *sqliteRows) Columns() []string {
+	return r.rows.Columns()
+}
+
+func (r *sqliteRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *sqliteRows) Next(dest []driver.Value) error {
+	err := r.rows.Next(dest)
+	if err != nil {
+		return err
+	}
+	types := r.rows.DeclTypes()
+	for i, value := range dest {
+		text, ok := value.(string)
+		if !ok || types[i] != "" || !sqliteTimestamp.MatchString(text) {
+			continue
+		}
+		timestamp, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", text, time.UTC)
+		if err == nil {
+			dest[i] = timestamp
+		}
+	}
+	return nil
+}
+
+// openSQLite opens the database file, the foreign keys have to be enabled on every connection
+func openSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriverName, "file:"+path+"?_foreign_keys=1&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	// test database connection
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// isSQLite tells whether the database is the SQLite backend
+func isSQLite(db *sql.DB) bool {
+	_, ok := db.Driver().(*sqliteDriver)
+	return ok
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlitePasswordColumns are the passwordColumns for SQLite, the array_agg of the driver leaves out the entries without tags
+const sqlitePasswordColumns = "p.entryid, p.url, p.passwd, p.username, COALESCE(CAST(p.folderid AS text), ''), " +
+	"array_agg(pt.tagid), p.name, p.type, p.createdate, " + passwordLastUsed + ", p.match, " +
+	"COALESCE((SELECT json_group_array(json_object('uri', u.uri, 'match', u.match) ORDER BY u.uriid) " +
+	"FROM passwd_uris u WHERE u.entryid = p.entryid), '[]'), p.favorite, " + passwordUseCount + ", p.deletedate, " +
+	"p.expires, p.rotationdays, p.passwordchanged, COALESCE(CAST(p.collectionid AS text), ''), p.revision, p.uuid"
+
+// sqlitePasswordDueDate is the passwordDueDate for SQLite, the timestamps are stored as text in sqliteTimestampFormat
+const sqlitePasswordDueDate = "least(p.expires, CASE WHEN p.rotationdays > 0 " +
+	"THEN strftime('%Y-%m-%d %H:%M:%f', COALESCE(p.passwordchanged, p.createdate), '+' || p.rotationdays || ' days') END)"
+
+// sqliteEmergencyContactColumns are the emergencyContactColumns for SQLite
+const sqliteEmergencyContactColumns = "e.contactid, g.name, r.name, e.access, e.waitdays, e.status, e.requestdate, " +
+	"strftime('%Y-%m-%d %H:%M:%f', e.requestdate, '+' || e.waitdays || ' days')"
+
+// sqliteSendUnused and sqliteSendAvailable are sendUnused and sendAvailable for SQLite
+const sqliteSendUnused = "s.expires > " + sqliteNow + " AND s.views < s.maxviews"
+
+const sqliteSendAvailable = sqliteSendUnused + " AND s.failedattempts < 10"
+
+// sqliteLikeAny matches the column with any of the patterns of the array parameter, LIKE ignores the case of ASCII letters only
+func sqliteLikeAny(column string, array string) string {
+	return "EXISTS (SELECT 1 FROM json_each(array_to_json(" + array + ")) WHERE " + column + " LIKE value ESCAPE '\\')"
+}
+
+// sqlitePasswordListing orders the timestamps by their text, the booleans are stored as integers
+var sqlitePasswordListing = passwordListing{
+	columns: sqlitePasswordColumns + ", " + sharedPasswordColumns,
+	sortKeys: map[string]passwordSortKey{
+		"name":     {"lower(COALESCE(NULLIF(p.name, ''), p.url, ''))", "text"},
+		"url":      {"lower(COALESCE(p.url, ''))", "text"},
+		"created":  {"p.createdate", "timestamp"},
+		"lastused": {"COALESCE(" + passwordLastUsed + ", '1970-01-01 00:00:00.000')", "timestamp"},
+		"usecount": {passwordUseCount, "integer"},
+		"favorite": {"p.favorite", "integer"},
+	},
+	keyText: "CAST((%s) AS text)",
+	search:  "(p.url LIKE $%[1]d ESCAPE '\\' OR p.username LIKE $%[1]d ESCAPE '\\' OR p.name LIKE $%[1]d ESCAPE '\\')",
+	cursor: func(n int, cast string) string {
+		// the driver reads the keys of the timestamps as times, which are passed back in another format
+		if cast == "timestamp" {
+			return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:%%M:%%f', $%d)", n)
+		}
+		return fmt.Sprintf("CAST($%d AS %s)", n, cast)
+	},
+}
+
+func CreateSQLitePassword(db *sql.DB, user *User, p *Password, cipher *DataCipher) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = createSQLitePassword(tx, user, p, cipher)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// createSQLitePassword is createPassword for SQLite
+func createSQLitePassword(tx *sql.Tx, user *User, p *Password, cipher *DataCipher) (err error) {
+	return insertPassword(tx, user, p, cipher, "INSERT INTO passwds (uuid, url, passwd, username, folderid, name, type, match, favorite) "+
+		"VALUES ($1, $2, $3, $4, (SELECT folderid FROM folders WHERE folderid = CAST(NULLIF($5, '') AS integer) AND uuid = $1), "+
+		"$6, $7, $8, $9) RETURNING entryid", "UPDATE passwds SET passwd = $1 WHERE entryid = $2")
+}
+
+func QuerySQLitePassword(db *sql.DB, user *User, url string, username string) (password *Password, err error) {
+	return queryPassword(db, user, url, username, "SELECT "+sqlitePasswordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.url = $2 AND p.username = $3 AND p.deletedate IS NULL GROUP BY p.entryid")
+}
+
+func QuerySQLitePasswordCandidates(db *sql.DB, user *User, domains []string) (passwords []*Password, err error) {
+	return queryPasswordCandidates(db, user, domains, "SELECT "+sqlitePasswordColumns+" FROM "+passwordTables+
+		" WHERE "+policyReadablePasswords+" AND p.deletedate IS NULL AND (p.match = 'regex' OR "+sqliteLikeAny("p.url", "$2")+
+		" OR EXISTS (SELECT 1 FROM passwd_uris u WHERE u.entryid = p.entryid AND (u.match = 'regex' OR "+
+		sqliteLikeAny("u.uri", "$2")+"))) GROUP BY p.entryid")
+}
+
+// UpdateSQLitePassword is UpdatePassword for SQLite, which locks the whole database while writing
+func UpdateSQLitePassword(db *sql.DB, user *User, p *Password, cipher *DataCipher) (err error) {
+	return updatePassword(db, user, p, cipher, "SELECT entryid, passwd, sharedpasswd IS NOT NULL FROM passwds WHERE uuid = $1 AND url = $2",
+		"UPDATE passwds SET passwd = $1, "+
+			"passwordchanged = CASE WHEN $3 THEN "+sqliteNow+" ELSE passwordchanged END WHERE entryid = $2")
+}
+
+func DeleteSQLitePassword(db *sql.DB, url string, username string, uuid string) (err error) {
+	return deletePassword(db, url, username, uuid, "UPDATE passwds SET deletedate = "+sqliteNow+
+		" WHERE uuid = $1 AND url = $2 AND username = $3 AND deletedate IS NULL")
+}
+
+func QueryAllSQLitePasswords(db *sql.DB, u *User, filter PasswordFilter) (passwords []*Password, err error) {
+	return queryAllPasswords(db, u, filter, sqlitePasswordListing)
+}
+
+// UpdateOrCreateSQLiteSessionKeyForUser is UpdateOrCreateSessionKeyForUser for SQLite, the constraints have no names
+func UpdateOrCreateSQLiteSessionKeyForUser(db *sql.DB, u *User, token []byte) (err error) {
+	return updateOrCreateSessionKeyForUser(db, u, token,
+		"INSERT INTO sessions VALUES ($1, $2) ON CONFLICT (uuid) DO UPDATE SET session_token = $3 WHERE sessions.uuid = $4")
+}
+
+func UpdateSQLitePasswordFolder(db *sql.DB, user *User, id string, folder string) (err error) {
+	return updatePasswordFolder(db, user, id, folder, "UPDATE passwds SET folderid = CAST(NULLIF($1, '') AS integer) "+
+		"WHERE entryid = $2 AND uuid = $3 AND (NULLIF($1, '') IS NULL OR EXISTS (SELECT 1 FROM folders "+
+		"WHERE folderid = CAST(NULLIF($1, '') AS integer) AND uuid = $3))")
+}
+
+func QuerySQLiteFolders(db *sql.DB, user *User) (folders []*Folder, err error) {
+	return queryFolders(db, user, "SELECT folderid, name, COALESCE(CAST(parentid AS text), '') FROM folders WHERE uuid = $1 ORDER BY name")
+}
+
+func CreateSQLiteFolder(db *sql.DB, user *User, folder *Folder) (err error) {
+	return createFolder(db, user, folder, "INSERT INTO folders (uuid, name, parentid) VALUES ($1, $2, CAST(NULLIF($3, '') AS integer)) "+
+		"RETURNING folderid")
+}
+
+func UpdateSQLiteFolder(db *sql.DB, user *User, folder *Folder) (err error) {
+	return updateFolder(db, user, folder, "UPDATE folders SET name = $1, parentid = CAST(NULLIF($2, '') AS integer) "+
+		"WHERE folderid = $3 AND uuid = $4")
+}
+
+// sqliteBatchQueries are the batchQueries for SQLite, the parameters are converted by the affinity of the columns
+var sqliteBatchQueries = batchQueries{
+	create: createSQLitePassword,
+	lock:   "SELECT revision, passwd, sharedpasswd IS NOT NULL FROM passwds WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL",
+	update: "UPDATE passwds SET passwd = COALESCE($1, passwd), url = COALESCE($2, url), " +
+		"username = COALESCE($3, username), name = COALESCE($4, name), favorite = COALESCE($5, favorite), " +
+		"folderid = CASE WHEN $6 IS NULL THEN folderid ELSE CAST(NULLIF($6, '') AS integer) END, " +
+		"passwordchanged = CASE WHEN $9 THEN " + sqliteNow + " ELSE passwordchanged END " +
+		"WHERE entryid = $7 AND uuid = $8 AND deletedate IS NULL " +
+		"AND (NULLIF($6, '') IS NULL OR EXISTS (SELECT 1 FROM folders WHERE folderid = CAST(NULLIF($6, '') AS integer) AND uuid = $8))",
+	trash: "UPDATE passwds SET deletedate = " + sqliteNow + " WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL",
+}
+
+func ApplySQLitePasswordBatch(db *sql.DB, user *User, operations []*BatchOperation, cipher *DataCipher) (results []*BatchResult, err error) {
+	return applyPasswordBatch(db, operations, func(tx *sql.Tx, operation *BatchOperation) (*BatchResult, error) {
+		return applyBatchOperation(tx, user, operation, cipher, sqliteBatchQueries)
+	})
+}
+
+// RecordSQLitePasswordUsage is RecordPasswordUsage for SQLite, the time of the use is compared with the cursors of the listing
+func RecordSQLitePasswordUsage(db *sql.DB, user *User, id string) (err error) {
+	return recordPasswordUsage(db, user, id, "INSERT INTO passwd_usage (entryid, uuid, lastused, usecount) VALUES ($1, $2, "+sqliteNow+
+		", 1) ON CONFLICT (entryid, uuid) DO UPDATE SET lastused = "+sqliteNow+", usecount = passwd_usage.usecount + 1")
+}
+
+func DeleteSQLitePasswordUsage(db *sql.DB, user *User, id string) (err error) {
+	return deletePasswordUsage(db, user, id, "DELETE FROM passwd_usage WHERE uuid = $1 AND ($2 = '' OR entryid = $2)")
+}
+
+func QuerySQLiteTrash(db *sql.DB, user *User) (passwords []*Password, err error) {
+	return queryTrash(db, user, "SELECT "+sqlitePasswordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.deletedate IS NOT NULL GROUP BY p.entryid ORDER BY p.deletedate DESC")
+}
+
+func RecordSQLitePasswordRotation(db *sql.DB, user *User, id string) (err error) {
+	return recordPasswordRotation(db, user, id, "UPDATE passwds SET passwordchanged = "+sqliteNow+
+		" WHERE entryid = $1 AND uuid = $2 AND deletedate IS NULL")
+}
+
+func QuerySQLiteDuePasswords(db *sql.DB, user *User, before time.Time) (passwords []*Password, err error) {
+	return queryDuePasswords(db, user, before, "SELECT "+sqlitePasswordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.deletedate IS NULL AND "+sqlitePasswordDueDate+" <= $2 "+
+		"GROUP BY p.entryid ORDER BY "+sqlitePasswordDueDate+", p.entryid")
+}
+
+func QuerySQLiteUsersToRemind(db *sql.DB, before time.Time) (users []*User, err error) {
+	return queryUsersToRemind(db, before, "SELECT u.uuid, u.name, u.mail, u.masterpasswd FROM users u "+
+		"WHERE (u.reminded IS NULL OR u.reminded < CURRENT_DATE) AND EXISTS (SELECT 1 FROM passwds p "+
+		"WHERE p.uuid = u.uuid AND p.deletedate IS NULL AND "+sqlitePasswordDueDate+" <= $1)")
+}
+
+func CreateSQLiteShare(db *sql.DB, user *User, share *Share, password string, ownerKey string) (err error) {
+	return createShare(db, user, share, password, ownerKey, "INSERT INTO shares (entryid, recipient, wrappedkey, permission) "+
+		"SELECT $1, uuid, $2, $3 FROM users WHERE name = $4 AND uuid <> $5 "+
+		"ON CONFLICT (entryid, recipient) DO UPDATE SET wrappedkey = $2, permission = $3")
+}
+
+// DeleteSQLiteShare is DeleteShare for SQLite, which cannot join other tables in a delete
+func DeleteSQLiteShare(db *sql.DB, user *User, entry string, username string) (err error) {
+	return deleteShare(db, user, entry, username, "DELETE FROM shares WHERE entryid = $1 "+
+		"AND EXISTS (SELECT 1 FROM passwds p WHERE p.entryid = shares.entryid AND p.uuid = $2) "+
+		"AND recipient IN (SELECT uuid FROM users WHERE name = $3)")
+}
+
+func UpdateSQLiteSharedPassword(db *sql.DB, user *User, id string, password string) (err error) {
+	return updateSharedPassword(db, user, id, password, "UPDATE passwds AS p SET sharedpasswd = $1, passwordchanged = "+sqliteNow+
+		" WHERE p.entryid = $2 AND p.sharedpasswd IS NOT NULL AND p.deletedate IS NULL AND (p.uuid = $3 OR EXISTS "+
+		"(SELECT 1 FROM shares s WHERE s.entryid = p.entryid AND s.recipient = $3 AND s.permission = 'edit'))")
+}
+
+func SaveSQLiteMember(db *sql.DB, member *Membership) (err error) {
+	return saveMember(db, member, "INSERT INTO memberships (orgid, uuid, role, status, orgkey) "+
+		"SELECT $1, uuid, $2, 'invited', $3 FROM users WHERE name = $4 "+
+		"ON CONFLICT (orgid, uuid) DO UPDATE SET role = $2, orgkey = COALESCE(NULLIF($3, ''), memberships.orgkey)")
+}
+
+func UpdateSQLiteMember(db *sql.DB, member *Membership) (err error) {
+	return saveMember(db, member, "UPDATE memberships SET role = $2, orgkey = COALESCE(NULLIF($3, ''), orgkey) "+
+		"WHERE orgid = $1 AND uuid IN (SELECT uuid FROM users WHERE name = $4)")
+}
+
+func DeleteSQLiteMember(db *sql.DB, org string, username string) (err error) {
+	return deleteMember(db, org, username, "DELETE FROM memberships WHERE orgid = $1 AND uuid IN (SELECT uuid FROM users WHERE name = $2)",
+		"DELETE FROM collection_grants WHERE collectionid IN (SELECT collectionid FROM collections WHERE orgid = $1) "+
+			"AND uuid IN (SELECT uuid FROM users WHERE name = $2)")
+}
+
+func DeleteSQLiteCollection(db *sql.DB, id string) (err error) {
+	return deleteCollection(db, id, "DELETE FROM collections AS c WHERE c.collectionid = $1 "+
+		"AND NOT EXISTS (SELECT 1 FROM passwds p WHERE p.collectionid = c.collectionid)")
+}
+
+func SaveSQLiteCollectionGrant(db *sql.DB, grant *CollectionGrant) (err error) {
+	return saveCollectionGrant(db, grant, "INSERT INTO collection_grants (collectionid, uuid, permission) "+
+		"SELECT c.collectionid, m.uuid, $2 FROM collections c JOIN memberships m ON m.orgid = c.orgid "+
+		"JOIN users u ON u.uuid = m.uuid WHERE c.collectionid = $1 AND u.name = $3 "+
+		"ON CONFLICT (collectionid, uuid) DO UPDATE SET permission = $2")
+}
+
+func DeleteSQLiteCollectionGrant(db *sql.DB, collection string, username string) (err error) {
+	return deleteCollectionGrant(db, collection, username, "DELETE FROM collection_grants WHERE collectionid = $1 "+
+		"AND uuid IN (SELECT uuid FROM users WHERE name = $2)")
+}
+
+func QuerySQLiteEntryAccess(db *sql.DB, user *User, id string) (access *EntryAccess, err error) {
+	return queryEntryAccess(db, user, id, "SELECT p.uuid, COALESCE(CAST(p.collectionid AS text), ''), "+
+		"COALESCE(CAST(c.orgid AS text), ''), COALESCE(m.role, ''), COALESCE(g.permission, ''), COALESCE(s.permission, ''), "+
+		"COALESCE(e.access, ''), p.revision FROM passwds p "+
+		"LEFT JOIN collections c ON c.collectionid = p.collectionid "+
+		"LEFT JOIN memberships m ON m.orgid = c.orgid AND m.uuid = $2 AND m.status = 'accepted' "+
+		"LEFT JOIN collection_grants g ON g.collectionid = p.collectionid AND g.uuid = $2 "+
+		"LEFT JOIN shares s ON s.entryid = p.entryid AND s.recipient = $2 "+
+		"LEFT JOIN emergency_contacts e ON e.grantor = p.uuid AND e.grantee = $2 AND e.status = 'granted' WHERE p.entryid = $1")
+}
+
+func UpdateSQLitePasswordCollection(db *sql.DB, owner *User, id string, collection string, password string) (err error) {
+	return updatePasswordCollection(db, owner, id, collection, password, "UPDATE passwds SET collectionid = CAST(NULLIF($1, '') AS integer), "+
+		"passwd = $2, folderid = NULL, sharedpasswd = NULL, sharekey = NULL WHERE entryid = $3 AND uuid = $4 AND deletedate IS NULL")
+}
+
+func QuerySQLiteEmergencyContacts(db *sql.DB, user *User) (contacts []*EmergencyContact, err error) {
+	return queryEmergencyContacts(db, user, "SELECT "+sqliteEmergencyContactColumns+", "+emergencyWrappedKey+" FROM "+
+		emergencyContactTables+" WHERE e.grantor = $1 OR e.grantee = $1 ORDER BY e.contactid")
+}
+
+func QuerySQLiteEmergencyContact(db *sql.DB, user *User, id string) (contact *EmergencyContact, err error) {
+	return queryEmergencyContact(db, user, id, "SELECT "+sqliteEmergencyContactColumns+", "+emergencyWrappedKey+" FROM "+
+		emergencyContactTables+" WHERE e.contactid = $2 AND (e.grantor = $1 OR e.grantee = $1)")
+}
+
+func UpdateSQLiteEmergencyContact(db *sql.DB, user *User, contact *EmergencyContact) (err error) {
+	return updateEmergencyContact(db, user, contact, "UPDATE emergency_contacts SET access = $1, waitdays = $2, "+
+		"wrappedkey = COALESCE(NULLIF($3, ''), wrappedkey) WHERE contactid = $4 AND grantor = $5")
+}
+
+func DeleteSQLiteEmergencyContact(db *sql.DB, user *User, id string) (err error) {
+	return deleteEmergencyContact(db, user, id, "DELETE FROM emergency_contacts WHERE contactid = $1 AND (grantor = $2 OR grantee = $2)")
+}
+
+// updateSQLiteEmergencyStatus is updateEmergencyStatus for SQLite
+func updateSQLiteEmergencyStatus(db *sql.DB, set string, condition string, args ...interface{}) (err error) {
+	// prepare statement
+	stmt, err := db.Prepare("UPDATE emergency_contacts SET " + set + " WHERE contactid = $1 AND " + condition)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	return execAffectingRows(stmt, args...)
+}
+
+func AcceptSQLiteEmergencyContact(db *sql.DB, user *User, id string) (err error) {
+	return updateSQLiteEmergencyStatus(db, "status = 'accepted'", "grantee = $2 AND status = 'invited'", id, user.Uuid)
+}
+
+func RequestSQLiteEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateSQLiteEmergencyStatus(db, "status = CASE WHEN waitdays = 0 THEN 'granted' ELSE 'requested' END, "+
+		"requestdate = "+sqliteNow, "grantee = $2 AND status = 'accepted'", id, user.Uuid)
+}
+
+func ApproveSQLiteEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateSQLiteEmergencyStatus(db, "status = 'granted'", "grantor = $2 AND status = 'requested'", id, user.Uuid)
+}
+
+func RejectSQLiteEmergencyAccess(db *sql.DB, user *User, id string) (err error) {
+	return updateSQLiteEmergencyStatus(db, "status = 'accepted', requestdate = NULL",
+		"grantor = $2 AND status IN ('requested', 'granted')", id, user.Uuid)
+}
+
+// GrantDueSQLiteEmergencyAccess is GrantDueEmergencyAccess for SQLite, the granted contacts are read after the update
+func GrantDueSQLiteEmergencyAccess(db *sql.DB) (contacts []*EmergencyContact, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// execute statement
+	rows, err := tx.Query("UPDATE emergency_contacts SET status = 'granted' WHERE status = 'requested' " +
+		"AND strftime('%Y-%m-%d %H:%M:%f', requestdate, '+' || waitdays || ' days') <= " + sqliteNow + " RETURNING contactid")
+	if err != nil {
+		return nil, err
+	}
+	var granted []int64
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		granted = append(granted, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := tx.Prepare("SELECT " + sqliteEmergencyContactColumns + " FROM " + emergencyContactTables + " WHERE e.contactid = $1")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	for _, id := range granted {
+		contact, err := scanEmergencyContact(stmt.QueryRow(id))
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func QuerySQLiteEmergencyPasswords(db *sql.DB, user *User, id string) (passwords []*Password, err error) {
+	return queryEmergencyPasswords(db, user, id, "SELECT "+sqlitePasswordColumns+" FROM "+passwordTables+
+		" JOIN emergency_contacts e ON e.grantor = p.uuid WHERE e.grantee = $1 AND e.contactid = $2 "+
+		"AND e.status = 'granted' AND p.collectionid IS NULL AND p.deletedate IS NULL GROUP BY p.entryid ORDER BY p.entryid")
+}
+
+func QuerySQLiteSends(db *sql.DB, user *User) (sends []*Send, err error) {
+	return querySends(db, user, "SELECT s.sendid, s.type, s.name, s.expires, s.maxviews, s.views, s.passwordhash IS NOT NULL, "+
+		"s.createdate FROM sends s WHERE s.uuid = $1 AND "+sqliteSendAvailable+" ORDER BY s.createdate")
+}
+
+func QuerySQLiteSendProtection(db *sql.DB, id string) (send *Send, err error) {
+	return querySendProtection(db, id, "SELECT s.sendid, s.passwordhash FROM sends s WHERE s.sendid = $1 AND "+
+		sqliteSendAvailable)
+}
+
+func CountSQLiteSendAttempt(db *sql.DB, id string) (err error) {
+	return countSendAttempt(db, id, "UPDATE sends AS s SET failedattempts = s.failedattempts + 1 WHERE s.sendid = $1 "+
+		"AND s.passwordhash IS NOT NULL AND "+sqliteSendAvailable)
+}
+
+// ConsumeSQLiteSend is ConsumeSend for SQLite, which only returns the columns of the changed row
+func ConsumeSQLiteSend(db *sql.DB, id string) (send *Send, err error) {
+	return consumeSend(db, id, "UPDATE sends AS s SET views = s.views + 1, failedattempts = s.failedattempts - "+
+		"CASE WHEN s.passwordhash IS NULL THEN 0 ELSE 1 END WHERE s.sendid = $1 AND "+sqliteSendUnused+
+		" RETURNING sendid, type, name, content, expires, maxviews, views, passwordhash IS NOT NULL")
+}
+
+func PurgeSQLiteSends(db *sql.DB) (purged int64, err error) {
+	return deleteUnavailableSends(db, "DELETE FROM sends AS s WHERE NOT ("+sqliteSendAvailable+")")
+}
+
+var sqliteSyncQueries = syncQueries{
+	passwords: "SELECT " + sqlitePasswordColumns + " FROM " + passwordTables +
+		" WHERE p.uuid = $1 AND p.revision > $2 GROUP BY p.entryid ORDER BY p.revision, p.entryid",
+	folders: "SELECT folderid, name, COALESCE(CAST(parentid AS text), ''), revision FROM folders " +
+		"WHERE uuid = $1 AND revision > $2 ORDER BY revision, folderid",
+	deletions: "SELECT kind, CAST(itemid AS text), revision FROM sync_deletions WHERE uuid = $1 AND revision > $2 " +
+		"ORDER BY revision",
+}
+
+func QuerySQLiteSyncChanges(db *sql.DB, user *User, since int64) (changes *SyncChanges, err error) {
+	return querySyncChanges(db, user, since, sqliteSyncQueries)
+}
+
+// PruneSQLiteSyncDeletions is PruneSyncDeletions for SQLite, which does not allow changes in common table expressions
+func PruneSQLiteSyncDeletions(db *sql.DB, before time.Time) (pruned int64, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// execute statement
+	_, err = tx.Exec("UPDATE users SET syncpruned = (SELECT max(revision) FROM sync_deletions d "+
+		"WHERE d.uuid = users.uuid AND d.createdate < $1) WHERE syncpruned < (SELECT max(revision) FROM sync_deletions d "+
+		"WHERE d.uuid = users.uuid AND d.createdate < $1)", before)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM sync_deletions WHERE createdate < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err = result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// end query
+	return pruned, tx.Commit()
+}
+
+func UpdateSQLitePasswordHealth(db *sql.DB, user *User, health []*EntryHealth) (err error) {
+	return updatePasswordHealth(db, user, health, "UPDATE passwds SET fingerprint = $1, strength = $2, "+
+		"identifier = COALESCE(NULLIF($3, ''), identifier), healthdate = "+sqliteNow+
+		" WHERE entryid = $4 AND uuid = $5 AND deletedate IS NULL")
+}
+
+func QuerySQLiteHealthRecords(db *sql.DB, user *User) (records []*HealthRecord, err error) {
+	return queryHealthRecords(db, user, "SELECT CAST(p.entryid AS text), "+
+		"CASE WHEN p.healthdate >= COALESCE(p.passwordchanged, p.createdate) THEN COALESCE(p.fingerprint, '') ELSE '' END, "+
+		"CASE WHEN p.healthdate >= COALESCE(p.passwordchanged, p.createdate) THEN COALESCE(p.strength, -1) ELSE -1 END, "+
+		"COALESCE(p.passwordchanged, p.createdate), p.url LIKE 'http://%' OR EXISTS (SELECT 1 FROM passwd_uris u "+
+		"WHERE u.entryid = p.entryid AND u.uri LIKE 'http://%') FROM passwds p "+
+		"WHERE p.uuid = $1 AND p.deletedate IS NULL AND p.type = $2 ORDER BY p.entryid")
+}
+
+// ImportSQLiteBreach is ImportBreach for SQLite, the identifiers are inserted from the elements of the array
+func ImportSQLiteBreach(db *sql.DB, breach *Breach, identifiers []string) (err error) {
+	return importBreach(db, breach, identifiers, "INSERT INTO breaches (name, title, domain, breachdate, dataclasses) "+
+		"VALUES ($1, $2, $3, $4, $5) ON CONFLICT (name) DO UPDATE SET title = $2, domain = $3, breachdate = $4, dataclasses = $5, "+
+		"importdate = "+sqliteNow, "INSERT INTO breach_identifiers (hash, breach) SELECT DISTINCT value, $2 "+
+		"FROM json_each(array_to_json($1)) WHERE true ON CONFLICT DO NOTHING")
+}
+
+func QuerySQLiteBreaches(db *sql.DB, user *User) (breaches []*Breach, err error) {
+	return queryBreaches(db, user, "SELECT b.name, b.title, b.domain, COALESCE(b.breachdate, b.importdate), b.dataclasses, "+
+		"COALESCE(CAST(f.entryid AS text), ''), f.founddate FROM breach_findings f JOIN breaches b ON b.name = f.breach "+
+		"LEFT JOIN passwds p ON p.entryid = f.entryid WHERE f.uuid = $1 AND (f.entryid IS NULL OR p.deletedate IS NULL) "+
+		"ORDER BY COALESCE(b.breachdate, b.importdate) DESC, b.name, f.entryid NULLS FIRST")
+}
+
+// CreateSQLiteAttachment is CreateAttachment for SQLite, whose writers are serialized by the lock of the database
+func CreateSQLiteAttachment(db *sql.DB, user *User, attachment *Attachment, quota int64) (err error) {
+	return createAttachment(db, user, attachment, quota, "SELECT (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uuid = $1) "+
+		"FROM users WHERE uuid = $1", "INSERT INTO attachments (attachmentid, entryid, uuid, filename, size, createdate) "+
+		"SELECT $1, entryid, uuid, $2, $3, "+sqliteNow+" FROM passwds WHERE entryid = $4 AND uuid = $5 AND deletedate IS NULL "+
+		"RETURNING createdate")
+}
+
+func QuerySQLiteSealedValues(db *sql.DB, column sealedColumn, after string, limit int) (keys []string, owners []string, values []string, err error) {
+	return querySealedValues(db, column, after, limit, fmt.Sprintf("SELECT CAST(%s AS text), %s, %s FROM %s WHERE %s > CAST($1 AS %s) "+
+		"AND %s IS NOT NULL ORDER BY %s LIMIT $2", column.key, column.owner, column.column, column.table, column.key, column.keyType,
+		column.column, column.key))
+}
+
+func UpdateSQLiteSealedValue(db *sql.DB, column sealedColumn, key string, previous string, value string) (bool, error) {
+	return updateSealedValue(db, column, key, previous, value, fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = CAST($2 AS %s) AND %s = $3",
+		column.table, column.column, column.key, column.keyType, column.column))
+}
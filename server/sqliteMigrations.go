@@ -0,0 +1,696 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sqliteMigrations create the schema of schemaMigrations on SQLite, every migration has the version and name of the
+// one of Postgres. The timestamps are stored as text in sqliteTimestampFormat and the arrays in the text format of Postgres.
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: `
+create table users
+(
+    uuid varchar(36) not null
+        constraint users_pk
+            primary key,
+    name text not null
+        constraint users_name_check
+            check (name <> ''),
+    mail text not null,
+    masterpasswd text not null,
+    createdate timestamp,
+    reminded date,
+    publickey text,
+    privatekey text
+);
+
+create table sessions
+(
+    uuid varchar(36) not null,
+    session_token varchar(32) not null,
+    constraint sessions_pk
+        primary key (uuid)
+);
+
+create table authenticators
+(
+    id blob not null,
+    credentialid blob not null,
+    publickey blob,
+    aaguid blob not null,
+    signcount integer not null,
+    userid varchar(36)
+);
+
+create table folders
+(
+    folderid integer not null
+        constraint folders_pk
+            primary key autoincrement,
+    uuid varchar(36) not null
+        constraint folders_users_uuid_fk
+            references users on delete cascade,
+    name text not null
+        constraint folders_name_check
+            check (name <> ''),
+    parentid integer
+        constraint folders_folders_folderid_fk
+            references folders on delete set null
+);
+
+create table organizations
+(
+    orgid integer not null
+        constraint organizations_pk
+            primary key autoincrement,
+    name text not null
+        constraint organizations_name_check
+            check (name <> ''),
+    createdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create table memberships
+(
+    orgid integer not null
+        constraint memberships_organizations_orgid_fk
+            references organizations on delete cascade,
+    uuid varchar(36) not null
+        constraint memberships_users_uuid_fk
+            references users on delete cascade,
+    role varchar(8) not null
+        constraint memberships_role_check
+            check (role in ('owner', 'admin', 'manager', 'member')),
+    status varchar(8) not null
+        constraint memberships_status_check
+            check (status in ('invited', 'accepted')),
+    orgkey text not null,
+    constraint memberships_pk
+        primary key (orgid, uuid)
+);
+
+create index memberships_uuid_idx on memberships (uuid);
+
+create table collections
+(
+    collectionid integer not null
+        constraint collections_pk
+            primary key autoincrement,
+    orgid integer not null
+        constraint collections_organizations_orgid_fk
+            references organizations on delete cascade,
+    name text not null
+        constraint collections_name_check
+            check (name <> '')
+);
+
+create table collection_grants
+(
+    collectionid integer not null
+        constraint collection_grants_collections_collectionid_fk
+            references collections on delete cascade,
+    uuid varchar(36) not null
+        constraint collection_grants_users_uuid_fk
+            references users on delete cascade,
+    permission varchar(8) not null
+        constraint collection_grants_permission_check
+            check (permission in ('read', 'edit')),
+    constraint collection_grants_pk
+        primary key (collectionid, uuid)
+);
+
+create table passwds
+(
+    entryid integer not null
+        constraint passwds_pk
+            primary key autoincrement,
+    uuid varchar(36) not null
+        constraint passwds_users_uuid_fk
+            references users on delete cascade,
+    url text,
+    passwd text not null,
+    username text,
+    folderid integer
+        constraint passwds_folders_folderid_fk
+            references folders on delete set null,
+    name text not null default '',
+    type text not null default 'login'
+        constraint passwds_type_check
+            check (type in ('login', 'note', 'card', 'identity')),
+    createdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    match text not null default 'domain'
+        constraint passwds_match_check
+            check (match in ('domain', 'host', 'hostport', 'startswith', 'regex', 'never')),
+    favorite boolean not null default false,
+    deletedate timestamp,
+    expires timestamp,
+    rotationdays integer not null default 0,
+    passwordchanged timestamp,
+    sharedpasswd text,
+    sharekey text,
+    collectionid integer
+        constraint passwds_collections_collectionid_fk
+            references collections on delete restrict
+);
+
+create table tags
+(
+    tagid integer not null
+        constraint tags_pk
+            primary key autoincrement,
+    uuid varchar(36) not null
+        constraint tags_users_uuid_fk
+            references users on delete cascade,
+    name text not null
+        constraint tags_name_check
+            check (name <> ''),
+    constraint tags_uuid_name_key
+        unique (uuid, name)
+);
+
+create table passwd_tags
+(
+    entryid integer not null
+        constraint passwd_tags_passwds_entryid_fk
+            references passwds on delete cascade,
+    tagid integer not null
+        constraint passwd_tags_tags_tagid_fk
+            references tags on delete cascade,
+    constraint passwd_tags_pk
+        primary key (entryid, tagid)
+);
+
+create index passwds_uuid_url_idx on passwds (uuid, url);
+create index passwds_uuid_folderid_idx on passwds (uuid, folderid);
+create index passwds_uuid_name_idx on passwds (uuid, lower(COALESCE(NULLIF(name, ''), url, '')), entryid);
+create index passwds_uuid_lowerurl_idx on passwds (uuid, lower(COALESCE(url, '')), entryid);
+create index passwds_uuid_createdate_idx on passwds (uuid, createdate, entryid);
+create index passwds_deletedate_idx on passwds (deletedate) where deletedate is not null;
+create index passwds_collectionid_idx on passwds (collectionid) where collectionid is not null;
+create index passwd_tags_tagid_idx on passwd_tags (tagid);
+create index folders_uuid_idx on folders (uuid);
+
+create table passwd_usage
+(
+    entryid integer not null
+        constraint passwd_usage_passwds_entryid_fk
+            references passwds on delete cascade,
+    uuid varchar(36) not null
+        constraint passwd_usage_users_uuid_fk
+            references users on delete cascade,
+    lastused timestamp not null,
+    usecount integer not null default 0,
+    constraint passwd_usage_pk
+        primary key (entryid, uuid)
+);
+
+create index passwd_usage_uuid_idx on passwd_usage (uuid);
+
+create table passwd_uris
+(
+    uriid integer not null
+        constraint passwd_uris_pk
+            primary key autoincrement,
+    entryid integer not null
+        constraint passwd_uris_passwds_entryid_fk
+            references passwds on delete cascade,
+    uri text not null,
+    match text not null default 'domain'
+        constraint passwd_uris_match_check
+            check (match in ('domain', 'host', 'hostport', 'startswith', 'regex', 'never'))
+);
+
+create index passwd_uris_entryid_idx on passwd_uris (entryid);
+
+create table equivalent_domains
+(
+    groupid integer not null
+        constraint equivalent_domains_pk
+            primary key autoincrement,
+    uuid varchar(36) not null
+        constraint equivalent_domains_users_uuid_fk
+            references users on delete cascade,
+    domains text not null
+);
+
+create table attachments
+(
+    attachmentid varchar(36) not null
+        constraint attachments_pk
+            primary key,
+    entryid integer not null
+        constraint attachments_passwds_entryid_fk
+            references passwds on delete cascade,
+    uuid varchar(36) not null
+        constraint attachments_users_uuid_fk
+            references users on delete cascade,
+    filename text not null,
+    size bigint not null,
+    createdate timestamp not null
+);
+
+create index attachments_entryid_idx on attachments (entryid);
+create index attachments_uuid_idx on attachments (uuid);
+
+create table attachment_blobs
+(
+    blobkey varchar(36) not null
+        constraint attachment_blobs_pk
+            primary key
+        constraint attachment_blobs_attachments_attachmentid_fk
+            references attachments on delete cascade,
+    data blob not null
+);
+
+create table shares
+(
+    shareid integer not null
+        constraint shares_pk
+            primary key autoincrement,
+    entryid integer not null
+        constraint shares_passwds_entryid_fk
+            references passwds on delete cascade,
+    recipient varchar(36) not null
+        constraint shares_users_uuid_fk
+            references users on delete cascade,
+    wrappedkey text not null,
+    permission varchar(8) not null
+        constraint shares_permission_check
+            check (permission in ('read', 'edit')),
+    constraint shares_entryid_recipient_key
+        unique (entryid, recipient)
+);
+
+create index shares_recipient_idx on shares (recipient);
+
+create table emergency_contacts
+(
+    contactid integer not null
+        constraint emergency_contacts_pk
+            primary key autoincrement,
+    grantor varchar(36) not null
+        constraint emergency_contacts_grantor_fk
+            references users on delete cascade,
+    grantee varchar(36) not null
+        constraint emergency_contacts_grantee_fk
+            references users on delete cascade,
+    access varchar(8) not null
+        constraint emergency_contacts_access_check
+            check (access in ('view', 'takeover')),
+    waitdays integer not null
+        constraint emergency_contacts_waitdays_check
+            check (waitdays >= 0),
+    status varchar(9) not null default 'invited'
+        constraint emergency_contacts_status_check
+            check (status in ('invited', 'accepted', 'requested', 'granted')),
+    wrappedkey text not null,
+    requestdate timestamp,
+    constraint emergency_contacts_grantor_grantee_key
+        unique (grantor, grantee),
+    constraint emergency_contacts_self_check
+        check (grantor <> grantee)
+);
+
+create index emergency_contacts_grantee_idx on emergency_contacts (grantee);
+
+create table sends
+(
+    sendid varchar(32) not null
+        constraint sends_pk
+            primary key,
+    uuid varchar(36) not null
+        constraint sends_users_uuid_fk
+            references users on delete cascade,
+    type varchar(8) not null
+        constraint sends_type_check
+            check (type in ('text', 'file')),
+    name text not null default '',
+    content text not null,
+    expires timestamp not null,
+    maxviews integer not null
+        constraint sends_maxviews_check
+            check (maxviews > 0),
+    views integer not null default 0,
+    passwordhash blob,
+    failedattempts integer not null default 0,
+    createdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create index sends_uuid_idx on sends (uuid);
+
+create table exports
+(
+    exportid integer not null
+        constraint exports_pk
+            primary key autoincrement,
+    uuid varchar(36) not null
+        constraint exports_users_uuid_fk
+            references users on delete cascade,
+    format varchar(4) not null
+        constraint exports_format_check
+            check (format in ('json', 'csv', 'kdbx')),
+    entries integer not null,
+    address text not null default '',
+    useragent text not null default '',
+    createdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create index exports_uuid_idx on exports (uuid, createdate);
+`,
+		Down: `
+drop table if exists exports;
+drop table if exists sends;
+drop table if exists emergency_contacts;
+drop table if exists shares;
+drop table if exists attachment_blobs;
+drop table if exists attachments;
+drop table if exists equivalent_domains;
+drop table if exists passwd_uris;
+drop table if exists passwd_usage;
+drop table if exists passwd_tags;
+drop table if exists tags;
+drop table if exists passwds;
+drop table if exists collection_grants;
+drop table if exists collections;
+drop table if exists memberships;
+drop table if exists organizations;
+drop table if exists folders;
+drop table if exists authenticators;
+drop table if exists sessions;
+drop table if exists users;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "widen_entry_columns",
+		// the baseline of SQLite already has the widened columns, SQLite does not check the lengths anyway
+		Up:   "-- nothing to do",
+		Down: "-- nothing to do",
+	},
+	{
+		Version: 3,
+		Name:    "widen_master_password",
+		Up:      "-- nothing to do",
+		Down:    "-- nothing to do",
+	},
+	{
+		Version: 4,
+		Name:    "sync_revisions",
+		Up: `
+alter table users add column revision bigint not null default 0;
+alter table users add column syncpruned bigint not null default 0;
+alter table passwds add column revision bigint not null default 0;
+alter table folders add column revision bigint not null default 0;
+alter table tags add column revision bigint not null default 0;
+
+create index passwds_uuid_revision_idx on passwds (uuid, revision);
+create index folders_uuid_revision_idx on folders (uuid, revision);
+create index tags_uuid_revision_idx on tags (uuid, revision);
+
+create table sync_deletions
+(
+    deletionid integer not null
+        constraint sync_deletions_pk
+            primary key autoincrement,
+    uuid varchar(36) not null,
+    kind text not null
+        constraint sync_deletions_kind_check
+            check (kind in ('entry', 'folder', 'tag')),
+    itemid integer not null,
+    revision bigint not null,
+    createdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create index sync_deletions_uuid_revision_idx on sync_deletions (uuid, revision);
+create index sync_deletions_createdate_idx on sync_deletions (createdate);
+` + sqliteRevisionTriggers(false),
+		Down: dropSQLiteRevisionTriggers() + `
+drop table if exists sync_deletions;
+drop index if exists tags_uuid_revision_idx;
+drop index if exists folders_uuid_revision_idx;
+drop index if exists passwds_uuid_revision_idx;
+alter table tags drop column revision;
+alter table folders drop column revision;
+alter table passwds drop column revision;
+alter table users drop column syncpruned;
+alter table users drop column revision;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "vault_events",
+		// vault_event hands the events to the server once the transaction is committed,
+		// the readers of an entry learn about its deletion before its shares are deleted along with it
+		Up: dropSQLiteRevisionTriggers() + sqliteRevisionTriggers(true) + `
+create trigger sync_deletions_vault_deleted after insert on sync_deletions for each row
+begin
+    select vault_event(json_object('user', NEW.uuid, 'type', NEW.kind, 'action', 'deleted', 'id', NEW.itemid || '',
+        'revision', NEW.revision));
+end;
+
+create trigger passwds_vault_deleted before delete on passwds for each row
+begin
+` + sqliteReaderEvents("'deleted'", "OLD") + `end;
+`,
+		Down: `
+drop trigger if exists passwds_vault_deleted;
+drop trigger if exists sync_deletions_vault_deleted;
+` + dropSQLiteRevisionTriggers() + sqliteRevisionTriggers(false),
+	},
+	{
+		Version: 6,
+		Name:    "entry_health",
+		Up: `
+alter table passwds add column fingerprint text;
+alter table passwds add column strength smallint;
+alter table passwds add column healthdate timestamp;
+`,
+		Down: `
+alter table passwds drop column healthdate;
+alter table passwds drop column strength;
+alter table passwds drop column fingerprint;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "breach_monitoring",
+		Up: `
+create table breaches
+(
+    name text not null
+        constraint breaches_pk
+            primary key,
+    title text not null,
+    domain text not null default '',
+    breachdate date,
+    dataclasses text not null default '{}',
+    importdate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create table breach_identifiers
+(
+    hash char(64) not null,
+    breach text not null
+        constraint breach_identifiers_breaches_name_fk
+            references breaches on delete cascade,
+    constraint breach_identifiers_pk
+        primary key (hash, breach)
+);
+
+create index breach_identifiers_breach_idx on breach_identifiers (breach);
+
+alter table passwds add column identifier char(64);
+
+create table breach_findings
+(
+    uuid varchar(36) not null
+        constraint breach_findings_users_uuid_fk
+            references users on delete cascade,
+    entryid integer
+        constraint breach_findings_passwds_entryid_fk
+            references passwds on delete cascade,
+    breach text not null
+        constraint breach_findings_breaches_name_fk
+            references breaches on delete cascade,
+    founddate timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create unique index breach_findings_account_idx on breach_findings (uuid, coalesce(entryid, 0), breach);
+`,
+		Down: `
+drop table if exists breach_findings;
+alter table passwds drop column identifier;
+drop table if exists breach_identifiers;
+drop table if exists breaches;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "password_rules",
+		Up: `
+create table password_rules
+(
+    uuid varchar(36) not null
+        constraint password_rules_users_uuid_fk
+            references users on delete cascade,
+    domain text not null
+        constraint password_rules_domain_check
+            check (domain <> ''),
+    rules text not null,
+    constraint password_rules_pk
+        primary key (uuid, domain)
+);
+`,
+		Down: `
+drop table if exists password_rules;
+`,
+	},
+}
+
+// sqliteRewrapSkipped is the condition of the triggers which keep the revision during the re-wrap
+const sqliteRewrapSkipped = "current_setting('keycloud.rewrap', true) is not 'on'"
+
+// sqliteItem is a table of vault items with a revision, action is the action of the vault event of an update
+type sqliteItem struct {
+	table   string
+	kind    string
+	id      string
+	columns string
+	action  string
+}
+
+var sqliteItems = []sqliteItem{
+	{"passwds", "entry", "entryid", "url, passwd, username, folderid, name, type, match, favorite, deletedate, " +
+		"expires, rotationdays, sharedpasswd, sharekey, collectionid",
+		"CASE WHEN OLD.deletedate IS NULL AND NEW.deletedate IS NOT NULL THEN 'trashed' " +
+			"WHEN OLD.deletedate IS NOT NULL AND NEW.deletedate IS NULL THEN 'restored' ELSE 'updated' END"},
+	{"folders", "folder", "folderid", "name, parentid", "'updated'"},
+	{"tags", "tag", "tagid", "name", "'updated'"},
+}
+
+// sqliteEntryChildren are the tables whose changes change the revision of their entry
+var sqliteEntryChildren = map[string][]string{
+	"passwd_tags": {"insert", "delete"},
+	"passwd_uris": {"insert", "update", "delete"},
+}
+
+// sqliteRevisionTriggers stand in for sync_revision, sync_deletion and sync_entry_revision of Postgres. SQLite triggers
+// cannot change the row they fire for, so the revision is set by a second update. Only these triggers see the row
+// before the change, with events they publish the vault events to the owners and the readers of the entries as well.
+// The re-wrap of the entries keeps their revision.
+func sqliteRevisionTriggers(events bool) string {
+	var triggers strings.Builder
+	for _, item := range sqliteItems {
+		when := ""
+		if item.kind == EventEntry {
+			when = sqliteRewrapSkipped
+		}
+		triggers.WriteString(sqliteItemTrigger(item, "created", events, "", events))
+		triggers.WriteString(sqliteItemTrigger(item, "updated", events, when, events))
+		// rows deleted together with their user leave no marker
+		fmt.Fprintf(&triggers, `
+create trigger %[1]s_sync_deletion after delete on %[1]s for each row
+begin
+    update users set revision = revision + 1 where uuid = OLD.uuid;
+    insert into sync_deletions (uuid, kind, itemid, revision)
+    select OLD.uuid, '%[2]s', OLD.%[3]s, revision from users where uuid = OLD.uuid;
+end;
+`, item.table, item.kind, item.id)
+	}
+	for _, table := range []string{"passwd_tags", "passwd_uris"} {
+		for _, event := range sqliteEntryChildren[table] {
+			triggers.WriteString(sqliteChildTrigger(table, event, events, events))
+		}
+	}
+	return triggers.String()
+}
+
+// sqliteEntryReaders is the condition on the users r who may read the entry p besides its owner, the recipients of
+// its shares and the members of its collection like entry_readers of Postgres
+const sqliteEntryReaders = `r.uuid <> p.uuid and r.uuid in (select recipient from shares where entryid = p.entryid
+        union select m.uuid from collections c join memberships m on m.orgid = c.orgid and m.status = 'accepted'
+        where c.collectionid = p.collectionid and (m.role in ('owner', 'admin')
+            or exists (select 1 from collection_grants g where g.collectionid = c.collectionid and g.uuid = m.uuid)))`
+
+// sqliteReaderEvents publishes the vault event of the entry of the row to its readers
+func sqliteReaderEvents(action string, row string) string {
+	return fmt.Sprintf(`    select vault_event(json_object('user', r.uuid, 'type', 'entry', 'action', %s, 'id', p.entryid || '',
+        'revision', p.revision)) from passwds p, users r where p.entryid = %s.entryid and %s;
+`, action, row, sqliteEntryReaders)
+}
+
+// sqliteChildTrigger is the trigger of sqliteRevisionTriggers for the change of a tag or uri of an entry,
+// with readers the readers of the entry receive its vault event as well
+func sqliteChildTrigger(table string, event string, events bool, readers bool) string {
+	var trigger strings.Builder
+	row := "NEW"
+	if event == "delete" {
+		row = "OLD"
+	}
+	fmt.Fprintf(&trigger, `
+create trigger %[1]s_sync_%[2]s after %[2]s on %[1]s for each row
+begin
+    update users set revision = revision + 1 where uuid = (select uuid from passwds where entryid = %[3]s.entryid);
+    update passwds set revision = COALESCE((select revision from users where users.uuid = passwds.uuid), revision)
+    where entryid = %[3]s.entryid;
+`, table, event, row)
+	if events {
+		fmt.Fprintf(&trigger, `    select vault_event(json_object('user', uuid, 'type', 'entry', 'action', 'updated', 'id', entryid || '',
+        'revision', revision)) from passwds where entryid = %s.entryid;
+`, row)
+	}
+	if events && readers {
+		trigger.WriteString(sqliteReaderEvents("'updated'", row))
+	}
+	trigger.WriteString("end;\n")
+	return trigger.String()
+}
+
+// sqliteItemTrigger is the trigger of sqliteRevisionTriggers for the created or updated rows of the item,
+// it only fires if the condition when holds. With readers the readers of an entry receive its vault event as well.
+func sqliteItemTrigger(item sqliteItem, name string, events bool, when string, readers bool) string {
+	var trigger strings.Builder
+	event, action := "insert", "'created'"
+	if name == "updated" {
+		event, action = "update of "+item.columns, item.action
+	}
+	fmt.Fprintf(&trigger, `
+create trigger %[1]s_sync_%[2]s after %[3]s on %[1]s for each row
+`, item.table, name, event)
+	if when != "" {
+		fmt.Fprintf(&trigger, "    when %s\n", when)
+	}
+	fmt.Fprintf(&trigger, `begin
+    update users set revision = revision + 1 where uuid = NEW.uuid;
+    update %[1]s set revision = COALESCE((select revision from users where uuid = NEW.uuid), 0) where %[2]s = NEW.%[2]s;
+`, item.table, item.id)
+	if events {
+		fmt.Fprintf(&trigger, `    select vault_event(json_object('user', uuid, 'type', '%s', 'action', %s, 'id', %s || '',
+        'revision', revision)) from %s where %[3]s = NEW.%[3]s;
+`, item.kind, action, item.id, item.table)
+	}
+	if events && readers && item.kind == EventEntry {
+		trigger.WriteString(sqliteReaderEvents(action, "NEW"))
+	}
+	trigger.WriteString("end;\n")
+	return trigger.String()
+}
+
+// dropSQLiteRevisionTriggers drops the triggers of sqliteRevisionTriggers
+func dropSQLiteRevisionTriggers() string {
+	var triggers strings.Builder
+	for _, item := range sqliteItems {
+		for _, name := range []string{"created", "updated", "deletion"} {
+			fmt.Fprintf(&triggers, "drop trigger if exists %s_sync_%s;\n", item.table, name)
+		}
+	}
+	for _, table := range []string{"passwd_tags", "passwd_uris"} {
+		for _, event := range sqliteEntryChildren[table] {
+			fmt.Fprintf(&triggers, "drop trigger if exists %s_sync_%s;\n", table, event)
+		}
+	}
+	return triggers.String()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"time"
+)
+
+// SQLiteStorage keeps the data of a single server in one SQLite file. The queries of Storage which are written
+// in the dialect of Postgres are replaced by their SQLite variants here, the others are shared.
+type SQLiteStorage struct {
+	Storage
+}
+
+func (s *SQLiteStorage) UpdateOrCreateSessionKeyForUser(user *User, b []byte) error {
+	return UpdateOrCreateSQLiteSessionKeyForUser(s.database, user, b)
+}
+
+/*
+Password operations
+*/
+func (s *SQLiteStorage) CreatePassword(u *User, st string, p *Password) error {
+	return CreateSQLitePassword(s.database, u, p, s.cipher)
+}
+
+func (s *SQLiteStorage) GetPassword(user *User, url string, username string) (*Password, error) {
+	password, err := QuerySQLitePassword(s.database, user, url, username)
+	if err != nil {
+		return password, err
+	}
+	return password, s.cipher.openPasswords([]*Password{password})
+}
+
+func (s *SQLiteStorage) UpdatePassword(u *User, st string, p *Password) error {
+	return UpdateSQLitePassword(s.database, u, p, s.cipher)
+}
+
+func (s *SQLiteStorage) DeletePassword(user *User, url string, username string) error {
+	return DeleteSQLitePassword(s.database, url, username, string(user.Uuid))
+}
+
+func (s *SQLiteStorage) GetPasswords(u *User, filter PasswordFilter) ([]*Password, error) {
+	passwords, err := QueryAllSQLitePasswords(s.database, u, filter)
+	if err != nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *SQLiteStorage) GetPasswordCandidates(user *User, domains []string) ([]*Password, error) {
+	passwords, err := QuerySQLitePasswordCandidates(s.database, user, domains)
+	if err != nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *SQLiteStorage) SetPasswordFolder(user *User, id string, folder string) error {
+	return UpdateSQLitePasswordFolder(s.database, user, id, folder)
+}
+
+func (s *SQLiteStorage) ApplyPasswordBatch(user *User, operations []*BatchOperation) ([]*BatchResult, error) {
+	return ApplySQLitePasswordBatch(s.database, user, operations, s.cipher)
+}
+
+/*
+Folder operations
+*/
+func (s *SQLiteStorage) GetFolders(user *User) ([]*Folder, error) {
+	folders, err := QuerySQLiteFolders(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if folders == nil {
+		return make([]*Folder, 0), nil
+	}
+	return folders, nil
+}
+
+func (s *SQLiteStorage) CreateFolder(user *User, folder *Folder) error {
+	return CreateSQLiteFolder(s.database, user, folder)
+}
+
+func (s *SQLiteStorage) UpdateFolder(user *User, folder *Folder) error {
+	return UpdateSQLiteFolder(s.database, user, folder)
+}
+
+/*
+Usage operations
+*/
+func (s *SQLiteStorage) RecordPasswordUsage(user *User, id string) error {
+	return RecordSQLitePasswordUsage(s.database, user, id)
+}
+
+func (s *SQLiteStorage) DeletePasswordUsage(user *User, id string) error {
+	return DeleteSQLitePasswordUsage(s.database, user, id)
+}
+
+/*
+Trash operations
+*/
+func (s *SQLiteStorage) GetTrash(user *User) ([]*Password, error) {
+	passwords, err := QuerySQLiteTrash(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+/*
+Expiry operations
+*/
+func (s *SQLiteStorage) RecordPasswordRotation(user *User, id string) error {
+	return RecordSQLitePasswordRotation(s.database, user, id)
+}
+
+func (s *SQLiteStorage) GetDuePasswords(user *User, before time.Time) ([]*Password, error) {
+	passwords, err := QuerySQLiteDuePasswords(s.database, user, before)
+	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *SQLiteStorage) GetUsersToRemind(before time.Time) ([]*User, error) {
+	users, err := QuerySQLiteUsersToRemind(s.database, before)
+	if err != nil {
+		return users, err
+	}
+	for _, user := range users {
+		err = s.cipher.openUser(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+/*
+Share operations
+*/
+func (s *SQLiteStorage) SharePassword(user *User, share *Share, password string, ownerKey string) error {
+	password, err := s.cipher.Seal(columnSharedPassword, share.Entry, string(user.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return CreateSQLiteShare(s.database, user, share, password, ownerKey)
+}
+
+func (s *SQLiteStorage) RevokeShare(user *User, entry string, username string) error {
+	return DeleteSQLiteShare(s.database, user, entry, username)
+}
+
+func (s *SQLiteStorage) SetSharedPassword(user *User, owner *User, id string, password string) error {
+	password, err := s.cipher.Seal(columnSharedPassword, id, string(owner.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return UpdateSQLiteSharedPassword(s.database, user, id, password)
+}
+
+/*
+Organization operations
+*/
+func (s *SQLiteStorage) SaveMember(member *Membership) error {
+	return SaveSQLiteMember(s.database, member)
+}
+
+func (s *SQLiteStorage) UpdateMember(member *Membership) error {
+	return UpdateSQLiteMember(s.database, member)
+}
+
+func (s *SQLiteStorage) DeleteMember(org string, username string) error {
+	return DeleteSQLiteMember(s.database, org, username)
+}
+
+func (s *SQLiteStorage) DeleteCollection(id string) error {
+	return DeleteSQLiteCollection(s.database, id)
+}
+
+func (s *SQLiteStorage) SaveCollectionGrant(grant *CollectionGrant) error {
+	return SaveSQLiteCollectionGrant(s.database, grant)
+}
+
+func (s *SQLiteStorage) DeleteCollectionGrant(collection string, username string) error {
+	return DeleteSQLiteCollectionGrant(s.database, collection, username)
+}
+
+func (s *SQLiteStorage) GetEntryAccess(user *User, id string) (*EntryAccess, error) {
+	return QuerySQLiteEntryAccess(s.database, user, id)
+}
+
+func (s *SQLiteStorage) SetPasswordCollection(owner *User, id string, collection string, password string) error {
+	password, err := s.cipher.Seal(columnPassword, id, string(owner.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return UpdateSQLitePasswordCollection(s.database, owner, id, collection, password)
+}
+
+/*
+Emergency access operations
+*/
+func (s *SQLiteStorage) GetEmergencyContacts(user *User) ([]*EmergencyContact, error) {
+	contacts, err := QuerySQLiteEmergencyContacts(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if contacts == nil {
+		return make([]*EmergencyContact, 0), nil
+	}
+	return contacts, nil
+}
+
+func (s *SQLiteStorage) GetEmergencyContact(user *User, id string) (*EmergencyContact, error) {
+	return QuerySQLiteEmergencyContact(s.database, user, id)
+}
+
+func (s *SQLiteStorage) UpdateEmergencyContact(user *User, contact *EmergencyContact) error {
+	return UpdateSQLiteEmergencyContact(s.database, user, contact)
+}
+
+func (s *SQLiteStorage) DeleteEmergencyContact(user *User, id string) error {
+	return DeleteSQLiteEmergencyContact(s.database, user, id)
+}
+
+func (s *SQLiteStorage) AcceptEmergencyContact(user *User, id string) error {
+	return AcceptSQLiteEmergencyContact(s.database, user, id)
+}
+
+func (s *SQLiteStorage) RequestEmergencyAccess(user *User, id string) error {
+	return RequestSQLiteEmergencyAccess(s.database, user, id)
+}
+
+func (s *SQLiteStorage) ApproveEmergencyAccess(user *User, id string) error {
+	return ApproveSQLiteEmergencyAccess(s.database, user, id)
+}
+
+func (s *SQLiteStorage) RejectEmergencyAccess(user *User, id string) error {
+	return RejectSQLiteEmergencyAccess(s.database, user, id)
+}
+
+func (s *SQLiteStorage) GrantDueEmergencyAccess() ([]*EmergencyContact, error) {
+	return GrantDueSQLiteEmergencyAccess(s.database)
+}
+
+func (s *SQLiteStorage) GetEmergencyPasswords(user *User, id string) ([]*Password, error) {
+	passwords, err := QuerySQLiteEmergencyPasswords(s.database, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+/*
+Send operations
+*/
+func (s *SQLiteStorage) GetSends(user *User) ([]*Send, error) {
+	sends, err := QuerySQLiteSends(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if sends == nil {
+		return make([]*Send, 0), nil
+	}
+	return sends, nil
+}
+
+func (s *SQLiteStorage) GetSendProtection(id string) (*Send, error) {
+	return QuerySQLiteSendProtection(s.database, id)
+}
+
+func (s *SQLiteStorage) CountSendAttempt(id string) error {
+	return CountSQLiteSendAttempt(s.database, id)
+}
+
+func (s *SQLiteStorage) ConsumeSend(id string) (*Send, error) {
+	return ConsumeSQLiteSend(s.database, id)
+}
+
+func (s *SQLiteStorage) PurgeSends() (int64, error) {
+	return PurgeSQLiteSends(s.database)
+}
+
+/*
+Sync operations
+*/
+func (s *SQLiteStorage) GetSyncChanges(user *User, since int64) (*SyncChanges, error) {
+	return s.getSyncChanges(user, since, QuerySQLiteSyncChanges)
+}
+
+func (s *SQLiteStorage) PruneSyncDeletions(before time.Time) (int64, error) {
+	return PruneSQLiteSyncDeletions(s.database, before)
+}
+
+/*
+Health operations
+*/
+func (s *SQLiteStorage) SetPasswordHealth(user *User, health []*EntryHealth) error {
+	return UpdateSQLitePasswordHealth(s.database, user, health)
+}
+
+func (s *SQLiteStorage) GetHealthRecords(user *User) ([]*HealthRecord, error) {
+	records, err := QuerySQLiteHealthRecords(s.database, user)
+	if records == nil {
+		records = make([]*HealthRecord, 0)
+	}
+	return records, err
+}
+
+/*
+Breach operations
+*/
+func (s *SQLiteStorage) GetBreaches(user *User) ([]*Breach, error) {
+	breaches, err := QuerySQLiteBreaches(s.database, user)
+	if breaches == nil {
+		breaches = make([]*Breach, 0)
+	}
+	return breaches, err
+}
+
+/*
+Attachment operations
+*/
+func (s *SQLiteStorage) CreateAttachment(user *User, attachment *Attachment, quota int64) error {
+	return CreateSQLiteAttachment(s.database, user, attachment, quota)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	if len(sqliteMigrations) != len(schemaMigrations) {
+		t.Fatalf("there are %d SQLite migrations and %d Postgres migrations", len(sqliteMigrations), len(schemaMigrations))
+	}
+	for i, migration := range sqliteMigrations {
+		if migration.Version != schemaMigrations[i].Version || migration.Name != schemaMigrations[i].Name {
+			t.Errorf("SQLite migration %d %s does not match %d %s", migration.Version, migration.Name,
+				schemaMigrations[i].Version, schemaMigrations[i].Name)
+		}
+	}
+
+	db, remove := openTestSQLite(t)
+	defer remove()
+	latest := sqliteMigrations[len(sqliteMigrations)-1].Version
+	applied, err := MigrateDatabase(db, sqliteMigrations, latest)
+	if err != nil || len(applied) != len(sqliteMigrations) {
+		t.Fatalf("unexpected migrations applied %d (%v)", len(applied), err)
+	}
+	reverted, err := RollbackDatabase(db, sqliteMigrations, 0)
+	if err != nil || len(reverted) != len(sqliteMigrations) {
+		t.Fatalf("unexpected migrations reverted %d (%v)", len(reverted), err)
+	}
+	var tables int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name <> 'schema_migrations' AND name NOT LIKE 'sqlite_%'").Scan(&tables)
+	if err != nil || tables != 0 {
+		t.Errorf("the rollback left %d tables, triggers and indexes (%v)", tables, err)
+	}
+	applied, err = MigrateDatabase(db, sqliteMigrations, latest)
+	if err != nil || len(applied) != len(sqliteMigrations) {
+		t.Fatalf("unexpected migrations applied again %d (%v)", len(applied), err)
+	}
+	status, err := QuerySchemaStatus(db, sqliteMigrations)
+	if err != nil || status.Current != latest {
+		t.Errorf("unexpected schema status %+v (%v)", status, err)
+	}
+}
+
+func TestSQLiteParameters(t *testing.T) {
+	db, remove := openTestSQLite(t)
+	defer remove()
+	// the parameters are bound by their number even if they are not used in order
+	var first, second string
+	err := db.QueryRow("SELECT $2, $1 || $2", "one", "two").Scan(&second, &first)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when querying", err)
+	}
+	if second != "two" || first != "onetwo" {
+		t.Errorf("unexpected values %s %s", second, first)
+	}
+}
+
+// nextSQLiteVaultEvent waits for the next event committed to the SQLite database
+func nextSQLiteVaultEvent(t *testing.T) string {
+	select {
+	case payload := <-sqliteVaultEvents:
+		return payload
+	case <-time.After(time.Second):
+		t.Fatalf("no event was committed")
+	}
+	return ""
+}
+
+func TestSQLiteVaultEvents(t *testing.T) {
+	db, remove := openTestSQLite(t)
+	defer remove()
+	_, err := MigrateDatabase(db, sqliteMigrations, sqliteMigrations[len(sqliteMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	storage := &SQLiteStorage{Storage{database: db}}
+	user := newConformanceUser(t, storage, "")
+	// other tests may have left events behind
+	for len(sqliteVaultEvents) > 0 {
+		<-sqliteVaultEvents
+	}
+
+	hub := NewEventHub()
+	events := hub.Subscribe(string(user.Uuid))
+	defer hub.Unsubscribe(string(user.Uuid), events)
+	tag := &Tag{Name: "private"}
+	err = storage.CreateTag(user, tag)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a tag", err)
+	}
+	err = storage.DeleteTag(user, tag.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting a tag", err)
+	}
+	for _, action := range []string{"created", "deleted"} {
+		err = hub.dispatch(nextSQLiteVaultEvent(t))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when dispatching", err)
+		}
+		event := <-events
+		if event.Type != EventTag || event.Action != action || event.Id != tag.Id || event.Revision == 0 {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+
+	// the events of a rolled back transaction are discarded
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when beginning a transaction", err)
+	}
+	_, err = tx.Exec("INSERT INTO tags (uuid, name) VALUES ($1, $2)", user.Uuid, "discarded")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a tag", err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when rolling back", err)
+	}
+	if len(sqliteVaultEvents) != 0 {
+		t.Errorf("the event of a rolled back transaction was committed: %s", <-sqliteVaultEvents)
+	}
+}
+
+func TestSQLiteRewrapKeepsRevision(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+	db, remove := openTestSQLite(t)
+	defer remove()
+	_, err = MigrateDatabase(db, sqliteMigrations, sqliteMigrations[len(sqliteMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	storage := &SQLiteStorage{Storage{database: db}}
+	user := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	revision := func() int64 {
+		var revision int64
+		err := db.QueryRow("SELECT revision FROM passwds WHERE entryid = $1", entry.Id).Scan(&revision)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when reading the revision", err)
+		}
+		return revision
+	}
+	before := revision()
+	for len(sqliteVaultEvents) > 0 {
+		<-sqliteVaultEvents
+	}
+
+	// the password is encrypted, but it is the same password
+	results, err := RewrapColumns(db, newTestDataCipher(t, dir, "first"))
+	if err != nil || results[0].Rewrapped != 1 {
+		t.Fatalf("unexpected re-wrap %+v (%v)", results[0], err)
+	}
+	if after := revision(); after != before {
+		t.Errorf("the re-wrap changed the revision from %d to %d", before, after)
+	}
+	if len(sqliteVaultEvents) != 0 {
+		t.Errorf("the re-wrap published an event: %s", <-sqliteVaultEvents)
+	}
+
+	// the setting ends with the transactions of the re-wrap
+	_, err = db.Exec("UPDATE passwds SET favorite = true WHERE entryid = $1", entry.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the entry", err)
+	}
+	if after := revision(); after <= before {
+		t.Errorf("the change did not advance the revision %d", after)
+	}
+}
+
+// committedSQLiteVaultEvents returns the users and actions of the events committed so far
+func committedSQLiteVaultEvents(t *testing.T) map[string]string {
+	users := make(map[string]string)
+	for len(sqliteVaultEvents) > 0 {
+		var notification vaultNotification
+		err := json.Unmarshal([]byte(<-sqliteVaultEvents), &notification)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when reading the event", err)
+		}
+		users[notification.User] = notification.Action
+	}
+	return users
+}
+
+func TestSQLiteVaultEventsForReaders(t *testing.T) {
+	db, remove := openTestSQLite(t)
+	defer remove()
+	_, err := MigrateDatabase(db, sqliteMigrations, sqliteMigrations[len(sqliteMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	storage := &SQLiteStorage{Storage{database: db}}
+	owner := newConformanceUser(t, storage, "")
+	recipient := newConformanceUser(t, storage, "")
+	member := newConformanceUser(t, storage, "")
+	shared := newConformancePassword(t, storage, owner, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	kept := newConformancePassword(t, storage, owner, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	err = storage.SharePassword(owner, &Share{Entry: shared.Id, Username: recipient.Name, Permission: PermissionRead,
+		WrappedKey: "wrapped"}, "encrypted", "owner-key")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sharing the entry", err)
+	}
+	org := &Organization{Name: "Example", OrgKey: "owner-key"}
+	err = storage.CreateOrganization(owner, org)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the organization", err)
+	}
+	err = storage.SaveMember(&Membership{Organization: org.Id, Username: member.Name, Role: RoleMember, OrgKey: "member-key"})
+	if err == nil {
+		err = storage.AcceptMembership(member, org.Id)
+	}
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when adding the member", err)
+	}
+	collection := &Collection{Organization: org.Id, Name: "Servers"}
+	err = storage.CreateCollection(collection)
+	if err == nil {
+		err = storage.SaveCollectionGrant(&CollectionGrant{Collection: collection.Id, Username: member.Name, Permission: PermissionRead})
+	}
+	if err == nil {
+		err = storage.SetPasswordCollection(owner, kept.Id, collection.Id, "collection-password")
+	}
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry into a collection", err)
+	}
+	committedSQLiteVaultEvents(t)
+
+	// the recipient of the share and the member of the collection see the changes of the entries
+	for _, change := range []struct {
+		entry   *Password
+		readers []*User
+		others  []*User
+	}{
+		{shared, []*User{owner, recipient}, []*User{member}},
+		{kept, []*User{owner, member}, []*User{recipient}},
+	} {
+		err = storage.SetPasswordFavorite(owner, change.entry.Id, true)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when updating the entry", err)
+		}
+		users := committedSQLiteVaultEvents(t)
+		for _, reader := range change.readers {
+			if users[string(reader.Uuid)] != "updated" {
+				t.Errorf("the change of entry %s was not published to %s: %v", change.entry.Id, reader.Name, users)
+			}
+		}
+		for _, other := range change.others {
+			if _, ok := users[string(other.Uuid)]; ok {
+				t.Errorf("the change of entry %s was published to %s", change.entry.Id, other.Name)
+			}
+		}
+	}
+
+	// the recipient learns about the deletion before the share is deleted along with the entry
+	_, err = db.Exec("DELETE FROM passwds WHERE entryid = $1", shared.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the entry", err)
+	}
+	users := committedSQLiteVaultEvents(t)
+	if users[string(owner.Uuid)] != "deleted" || users[string(recipient.Uuid)] != "deleted" || len(users) != 2 {
+		t.Errorf("unexpected events of the deletion %v", users)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"github.com/keycloud/webauthn/webauthn"
+	"time"
 )
 
 type Storage struct {
 	database *sql.DB
+	// cipher encrypts the passwords and master passwords at rest, nil keeps them unencrypted
+	cipher *DataCipher
 }
 
 /*
@@ -65,21 +69,61 @@ func (s *Storage) DeleteSessionKeyForUser(user *User) error {
 	User operations
 */
 func (s *Storage) GetUser(ID string) (*User, error) {
-	return QueryUser(s.database, ID)
+	user, err := QueryUser(s.database, ID)
+	if err != nil {
+		return user, err
+	}
+	return user, s.cipher.openUser(user)
 }
 func (s *Storage) GetUserByName(name string) (*User, error) {
-	return QueryUserByName(s.database, name)
+	user, err := QueryUserByName(s.database, name)
+	if err != nil {
+		return user, err
+	}
+	return user, s.cipher.openUser(user)
 }
 
 func (s *Storage) CreateUser(u *User) error {
+	// the sealed master password is bound to the uuid of the user
+	if len(u.Uuid) == 0 {
+		u.Uuid = []byte(newUUID())
+	}
+	masterPassword, err := s.cipher.sealMasterPassword(u)
+	if err != nil {
+		return err
+	}
+	defer func() { u.MasterPassword = masterPassword }()
 	return CreateUser(s.database, u)
 }
 
 func (s *Storage) RemoveUser(u *User) error {
-	return RemoveUser(s.database, u)
+	if s.cipher == nil {
+		return RemoveUser(s.database, u)
+	}
+	// sealed master passwords differ on every write, they are compared after decryption
+	stored, err := QueryUser(s.database, string(u.Uuid))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	masterPassword, err := s.cipher.Open(columnMasterPassword, string(stored.Uuid), string(stored.Uuid), string(stored.MasterPassword))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(masterPassword), u.MasterPassword) != 1 {
+		return nil
+	}
+	return RemoveUser(s.database, stored)
 }
 
 func (s *Storage) UpdateUser(u *User) error {
+	masterPassword, err := s.cipher.sealMasterPassword(u)
+	if err != nil {
+		return err
+	}
+	defer func() { u.MasterPassword = masterPassword }()
 	return UpdateUser(s.database, u)
 }
 
@@ -87,33 +131,614 @@ func (s *Storage) UpdateUser(u *User) error {
 	Password operations
 */
 func (s *Storage) CreatePassword(u *User, st string, p *Password) error {
-	return CreatePassword(s.database, u, p)
+	return CreatePassword(s.database, u, p, s.cipher)
 }
 
 func (s *Storage) GetPassword(user *User, url string, username string) (*Password, error) {
-	return QueryPassword(s.database, user, url, username)
+	password, err := QueryPassword(s.database, user, url, username)
+	if err != nil {
+		return password, err
+	}
+	return password, s.cipher.openPasswords([]*Password{password})
 }
 
 func (s *Storage) UpdatePassword(u *User, st string, p *Password) error {
-	return UpdatePassword(s.database, u, p)
+	return UpdatePassword(s.database, u, p, s.cipher)
 }
 
 func (s *Storage) DeletePassword(user *User, url string, username string) error {
 	return DeletePassword(s.database, url, username, string(user.Uuid))
 }
 
-func (s *Storage) GetPasswords(u *User) ([]*Password, error) {
-	passwords, err := QueryAllPasswords(s.database, u)
+func (s *Storage) GetPasswords(u *User, filter PasswordFilter) ([]*Password, error) {
+	passwords, err := QueryAllPasswords(s.database, u, filter)
+	if err != nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *Storage) GetPasswordCandidates(user *User, domains []string) ([]*Password, error) {
+	passwords, err := QueryPasswordCandidates(s.database, user, domains)
 	if err != nil {
 		return make([]*Password, 0), nil
 	}
-	return passwords, nil
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *Storage) SetPasswordFolder(user *User, id string, folder string) error {
+	return UpdatePasswordFolder(s.database, user, id, folder)
+}
+
+func (s *Storage) SetPasswordTags(user *User, id string, tags []string) error {
+	return UpdatePasswordTags(s.database, user, id, tags)
+}
+
+/*
+	Folder operations
+*/
+func (s *Storage) GetFolders(user *User) ([]*Folder, error) {
+	folders, err := QueryFolders(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if folders == nil {
+		return make([]*Folder, 0), nil
+	}
+	return folders, nil
+}
+
+func (s *Storage) CreateFolder(user *User, folder *Folder) error {
+	return CreateFolder(s.database, user, folder)
+}
+
+func (s *Storage) UpdateFolder(user *User, folder *Folder) error {
+	return UpdateFolder(s.database, user, folder)
+}
+
+func (s *Storage) DeleteFolder(user *User, id string) error {
+	return DeleteFolder(s.database, user, id)
+}
+
+/*
+	Tag operations
+*/
+func (s *Storage) GetTags(user *User) ([]*Tag, error) {
+	tags, err := QueryTags(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		return make([]*Tag, 0), nil
+	}
+	return tags, nil
+}
+
+func (s *Storage) CreateTag(user *User, tag *Tag) error {
+	return CreateTag(s.database, user, tag)
+}
+
+func (s *Storage) UpdateTag(user *User, tag *Tag) error {
+	return UpdateTag(s.database, user, tag)
+}
+
+func (s *Storage) DeleteTag(user *User, id string) error {
+	return DeleteTag(s.database, user, id)
+}
+
+func (s *Storage) SetPasswordUris(user *User, id string, match string, uris []PasswordUri) error {
+	return UpdatePasswordUris(s.database, user, id, match, uris)
+}
+
+/*
+	Equivalent domain operations
+*/
+func (s *Storage) GetEquivalentDomains(user *User) ([]*EquivalentDomains, error) {
+	groups, err := QueryEquivalentDomains(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if groups == nil {
+		return make([]*EquivalentDomains, 0), nil
+	}
+	return groups, nil
+}
+
+func (s *Storage) CreateEquivalentDomains(user *User, group *EquivalentDomains) error {
+	return CreateEquivalentDomains(s.database, user, group)
+}
+
+func (s *Storage) UpdateEquivalentDomains(user *User, group *EquivalentDomains) error {
+	return UpdateEquivalentDomains(s.database, user, group)
+}
+
+func (s *Storage) DeleteEquivalentDomains(user *User, id string) error {
+	return DeleteEquivalentDomains(s.database, user, id)
+}
+
+func (s *Storage) SetPasswordFavorite(user *User, id string, favorite bool) error {
+	return UpdatePasswordFavorite(s.database, user, id, favorite)
+}
+
+func (s *Storage) ApplyPasswordBatch(user *User, operations []*BatchOperation) ([]*BatchResult, error) {
+	return ApplyPasswordBatch(s.database, user, operations, s.cipher)
+}
+
+/*
+	Usage operations
+*/
+func (s *Storage) RecordPasswordUsage(user *User, id string) error {
+	return RecordPasswordUsage(s.database, user, id)
+}
+
+func (s *Storage) DeletePasswordUsage(user *User, id string) error {
+	return DeletePasswordUsage(s.database, user, id)
+}
+
+/*
+	Trash operations
+*/
+func (s *Storage) GetTrash(user *User) ([]*Password, error) {
+	passwords, err := QueryTrash(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *Storage) RestorePassword(user *User, id string) error {
+	return RestorePassword(s.database, user, id)
+}
+
+func (s *Storage) EmptyTrash(user *User) error {
+	return EmptyTrash(s.database, user)
+}
+
+func (s *Storage) PurgeTrash(deletedBefore time.Time) (int64, error) {
+	return PurgeTrash(s.database, deletedBefore)
+}
+
+/*
+	Expiry operations
+*/
+func (s *Storage) SetPasswordExpiry(user *User, id string, expires *time.Time, rotationDays int) error {
+	return UpdatePasswordExpiry(s.database, user, id, expires, rotationDays)
+}
+
+func (s *Storage) RecordPasswordRotation(user *User, id string) error {
+	return RecordPasswordRotation(s.database, user, id)
+}
+
+func (s *Storage) GetDuePasswords(user *User, before time.Time) ([]*Password, error) {
+	passwords, err := QueryDuePasswords(s.database, user, before)
+	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
+		return make([]*Password, 0), nil
+	}
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+func (s *Storage) GetUsersToRemind(before time.Time) ([]*User, error) {
+	users, err := QueryUsersToRemind(s.database, before)
+	if err != nil {
+		return users, err
+	}
+	for _, user := range users {
+		err = s.cipher.openUser(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+func (s *Storage) SetUserReminded(user *User) error {
+	return UpdateUserReminded(s.database, user)
+}
+
+/*
+	Sharing operations
+*/
+func (s *Storage) SetUserKeys(user *User, keys *UserKeys) error {
+	return UpdateUserKeys(s.database, user, keys)
+}
+
+func (s *Storage) GetUserKeys(user *User) (*UserKeys, error) {
+	return QueryUserKeys(s.database, user)
+}
+
+func (s *Storage) GetPublicKey(username string) (string, error) {
+	return QueryPublicKey(s.database, username)
+}
+
+func (s *Storage) SharePassword(user *User, share *Share, password string, ownerKey string) error {
+	password, err := s.cipher.Seal(columnSharedPassword, share.Entry, string(user.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return CreateShare(s.database, user, share, password, ownerKey)
+}
+
+func (s *Storage) GetShares(user *User, entry string) ([]*Share, error) {
+	shares, err := QueryShares(s.database, user, entry)
+	if err != nil {
+		return nil, err
+	}
+	if shares == nil {
+		return make([]*Share, 0), nil
+	}
+	return shares, nil
+}
+
+func (s *Storage) RevokeShare(user *User, entry string, username string) error {
+	return DeleteShare(s.database, user, entry, username)
+}
+
+func (s *Storage) SetSharedPassword(user *User, owner *User, id string, password string) error {
+	password, err := s.cipher.Seal(columnSharedPassword, id, string(owner.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return UpdateSharedPassword(s.database, user, id, password)
+}
+
+/*
+	Organization operations
+*/
+func (s *Storage) GetOrganizations(user *User) ([]*Organization, error) {
+	orgs, err := QueryOrganizations(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if orgs == nil {
+		return make([]*Organization, 0), nil
+	}
+	return orgs, nil
+}
+
+func (s *Storage) CreateOrganization(user *User, org *Organization) error {
+	return CreateOrganization(s.database, user, org)
+}
+
+func (s *Storage) DeleteOrganization(id string) error {
+	return DeleteOrganization(s.database, id)
+}
+
+func (s *Storage) GetMembership(user *User, org string) (*Membership, error) {
+	return QueryMembership(s.database, user, org)
+}
+
+func (s *Storage) GetMembers(org string) ([]*Membership, error) {
+	members, err := QueryMembers(s.database, org)
+	if err != nil {
+		return nil, err
+	}
+	if members == nil {
+		return make([]*Membership, 0), nil
+	}
+	return members, nil
+}
+
+func (s *Storage) SaveMember(member *Membership) error {
+	return SaveMember(s.database, member)
+}
+
+func (s *Storage) CountCollectionEntries(user *User) (int, error) {
+	return CountCollectionEntries(s.database, user)
+}
+
+func (s *Storage) UpdateMember(member *Membership) error {
+	return UpdateMember(s.database, member)
+}
+
+func (s *Storage) AcceptMembership(user *User, org string) error {
+	return AcceptMembership(s.database, user, org)
+}
+
+func (s *Storage) DeleteMember(org string, username string) error {
+	return DeleteMember(s.database, org, username)
+}
+
+func (s *Storage) GetCollections(user *User, org string) ([]*Collection, error) {
+	collections, err := QueryCollections(s.database, user, org)
+	if err != nil {
+		return nil, err
+	}
+	if collections == nil {
+		return make([]*Collection, 0), nil
+	}
+	return collections, nil
+}
+
+func (s *Storage) GetCollection(id string) (*Collection, error) {
+	return QueryCollection(s.database, id)
+}
+
+func (s *Storage) CreateCollection(collection *Collection) error {
+	return CreateCollection(s.database, collection)
+}
+
+func (s *Storage) UpdateCollection(collection *Collection) error {
+	return UpdateCollection(s.database, collection)
+}
+
+func (s *Storage) DeleteCollection(id string) error {
+	return DeleteCollection(s.database, id)
+}
+
+func (s *Storage) GetCollectionGrants(collection string) ([]*CollectionGrant, error) {
+	grants, err := QueryCollectionGrants(s.database, collection)
+	if err != nil {
+		return nil, err
+	}
+	if grants == nil {
+		return make([]*CollectionGrant, 0), nil
+	}
+	return grants, nil
+}
+
+func (s *Storage) SaveCollectionGrant(grant *CollectionGrant) error {
+	return SaveCollectionGrant(s.database, grant)
+}
+
+func (s *Storage) DeleteCollectionGrant(collection string, username string) error {
+	return DeleteCollectionGrant(s.database, collection, username)
+}
+
+func (s *Storage) GetEntryAccess(user *User, id string) (*EntryAccess, error) {
+	return QueryEntryAccess(s.database, user, id)
+}
+
+func (s *Storage) SetPasswordCollection(owner *User, id string, collection string, password string) error {
+	password, err := s.cipher.Seal(columnPassword, id, string(owner.Uuid), password)
+	if err != nil {
+		return err
+	}
+	return UpdatePasswordCollection(s.database, owner, id, collection, password)
+}
+
+/*
+	Emergency access operations
+*/
+func (s *Storage) GetEmergencyContacts(user *User) ([]*EmergencyContact, error) {
+	contacts, err := QueryEmergencyContacts(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if contacts == nil {
+		return make([]*EmergencyContact, 0), nil
+	}
+	return contacts, nil
+}
+
+func (s *Storage) GetEmergencyContact(user *User, id string) (*EmergencyContact, error) {
+	return QueryEmergencyContact(s.database, user, id)
+}
+
+func (s *Storage) CreateEmergencyContact(user *User, contact *EmergencyContact) error {
+	return CreateEmergencyContact(s.database, user, contact)
+}
+
+func (s *Storage) UpdateEmergencyContact(user *User, contact *EmergencyContact) error {
+	return UpdateEmergencyContact(s.database, user, contact)
+}
+
+func (s *Storage) DeleteEmergencyContact(user *User, id string) error {
+	return DeleteEmergencyContact(s.database, user, id)
+}
+
+func (s *Storage) AcceptEmergencyContact(user *User, id string) error {
+	return AcceptEmergencyContact(s.database, user, id)
+}
+
+func (s *Storage) RequestEmergencyAccess(user *User, id string) error {
+	return RequestEmergencyAccess(s.database, user, id)
+}
+
+func (s *Storage) ApproveEmergencyAccess(user *User, id string) error {
+	return ApproveEmergencyAccess(s.database, user, id)
+}
+
+func (s *Storage) RejectEmergencyAccess(user *User, id string) error {
+	return RejectEmergencyAccess(s.database, user, id)
 }
 
-func (s *Storage) GetPasswordByUrl(user *User, url string) ([]*Password, error) {
-	passwords, err := QueryPasswordByUrl(s.database, user, url)
+func (s *Storage) GrantDueEmergencyAccess() ([]*EmergencyContact, error) {
+	return GrantDueEmergencyAccess(s.database)
+}
+
+func (s *Storage) GetEmergencyPasswords(user *User, id string) ([]*Password, error) {
+	passwords, err := QueryEmergencyPasswords(s.database, user, id)
 	if err != nil {
+		return nil, err
+	}
+	if passwords == nil {
 		return make([]*Password, 0), nil
 	}
-	return passwords, nil
+	return passwords, s.cipher.openPasswords(passwords)
+}
+
+/*
+	Send operations
+*/
+func (s *Storage) GetSends(user *User) ([]*Send, error) {
+	sends, err := QuerySends(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if sends == nil {
+		return make([]*Send, 0), nil
+	}
+	return sends, nil
+}
+
+func (s *Storage) CreateSend(user *User, send *Send) error {
+	return CreateSend(s.database, user, send)
+}
+
+func (s *Storage) DeleteSend(user *User, id string) error {
+	return DeleteSend(s.database, user, id)
+}
+
+func (s *Storage) GetSendProtection(id string) (*Send, error) {
+	return QuerySendProtection(s.database, id)
+}
+
+func (s *Storage) CountSendAttempt(id string) error {
+	return CountSendAttempt(s.database, id)
+}
+
+func (s *Storage) ConsumeSend(id string) (*Send, error) {
+	return ConsumeSend(s.database, id)
+}
+
+func (s *Storage) PurgeSends() (int64, error) {
+	return PurgeSends(s.database)
+}
+
+/*
+	Sync operations
+*/
+func (s *Storage) GetSyncChanges(user *User, since int64) (*SyncChanges, error) {
+	return s.getSyncChanges(user, since, QuerySyncChanges)
+}
+
+// getSyncChanges reads the changes with query and opens their passwords
+func (s *Storage) getSyncChanges(user *User, since int64,
+	query func(db *sql.DB, user *User, since int64) (*SyncChanges, error)) (*SyncChanges, error) {
+	changes, err := query(s.database, user, since)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Passwords == nil {
+		changes.Passwords = make([]*Password, 0)
+	}
+	if changes.Folders == nil {
+		changes.Folders = make([]*Folder, 0)
+	}
+	if changes.Tags == nil {
+		changes.Tags = make([]*Tag, 0)
+	}
+	if changes.Deleted == nil {
+		changes.Deleted = make([]*SyncDeletion, 0)
+	}
+	return changes, s.cipher.openPasswords(changes.Passwords)
+}
+
+func (s *Storage) PruneSyncDeletions(before time.Time) (int64, error) {
+	return PruneSyncDeletions(s.database, before)
+}
+
+/*
+	Health operations
+*/
+func (s *Storage) SetPasswordHealth(user *User, health []*EntryHealth) error {
+	return UpdatePasswordHealth(s.database, user, health)
+}
+
+func (s *Storage) GetHealthRecords(user *User) ([]*HealthRecord, error) {
+	records, err := QueryHealthRecords(s.database, user)
+	if records == nil {
+		records = make([]*HealthRecord, 0)
+	}
+	return records, err
+}
+
+/*
+	Password rule operations
+*/
+func (s *Storage) GetPasswordRules(user *User) ([]*PasswordRule, error) {
+	rules, err := QueryPasswordRules(s.database, user)
+	if rules == nil {
+		rules = make([]*PasswordRule, 0)
+	}
+	return rules, err
+}
+
+func (s *Storage) SavePasswordRule(user *User, rule *PasswordRule) error {
+	return SavePasswordRule(s.database, user, rule)
+}
+
+func (s *Storage) DeletePasswordRule(user *User, domain string) error {
+	return DeletePasswordRule(s.database, user, domain)
+}
+
+/*
+	Breach operations
+*/
+func (s *Storage) GetBreaches(user *User) ([]*Breach, error) {
+	breaches, err := QueryBreaches(s.database, user)
+	if breaches == nil {
+		breaches = make([]*Breach, 0)
+	}
+	return breaches, err
+}
+
+func (s *Storage) FlagBreachedAccounts() (int64, error) {
+	return FlagBreachedAccounts(s.database)
+}
+
+/*
+	Export operations
+*/
+func (s *Storage) GetExports(user *User) ([]*ExportRecord, error) {
+	exports, err := QueryExports(s.database, user)
+	if err != nil {
+		return nil, err
+	}
+	if exports == nil {
+		return make([]*ExportRecord, 0), nil
+	}
+	return exports, nil
+}
+
+func (s *Storage) RecordExport(user *User, record *ExportRecord) error {
+	return RecordExport(s.database, user, record)
+}
+
+/*
+	Attachment operations
+*/
+func (s *Storage) GetAttachments(user *User, entry string) ([]*Attachment, error) {
+	attachments, err := QueryAttachments(s.database, user, entry)
+	if err != nil {
+		return nil, err
+	}
+	if attachments == nil {
+		return make([]*Attachment, 0), nil
+	}
+	return attachments, nil
+}
+
+func (s *Storage) GetAttachment(user *User, id string) (*Attachment, error) {
+	return QueryAttachment(s.database, user, id)
+}
+
+func (s *Storage) CreateAttachment(user *User, attachment *Attachment, quota int64) error {
+	return CreateAttachment(s.database, user, attachment, quota)
+}
+
+func (s *Storage) UpdateAttachmentSize(user *User, id string, size int64) error {
+	return UpdateAttachmentSize(s.database, user, id, size)
+}
+
+func (s *Storage) DeleteAttachment(user *User, id string) error {
+	return DeleteAttachment(s.database, user, id)
+}
+
+func (s *Storage) GetAttachmentUsage(user *User) (int64, error) {
+	return QueryAttachmentUsage(s.database, user)
+}
+
+func (s *Storage) GetAttachmentEntry(id string) (string, error) {
+	return QueryAttachmentEntry(s.database, id)
+}
+
+func (s *Storage) GetAttachmentIds() ([]string, error) {
+	return QueryAttachmentIds(s.database)
 }
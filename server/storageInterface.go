@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/keycloud/webauthn/webauthn"
+	"time"
 )
 
 type User struct {
@@ -22,10 +25,401 @@ type Authenticator struct {
 }
 
 type Password struct {
-	Password string `json:"password"`
+	Password string       `json:"password"`
+	Id       string       `json:"id"`
+	Url      string       `json:"url"`
+	Username string       `json:"username"`
+	Folder   string       `json:"folder,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+	Name     string       `json:"name,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Created  *time.Time   `json:"created,omitempty"`
+	LastUsed *time.Time   `json:"lastused,omitempty"`
+	Match    string       `json:"match,omitempty"`
+	Uris     PasswordUris `json:"uris,omitempty"`
+	Favorite bool         `json:"favorite,omitempty"`
+	UseCount int          `json:"usecount,omitempty"`
+	Deleted  *time.Time   `json:"deleted,omitempty"`
+	Expires  *time.Time   `json:"expires,omitempty"`
+	// RotationDays is the interval in which the password has to be changed, zero disables the rotation
+	RotationDays int             `json:"rotationdays,omitempty"`
+	Changed      *time.Time      `json:"changed,omitempty"`
+	Due          *time.Time      `json:"due,omitempty"`
+	Shared       *PasswordShare  `json:"shared,omitempty"`
+	Collection   string          `json:"collection,omitempty"`
+	Score        int             `json:"score,omitempty"`
+	Cursor       *PasswordCursor `json:"-"`
+	// Revision is the revision of the vault the entry was last changed at, it is the version of the entry in its ETag
+	Revision int64 `json:"revision,omitempty"`
+	// Owner is the uuid of the user owning the entry, its password is sealed for them
+	Owner string `json:"-"`
+}
+
+// dueDate is the earlier of the expiry date and the date the next rotation is due, nil if neither is set
+func (p *Password) dueDate() *time.Time {
+	due := p.Expires
+	if p.RotationDays > 0 {
+		changed := p.Created
+		if p.Changed != nil {
+			changed = p.Changed
+		}
+		if changed != nil {
+			rotation := changed.AddDate(0, 0, p.RotationDays)
+			if due == nil || rotation.Before(*due) {
+				due = &rotation
+			}
+		}
+	}
+	return due
+}
+
+// Permissions on a shared entry, the owner keeps full control
+const (
+	PermissionOwner = "owner"
+	PermissionRead  = "read"
+	PermissionEdit  = "edit"
+)
+
+// PasswordShare marks a shared entry, its password is encrypted with a key wrapped for the public key of the user
+type PasswordShare struct {
+	Owner      string `json:"owner"`
+	Permission string `json:"permission"`
+	WrappedKey string `json:"wrappedkey"`
+}
+
+// Share grants a recipient access to an entry
+type Share struct {
+	Entry      string `json:"entry"`
+	Username   string `json:"username"`
+	Permission string `json:"permission"`
+	WrappedKey string `json:"wrappedkey"`
+}
+
+// Roles of the members of an organization, ordered by their privileges
+const (
+	RoleMember  = "member"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+	RoleOwner   = "owner"
+)
+
+const (
+	MembershipInvited  = "invited"
+	MembershipAccepted = "accepted"
+)
+
+// Organization is a shared vault, Role, Status and OrgKey describe the membership of the requesting user
+type Organization struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status,omitempty"`
+	// OrgKey is the key of the organization wrapped for the public key of the member
+	OrgKey string `json:"orgkey,omitempty"`
+}
+
+type Membership struct {
+	Organization string `json:"organization"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	Status       string `json:"status"`
+	OrgKey       string `json:"orgkey,omitempty"`
+}
+
+// Collection groups the entries of an organization, Permission is the access of the requesting user
+type Collection struct {
+	Id           string `json:"id"`
+	Organization string `json:"organization"`
+	Name         string `json:"name"`
+	Permission   string `json:"permission,omitempty"`
+}
+
+type CollectionGrant struct {
+	Collection string `json:"collection"`
+	Username   string `json:"username"`
+	Permission string `json:"permission"`
+}
+
+// EntryAccess describes the relations of a user to an entry, it is evaluated by the Policy
+type EntryAccess struct {
+	Owner        []byte
+	Collection   string
+	Organization string
+	// Role in the organization of the collection, Grant on the collection and Share of the entry
+	Role  string
+	Grant string
+	Share string
+	// Emergency is the access of the user as granted emergency contact of the owner
+	Emergency string
+	// Revision is the current version of the entry
+	Revision int64
+}
+
+// Types of the payload of a send
+const (
+	SendText = "text"
+	SendFile = "file"
+)
+
+// Send is a client encrypted secret which can be read without an account until it expires or all views are used.
+// Name and Content are encrypted with a key which only the link knows, Content is never listed to the creator.
+type Send struct {
+	Id        string     `json:"id"`
+	Type      string     `json:"type"`
+	Name      string     `json:"name,omitempty"`
+	Content   string     `json:"content,omitempty"`
+	Expires   time.Time  `json:"expires"`
+	MaxViews  int        `json:"maxviews"`
+	Views     int        `json:"views"`
+	Protected bool       `json:"protected"`
+	Created   *time.Time `json:"created,omitempty"`
+	// PasswordHash is the bcrypt hash of the access password derived by the client
+	PasswordHash []byte `json:"-"`
+}
+
+// SyncChanges are the entries, folders and tags of a user changed after a revision and the ones deleted since.
+// A Full sync lists everything instead, the client replaces its cache with it.
+type SyncChanges struct {
+	Revision  int64           `json:"revision"`
+	Full      bool            `json:"full"`
+	Passwords []*Password     `json:"passwords"`
+	Folders   []*Folder       `json:"folders"`
+	Tags      []*Tag          `json:"tags"`
+	Deleted   []*SyncDeletion `json:"deleted"`
+}
+
+// SyncDeletion marks an entry, folder or tag which has been deleted permanently
+type SyncDeletion struct {
+	Type     string `json:"type"`
+	Id       string `json:"id"`
+	Revision int64  `json:"revision"`
+}
+
+// EntryHealth is reported by the client for an entry, the server never sees the password itself.
+// The Fingerprint is keyed with a secret of the user, equal fingerprints mean a reused password.
+type EntryHealth struct {
+	Id          string `json:"id"`
+	Fingerprint string `json:"fingerprint"`
+	// Strength is the score of the password from 0 (weakest) to 4
+	Strength int `json:"strength"`
+	// Identifier is the SHA-256 of the lowercased username the breach monitoring matches, empty keeps the last one
+	Identifier string `json:"identifier,omitempty"`
+}
+
+// HealthRecord is the state of a login the health report is built from
+type HealthRecord struct {
+	Id string
+	// Fingerprint is empty and Strength -1 if they were not reported since the password was last changed
+	Fingerprint string
+	Strength    int
+	Changed     time.Time
+	// Insecure is set if the url or one of the uris uses http
+	Insecure bool
+}
+
+// HealthReport lists the ids of the logins with reused, weak, old and insecure passwords.
+// The Score is the percentage of the logins without any of these issues.
+type HealthReport struct {
+	Score   int `json:"score"`
+	Entries int `json:"entries"`
+	// Days is the age after which a password counts as old
+	Days     int        `json:"days"`
+	Reused   [][]string `json:"reused"`
+	Weak     []string   `json:"weak"`
+	Old      []string   `json:"old"`
+	Insecure []string   `json:"insecure"`
+	// Unreported lists the logins the client has to report the health of
+	Unreported []string `json:"unreported"`
+}
+
+// Accounts matched by the breach monitoring
+const (
+	BreachAccountMail  = "mail"
+	BreachAccountEntry = "entry"
+)
+
+// Breach is a breach of the imported corpus, Accounts lists the accounts of the user found in it
+type Breach struct {
+	Name        string             `json:"name"`
+	Title       string             `json:"title"`
+	Domain      string             `json:"domain"`
+	Date        time.Time          `json:"date"`
+	DataClasses []string           `json:"dataclasses"`
+	Accounts    []*BreachedAccount `json:"accounts,omitempty"`
+}
+
+// BreachedAccount is the mail of the user or the username of an entry, Id is the id of the entry
+type BreachedAccount struct {
+	Account string    `json:"account"`
+	Id      string    `json:"id,omitempty"`
+	Found   time.Time `json:"found"`
+}
+
+// ExportRecord is the audit trail entry of an export
+type ExportRecord struct {
+	Id        string     `json:"id"`
+	Format    string     `json:"format"`
+	Entries   int        `json:"entries"`
+	Address   string     `json:"address"`
+	UserAgent string     `json:"useragent"`
+	Created   *time.Time `json:"created,omitempty"`
+}
+
+// Access levels of emergency contacts
+const (
+	EmergencyView     = "view"
+	EmergencyTakeover = "takeover"
+)
+
+// States of emergency contacts, access is granted after the waiting period unless the grantor rejects the request
+const (
+	EmergencyInvited   = "invited"
+	EmergencyAccepted  = "accepted"
+	EmergencyRequested = "requested"
+	EmergencyGranted   = "granted"
+)
+
+// EmergencyContact is a trusted user who may request access to the vault of the grantor.
+// WrappedKey is the vault key of the grantor wrapped for the public key of the grantee,
+// it is only listed to the grantee once access has been granted.
+type EmergencyContact struct {
+	Id         string     `json:"id"`
+	Grantor    string     `json:"grantor"`
+	Grantee    string     `json:"grantee"`
+	Access     string     `json:"access"`
+	WaitDays   int        `json:"waitdays"`
+	Status     string     `json:"status"`
+	WrappedKey string     `json:"wrappedkey,omitempty"`
+	Requested  *time.Time `json:"requested,omitempty"`
+	GrantsAt   *time.Time `json:"grantsat,omitempty"`
+}
+
+// UserKeys is the key pair of a user used for sharing, the private key is encrypted by the client
+type UserKeys struct {
+	PublicKey  string `json:"publickey"`
+	PrivateKey string `json:"privatekey"`
+}
+
+// PasswordUri is an additional uri of an entry with the strategy used to match it
+type PasswordUri struct {
+	Uri   string `json:"uri"`
+	Match string `json:"match"`
+}
+
+// PasswordUris is read from the json aggregated by the database
+type PasswordUris []PasswordUri
+
+func (uris *PasswordUris) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		*uris = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(value), uris)
+	case []byte:
+		return json.Unmarshal(value, uris)
+	}
+	return fmt.Errorf("cannot scan %T into PasswordUris", src)
+}
+
+// Operations of a batch
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// PasswordChanges are the fields an update of a batch changes, nil fields are kept
+type PasswordChanges struct {
+	Password *string `json:"password"`
+	Url      *string `json:"url"`
+	Username *string `json:"username"`
+	Name     *string `json:"name"`
+	// Folder moves the entry, an empty folder moves it back to the root
+	Folder   *string   `json:"folder"`
+	Tags     *[]string `json:"tags"`
+	Favorite *bool     `json:"favorite"`
+}
+
+// BatchOperation creates the Entry, or updates or deletes the entry Id of the Owner
+type BatchOperation struct {
+	Op      string           `json:"op"`
+	Id      string           `json:"id,omitempty"`
+	Entry   *Password        `json:"entry,omitempty"`
+	Changes *PasswordChanges `json:"changes,omitempty"`
+	// Version is the ETag of the entry the update or delete is based on, like the If-Match header of single changes
+	Version string `json:"version,omitempty"`
+	// Owner is set once the Policy authorized the operation
+	Owner *User `json:"-"`
+}
+
+// BatchResult is the outcome of a single operation, Status is the one of the single calls or FAILED and ROLLEDBACK
+type BatchResult struct {
+	Id     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Version is the current ETag of the entry if the operation was based on a stale version
+	Version string `json:"version,omitempty"`
+}
+
+// Attachment describes a client encrypted file of an entry, its content is kept in the BlobStore
+type Attachment struct {
+	Id       string    `json:"id"`
+	Entry    string    `json:"entry"`
+	FileName string    `json:"filename"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+}
+
+// EquivalentDomains is a group of registrable domains which share their credentials
+type EquivalentDomains struct {
+	Id      string   `json:"id"`
+	Domains []string `json:"domains"`
+}
+
+type Folder struct {
 	Id       string `json:"id"`
-	Url      string `json:"url"`
-	Username string `json:"username"`
+	Name     string `json:"name"`
+	Parent   string `json:"parent,omitempty"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+type Tag struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Revision int64  `json:"revision,omitempty"`
+}
+
+// RootFolder can be used as folder filter to only match entries which are not assigned to any folder
+const RootFolder = "root"
+
+// Entry types which can be stored in the vault
+const (
+	TypeLogin    = "login"
+	TypeNote     = "note"
+	TypeCard     = "card"
+	TypeIdentity = "identity"
+)
+
+// PasswordCursor marks the position of an entry within a sorted listing, the next page starts after it
+type PasswordCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Key        string `json:"k"`
+	Id         string `json:"i"`
+}
+
+// PasswordFilter narrows down the passwords returned by GetPasswords, empty fields are ignored
+type PasswordFilter struct {
+	Folder     string
+	Tag        string
+	Type       string
+	Query      string
+	Favorite   bool
+	Sort       string
+	Descending bool
+	Limit      int
+	After      *PasswordCursor
 }
 
 func (u *User) WebAuthID() []byte {
@@ -77,9 +471,120 @@ type StorageInterface interface {
 	DeleteSessionKeyForUser(*User) error
 	// Password operations
 	GetPassword(user *User, url string, username string) (*Password, error)
-	GetPasswordByUrl(user *User, url string) ([] *Password, error)
-	GetPasswords(*User) ([] *Password, error)
+	GetPasswordCandidates(user *User, domains []string) ([]*Password, error)
+	GetPasswords(*User, PasswordFilter) ([]*Password, error)
 	CreatePassword(*User, string, *Password) error
 	UpdatePassword(*User, string, *Password) error
 	DeletePassword(user *User, url string, username string) error
+	SetPasswordFolder(user *User, id string, folder string) error
+	SetPasswordTags(user *User, id string, tags []string) error
+	SetPasswordUris(user *User, id string, match string, uris []PasswordUri) error
+	SetPasswordFavorite(user *User, id string, favorite bool) error
+	// ApplyPasswordBatch applies all operations or none, the results are returned in both cases
+	ApplyPasswordBatch(user *User, operations []*BatchOperation) ([]*BatchResult, error)
+	// Usage operations, an empty id applies to all entries of the user
+	RecordPasswordUsage(user *User, id string) error
+	DeletePasswordUsage(user *User, id string) error
+	// Equivalent domain operations
+	GetEquivalentDomains(*User) ([]*EquivalentDomains, error)
+	CreateEquivalentDomains(*User, *EquivalentDomains) error
+	UpdateEquivalentDomains(*User, *EquivalentDomains) error
+	DeleteEquivalentDomains(user *User, id string) error
+	// Trash operations
+	GetTrash(*User) ([]*Password, error)
+	RestorePassword(user *User, id string) error
+	EmptyTrash(*User) error
+	PurgeTrash(deletedBefore time.Time) (int64, error)
+	// Expiry operations, users are reminded of entries due before the given time at most once a day
+	SetPasswordExpiry(user *User, id string, expires *time.Time, rotationDays int) error
+	RecordPasswordRotation(user *User, id string) error
+	GetDuePasswords(user *User, before time.Time) ([]*Password, error)
+	GetUsersToRemind(before time.Time) ([]*User, error)
+	SetUserReminded(*User) error
+	// Sharing operations, shared passwords are encrypted with an entry key which is wrapped for every user
+	SetUserKeys(*User, *UserKeys) error
+	GetUserKeys(*User) (*UserKeys, error)
+	GetPublicKey(username string) (string, error)
+	SharePassword(user *User, share *Share, password string, ownerKey string) error
+	GetShares(user *User, entry string) ([]*Share, error)
+	RevokeShare(user *User, entry string, username string) error
+	SetSharedPassword(user *User, owner *User, id string, password string) error
+	// Organization operations, the authorization is done by the Policy
+	GetOrganizations(*User) ([]*Organization, error)
+	CreateOrganization(user *User, org *Organization) error
+	DeleteOrganization(id string) error
+	GetMembership(user *User, org string) (*Membership, error)
+	GetMembers(org string) ([]*Membership, error)
+	SaveMember(*Membership) error
+	UpdateMember(*Membership) error
+	CountCollectionEntries(user *User) (int, error)
+	AcceptMembership(user *User, org string) error
+	DeleteMember(org string, username string) error
+	GetCollections(user *User, org string) ([]*Collection, error)
+	GetCollection(id string) (*Collection, error)
+	CreateCollection(*Collection) error
+	UpdateCollection(*Collection) error
+	DeleteCollection(id string) error
+	GetCollectionGrants(collection string) ([]*CollectionGrant, error)
+	SaveCollectionGrant(*CollectionGrant) error
+	DeleteCollectionGrant(collection string, username string) error
+	GetEntryAccess(user *User, id string) (*EntryAccess, error)
+	SetPasswordCollection(owner *User, id string, collection string, password string) error
+	// Emergency access operations, grantor and grantee are both allowed to read and delete a contact
+	GetEmergencyContacts(*User) ([]*EmergencyContact, error)
+	GetEmergencyContact(user *User, id string) (*EmergencyContact, error)
+	CreateEmergencyContact(*User, *EmergencyContact) error
+	UpdateEmergencyContact(*User, *EmergencyContact) error
+	DeleteEmergencyContact(user *User, id string) error
+	AcceptEmergencyContact(user *User, id string) error
+	RequestEmergencyAccess(user *User, id string) error
+	ApproveEmergencyAccess(user *User, id string) error
+	RejectEmergencyAccess(user *User, id string) error
+	GrantDueEmergencyAccess() ([]*EmergencyContact, error)
+	GetEmergencyPasswords(user *User, id string) ([]*Password, error)
+	// Send operations, sends are read without an account and every read uses up one view
+	GetSends(*User) ([]*Send, error)
+	CreateSend(*User, *Send) error
+	DeleteSend(user *User, id string) error
+	GetSendProtection(id string) (*Send, error)
+	// CountSendAttempt counts a read of a protected send, after 10 of them without consuming it the send is unavailable
+	CountSendAttempt(id string) error
+	ConsumeSend(id string) (*Send, error)
+	PurgeSends() (int64, error)
+	// Sync operations, every change of an entry, folder or tag increments the revision of its user
+	GetSyncChanges(user *User, since int64) (*SyncChanges, error)
+	PruneSyncDeletions(before time.Time) (int64, error)
+	// Health operations, the client reports fingerprints and strength scores instead of the passwords
+	SetPasswordHealth(user *User, health []*EntryHealth) error
+	GetHealthRecords(user *User) ([]*HealthRecord, error)
+	// Password rule operations, the rules of the user override the bundled rules of a domain
+	GetPasswordRules(*User) ([]*PasswordRule, error)
+	SavePasswordRule(*User, *PasswordRule) error
+	DeletePasswordRule(user *User, domain string) error
+	// Breach operations, the corpus is matched by hashed identifiers only
+	GetBreaches(*User) ([]*Breach, error)
+	FlagBreachedAccounts() (int64, error)
+	// Export operations, every export is recorded before it is handed out
+	GetExports(*User) ([]*ExportRecord, error)
+	RecordExport(*User, *ExportRecord) error
+	// Attachment operations
+	GetAttachments(user *User, entry string) ([]*Attachment, error)
+	GetAttachment(user *User, id string) (*Attachment, error)
+	// CreateAttachment reserves the Size of the attachment within the quota of the user, it is reduced to what remains
+	CreateAttachment(user *User, attachment *Attachment, quota int64) error
+	UpdateAttachmentSize(user *User, id string, size int64) error
+	DeleteAttachment(user *User, id string) error
+	GetAttachmentUsage(*User) (int64, error)
+	GetAttachmentEntry(id string) (string, error)
+	GetAttachmentIds() ([]string, error)
+	// Folder operations
+	GetFolders(*User) ([]*Folder, error)
+	CreateFolder(*User, *Folder) error
+	UpdateFolder(*User, *Folder) error
+	DeleteFolder(user *User, id string) error
+	// Tag operations
+	GetTags(*User) ([]*Tag, error)
+	CreateTag(*User, *Tag) error
+	UpdateTag(*User, *Tag) error
+	DeleteTag(user *User, id string) error
 }
@@ -0,0 +1,1431 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The conformance tests run against every StorageInterface implementation with a real database.
+// Every test creates its own users, so the implementations can be run against a shared database.
+
+func TestPostgresStorage(t *testing.T) {
+	connInfo := os.Getenv("KEYCLOUD_TEST_POSTGRES")
+	if connInfo == "" {
+		t.Skip("KEYCLOUD_TEST_POSTGRES is not set")
+	}
+	db, err := sql.Open("postgres", connInfo)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening the database", err)
+	}
+	defer db.Close()
+	_, err = MigrateDatabase(db, schemaMigrations, schemaMigrations[len(schemaMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	testStorageConformance(t, &Storage{database: db}, db)
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	db, remove := openTestSQLite(t)
+	defer remove()
+	_, err := MigrateDatabase(db, sqliteMigrations, sqliteMigrations[len(sqliteMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	testStorageConformance(t, &SQLiteStorage{Storage{database: db}}, db)
+}
+
+// TestSQLiteStorageSealed runs the conformance tests with the passwords encrypted at rest
+func TestSQLiteStorageSealed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	defer os.RemoveAll(dir)
+	db, remove := openTestSQLite(t)
+	defer remove()
+	_, err = MigrateDatabase(db, sqliteMigrations, sqliteMigrations[len(sqliteMigrations)-1].Version)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when migrating", err)
+	}
+	testStorageConformance(t, &SQLiteStorage{Storage{database: db, cipher: newTestDataCipher(t, dir, "first")}}, db)
+}
+
+// openTestSQLite opens a SQLite database in a temporary directory, the directory is removed by the returned function
+func openTestSQLite(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a directory", err)
+	}
+	db, err := openSQLite(filepath.Join(dir, "keycloud.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("an error '%s' was not expected when opening the database", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// testStorageConformance runs the conformance tests, the database is used to backdate rows
+func testStorageConformance(t *testing.T, storage StorageInterface, db *sql.DB) {
+	tests := []struct {
+		name string
+		test func(*testing.T, StorageInterface, *sql.DB)
+	}{
+		{"users", testConformanceUsers},
+		{"passwords", testConformancePasswords},
+		{"listing", testConformanceListing},
+		{"folders and tags", testConformanceFoldersAndTags},
+		{"uris", testConformanceUris},
+		{"usage", testConformanceUsage},
+		{"batch", testConformanceBatch},
+		{"trash", testConformanceTrash},
+		{"expiry", testConformanceExpiry},
+		{"shares", testConformanceShares},
+		{"organizations", testConformanceOrganizations},
+		{"emergency", testConformanceEmergency},
+		{"sends", testConformanceSends},
+		{"sync", testConformanceSync},
+		{"health", testConformanceHealth},
+		{"password rules", testConformancePasswordRules},
+		{"breaches", testConformanceBreaches},
+		{"exports", testConformanceExports},
+		{"attachments", testConformanceAttachments},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, storage, db)
+		})
+	}
+}
+
+// conformanceUsers counts the users created by the tests, it keeps their names unique across runs on the same database
+var conformanceUsers = 0
+
+// newConformanceUser creates a user with a unique name and the given mail
+func newConformanceUser(t *testing.T, storage StorageInterface, mail string) *User {
+	conformanceUsers++
+	user := &User{
+		Name:           "conformance-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(conformanceUsers),
+		Mail:           mail,
+		MasterPassword: []byte("master"),
+	}
+	err := storage.CreateUser(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a user", err)
+	}
+	return user
+}
+
+// newConformancePassword creates an entry of the user, the type and the match default like in the handler
+func newConformancePassword(t *testing.T, storage StorageInterface, user *User, password *Password) *Password {
+	if password.Type == "" {
+		password.Type = TypeLogin
+	}
+	if password.Match == "" {
+		password.Match = "domain"
+	}
+	err := storage.CreatePassword(user, "", password)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating an entry", err)
+	}
+	if password.Id == "" {
+		t.Fatalf("the entry was created without an id")
+	}
+	return password
+}
+
+// passwordIds lists the ids of the entries in their order
+func passwordIds(passwords []*Password) []string {
+	ids := make([]string, 0, len(passwords))
+	for _, password := range passwords {
+		ids = append(ids, password.Id)
+	}
+	return ids
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testConformanceUsers(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "john@example.com")
+
+	found, err := storage.GetUserByName(user.Name)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the user", err)
+	}
+	if string(found.Uuid) != string(user.Uuid) || found.Mail != user.Mail || string(found.MasterPassword) != "master" {
+		t.Errorf("unexpected user %+v", found)
+	}
+	found, err = storage.GetUser(string(user.Uuid))
+	if err != nil || found.Name != user.Name {
+		t.Errorf("unexpected user %+v (%v)", found, err)
+	}
+	if _, err := storage.GetUserByName(user.Name + "-missing"); err == nil {
+		t.Errorf("a missing user was found")
+	}
+
+	user.Mail = "jane@example.com"
+	err = storage.UpdateUser(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the user", err)
+	}
+	found, err = storage.GetUserByName(user.Name)
+	if err != nil || found.Mail != "jane@example.com" {
+		t.Errorf("the mail was not updated: %+v (%v)", found, err)
+	}
+
+	err = storage.UpdateOrCreateSessionKeyForUser(user, []byte("first"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the session key", err)
+	}
+	err = storage.UpdateOrCreateSessionKeyForUser(user, []byte("second"))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the session key", err)
+	}
+	key, err := storage.GetSessionKeyForUser(user)
+	if err != nil || string(key) != "second" {
+		t.Errorf("unexpected session key %q (%v)", key, err)
+	}
+	err = storage.DeleteSessionKeyForUser(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the session key", err)
+	}
+	if key, err := storage.GetSessionKeyForUser(user); err == nil && len(key) != 0 {
+		t.Errorf("the session key was not deleted")
+	}
+
+	err = storage.RemoveUser(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when removing the user", err)
+	}
+	if _, err := storage.GetUserByName(user.Name); err == nil {
+		t.Errorf("the user was not removed")
+	}
+}
+
+func testConformancePasswords(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	other := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{
+		Url: "https://example.com", Username: "john", Password: "secret", Name: "Example", Type: TypeLogin,
+	})
+
+	found, err := storage.GetPassword(user, "https://example.com", "john")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the entry", err)
+	}
+	if found.Id != entry.Id || found.Password != "secret" || found.Name != "Example" || found.Type != TypeLogin {
+		t.Errorf("unexpected entry %+v", found)
+	}
+	if found.Created == nil || time.Since(*found.Created) > time.Hour || time.Since(*found.Created) < -time.Hour {
+		t.Errorf("unexpected creation date %v", found.Created)
+	}
+	if _, err := storage.GetPassword(other, "https://example.com", "john"); err == nil {
+		t.Errorf("the entry was found for another user")
+	}
+
+	err = storage.UpdatePassword(user, "", &Password{Url: "https://example.com", Password: "changed"})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the entry", err)
+	}
+	found, err = storage.GetPassword(user, "https://example.com", "john")
+	if err != nil || found.Password != "changed" || found.Changed == nil {
+		t.Errorf("the password was not updated: %+v (%v)", found, err)
+	}
+	// writing the same password again is no change of the password
+	changed := time.Now().AddDate(0, 0, -10)
+	_, err = db.Exec("UPDATE passwds SET passwordchanged = $1 WHERE entryid = $2", changed, entry.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when backdating the change", err)
+	}
+	err = storage.UpdatePassword(user, "", &Password{Url: "https://example.com", Password: "changed"})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the entry", err)
+	}
+	found, err = storage.GetPassword(user, "https://example.com", "john")
+	if err != nil || found.Changed == nil || time.Since(*found.Changed) < 9*24*time.Hour {
+		t.Errorf("the unchanged password changed the date %+v (%v)", found, err)
+	}
+
+	err = storage.SetPasswordFavorite(user, entry.Id, true)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marking the favorite", err)
+	}
+	if err := storage.SetPasswordFavorite(other, entry.Id, true); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for the entry of another user, got %v", err)
+	}
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{Favorite: true})
+	if !equalStrings(passwordIds(passwords), []string{entry.Id}) || !passwords[0].Favorite {
+		t.Errorf("unexpected favorites %v", passwordIds(passwords))
+	}
+
+	candidates, _ := storage.GetPasswordCandidates(user, []string{"example.com"})
+	if !equalStrings(passwordIds(candidates), []string{entry.Id}) {
+		t.Errorf("unexpected candidates %v", passwordIds(candidates))
+	}
+	candidates, _ = storage.GetPasswordCandidates(user, []string{"example.org"})
+	if len(candidates) != 0 {
+		t.Errorf("unexpected candidates %v", passwordIds(candidates))
+	}
+
+	err = storage.DeletePassword(user, "https://example.com", "john")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the entry", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{})
+	if len(passwords) != 0 {
+		t.Errorf("the deleted entry is still listed")
+	}
+}
+
+func testConformanceListing(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	names := []string{"delta", "Alpha", "charlie", "bravo", "echo_1"}
+	ids := make(map[string]string)
+	for _, name := range names {
+		entry := newConformancePassword(t, storage, user, &Password{
+			Url: "https://" + name + ".example.com", Username: "user", Password: "secret", Name: name, Type: TypeLogin,
+		})
+		ids[name] = entry.Id
+	}
+	note := newConformancePassword(t, storage, user, &Password{Name: "note", Password: "text", Type: TypeNote})
+	for i := 0; i < 3; i++ {
+		err := storage.RecordPasswordUsage(user, ids["charlie"])
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when recording the usage", err)
+		}
+	}
+	err := storage.RecordPasswordUsage(user, ids["bravo"])
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when recording the usage", err)
+	}
+
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{})
+	expected := []string{ids["Alpha"], ids["bravo"], ids["charlie"], ids["delta"], ids["echo_1"], note.Id}
+	if !equalStrings(passwordIds(passwords), expected) {
+		t.Errorf("unexpected order by name %v, expected %v", passwordIds(passwords), expected)
+	}
+
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Sort: "usecount", Descending: true, Limit: 2})
+	if !equalStrings(passwordIds(passwords), []string{ids["charlie"], ids["bravo"]}) {
+		t.Errorf("unexpected order by use count %v", passwordIds(passwords))
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Sort: "lastused", Descending: true, Limit: 2})
+	if len(passwords) != 2 || passwords[0].LastUsed == nil {
+		t.Errorf("unexpected order by last use %v", passwordIds(passwords))
+	}
+
+	// the cursor continues every sort after the last entry of the page
+	for _, sorting := range []string{"name", "url", "created", "lastused", "usecount", "favorite"} {
+		for _, descending := range []bool{false, true} {
+			all, _ := storage.GetPasswords(user, PasswordFilter{Sort: sorting, Descending: descending})
+			var paged []*Password
+			filter := PasswordFilter{Sort: sorting, Descending: descending, Limit: 2}
+			for i := 0; i < 10; i++ {
+				page, _ := storage.GetPasswords(user, filter)
+				paged = append(paged, page...)
+				if len(page) < filter.Limit {
+					break
+				}
+				filter.After = page[len(page)-1].Cursor
+			}
+			if len(all) != len(names)+1 || !equalStrings(passwordIds(paged), passwordIds(all)) {
+				t.Errorf("unexpected pages by %s (descending %v): %v, expected %v",
+					sorting, descending, passwordIds(paged), passwordIds(all))
+			}
+		}
+	}
+
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Query: "ALPHA"})
+	if !equalStrings(passwordIds(passwords), []string{ids["Alpha"]}) {
+		t.Errorf("unexpected search result %v", passwordIds(passwords))
+	}
+	// wildcards of the search are matched literally
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Query: "o_1"})
+	if !equalStrings(passwordIds(passwords), []string{ids["echo_1"]}) {
+		t.Errorf("unexpected search result %v", passwordIds(passwords))
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Query: "USER"})
+	if len(passwords) != 5 {
+		t.Errorf("unexpected search result by username %v", passwordIds(passwords))
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Query: "%"})
+	if len(passwords) != 0 {
+		t.Errorf("unexpected search result %v", passwordIds(passwords))
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Type: TypeNote})
+	if !equalStrings(passwordIds(passwords), []string{note.Id}) {
+		t.Errorf("unexpected notes %v", passwordIds(passwords))
+	}
+}
+
+func testConformanceFoldersAndTags(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	other := newConformanceUser(t, storage, "")
+	parent := &Folder{Name: "work"}
+	err := storage.CreateFolder(user, parent)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a folder", err)
+	}
+	child := &Folder{Name: "projects", Parent: parent.Id}
+	err = storage.CreateFolder(user, child)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a folder", err)
+	}
+	child.Name = "clients"
+	err = storage.UpdateFolder(user, child)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when renaming a folder", err)
+	}
+	if err := storage.UpdateFolder(other, child); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for the folder of another user, got %v", err)
+	}
+	folders, err := storage.GetFolders(user)
+	if err != nil || len(folders) != 2 {
+		t.Fatalf("unexpected folders %+v (%v)", folders, err)
+	}
+	for _, folder := range folders {
+		if folder.Id == child.Id && (folder.Name != "clients" || folder.Parent != parent.Id) {
+			t.Errorf("unexpected folder %+v", folder)
+		}
+	}
+
+	tag := &Tag{Name: "private"}
+	err = storage.CreateTag(user, tag)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a tag", err)
+	}
+	second := &Tag{Name: "shared"}
+	err = storage.CreateTag(user, second)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a tag", err)
+	}
+	tag.Name = "personal"
+	err = storage.UpdateTag(user, tag)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when renaming a tag", err)
+	}
+	tags, err := storage.GetTags(user)
+	if err != nil || len(tags) != 2 {
+		t.Fatalf("unexpected tags %+v (%v)", tags, err)
+	}
+
+	entry := newConformancePassword(t, storage, user, &Password{
+		Url: "https://example.com", Username: "john", Password: "secret", Folder: child.Id, Tags: []string{tag.Id},
+	})
+	newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{Folder: child.Id})
+	if !equalStrings(passwordIds(passwords), []string{entry.Id}) || !equalStrings(passwords[0].Tags, []string{tag.Id}) {
+		t.Errorf("unexpected entries of the folder %+v", passwords)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Folder: RootFolder})
+	if len(passwords) != 1 || passwords[0].Id == entry.Id {
+		t.Errorf("unexpected entries of the root %v", passwordIds(passwords))
+	}
+
+	err = storage.SetPasswordTags(user, entry.Id, []string{second.Id, tag.Id})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when tagging the entry", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Tag: second.Id})
+	if !equalStrings(passwordIds(passwords), []string{entry.Id}) {
+		t.Errorf("unexpected entries of the tag %v", passwordIds(passwords))
+	}
+	sort.Strings(passwords[0].Tags)
+	expected := []string{second.Id, tag.Id}
+	sort.Strings(expected)
+	if !equalStrings(passwords[0].Tags, expected) {
+		t.Errorf("unexpected tags %v", passwords[0].Tags)
+	}
+
+	err = storage.SetPasswordFolder(user, entry.Id, "")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Folder: RootFolder})
+	if len(passwords) != 2 {
+		t.Errorf("the entry was not moved to the root %v", passwordIds(passwords))
+	}
+
+	err = storage.DeleteTag(user, second.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting a tag", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Tag: tag.Id})
+	if len(passwords) != 1 || !equalStrings(passwords[0].Tags, []string{tag.Id}) {
+		t.Errorf("the deleted tag is still assigned %+v", passwords)
+	}
+	err = storage.DeleteFolder(user, parent.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting a folder", err)
+	}
+	if err := storage.DeleteFolder(user, parent.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a deleted folder, got %v", err)
+	}
+}
+
+func testConformanceUris(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{
+		Url: "https://example.com", Username: "john", Password: "secret",
+		Uris: PasswordUris{{Uri: "https://login.example.net", Match: "host"}},
+	})
+	candidates, _ := storage.GetPasswordCandidates(user, []string{"example.net"})
+	if !equalStrings(passwordIds(candidates), []string{entry.Id}) || len(candidates[0].Uris) != 1 {
+		t.Errorf("unexpected candidates %+v", candidates)
+	}
+	err := storage.SetPasswordUris(user, entry.Id, "host", []PasswordUri{
+		{Uri: "https://example.org", Match: "domain"}, {Uri: "https://example.edu", Match: "host"},
+	})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when setting the uris", err)
+	}
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].Match != "host" || len(passwords[0].Uris) != 2 {
+		t.Errorf("unexpected entry %+v", passwords)
+	}
+	candidates, _ = storage.GetPasswordCandidates(user, []string{"example.net"})
+	if len(candidates) != 0 {
+		t.Errorf("the replaced uri is still matched")
+	}
+
+	group := &EquivalentDomains{Domains: []string{"example.com", "example.de"}}
+	err = storage.CreateEquivalentDomains(user, group)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating equivalent domains", err)
+	}
+	group.Domains = append(group.Domains, "example.fr")
+	err = storage.UpdateEquivalentDomains(user, group)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating equivalent domains", err)
+	}
+	groups, err := storage.GetEquivalentDomains(user)
+	if err != nil || len(groups) != 1 || groups[0].Id != group.Id || !equalStrings(groups[0].Domains, group.Domains) {
+		t.Errorf("unexpected equivalent domains %+v (%v)", groups, err)
+	}
+	err = storage.DeleteEquivalentDomains(user, group.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting equivalent domains", err)
+	}
+	groups, _ = storage.GetEquivalentDomains(user)
+	if len(groups) != 0 {
+		t.Errorf("the equivalent domains were not deleted")
+	}
+}
+
+func testConformanceUsage(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	first := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	second := newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	for _, id := range []string{first.Id, first.Id, second.Id} {
+		err := storage.RecordPasswordUsage(user, id)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when recording the usage", err)
+		}
+	}
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{Sort: "usecount", Descending: true})
+	if len(passwords) != 2 || passwords[0].UseCount != 2 || passwords[0].LastUsed == nil || passwords[1].UseCount != 1 {
+		t.Errorf("unexpected usage %+v", passwords)
+	}
+	err := storage.DeletePasswordUsage(user, first.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the usage", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Sort: "usecount", Descending: true})
+	if len(passwords) != 2 || passwords[0].Id != second.Id || passwords[1].UseCount != 0 || passwords[1].LastUsed != nil {
+		t.Errorf("unexpected usage %+v", passwords)
+	}
+	err = storage.DeletePasswordUsage(user, "")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the usage", err)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Sort: "usecount", Descending: true})
+	if len(passwords) != 2 || passwords[0].UseCount != 0 {
+		t.Errorf("unexpected usage %+v", passwords)
+	}
+}
+
+func testConformanceBatch(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	removed := newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	access, err := storage.GetEntryAccess(user, entry.Id)
+	if err != nil || string(access.Owner) != string(user.Uuid) {
+		t.Fatalf("unexpected access %+v (%v)", access, err)
+	}
+	version := entryETag(access.Revision)
+	access, err = storage.GetEntryAccess(user, removed.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reading the access", err)
+	}
+	name, favorite := "renamed", true
+
+	// a stale version rolls back the whole batch
+	results, err := storage.ApplyPasswordBatch(user, []*BatchOperation{
+		{Op: BatchCreate, Entry: &Password{Url: "https://example.net", Username: "john", Password: "secret", Type: TypeLogin, Match: "domain"}},
+		{Op: BatchUpdate, Id: entry.Id, Version: `"0"`, Owner: user, Changes: &PasswordChanges{Name: &name}},
+	})
+	if err == nil || len(results) != 2 || results[0].Status != "ROLLEDBACK" || results[1].Status != "FAILED" || results[1].Version != version {
+		t.Errorf("unexpected results %+v (%v)", results, err)
+	}
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{})
+	if len(passwords) != 2 {
+		t.Errorf("the batch was not rolled back %v", passwordIds(passwords))
+	}
+
+	created := &Password{Url: "https://example.net", Username: "john", Password: "secret", Type: TypeLogin, Match: "domain"}
+	results, err = storage.ApplyPasswordBatch(user, []*BatchOperation{
+		{Op: BatchCreate, Entry: created},
+		{Op: BatchUpdate, Id: entry.Id, Version: version, Owner: user, Changes: &PasswordChanges{Name: &name, Favorite: &favorite}},
+		{Op: BatchDelete, Id: removed.Id, Version: entryETag(access.Revision), Owner: user},
+	})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when applying the batch", err)
+	}
+	if results[0].Status != "CREATED" || results[0].Id == "" || results[1].Status != "UPDATED" || results[2].Status != "REMOVED" {
+		t.Errorf("unexpected results %+v %+v %+v", results[0], results[1], results[2])
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{Favorite: true})
+	if len(passwords) != 1 || passwords[0].Id != entry.Id || passwords[0].Name != "renamed" {
+		t.Errorf("the update was not applied %+v", passwords)
+	}
+	passwords, _ = storage.GetPasswords(user, PasswordFilter{})
+	if len(passwords) != 2 {
+		t.Errorf("unexpected entries %v", passwordIds(passwords))
+	}
+}
+
+func testConformanceTrash(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	purged := newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	for _, url := range []string{"https://example.com", "https://example.org"} {
+		err := storage.DeletePassword(user, url, "john")
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when deleting an entry", err)
+		}
+	}
+	trash, err := storage.GetTrash(user)
+	if err != nil || len(trash) != 2 || trash[0].Deleted == nil {
+		t.Fatalf("unexpected trash %+v (%v)", trash, err)
+	}
+
+	err = storage.RestorePassword(user, entry.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when restoring the entry", err)
+	}
+	if err := storage.RestorePassword(user, entry.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when restoring an entry twice, got %v", err)
+	}
+	passwords, _ := storage.GetPasswords(user, PasswordFilter{})
+	if !equalStrings(passwordIds(passwords), []string{entry.Id}) {
+		t.Errorf("the entry was not restored %v", passwordIds(passwords))
+	}
+
+	_, err = db.Exec("UPDATE passwds SET deletedate = $1 WHERE entryid = $2", time.Now().AddDate(0, 0, -31), purged.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when backdating the entry", err)
+	}
+	count, err := storage.PurgeTrash(time.Now().AddDate(0, 0, -30))
+	if err != nil || count < 1 {
+		t.Errorf("unexpected number of purged entries %d (%v)", count, err)
+	}
+	trash, _ = storage.GetTrash(user)
+	if len(trash) != 0 {
+		t.Errorf("the entry was not purged %v", passwordIds(trash))
+	}
+
+	err = storage.DeletePassword(user, "https://example.com", "john")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting an entry", err)
+	}
+	err = storage.EmptyTrash(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when emptying the trash", err)
+	}
+	trash, _ = storage.GetTrash(user)
+	if len(trash) != 0 {
+		t.Errorf("the trash was not emptied %v", passwordIds(trash))
+	}
+}
+
+func testConformanceExpiry(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "john@example.com")
+	expiring := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	rotated := newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "john", Password: "secret"})
+	newConformancePassword(t, storage, user, &Password{Url: "https://example.net", Username: "john", Password: "secret"})
+
+	expires := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	err := storage.SetPasswordExpiry(user, expiring.Id, &expires, 0)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when setting the expiry", err)
+	}
+	err = storage.SetPasswordExpiry(user, rotated.Id, nil, 1)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when setting the rotation", err)
+	}
+	due, err := storage.GetDuePasswords(user, time.Now().Add(72*time.Hour))
+	if err != nil || !equalStrings(passwordIds(due), []string{rotated.Id, expiring.Id}) {
+		t.Fatalf("unexpected due entries %v (%v)", passwordIds(due), err)
+	}
+	if due[1].Expires == nil || !due[1].Expires.Equal(expires) || due[0].RotationDays != 1 || due[0].Due == nil {
+		t.Errorf("unexpected due entries %+v %+v", due[0], due[1])
+	}
+	due, _ = storage.GetDuePasswords(user, time.Now().Add(36*time.Hour))
+	if !equalStrings(passwordIds(due), []string{rotated.Id}) {
+		t.Errorf("unexpected due entries %v", passwordIds(due))
+	}
+
+	err = storage.RecordPasswordRotation(user, rotated.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when recording the rotation", err)
+	}
+	users, err := storage.GetUsersToRemind(time.Now().Add(72 * time.Hour))
+	if err != nil || !containsUser(users, user) {
+		t.Fatalf("the user is not reminded %+v (%v)", users, err)
+	}
+	err = storage.SetUserReminded(user)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when marking the reminder", err)
+	}
+	users, _ = storage.GetUsersToRemind(time.Now().Add(72 * time.Hour))
+	if containsUser(users, user) {
+		t.Errorf("the user is reminded twice a day")
+	}
+}
+
+func containsUser(users []*User, user *User) bool {
+	for _, candidate := range users {
+		if candidate.Name == user.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func testConformanceShares(t *testing.T, storage StorageInterface, db *sql.DB) {
+	owner := newConformanceUser(t, storage, "")
+	recipient := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, owner, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	for _, user := range []*User{owner, recipient} {
+		err := storage.SetUserKeys(user, &UserKeys{PublicKey: "public-" + user.Name, PrivateKey: "private-" + user.Name})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when setting the keys", err)
+		}
+	}
+	keys, err := storage.GetUserKeys(owner)
+	if err != nil || keys.PublicKey != "public-"+owner.Name || keys.PrivateKey != "private-"+owner.Name {
+		t.Errorf("unexpected keys %+v (%v)", keys, err)
+	}
+	publicKey, err := storage.GetPublicKey(recipient.Name)
+	if err != nil || publicKey != "public-"+recipient.Name {
+		t.Errorf("unexpected public key %q (%v)", publicKey, err)
+	}
+
+	share := &Share{Entry: entry.Id, Username: recipient.Name, Permission: PermissionRead, WrappedKey: "wrapped"}
+	err = storage.SharePassword(owner, share, "encrypted", "owner-key")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sharing the entry", err)
+	}
+	share.Permission = PermissionEdit
+	err = storage.SharePassword(owner, share, "encrypted", "owner-key")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sharing the entry again", err)
+	}
+	shares, err := storage.GetShares(owner, entry.Id)
+	if err != nil || len(shares) != 1 || *shares[0] != *share {
+		t.Errorf("unexpected shares %+v (%v)", shares, err)
+	}
+	// the shared password is encrypted at rest like the password
+	var password, sharedPassword string
+	err = db.QueryRow("SELECT passwd, sharedpasswd FROM passwds WHERE entryid = $1", entry.Id).Scan(&password, &sharedPassword)
+	if err != nil || isSealed(password) != isSealed(sharedPassword) {
+		t.Errorf("unexpected shared password at rest %s (%v)", sharedPassword, err)
+	}
+	// the recipients read the shared password, so the password of the owner is not changed alone
+	if err := storage.UpdatePassword(owner, "", &Password{Url: "https://example.com", Password: "changed"}); err != errSharedPassword {
+		t.Errorf("expected errSharedPassword when changing the password of a shared entry, got %v", err)
+	}
+	passwords, _ := storage.GetPasswords(recipient, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].Password != "encrypted" || passwords[0].Shared == nil ||
+		*passwords[0].Shared != (PasswordShare{Owner: owner.Name, Permission: PermissionEdit, WrappedKey: "wrapped"}) {
+		t.Errorf("unexpected shared entries %+v", passwords)
+	}
+	access, err := storage.GetEntryAccess(recipient, entry.Id)
+	if err != nil || access.Share != PermissionEdit {
+		t.Errorf("unexpected access %+v (%v)", access, err)
+	}
+
+	err = storage.SetSharedPassword(recipient, owner, entry.Id, "changed")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when changing the shared password", err)
+	}
+	passwords, _ = storage.GetPasswords(owner, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].Password != "changed" || passwords[0].Shared.Permission != PermissionOwner {
+		t.Errorf("unexpected own entries %+v", passwords)
+	}
+
+	// the usage of the recipient is not the usage of the owner
+	err = storage.RecordPasswordUsage(recipient, entry.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when recording the usage", err)
+	}
+	passwords, _ = storage.GetPasswords(recipient, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].UseCount != 1 || passwords[0].LastUsed == nil {
+		t.Errorf("unexpected usage of the recipient %+v", passwords)
+	}
+	passwords, _ = storage.GetPasswords(owner, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].UseCount != 0 || passwords[0].LastUsed != nil {
+		t.Errorf("unexpected usage of the owner %+v", passwords)
+	}
+
+	err = storage.RevokeShare(owner, entry.Id, recipient.Name)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when revoking the share", err)
+	}
+	if err := storage.RevokeShare(owner, entry.Id, recipient.Name); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when revoking a share twice, got %v", err)
+	}
+	passwords, _ = storage.GetPasswords(recipient, PasswordFilter{})
+	if len(passwords) != 0 {
+		t.Errorf("the share was not revoked %v", passwordIds(passwords))
+	}
+}
+
+func testConformanceOrganizations(t *testing.T, storage StorageInterface, db *sql.DB) {
+	owner := newConformanceUser(t, storage, "")
+	member := newConformanceUser(t, storage, "")
+	org := &Organization{Name: "Example", OrgKey: "owner-key"}
+	err := storage.CreateOrganization(owner, org)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the organization", err)
+	}
+	err = storage.SaveMember(&Membership{Organization: org.Id, Username: member.Name, Role: RoleMember, OrgKey: "member-key"})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when inviting the member", err)
+	}
+	err = storage.UpdateMember(&Membership{Organization: org.Id, Username: member.Name, Role: RoleManager})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when changing the member", err)
+	}
+	if err := storage.UpdateMember(&Membership{Organization: org.Id, Username: "nobody", Role: RoleManager}); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when changing a user who is no member, got %v", err)
+	}
+	membership, err := storage.GetMembership(member, org.Id)
+	if err != nil || membership.Role != RoleManager || membership.Status != MembershipInvited || membership.OrgKey != "member-key" {
+		t.Errorf("unexpected membership %+v (%v)", membership, err)
+	}
+	err = storage.AcceptMembership(member, org.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when accepting the membership", err)
+	}
+	orgs, err := storage.GetOrganizations(member)
+	if err != nil || len(orgs) != 1 || orgs[0].Id != org.Id || orgs[0].Status != MembershipAccepted {
+		t.Errorf("unexpected organizations %+v (%v)", orgs, err)
+	}
+	members, err := storage.GetMembers(org.Id)
+	if err != nil || len(members) != 2 {
+		t.Errorf("unexpected members %+v (%v)", members, err)
+	}
+
+	collection := &Collection{Organization: org.Id, Name: "Servers"}
+	err = storage.CreateCollection(collection)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the collection", err)
+	}
+	collection.Name = "Infrastructure"
+	err = storage.UpdateCollection(collection)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when renaming the collection", err)
+	}
+	found, err := storage.GetCollection(collection.Id)
+	if err != nil || found.Name != "Infrastructure" || found.Organization != org.Id {
+		t.Errorf("unexpected collection %+v (%v)", found, err)
+	}
+	err = storage.SaveCollectionGrant(&CollectionGrant{Collection: collection.Id, Username: member.Name, Permission: PermissionRead})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when granting the collection", err)
+	}
+	err = storage.SaveCollectionGrant(&CollectionGrant{Collection: collection.Id, Username: member.Name, Permission: PermissionEdit})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when changing the grant", err)
+	}
+	grants, err := storage.GetCollectionGrants(collection.Id)
+	if err != nil || len(grants) != 1 || grants[0].Permission != PermissionEdit {
+		t.Errorf("unexpected grants %+v (%v)", grants, err)
+	}
+	collections, err := storage.GetCollections(member, org.Id)
+	if err != nil || len(collections) != 1 || collections[0].Permission != PermissionEdit {
+		t.Errorf("unexpected collections %+v (%v)", collections, err)
+	}
+
+	entry := newConformancePassword(t, storage, owner, &Password{Url: "https://example.com", Username: "root", Password: "secret"})
+	err = storage.SetPasswordCollection(owner, entry.Id, collection.Id, "org-encrypted")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry", err)
+	}
+	passwords, _ := storage.GetPasswords(member, PasswordFilter{})
+	if len(passwords) != 1 || passwords[0].Collection != collection.Id || passwords[0].Password != "org-encrypted" {
+		t.Errorf("unexpected entries of the member %+v", passwords)
+	}
+	access, err := storage.GetEntryAccess(member, entry.Id)
+	if err != nil || access.Collection != collection.Id || access.Organization != org.Id || access.Role != RoleManager ||
+		access.Grant != PermissionEdit {
+		t.Errorf("unexpected access %+v (%v)", access, err)
+	}
+	if err := storage.DeleteCollection(collection.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when deleting a collection with entries, got %v", err)
+	}
+
+	err = storage.DeleteCollectionGrant(collection.Id, member.Name)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when revoking the grant", err)
+	}
+	err = storage.DeleteMember(org.Id, member.Name)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when removing the member", err)
+	}
+	passwords, _ = storage.GetPasswords(member, PasswordFilter{})
+	if len(passwords) != 0 {
+		t.Errorf("the removed member still reads %v", passwordIds(passwords))
+	}
+	if count, err := storage.CountCollectionEntries(owner); err != nil || count != 1 {
+		t.Errorf("unexpected entries in collections: got %d (%v) want 1", count, err)
+	}
+	err = storage.SetPasswordCollection(owner, entry.Id, "", "secret")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry back", err)
+	}
+	// entries in the trash are kept with their collection
+	trashed := newConformancePassword(t, storage, owner, &Password{Url: "https://trash.example.com", Username: "root", Password: "secret"})
+	err = storage.SetPasswordCollection(owner, trashed.Id, collection.Id, "org-encrypted")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry", err)
+	}
+	err = storage.DeletePassword(owner, "https://trash.example.com", "root")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when trashing the entry", err)
+	}
+	if err := storage.DeleteCollection(collection.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when deleting a collection with entries in the trash, got %v", err)
+	}
+	err = storage.EmptyTrash(owner)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when emptying the trash", err)
+	}
+	err = storage.DeleteCollection(collection.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the collection", err)
+	}
+	// the entries of the collections are deleted with the organization
+	collection = &Collection{Organization: org.Id, Name: "Databases"}
+	err = storage.CreateCollection(collection)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the collection", err)
+	}
+	err = storage.SetPasswordCollection(owner, entry.Id, collection.Id, "org-encrypted")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when moving the entry", err)
+	}
+	err = storage.DeleteOrganization(org.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the organization", err)
+	}
+	orgs, _ = storage.GetOrganizations(owner)
+	if len(orgs) != 0 {
+		t.Errorf("the organization was not deleted %+v", orgs)
+	}
+	if count, err := storage.CountCollectionEntries(owner); err != nil || count != 0 {
+		t.Errorf("unexpected entries in collections: got %d (%v) want 0", count, err)
+	}
+}
+
+func testConformanceEmergency(t *testing.T, storage StorageInterface, db *sql.DB) {
+	grantor := newConformanceUser(t, storage, "")
+	grantee := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, grantor, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	contact := &EmergencyContact{Grantee: grantee.Name, Access: EmergencyView, WaitDays: 7, WrappedKey: "wrapped"}
+	err := storage.CreateEmergencyContact(grantor, contact)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the contact", err)
+	}
+	contact.WaitDays, contact.WrappedKey = 2, ""
+	err = storage.UpdateEmergencyContact(grantor, contact)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the contact", err)
+	}
+	err = storage.AcceptEmergencyContact(grantee, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when accepting the contact", err)
+	}
+	err = storage.RequestEmergencyAccess(grantee, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when requesting access", err)
+	}
+	found, err := storage.GetEmergencyContact(grantee, contact.Id)
+	if err != nil || found.Status != EmergencyRequested || found.WaitDays != 2 || found.WrappedKey != "" || found.GrantsAt == nil {
+		t.Fatalf("unexpected contact %+v (%v)", found, err)
+	}
+	if time.Until(*found.GrantsAt) < 47*time.Hour || time.Until(*found.GrantsAt) > 49*time.Hour {
+		t.Errorf("unexpected end of the waiting period %v", found.GrantsAt)
+	}
+	if passwords, _ := storage.GetEmergencyPasswords(grantee, contact.Id); len(passwords) != 0 {
+		t.Errorf("the entries were read before access was granted")
+	}
+
+	granted, err := storage.GrantDueEmergencyAccess()
+	if err != nil || containsContact(granted, contact.Id) {
+		t.Errorf("access was granted before the waiting period was over %+v (%v)", granted, err)
+	}
+	_, err = db.Exec("UPDATE emergency_contacts SET requestdate = $1 WHERE contactid = $2", time.Now().AddDate(0, 0, -3), contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when backdating the request", err)
+	}
+	granted, err = storage.GrantDueEmergencyAccess()
+	if err != nil || !containsContact(granted, contact.Id) {
+		t.Fatalf("access was not granted %+v (%v)", granted, err)
+	}
+	for _, contact := range granted {
+		if contact.Grantor == grantor.Name && (contact.Grantee != grantee.Name || contact.Status != EmergencyGranted) {
+			t.Errorf("unexpected granted contact %+v", contact)
+		}
+	}
+	contacts, err := storage.GetEmergencyContacts(grantee)
+	if err != nil || len(contacts) != 1 || contacts[0].WrappedKey != "wrapped" {
+		t.Errorf("unexpected contacts %+v (%v)", contacts, err)
+	}
+	passwords, err := storage.GetEmergencyPasswords(grantee, contact.Id)
+	if err != nil || !equalStrings(passwordIds(passwords), []string{entry.Id}) {
+		t.Errorf("unexpected entries %v (%v)", passwordIds(passwords), err)
+	}
+	access, err := storage.GetEntryAccess(grantee, entry.Id)
+	if err != nil || access.Emergency != EmergencyView {
+		t.Errorf("unexpected access %+v (%v)", access, err)
+	}
+
+	err = storage.RejectEmergencyAccess(grantor, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when ending access", err)
+	}
+	err = storage.RequestEmergencyAccess(grantee, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when requesting access again", err)
+	}
+	err = storage.ApproveEmergencyAccess(grantor, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when approving access", err)
+	}
+	found, err = storage.GetEmergencyContact(grantor, contact.Id)
+	if err != nil || found.Status != EmergencyGranted {
+		t.Errorf("unexpected contact %+v (%v)", found, err)
+	}
+	err = storage.DeleteEmergencyContact(grantee, contact.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the contact", err)
+	}
+	contacts, _ = storage.GetEmergencyContacts(grantor)
+	if len(contacts) != 0 {
+		t.Errorf("the contact was not deleted %+v", contacts)
+	}
+}
+
+func containsContact(contacts []*EmergencyContact, id string) bool {
+	for _, contact := range contacts {
+		if contact.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func testConformanceSends(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	send := &Send{Id: newUUID(), Type: SendText, Name: "name", Content: "content", Expires: expires, MaxViews: 2}
+	err := storage.CreateSend(user, send)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the send", err)
+	}
+	protected := &Send{Id: newUUID(), Type: SendText, Name: "name", Content: "content", Expires: expires, MaxViews: 1,
+		PasswordHash: []byte{0xff, 0x01}}
+	err = storage.CreateSend(user, protected)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the send", err)
+	}
+	sends, err := storage.GetSends(user)
+	if err != nil || len(sends) != 2 {
+		t.Fatalf("unexpected sends %+v (%v)", sends, err)
+	}
+	for _, listed := range sends {
+		if listed.Content != "" || !listed.Expires.Equal(expires) || listed.Protected != (listed.Id == protected.Id) {
+			t.Errorf("unexpected send %+v", listed)
+		}
+	}
+	protection, err := storage.GetSendProtection(protected.Id)
+	if err != nil || string(protection.PasswordHash) != string(protected.PasswordHash) {
+		t.Errorf("unexpected protection %+v (%v)", protection, err)
+	}
+
+	// a right password takes its attempt back, the eleventh attempt finds no send
+	guessed := &Send{Id: newUUID(), Type: SendText, Content: "content", Expires: expires, MaxViews: 2, PasswordHash: []byte{0xff}}
+	err = storage.CreateSend(user, guessed)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the send", err)
+	}
+	for attempt := 1; attempt <= 11; attempt++ {
+		err = storage.CountSendAttempt(guessed.Id)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when counting attempt %d", err, attempt)
+		}
+		if attempt == 1 {
+			_, err = storage.ConsumeSend(guessed.Id)
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when consuming the send", err)
+			}
+		}
+	}
+	if err := storage.CountSendAttempt(guessed.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a guessed send, got %v", err)
+	}
+	if _, err := storage.GetSendProtection(guessed.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a guessed send, got %v", err)
+	}
+	if err := storage.CountSendAttempt(send.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for the attempt on an unprotected send, got %v", err)
+	}
+
+	for views := 1; views <= 2; views++ {
+		consumed, err := storage.ConsumeSend(send.Id)
+		if err != nil || consumed.Content != "content" || consumed.Views != views || consumed.Protected {
+			t.Fatalf("unexpected send %+v (%v)", consumed, err)
+		}
+	}
+	if _, err := storage.ConsumeSend(send.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a used up send, got %v", err)
+	}
+
+	_, err = db.Exec("UPDATE sends SET expires = $1 WHERE sendid = $2", time.Now().Add(-time.Minute), protected.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when backdating the send", err)
+	}
+	if _, err := storage.GetSendProtection(protected.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an expired send, got %v", err)
+	}
+	purged, err := storage.PurgeSends()
+	if err != nil || purged < 1 {
+		t.Errorf("unexpected number of purged sends %d (%v)", purged, err)
+	}
+	sends, _ = storage.GetSends(user)
+	if len(sends) != 0 {
+		t.Errorf("the sends were not deleted %+v", sends)
+	}
+
+	other := &Send{Id: newUUID(), Type: SendText, Content: "content", Expires: expires, MaxViews: 1}
+	err = storage.CreateSend(user, other)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the send", err)
+	}
+	err = storage.DeleteSend(user, other.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the send", err)
+	}
+	if err := storage.DeleteSend(user, other.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when deleting a send twice, got %v", err)
+	}
+}
+
+func testConformanceSync(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	folder := &Folder{Name: "work"}
+	err := storage.CreateFolder(user, folder)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a folder", err)
+	}
+	tag := &Tag{Name: "private"}
+	err = storage.CreateTag(user, tag)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a tag", err)
+	}
+	entry := newConformancePassword(t, storage, user, &Password{
+		Url: "https://example.com", Username: "john", Password: "secret", Folder: folder.Id, Tags: []string{tag.Id},
+	})
+	full, err := storage.GetSyncChanges(user, 0)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when syncing", err)
+	}
+	if !full.Full || full.Revision < 3 || len(full.Passwords) != 1 || len(full.Folders) != 1 || len(full.Tags) != 1 {
+		t.Errorf("unexpected full sync %+v", full)
+	}
+	if full.Passwords[0].Revision != full.Revision {
+		t.Errorf("the entry is at revision %d, the vault at %d", full.Passwords[0].Revision, full.Revision)
+	}
+
+	unchanged, err := storage.GetSyncChanges(user, full.Revision)
+	if err != nil || unchanged.Full || unchanged.Revision != full.Revision || len(unchanged.Passwords) != 0 || len(unchanged.Deleted) != 0 {
+		t.Errorf("unexpected changes %+v (%v)", unchanged, err)
+	}
+
+	err = storage.SetPasswordFavorite(user, entry.Id, true)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when changing the entry", err)
+	}
+	err = storage.DeleteTag(user, tag.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the tag", err)
+	}
+	changes, err := storage.GetSyncChanges(user, full.Revision)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when syncing", err)
+	}
+	if changes.Full || changes.Revision <= full.Revision || !equalStrings(passwordIds(changes.Passwords), []string{entry.Id}) ||
+		len(changes.Folders) != 0 || len(changes.Tags) != 0 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+	if len(changes.Deleted) != 1 || changes.Deleted[0].Type != "tag" || changes.Deleted[0].Id != tag.Id {
+		t.Errorf("unexpected deletions %+v", changes.Deleted)
+	}
+	access, err := storage.GetEntryAccess(user, entry.Id)
+	if err != nil || access.Revision != changes.Passwords[0].Revision {
+		t.Errorf("unexpected revision of the entry %+v (%v)", access, err)
+	}
+
+	_, err = db.Exec("UPDATE sync_deletions SET createdate = $1 WHERE uuid = $2", time.Now().AddDate(0, 0, -100), user.Uuid)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when backdating the deletions", err)
+	}
+	pruned, err := storage.PruneSyncDeletions(time.Now().AddDate(0, 0, -90))
+	if err != nil || pruned < 1 {
+		t.Errorf("unexpected number of pruned deletions %d (%v)", pruned, err)
+	}
+	changes, err = storage.GetSyncChanges(user, full.Revision)
+	if err != nil || !changes.Full || len(changes.Passwords) != 1 || len(changes.Tags) != 0 {
+		t.Errorf("a client behind the pruned deletions did not get a full sync %+v (%v)", changes, err)
+	}
+	changes, err = storage.GetSyncChanges(user, changes.Revision)
+	if err != nil || changes.Full {
+		t.Errorf("a client after the pruned deletions got a full sync %+v (%v)", changes, err)
+	}
+}
+
+func testConformanceHealth(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	secure := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	insecure := newConformancePassword(t, storage, user, &Password{
+		Url: "https://example.org", Username: "john", Password: "secret", Uris: PasswordUris{{Uri: "HTTP://login.example.org", Match: "host"}},
+	})
+	newConformancePassword(t, storage, user, &Password{Name: "note", Password: "text", Type: TypeNote})
+
+	err := storage.SetPasswordHealth(user, []*EntryHealth{{Id: secure.Id, Fingerprint: "print", Strength: 3}})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reporting the health", err)
+	}
+	err = storage.SetPasswordHealth(user, []*EntryHealth{{Id: insecure.Id, Fingerprint: "other", Strength: 1}, {Id: "0", Strength: 1}})
+	if err == nil {
+		t.Errorf("the report of an unknown entry was accepted")
+	}
+	records, err := storage.GetHealthRecords(user)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected health records %+v (%v)", records, err)
+	}
+	if records[0].Id != secure.Id || records[0].Fingerprint != "print" || records[0].Strength != 3 || records[0].Insecure {
+		t.Errorf("unexpected health record %+v", records[0])
+	}
+	if records[1].Id != insecure.Id || records[1].Fingerprint != "" || records[1].Strength != -1 || !records[1].Insecure {
+		t.Errorf("unexpected health record %+v", records[1])
+	}
+	if time.Since(records[0].Changed) > time.Hour || time.Since(records[0].Changed) < -time.Hour {
+		t.Errorf("unexpected change date %v", records[0].Changed)
+	}
+}
+
+func testConformancePasswordRules(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	for _, rule := range []*PasswordRule{
+		{Domain: "example.org", Rules: "minlength: 8;"},
+		{Domain: "example.com", Rules: "minlength: 12;"},
+		{Domain: "example.com", Rules: "minlength: 16;"},
+	} {
+		err := storage.SavePasswordRule(user, rule)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when saving the rule", err)
+		}
+	}
+	rules, err := storage.GetPasswordRules(user)
+	if err != nil || len(rules) != 2 || *rules[0] != (PasswordRule{Domain: "example.com", Rules: "minlength: 16;"}) {
+		t.Errorf("unexpected rules %+v (%v)", rules, err)
+	}
+	err = storage.DeletePasswordRule(user, "example.com")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the rule", err)
+	}
+	if err := storage.DeletePasswordRule(user, "example.com"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows when deleting a rule twice, got %v", err)
+	}
+	rules, _ = storage.GetPasswordRules(user)
+	if len(rules) != 1 || rules[0].Domain != "example.org" {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+}
+
+func testConformanceBreaches(t *testing.T, storage StorageInterface, db *sql.DB) {
+	mail := "breached-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "@example.com"
+	user := newConformanceUser(t, storage, " "+strings.ToUpper(mail))
+	entry := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "Breached-Entry", Password: "secret"})
+	reported := newConformancePassword(t, storage, user, &Password{Url: "https://example.org", Username: "v1.encrypted", Password: "secret"})
+	newConformancePassword(t, storage, user, &Password{Url: "https://example.net", Username: "safe", Password: "secret"})
+	err := storage.SetPasswordHealth(user, []*EntryHealth{{Id: reported.Id, Fingerprint: "print", Strength: 3, Identifier: sha256Hex("reported")}})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when reporting the identifier", err)
+	}
+
+	name := "conformance-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	date := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	breach := &Breach{Name: name, Title: "Example", Domain: "example.com", Date: date, DataClasses: []string{"Email addresses", "Passwords"}}
+	importBreach := ImportBreach
+	if isSQLite(db) {
+		importBreach = ImportSQLiteBreach
+	}
+	err = importBreach(db, breach, []string{sha256Hex(mail), sha256Hex("breached-entry"), sha256Hex("reported"), sha256Hex(mail)})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when importing the breach", err)
+	}
+	flagged, err := storage.FlagBreachedAccounts()
+	if err != nil || flagged < 3 {
+		t.Errorf("unexpected number of flagged accounts %d (%v)", flagged, err)
+	}
+	// findings are recorded once
+	flagged, err = storage.FlagBreachedAccounts()
+	if err != nil || flagged != 0 {
+		t.Errorf("unexpected number of flagged accounts %d (%v)", flagged, err)
+	}
+
+	breaches, err := storage.GetBreaches(user)
+	if err != nil || len(breaches) != 1 {
+		t.Fatalf("unexpected breaches %+v (%v)", breaches, err)
+	}
+	if breaches[0].Name != name || !breaches[0].Date.Equal(date) || !equalStrings(breaches[0].DataClasses, breach.DataClasses) {
+		t.Errorf("unexpected breach %+v", breaches[0])
+	}
+	accounts := breaches[0].Accounts
+	if len(accounts) != 3 || accounts[0].Account != BreachAccountMail {
+		t.Fatalf("unexpected accounts %+v", accounts)
+	}
+	ids := []string{accounts[1].Id, accounts[2].Id}
+	sort.Strings(ids)
+	expected := []string{entry.Id, reported.Id}
+	sort.Strings(expected)
+	if !equalStrings(ids, expected) || accounts[1].Account != BreachAccountEntry {
+		t.Errorf("unexpected accounts %+v %+v", accounts[1], accounts[2])
+	}
+
+	// deleted entries are not listed
+	err = storage.DeletePassword(user, "https://example.com", "Breached-Entry")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the entry", err)
+	}
+	breaches, _ = storage.GetBreaches(user)
+	if len(breaches) != 1 || len(breaches[0].Accounts) != 2 {
+		t.Errorf("unexpected breaches %+v", breaches)
+	}
+}
+
+func sha256Hex(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func testConformanceExports(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	for _, format := range []string{"csv", "json"} {
+		record := &ExportRecord{Format: format, Entries: 3, Address: "127.0.0.1", UserAgent: "test"}
+		err := storage.RecordExport(user, record)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when recording the export", err)
+		}
+		if record.Id == "" || record.Created == nil {
+			t.Errorf("unexpected record %+v", record)
+		}
+	}
+	exports, err := storage.GetExports(user)
+	if err != nil || len(exports) != 2 || exports[0].Entries != 3 || exports[0].Created == nil {
+		t.Errorf("unexpected exports %+v (%v)", exports, err)
+	}
+}
+
+func testConformanceAttachments(t *testing.T, storage StorageInterface, db *sql.DB) {
+	user := newConformanceUser(t, storage, "")
+	other := newConformanceUser(t, storage, "")
+	entry := newConformancePassword(t, storage, user, &Password{Url: "https://example.com", Username: "john", Password: "secret"})
+	attachment := &Attachment{Entry: entry.Id, FileName: "key.pem", Size: 2048}
+	err := storage.CreateAttachment(user, attachment, 3000)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating the attachment", err)
+	}
+	if attachment.Id == "" || attachment.Size != 2048 || time.Since(attachment.Created) > time.Hour {
+		t.Errorf("unexpected attachment %+v", attachment)
+	}
+	if err := storage.CreateAttachment(other, &Attachment{Entry: entry.Id, FileName: "key.pem", Size: 2048}, 3000); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for the entry of another user, got %v", err)
+	}
+	// the reservation of the first attachment counts until its size is known
+	reduced := &Attachment{Entry: entry.Id, FileName: "key.pub", Size: 2048}
+	err = storage.CreateAttachment(user, reduced, 3000)
+	if err != nil || reduced.Size != 952 {
+		t.Errorf("unexpected reservation %+v (%v)", reduced, err)
+	}
+	if err := storage.CreateAttachment(user, &Attachment{Entry: entry.Id, FileName: "key.crt", Size: 2048}, 3000); err != errAttachmentQuota {
+		t.Errorf("expected errAttachmentQuota for an exhausted quota, got %v", err)
+	}
+	err = storage.DeleteAttachment(user, reduced.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the attachment", err)
+	}
+	err = storage.UpdateAttachmentSize(user, attachment.Id, 1024)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when updating the size", err)
+	}
+	attachments, err := storage.GetAttachments(user, entry.Id)
+	if err != nil || len(attachments) != 1 || attachments[0].Size != 1024 || attachments[0].FileName != "key.pem" {
+		t.Errorf("unexpected attachments %+v (%v)", attachments, err)
+	}
+	found, err := storage.GetAttachment(user, attachment.Id)
+	if err != nil || found.Entry != entry.Id {
+		t.Errorf("unexpected attachment %+v (%v)", found, err)
+	}
+	usage, err := storage.GetAttachmentUsage(user)
+	if err != nil || usage != 1024 {
+		t.Errorf("unexpected usage %d (%v)", usage, err)
+	}
+	ids, err := storage.GetAttachmentIds()
+	if err != nil || !containsString(ids, attachment.Id) {
+		t.Errorf("unexpected attachment ids %v (%v)", ids, err)
+	}
+	err = storage.DeleteAttachment(user, attachment.Id)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when deleting the attachment", err)
+	}
+	if _, err := storage.GetAttachment(user, attachment.Id); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for a deleted attachment, got %v", err)
+	}
+}
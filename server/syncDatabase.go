@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// syncQueries list the entries, the folders and the deletion markers of the user $1 changed after the revision $2
+type syncQueries struct {
+	passwords string
+	folders   string
+	deletions string
+}
+
+var postgresSyncQueries = syncQueries{
+	passwords: "SELECT " + passwordColumns + " FROM " + passwordTables +
+		" WHERE p.uuid = $1 AND p.revision > $2 GROUP BY p.entryid ORDER BY p.revision, p.entryid",
+	folders: "SELECT folderid, name, COALESCE(parentid::text, ''), revision FROM folders " +
+		"WHERE uuid = $1 AND revision > $2 ORDER BY revision, folderid",
+	deletions: "SELECT kind, itemid::text, revision FROM sync_deletions WHERE uuid = $1 AND revision > $2 " +
+		"ORDER BY revision",
+}
+
+// QuerySyncChanges reads the changes of the vault of the user after the revision since from one snapshot.
+// If the deletion markers after since have been pruned, or since is unknown, everything is listed as a full sync.
+func QuerySyncChanges(db *sql.DB, user *User, since int64) (changes *SyncChanges, err error) {
+	return querySyncChanges(db, user, since, postgresSyncQueries)
+}
+
+func querySyncChanges(db *sql.DB, user *User, since int64, queries syncQueries) (changes *SyncChanges, err error) {
+	// begin new statement, the revision and the changes are read from the same snapshot
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	changes = &SyncChanges{}
+	var pruned int64
+	err = tx.QueryRow("SELECT revision, syncpruned FROM users WHERE uuid = $1", user.Uuid).Scan(&changes.Revision, &pruned)
+	if err != nil {
+		return nil, err
+	}
+	if since <= 0 || since < pruned || since > changes.Revision {
+		// rows written before the revisions were introduced have the revision 0
+		changes.Full = true
+		since = -1
+	}
+	changes.Passwords, err = querySyncPasswords(tx, queries.passwords, user, since)
+	if err != nil {
+		return nil, err
+	}
+	changes.Folders, err = querySyncFolders(tx, queries.folders, user, since)
+	if err != nil {
+		return nil, err
+	}
+	changes.Tags, err = querySyncTags(tx, user, since)
+	if err != nil {
+		return nil, err
+	}
+	if !changes.Full {
+		changes.Deleted, err = querySyncDeletions(tx, queries.deletions, user, since)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// end query
+	return changes, tx.Commit()
+}
+
+// querySyncPasswords lists the entries owned by the user, including the ones in the trash and in collections
+func querySyncPasswords(tx *sql.Tx, query string, user *User, since int64) (passwords []*Password, err error) {
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, since)
+	if err != nil {
+		return nil, err
+	}
+	return scanPasswords(rows)
+}
+
+func querySyncFolders(tx *sql.Tx, query string, user *User, since int64) (folders []*Folder, err error) {
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		folder := &Folder{}
+		err = rows.Scan(&folder.Id, &folder.Name, &folder.Parent, &folder.Revision)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+func querySyncTags(tx *sql.Tx, user *User, since int64) (tags []*Tag, err error) {
+	// prepare statement
+	stmt, err := tx.Prepare("SELECT tagid, name, revision FROM tags WHERE uuid = $1 AND revision > $2 ORDER BY revision, tagid")
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tag := &Tag{}
+		err = rows.Scan(&tag.Id, &tag.Name, &tag.Revision)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func querySyncDeletions(tx *sql.Tx, query string, user *User, since int64) (deletions []*SyncDeletion, err error) {
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	rows, err := stmt.Query(user.Uuid, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		deletion := &SyncDeletion{}
+		err = rows.Scan(&deletion.Type, &deletion.Id, &deletion.Revision)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, rows.Err()
+}
+
+// PruneSyncDeletions deletes the markers created before the given time. Clients which synced before the
+// latest pruned marker of their user get a full sync.
+func PruneSyncDeletions(db *sql.DB, before time.Time) (pruned int64, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("WITH pruned AS (DELETE FROM sync_deletions WHERE createdate < $1 RETURNING uuid, revision), " +
+		"latest AS (SELECT uuid, max(revision) AS revision, count(*) AS markers FROM pruned GROUP BY uuid), " +
+		"updated AS (UPDATE users u SET syncpruned = l.revision FROM latest l WHERE u.uuid = l.uuid AND u.syncpruned < l.revision) " +
+		"SELECT COALESCE(sum(markers), 0) FROM latest")
+	if err != nil {
+		return 0, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(before).Scan(&pruned)
+	if err != nil {
+		return 0, err
+	}
+	// end query
+	return pruned, tx.Commit()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GetSync lists what changed in the vault of the user after the revision given as since.
+// Clients keep the returned revision and pass it on the next sync.
+func (handler CRUDHandler) GetSync(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var since int64
+	if value := request.URL.Query().Get("since"); value != "" {
+		since, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(writer, "since has to be a revision", http.StatusBadRequest)
+			return
+		}
+	}
+	changes, err := handler.storage.GetSyncChanges(user, since)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changesJson, err := json.Marshal(changes)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_, _ = fmt.Fprint(writer, string(changesJson))
+}
+
+// pruneSyncDeletions deletes the deletion markers which are older than the retention
+func pruneSyncDeletions(storage StorageInterface, retention time.Duration) error {
+	pruned, err := storage.PruneSyncDeletions(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		fmt.Printf("Pruned %d sync deletion markers\n", pruned)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// expectSyncQueries mocks the snapshot of the revision and the changes after since
+func expectSyncQueries(mock sqlmock.Sqlmock, revision int64, pruned int64, since int64, deletions bool) {
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revision, syncpruned FROM users").WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "syncpruned"}).AddRow(revision, pruned))
+	mock.ExpectPrepare("SELECT (.+) FROM passwds").
+		ExpectQuery().WithArgs([]byte("USERID"), since).
+		WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+			AddRow(1, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 41, "USERID"))
+	mock.ExpectPrepare("SELECT (.+) FROM folders").
+		ExpectQuery().WithArgs([]byte("USERID"), since).
+		WillReturnRows(sqlmock.NewRows([]string{"folderid", "name", "parentid", "revision"}).AddRow("2", "work", "", 40))
+	mock.ExpectPrepare("SELECT (.+) FROM tags").
+		ExpectQuery().WithArgs([]byte("USERID"), since).
+		WillReturnRows(sqlmock.NewRows([]string{"tagid", "name", "revision"}))
+	if deletions {
+		mock.ExpectPrepare("SELECT (.+) FROM sync_deletions").
+			ExpectQuery().WithArgs([]byte("USERID"), since).
+			WillReturnRows(sqlmock.NewRows([]string{"kind", "itemid", "revision"}).AddRow("entry", "3", 42))
+	}
+	mock.ExpectCommit()
+}
+
+func TestCRUDHandler_GetSync(t *testing.T) {
+	req, err := http.NewRequest("GET", "/sync?since=39", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	expectSyncQueries(mock, 42, 10, 39, true)
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetSync)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"revision":42,"full":false,"passwords":[{"password":"password","id":"1","url":"john.doe","username":"johndoe",` +
+		`"type":"login","created":"2020-05-01T12:00:00Z","match":"domain","revision":41}],"folders":[{"id":"2","name":"work","revision":40}],` +
+		`"tags":[],"deleted":[{"type":"entry","id":"3","revision":42}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCRUDHandler_GetSyncAfterPruning(t *testing.T) {
+	req, err := http.NewRequest("GET", "/sync?since=5", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	// the markers up to revision 10 are gone, everything is listed without markers
+	expectSyncQueries(mock, 42, 10, -1, false)
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetSync)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Check the response body is what we expect.
+	expected := `{"revision":42,"full":true,"passwords":[{"password":"password","id":"1","url":"john.doe","username":"johndoe",` +
+		`"type":"login","created":"2020-05-01T12:00:00Z","match":"domain","revision":41}],"folders":[{"id":"2","name":"work","revision":40}],` +
+		`"tags":[],"deleted":[]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
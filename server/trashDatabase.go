@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+func QueryTrash(db *sql.DB, user *User) (passwords []*Password, err error) {
+	return queryTrash(db, user, "SELECT "+passwordColumns+" FROM "+passwordTables+
+		" WHERE p.uuid = $1 AND p.deletedate IS NOT NULL GROUP BY p.entryid ORDER BY p.deletedate DESC")
+}
+
+// queryTrash reads the trashed entries of the user $1 with the query
+func queryTrash(db *sql.DB, user *User, query string) (passwords []*Password, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	passwords, err = scanPasswords(rows)
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func RestorePassword(db *sql.DB, user *User, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE passwds SET deletedate = NULL WHERE entryid = $1 AND uuid = $2 AND deletedate IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func EmptyTrash(db *sql.DB, user *User) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM passwds WHERE uuid = $1 AND deletedate IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes the passwords of all users which were moved into the trash before the given time
+func PurgeTrash(db *sql.DB, deletedBefore time.Time) (purged int64, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM passwds WHERE deletedate < $1")
+	if err != nil {
+		return 0, err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	res, err := stmt.Exec(deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	purged, err = res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// end query
+	return purged, tx.Commit()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type TrashRequest struct {
+	Id string `json:"id"`
+}
+
+func (handler CRUDHandler) GetTrash(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	passwords, err := handler.storage.GetTrash(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, passwords)
+}
+
+func (handler CRUDHandler) RestorePassword(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var trashMsg TrashRequest
+	err = json.Unmarshal(b, &trashMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntry(user, trashMsg.Id, ActionWrite)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.RestorePassword(owner, trashMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("RESTORED", "", writer)
+}
+
+func (handler CRUDHandler) EmptyTrash(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.EmptyTrash(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
+
+// purgeTrash permanently deletes all passwords which have been in the trash for longer than the retention
+func purgeTrash(storage StorageInterface, retention time.Duration) error {
+	purged, err := storage.PurgeTrash(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		fmt.Printf("Purged %d passwords from the trash\n", purged)
+	}
+	return nil
+}
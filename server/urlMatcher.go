@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Strategies deciding whether an uri of an entry matches the url of the page which should be filled
+const (
+	MatchDomain     = "domain"
+	MatchHost       = "host"
+	MatchHostPort   = "hostport"
+	MatchStartsWith = "startswith"
+	MatchRegex      = "regex"
+	MatchNever      = "never"
+)
+
+var matchStrategies = map[string]bool{
+	MatchDomain: true, MatchHost: true, MatchHostPort: true, MatchStartsWith: true, MatchRegex: true, MatchNever: true,
+}
+
+// Scores of the different kinds of matches, better matches are ranked first
+const (
+	scoreEquivalentDomain = 40
+	scoreDomain           = 50
+	scoreHost             = 60
+	scoreHostPort         = 70
+	scoreStartsWith       = 80
+	scoreRegex            = 90
+	scoreExact            = 100
+)
+
+// PublicSuffixList knows the rules of the public suffix list, see https://publicsuffix.org/list/
+type PublicSuffixList struct {
+	rules      map[string]bool
+	wildcards  map[string]bool
+	exceptions map[string]bool
+}
+
+func NewPublicSuffixList(reader io.Reader) (*PublicSuffixList, error) {
+	list := &PublicSuffixList{
+		rules:      make(map[string]bool),
+		wildcards:  make(map[string]bool),
+		exceptions: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// only the first word of a line is part of the rule
+		line = strings.ToLower(strings.Fields(line)[0])
+		if strings.HasPrefix(line, "!") {
+			list.exceptions[line[1:]] = true
+		} else if strings.HasPrefix(line, "*.") {
+			list.wildcards[line[2:]] = true
+		} else {
+			list.rules[line] = true
+		}
+	}
+	return list, scanner.Err()
+}
+
+func LoadPublicSuffixList(path string) (*PublicSuffixList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return NewPublicSuffixList(file)
+}
+
+// RegistrableDomain returns the public suffix of the host plus one label, e.g. example.co.uk for www.example.co.uk.
+// IP addresses and hosts which are public suffixes themselves are returned unchanged.
+func (list *PublicSuffixList) RegistrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	labels := strings.Split(host, ".")
+	// without a matching rule the last label is the public suffix
+	suffixLength := 1
+	for i := range labels {
+		suffix := strings.Join(labels[i:], ".")
+		if list.exceptions[suffix] {
+			suffixLength = len(labels) - i - 1
+			break
+		}
+		if list.rules[suffix] || (i+1 < len(labels) && list.wildcards[strings.Join(labels[i+1:], ".")]) {
+			suffixLength = len(labels) - i
+			break
+		}
+	}
+	if suffixLength >= len(labels) {
+		return host
+	}
+	return strings.Join(labels[len(labels)-suffixLength-1:], ".")
+}
+
+// UrlMatcher ranks the entries of a user against the url of the page which should be filled
+type UrlMatcher struct {
+	suffixes *PublicSuffixList
+}
+
+func NewUrlMatcher(suffixes *PublicSuffixList) *UrlMatcher {
+	if suffixes == nil {
+		suffixes, _ = NewPublicSuffixList(strings.NewReader(""))
+	}
+	return &UrlMatcher{suffixes: suffixes}
+}
+
+// parseUri accepts uris without scheme like they are stored by the dashboard
+func parseUri(uri string) (*url.URL, error) {
+	uri = strings.TrimSpace(uri)
+	if !strings.Contains(uri, "://") {
+		uri = "https://" + uri
+	}
+	return url.Parse(uri)
+}
+
+func effectivePort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Port()
+	}
+	switch u.Scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// Domain returns the registrable domain of the uri or an empty string if it has no host
+func (matcher *UrlMatcher) Domain(uri string) string {
+	u, err := parseUri(uri)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return matcher.suffixes.RegistrableDomain(u.Hostname())
+}
+
+// Domains returns the registrable domain of the url together with all domains configured as equivalent to it
+func (matcher *UrlMatcher) Domains(target string, equivalents []*EquivalentDomains) []string {
+	domain := matcher.Domain(target)
+	if domain == "" {
+		return nil
+	}
+	domains := []string{domain}
+	for _, group := range equivalents {
+		if !containsString(group.Domains, domain) {
+			continue
+		}
+		for _, equivalent := range group.Domains {
+			if !containsString(domains, equivalent) {
+				domains = append(domains, equivalent)
+			}
+		}
+	}
+	return domains
+}
+
+// Score rates how well the uri matches the target url using the strategy, zero means no match
+func (matcher *UrlMatcher) Score(uri string, strategy string, target string, equivalents []string) int {
+	switch strategy {
+	case MatchNever:
+		return 0
+	case MatchRegex:
+		expression, err := regexp.Compile(uri)
+		if err != nil || !expression.MatchString(target) {
+			return 0
+		}
+		return scoreRegex
+	}
+	entryUrl, err := parseUri(uri)
+	if err != nil || entryUrl.Hostname() == "" {
+		return 0
+	}
+	targetUrl, err := parseUri(target)
+	if err != nil || targetUrl.Hostname() == "" {
+		return 0
+	}
+	if strategy == MatchStartsWith {
+		if !strings.HasPrefix(targetUrl.String(), entryUrl.String()) {
+			return 0
+		}
+		if targetUrl.String() == entryUrl.String() {
+			return scoreExact
+		}
+		return scoreStartsWith
+	}
+	sameHost := strings.EqualFold(entryUrl.Hostname(), targetUrl.Hostname())
+	score := 0
+	if sameHost && effectivePort(entryUrl) == effectivePort(targetUrl) {
+		score = scoreHostPort
+		if targetUrl.String() == entryUrl.String() {
+			score = scoreExact
+		} else if strings.Trim(entryUrl.Path, "/") != "" && strings.HasPrefix(targetUrl.String(), entryUrl.String()) {
+			// a bare host is a prefix of every url on it, only paths make the match more specific
+			score = scoreStartsWith
+		}
+	} else if sameHost {
+		score = scoreHost
+	} else {
+		domain := matcher.suffixes.RegistrableDomain(entryUrl.Hostname())
+		if domain == matcher.suffixes.RegistrableDomain(targetUrl.Hostname()) {
+			score = scoreDomain
+		} else if containsString(equivalents, domain) {
+			score = scoreEquivalentDomain
+		}
+	}
+	switch strategy {
+	case MatchHostPort:
+		if score < scoreHostPort {
+			return 0
+		}
+	case MatchHost:
+		if score < scoreHost {
+			return 0
+		}
+	case MatchDomain, "":
+	default:
+		return 0
+	}
+	return score
+}
+
+// Rank scores all uris of the entries against the target and returns the matching entries, best matches first.
+// Equally good matches put favorites and frequently used entries first.
+func (matcher *UrlMatcher) Rank(target string, passwords []*Password, equivalents []string) []*Password {
+	ranked := make([]*Password, 0)
+	for _, password := range passwords {
+		password.Score = matcher.Score(password.Url, password.Match, target, equivalents)
+		for _, uri := range password.Uris {
+			if score := matcher.Score(uri.Uri, uri.Match, target, equivalents); score > password.Score {
+				password.Score = score
+			}
+		}
+		if password.Score > 0 {
+			ranked = append(ranked, password)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if ranked[i].Favorite != ranked[j].Favorite {
+			return ranked[i].Favorite
+		}
+		return ranked[i].UseCount > ranked[j].UseCount
+	})
+	return ranked
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
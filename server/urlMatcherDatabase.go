@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/lib/pq"
+)
+
+func insertPasswordUris(tx *sql.Tx, id string, uris []PasswordUri) error {
+	if len(uris) == 0 {
+		return nil
+	}
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO passwd_uris (entryid, uri, match) VALUES ($1, $2, $3)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, uri := range uris {
+		_, err = stmt.Exec(id, uri.Uri, uri.Match)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func UpdatePasswordUris(db *sql.DB, user *User, id string, match string, uris []PasswordUri) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE passwds SET match = $1 WHERE entryid = $2 AND uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, match, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// the entry belongs to the user, so its uris can be replaced
+	_, err = tx.Exec("DELETE FROM passwd_uris WHERE entryid = $1", id)
+	if err != nil {
+		return err
+	}
+	err = insertPasswordUris(tx, id, uris)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func QueryEquivalentDomains(db *sql.DB, user *User) (groups []*EquivalentDomains, err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// prepare statement
+	stmt, err := db.Prepare("SELECT groupid, domains FROM equivalent_domains WHERE uuid = $1 ORDER BY groupid")
+	if err != nil {
+		return nil, err
+	}
+	// execute statement
+	rows, err := stmt.Query(user.Uuid)
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		group := &EquivalentDomains{}
+		err = rows.Scan(&group.Id, pq.Array(&group.Domains))
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// end query
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func CreateEquivalentDomains(db *sql.DB, user *User, group *EquivalentDomains) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("INSERT INTO equivalent_domains (uuid, domains) VALUES ($1, $2) RETURNING groupid")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = stmt.QueryRow(user.Uuid, pq.Array(group.Domains)).Scan(&group.Id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func UpdateEquivalentDomains(db *sql.DB, user *User, group *EquivalentDomains) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE equivalent_domains SET domains = $1 WHERE groupid = $2 AND uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, pq.Array(group.Domains), group.Id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+func DeleteEquivalentDomains(db *sql.DB, user *User, id string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("DELETE FROM equivalent_domains WHERE groupid = $1 AND uuid = $2")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
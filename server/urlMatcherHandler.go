@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type PasswordUrisRequest struct {
+	Id    string        `json:"id"`
+	Match string        `json:"match"`
+	Uris  []PasswordUri `json:"uris"`
+}
+
+type EquivalentDomainsRequest struct {
+	Id      string   `json:"id"`
+	Domains []string `json:"domains"`
+}
+
+// checkPasswordUris validates the match strategies of an entry and returns the strategy of its main url
+func checkPasswordUris(match string, uris []PasswordUri) (string, error) {
+	if match == "" {
+		match = MatchDomain
+	}
+	if !matchStrategies[match] {
+		return "", errors.New("unknown match " + match)
+	}
+	for i := range uris {
+		if uris[i].Match == "" {
+			uris[i].Match = MatchDomain
+		}
+		if !matchStrategies[uris[i].Match] {
+			return "", errors.New("unknown match " + uris[i].Match)
+		}
+		if uris[i].Uri == "" {
+			return "", errors.New("uri must not be empty")
+		}
+		if uris[i].Match == MatchRegex {
+			if _, err := regexp.Compile(uris[i].Uri); err != nil {
+				return "", err
+			}
+		}
+	}
+	return match, nil
+}
+
+// normalizeDomains reduces all domains of a group to their registrable domain
+func (handler CRUDHandler) normalizeDomains(domains []string) ([]string, error) {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		registrable := handler.matcher.Domain(strings.TrimSpace(domain))
+		if registrable == "" {
+			return nil, errors.New("invalid domain " + domain)
+		}
+		if !containsString(normalized, registrable) {
+			normalized = append(normalized, registrable)
+		}
+	}
+	if len(normalized) < 2 {
+		return nil, errors.New("a group needs at least two different domains")
+	}
+	return normalized, nil
+}
+
+func (handler CRUDHandler) SetPasswordUris(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var urisMsg PasswordUrisRequest
+	err = json.Unmarshal(b, &urisMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	match, err := checkPasswordUris(urisMsg.Match, urisMsg.Uris)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, urisMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordUris(owner, urisMsg.Id, match, urisMsg.Uris)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+func (handler CRUDHandler) GetEquivalentDomains(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups, err := handler.storage.GetEquivalentDomains(user)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendListing(writer, request, groups)
+}
+
+func (handler CRUDHandler) CreateEquivalentDomains(writer http.ResponseWriter, request *http.Request) {
+	handler.saveEquivalentDomains(writer, request, true)
+}
+
+func (handler CRUDHandler) UpdateEquivalentDomains(writer http.ResponseWriter, request *http.Request) {
+	handler.saveEquivalentDomains(writer, request, false)
+}
+
+func (handler CRUDHandler) saveEquivalentDomains(writer http.ResponseWriter, request *http.Request, create bool) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var groupMsg EquivalentDomainsRequest
+	err = json.Unmarshal(b, &groupMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	domains, err := handler.normalizeDomains(groupMsg.Domains)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group := &EquivalentDomains{
+		Id:      groupMsg.Id,
+		Domains: domains,
+	}
+	if create {
+		err = handler.storage.CreateEquivalentDomains(user, group)
+	} else {
+		err = handler.storage.UpdateEquivalentDomains(user, group)
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if create {
+		sendCRUDAnswer("CREATED", "", writer)
+	} else {
+		sendCRUDAnswer("UPDATED", "", writer)
+	}
+}
+
+func (handler CRUDHandler) RemoveEquivalentDomains(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var groupMsg EquivalentDomainsRequest
+	err = json.Unmarshal(b, &groupMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = handler.storage.DeleteEquivalentDomains(user, groupMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
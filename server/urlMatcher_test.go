@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublicSuffixList_RegistrableDomain(t *testing.T) {
+	suffixes, err := LoadPublicSuffixList("public_suffix_list.dat")
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when loading the public suffix list", err)
+	}
+	tests := map[string]string{
+		"example.com":             "example.com",
+		"www.example.com":         "example.com",
+		"login.www.example.co.uk": "example.co.uk",
+		"john.github.io":          "john.github.io",
+		"www.ck":                  "www.ck",
+		"a.b.example.ck":          "b.example.ck",
+		"co.uk":                   "co.uk",
+		"192.168.0.1":             "192.168.0.1",
+		"john.doe":                "john.doe",
+	}
+	for host, expected := range tests {
+		if domain := suffixes.RegistrableDomain(host); domain != expected {
+			t.Errorf("unexpected registrable domain of %s: got %v want %v", host, domain, expected)
+		}
+	}
+}
+
+func TestUrlMatcher_Score(t *testing.T) {
+	suffixes, _ := NewPublicSuffixList(strings.NewReader("com\nco.uk\n"))
+	matcher := NewUrlMatcher(suffixes)
+	target := "https://login.example.com/auth?next=/"
+	tests := []struct {
+		uri      string
+		strategy string
+		expected int
+	}{
+		{"example.com", MatchDomain, scoreDomain},
+		{"https://login.example.com", MatchDomain, scoreHostPort},
+		{"https://login.example.com/auth", MatchDomain, scoreStartsWith},
+		{"https://login.example.com/auth?next=/", MatchHost, scoreExact},
+		{"example.com", MatchHost, 0},
+		{"login.example.com", MatchHost, scoreHostPort},
+		{"http://login.example.com", MatchHost, scoreHost},
+		{"http://login.example.com", MatchHostPort, 0},
+		{"https://login.example.com:8443", MatchHostPort, 0},
+		{"https://login.example.com/au", MatchStartsWith, scoreStartsWith},
+		{"https://login.example.com/other", MatchStartsWith, 0},
+		{`^https://[a-z]+\.example\.com/`, MatchRegex, scoreRegex},
+		{`^https://example\.com/`, MatchRegex, 0},
+		{"login.example.com", MatchNever, 0},
+		{"example.co.uk", MatchDomain, 0},
+	}
+	for _, test := range tests {
+		if score := matcher.Score(test.uri, test.strategy, target, nil); score != test.expected {
+			t.Errorf("unexpected score of %s with %s: got %v want %v", test.uri, test.strategy, score, test.expected)
+		}
+	}
+	if score := matcher.Score("example.co.uk", MatchDomain, target, []string{"example.com", "example.co.uk"}); score != scoreEquivalentDomain {
+		t.Errorf("unexpected score of an equivalent domain: got %v want %v", score, scoreEquivalentDomain)
+	}
+}
+
+func TestUrlMatcher_Rank(t *testing.T) {
+	matcher := NewUrlMatcher(nil)
+	passwords := []*Password{
+		{Id: "1", Url: "example.com", Match: MatchDomain},
+		{Id: "2", Url: "other.com", Match: MatchDomain, Uris: PasswordUris{{Uri: "https://www.example.com", Match: MatchHost}}},
+		{Id: "3", Url: "www.example.com", Match: MatchNever},
+	}
+	ranked := matcher.Rank("https://www.example.com/login", passwords, nil)
+	if len(ranked) != 2 || ranked[0].Id != "2" || ranked[1].Id != "1" {
+		t.Errorf("unexpected ranking: got %v", ranked)
+	}
+}
+
+func TestCRUDHandler_GetPasswordByUrl(t *testing.T) {
+	req, err := http.NewRequest("GET", "/password-by-url?url=https%3A%2F%2Fwww.john.doe%2Flogin", nil)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when creating a request", err)
+	}
+	if req.Form == nil {
+		req.Form = make(map[string][]string)
+	}
+	req.Form.Add("UserId", string("USERID"))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM users").
+		ExpectQuery().WithArgs("USERID").
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "name", "mail", "masterpasswd"}).
+			AddRow("USERID", "john", "@", "password"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM equivalent_domains").
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"groupid", "domains"}).
+		AddRow(1, "{john.doe,doe.john}"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT (.+) FROM passwds (.+) ILIKE ANY").
+		ExpectQuery().WithArgs(sqlmock.AnyArg(), "{\"%john.doe%\",\"%doe.john%\"}").
+		WillReturnRows(sqlmock.NewRows(passwordColumnNames).
+			AddRow(1, "doe.john", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID").
+			AddRow(2, "john.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain", "[]", false, 0, nil, nil, 0, nil, "", 0, "USERID").
+			AddRow(3, "other.doe", "password", "johndoe", "", "{}", "", "login", passwordCreated, nil, "domain",
+				`[{"uri": "https://www.john.doe/login", "match": "startswith"}]`, false, 0, nil, nil, 0, nil, "", 0, "USERID"))
+	mock.ExpectCommit()
+	mock.ExpectPrepare("SELECT (.+) FROM password_rules").
+		ExpectQuery().WithArgs([]byte("USERID")).
+		WillReturnRows(sqlmock.NewRows([]string{"domain", "rules"}).
+			AddRow("john.doe", "minlength: 8; maxlength: 16;"))
+
+	// Set global values to mocked one
+	initFromDatabaseAndRouter(db)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(crudHandler.GetPasswordByUrl)
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if rules := rr.Header().Get(passwordRulesHeader); rules != "minlength: 8; maxlength: 16;" {
+		t.Errorf("handler returned unexpected password rules: got %v", rules)
+	}
+
+	// Check the response body is what we expect.
+	expected := `[{"password":"password","id":"3","url":"other.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain","uris":[{"uri":"https://www.john.doe/login","match":"startswith"}],"score":100},` +
+		`{"password":"password","id":"2","url":"john.doe","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain","score":50},` +
+		`{"password":"password","id":"1","url":"doe.john","username":"johndoe","type":"login","created":"2020-05-01T12:00:00Z","match":"domain","score":40}]`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUrlMatcher_RankByUsage(t *testing.T) {
+	matcher := NewUrlMatcher(nil)
+	passwords := []*Password{
+		{Id: "1", Url: "example.com", UseCount: 2},
+		{Id: "2", Url: "example.com", UseCount: 9},
+		{Id: "3", Url: "example.com", Favorite: true},
+	}
+	ranked := matcher.Rank("https://example.com", passwords, nil)
+	if len(ranked) != 3 || ranked[0].Id != "3" || ranked[1].Id != "2" || ranked[2].Id != "1" {
+		t.Errorf("unexpected ranking: got %v", ranked)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+)
+
+func UpdatePasswordFavorite(db *sql.DB, user *User, id string, favorite bool) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare("UPDATE passwds SET favorite = $1 WHERE entryid = $2 AND uuid = $3")
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	err = execAffectingRows(stmt, favorite, id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// RecordPasswordUsage counts a fill of the entry reported by a client, every user who can read the entry has an own usage
+func RecordPasswordUsage(db *sql.DB, user *User, id string) (err error) {
+	return recordPasswordUsage(db, user, id, "INSERT INTO passwd_usage (entryid, uuid, lastused, usecount) VALUES ($1, $2, CURRENT_TIMESTAMP, 1) "+
+		"ON CONFLICT (entryid, uuid) DO UPDATE SET lastused = CURRENT_TIMESTAMP, usecount = passwd_usage.usecount + 1")
+}
+
+// recordPasswordUsage counts the fill of the entry $1 by the user $2 with the query
+func recordPasswordUsage(db *sql.DB, user *User, id string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement
+	_, err = stmt.Exec(id, user.Uuid)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
+
+// DeletePasswordUsage forgets the usage of the user, without id of all entries
+func DeletePasswordUsage(db *sql.DB, user *User, id string) (err error) {
+	return deletePasswordUsage(db, user, id, "DELETE FROM passwd_usage WHERE uuid = $1 AND ($2 = '' OR entryid = NULLIF($2, '')::integer)")
+}
+
+// deletePasswordUsage forgets the usage of the user $1 of the entry $2 with the query
+func deletePasswordUsage(db *sql.DB, user *User, id string, query string) (err error) {
+	// begin new statement
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// prepare statement
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	// close connection and connection once query is executed
+	defer stmt.Close()
+	// execute statement, an entry which was never used has no usage to forget
+	_, err = stmt.Exec(user.Uuid, id)
+	if err != nil {
+		return err
+	}
+	// end query
+	return tx.Commit()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+type PasswordFavoriteRequest struct {
+	Id       string `json:"id"`
+	Favorite bool   `json:"favorite"`
+}
+
+type PasswordUsageRequest struct {
+	Id string `json:"id"`
+}
+
+func (handler CRUDHandler) SetPasswordFavorite(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var favoriteMsg PasswordFavoriteRequest
+	err = json.Unmarshal(b, &favoriteMsg)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	owner, err := handler.policy.AuthorizeEntryVersion(user, favoriteMsg.Id, ActionWrite, request.Header.Get("If-Match"))
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	err = handler.storage.SetPasswordFavorite(owner, favoriteMsg.Id, favoriteMsg.Favorite)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("UPDATED", "", writer)
+}
+
+// RecordPasswordUsage is called by the clients whenever they filled an entry into a page
+func (handler CRUDHandler) RecordPasswordUsage(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var usageMsg PasswordUsageRequest
+	err = json.Unmarshal(b, &usageMsg)
+	if err != nil || usageMsg.Id == "" {
+		http.Error(writer, "id is missing", http.StatusBadRequest)
+		return
+	}
+	_, err = handler.policy.AuthorizeEntry(user, usageMsg.Id, ActionRead)
+	if err != nil {
+		sendPolicyError(writer, err)
+		return
+	}
+	// the usage is recorded for the user who filled the entry, not for its owner
+	err = handler.storage.RecordPasswordUsage(user, usageMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// DeletePasswordUsage forgets when and how often the user used an entry, without id all entries
+func (handler CRUDHandler) DeletePasswordUsage(writer http.ResponseWriter, request *http.Request) {
+	user, err := handler.storage.GetUser(request.Form.Get("UserId"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+	var usageMsg PasswordUsageRequest
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &usageMsg)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if usageMsg.Id != "" {
+		_, err = handler.policy.AuthorizeEntry(user, usageMsg.Id, ActionRead)
+		if err != nil {
+			sendPolicyError(writer, err)
+			return
+		}
+	}
+	err = handler.storage.DeletePasswordUsage(user, usageMsg.Id)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendCRUDAnswer("REMOVED", "", writer)
+}
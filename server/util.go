@@ -3,6 +3,8 @@ package main
 import (
 	uuid "github.com/nu7hatch/gouuid"
 	"math/rand"
+	"os"
+	"strconv"
 )
 
 func newUUID() string {
@@ -23,3 +25,12 @@ func GeneratePassword(length int) []byte {
 	}
 	return b
 }
+
+// getEnvInt reads a numeric setting from the environment, the fallback is used if it is missing or invalid
+func getEnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
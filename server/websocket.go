@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// webSocketGUID is appended to the key of the client to compute the accept key of the handshake, see RFC 6455
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of the WebSocket frames
+const (
+	opText  byte = 0x1
+	opClose byte = 0x8
+	opPing  byte = 0x9
+	opPong  byte = 0xA
+)
+
+// maxWebSocketFrame limits the frames read from clients, which only send control frames to the event stream
+const maxWebSocketFrame = 4096
+
+var errWebSocketFrame = errors.New("malformed websocket frame")
+
+// isWebSocketUpgrade tells whether the client asks to upgrade the connection to a WebSocket
+func isWebSocketUpgrade(request *http.Request) bool {
+	return headerContainsToken(request.Header.Get("Connection"), "upgrade") &&
+		strings.EqualFold(request.Header.Get("Upgrade"), "websocket")
+}
+
+func headerContainsToken(header string, token string) bool {
+	for _, value := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// webSocketAccept is the accept key of the handshake for the key of the client
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// checkWebSocketOrigin accepts browsers on the same host and the origins of the plugins.
+// Browsers send the cookie with the handshake of every site, so other origins must not open the stream.
+func checkWebSocketOrigin(request *http.Request, allowed []string) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowedOrigin := range allowed {
+		if origin == allowedOrigin {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, request.Host)
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection, errors are answered before the takeover
+func upgradeWebSocket(writer http.ResponseWriter, request *http.Request, allowedOrigins []string) (net.Conn, *bufio.ReadWriter, error) {
+	key := request.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(writer, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		writer.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(writer, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, nil, errors.New("unsupported WebSocket version")
+	}
+	if !checkWebSocketOrigin(request, allowedOrigins) {
+		http.Error(writer, "403 - Origin not allowed - ", http.StatusForbidden)
+		return nil, nil, errors.New("origin not allowed")
+	}
+	hijacker, ok := writer.(http.Hijacker)
+	if !ok {
+		http.Error(writer, "WebSockets are not supported", http.StatusInternalServerError)
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	conn, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = readWriter.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err == nil {
+		err = readWriter.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, readWriter, nil
+}
+
+// writeWebSocketFrame writes a single unmasked frame as the server
+func writeWebSocketFrame(writer *bufio.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	_, err := writer.Write(header)
+	if err == nil {
+		_, err = writer.Write(payload)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	return err
+}
+
+// readWebSocketFrame reads a frame of the client, which has to be masked
+func readWebSocketFrame(reader *bufio.Reader) (opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errWebSocketFrame
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(reader, extended)
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(reader, extended)
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxWebSocketFrame {
+		return 0, nil, errWebSocketFrame
+	}
+	mask := make([]byte, 4)
+	_, err = io.ReadFull(reader, mask)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}